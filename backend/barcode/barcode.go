@@ -0,0 +1,195 @@
+// Package barcode builds and validates the library's 14 digit Codabar barcodes:
+// a kind digit, a 4 digit institution code, an 8 digit sequence number and a
+// mod-10 check digit, the layout most library systems print on items and cards.
+package barcode
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Kind - First digit of a barcode, telling member cards and items apart.
+type Kind byte
+
+const (
+	Patron Kind = '2'
+	Item   Kind = '3'
+)
+
+// String - Human readable name of the kind.
+func (k Kind) String() string {
+	switch k {
+	case Patron:
+		return "patron"
+	case Item:
+		return "item"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	institutionLength = 4
+	sequenceLength    = 8
+	maxSequence       = 99999999
+)
+
+// Common barcode errors
+var ErrFormat = errors.New("barcode must be digits only")
+var ErrLength = errors.New("barcode has the wrong length")
+var ErrKind = errors.New("barcode isn't a patron or item barcode")
+var ErrInstitution = errors.New("barcode belongs to another institution")
+var ErrCheckDigit = errors.New("barcode check digit doesn't match")
+var ErrSequence = errors.New("barcode sequence number is out of range")
+
+// Format - The barcode layout a library uses.
+type Format struct {
+	Institution string // Digits every barcode of the library carries after its kind.
+	CheckDigit  bool   // Whether barcodes end in a mod-10 check digit.
+}
+
+// Default - Format used unless configured otherwise.
+var Default = Format{
+	Institution: "0001",
+	CheckDigit:  true,
+}
+
+// ValidateInstitution - Make sure the institution code fits the layout.
+func (f Format) ValidateInstitution() error {
+	if len(f.Institution) != institutionLength || !isDigits(f.Institution) {
+		return fmt.Errorf("institution code must be %d digits", institutionLength)
+	}
+
+	return nil
+}
+
+// Length - Number of digits in a barcode of this format.
+func (f Format) Length() int {
+	length := 1 + institutionLength + sequenceLength
+	if f.CheckDigit {
+		length++
+	}
+
+	return length
+}
+
+// Prefix - Leading digits shared by every barcode of a kind.
+func (f Format) Prefix(kind Kind) string {
+	return string(kind) + f.Institution
+}
+
+// Generate - Build the barcode for a sequence number.
+func (f Format) Generate(kind Kind, sequence uint) (string, error) {
+	if sequence == 0 || sequence > maxSequence {
+		return "", ErrSequence
+	}
+
+	code := fmt.Sprintf("%s%0*d", f.Prefix(kind), sequenceLength, sequence)
+	if f.CheckDigit {
+		digit, err := CheckDigit(code)
+		if err != nil {
+			return "", err
+		}
+		code += string(digit)
+	}
+
+	return code, nil
+}
+
+// Parse - Validate a barcode, returning its kind and sequence number.
+func (f Format) Parse(code string) (Kind, uint, error) {
+	code = strings.TrimSpace(code)
+	if !isDigits(code) {
+		return 0, 0, ErrFormat
+	}
+	if len(code) != f.Length() {
+		return 0, 0, ErrLength
+	}
+
+	kind := Kind(code[0])
+	if kind != Patron && kind != Item {
+		return 0, 0, ErrKind
+	}
+	if code[1:1+institutionLength] != f.Institution {
+		return 0, 0, ErrInstitution
+	}
+	if f.CheckDigit {
+		body := code[:len(code)-1]
+		digit, err := CheckDigit(body)
+		if err != nil {
+			return 0, 0, err
+		}
+		if code[len(code)-1] != digit {
+			return 0, 0, ErrCheckDigit
+		}
+	}
+
+	start := 1 + institutionLength
+	sequence, err := strconv.ParseUint(code[start:start+sequenceLength], 10, 32)
+	if err != nil {
+		return 0, 0, ErrFormat
+	}
+
+	return kind, uint(sequence), nil
+}
+
+// Sequence - Sequence number of a barcode of this institution, read without checking its length or
+// check digit, so barcodes printed before the check digit setting changed still count.
+func (f Format) Sequence(code string) (uint, error) {
+	code = strings.TrimSpace(code)
+	start := 1 + institutionLength
+	if !isDigits(code) || len(code) < start+sequenceLength {
+		return 0, ErrFormat
+	}
+	if code[1:start] != f.Institution {
+		return 0, ErrInstitution
+	}
+
+	sequence, err := strconv.ParseUint(code[start:start+sequenceLength], 10, 32)
+	if err != nil {
+		return 0, ErrFormat
+	}
+
+	return uint(sequence), nil
+}
+
+// CheckDigit - Codabar mod-10 check digit of a string of digits: every other
+// digit, starting with the rightmost, is doubled (less 9 when over 9) and the
+// check digit brings the sum up to a multiple of 10.
+func CheckDigit(digits string) (byte, error) {
+	if !isDigits(digits) {
+		return 0, ErrFormat
+	}
+
+	sum := 0
+	double := true
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+
+	return byte('0' + (10-sum%10)%10), nil
+}
+
+// isDigits - Check a string is non empty and only holds 0-9.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,117 @@
+package barcode
+
+import "testing"
+
+func TestCheckDigit(t *testing.T) {
+	tests := []struct {
+		in   string
+		want byte
+		err  error
+	}{
+		{"7992739871", '3', nil},
+		{"3000100000001", '0', nil},
+		{"3000100000002", '8', nil},
+		{"2000100000001", '2', nil},
+		{"0", '0', nil},
+		{"", 0, ErrFormat},
+		{"30001a", 0, ErrFormat},
+	}
+
+	for _, tt := range tests {
+		got, err := CheckDigit(tt.in)
+		if err != tt.err || got != tt.want {
+			t.Errorf("CheckDigit(%q) = %q, %v, want %q, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	plain := Format{Institution: "0001", CheckDigit: false}
+	tests := []struct {
+		name     string
+		format   Format
+		kind     Kind
+		sequence uint
+		want     string
+		err      error
+	}{
+		{"first item", Default, Item, 1, "30001000000010", nil},
+		{"first patron", Default, Patron, 1, "20001000000012", nil},
+		{"without check digit", plain, Item, 3, "3000100000003", nil},
+		{"last sequence", plain, Item, maxSequence, "3000199999999", nil},
+		{"sequence 0", Default, Item, 0, "", ErrSequence},
+		{"sequence too big", Default, Item, maxSequence + 1, "", ErrSequence},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.format.Generate(tt.kind, tt.sequence)
+			if err != tt.err || got != tt.want {
+				t.Fatalf("Generate(%c, %d) = %q, %v, want %q, %v", tt.kind, tt.sequence, got, err, tt.want, tt.err)
+			}
+			if err != nil {
+				return
+			}
+
+			kind, sequence, err := tt.format.Parse(got)
+			if err != nil || kind != tt.kind || sequence != tt.sequence {
+				t.Errorf("Parse(%q) = %c, %d, %v, want %c, %d", got, kind, sequence, err, tt.kind, tt.sequence)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   Format
+		code     string
+		kind     Kind
+		sequence uint
+		err      error
+	}{
+		{"item", Default, "30001000000028", Item, 2, nil},
+		{"patron", Default, " 20001000000012 ", Patron, 1, nil},
+		{"without check digit", Format{Institution: "0001"}, "3000100000004", Item, 4, nil},
+		{"wrong check digit", Default, "30001000000011", 0, 0, ErrCheckDigit},
+		{"check digit missing", Default, "3000100000001", 0, 0, ErrLength},
+		{"unexpected check digit", Format{Institution: "0001"}, "30001000000010", 0, 0, ErrLength},
+		{"unknown kind", Default, "40001000000017", 0, 0, ErrKind},
+		{"other institution", Default, "30002000000019", 0, 0, ErrInstitution},
+		{"letters", Default, "3000a000000010", 0, 0, ErrFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, sequence, err := tt.format.Parse(tt.code)
+			if err != tt.err || kind != tt.kind || sequence != tt.sequence {
+				t.Errorf("Parse(%q) = %c, %d, %v, want %c, %d, %v", tt.code, kind, sequence, err, tt.kind, tt.sequence, tt.err)
+			}
+		})
+	}
+}
+
+func TestSequence(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		sequence uint
+		err      error
+	}{
+		{"with check digit", "30001000000028", 2, nil},
+		{"printed without check digit", "3000100000004", 4, nil},
+		{"wrong check digit still counts", "30001000000071", 7, nil},
+		{"other institution", "30002000000010", 0, ErrInstitution},
+		{"too short", "300010000", 0, ErrFormat},
+		{"letters", "3000100000a01", 0, ErrFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sequence, err := Default.Sequence(tt.code)
+			if err != tt.err || sequence != tt.sequence {
+				t.Errorf("Sequence(%q) = %d, %v, want %d, %v", tt.code, sequence, err, tt.sequence, tt.err)
+			}
+		})
+	}
+}
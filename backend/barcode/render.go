@@ -0,0 +1,204 @@
+package barcode
+
+import (
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// codabarPatterns - Widths of the 7 alternating bars and spaces of each Codabar
+// character, 1 for a wide element and 0 for a narrow one.
+var codabarPatterns = map[rune]string{
+	'0': "0000011",
+	'1': "0000110",
+	'2': "0001001",
+	'3': "1100000",
+	'4': "0010010",
+	'5': "1000010",
+	'6': "0100001",
+	'7': "0100100",
+	'8': "0110000",
+	'9': "1001000",
+	'-': "0001100",
+	'$': "0011000",
+	'A': "0011010",
+	'B': "0101001",
+}
+
+// Rendering sizes, in pixels.
+const (
+	narrowWidth = 2
+	wideWidth   = 5
+	barHeight   = 60
+	labelWidth  = 420
+	labelHeight = 120
+	sheetCols   = 3
+)
+
+// Label - One barcode on a label sheet with an optional caption, e.g. a title.
+type Label struct {
+	Code    string
+	Caption string
+}
+
+// bar - A black bar at x, width pixels wide.
+type bar struct {
+	x     int
+	width int
+}
+
+// encode - Lay out the bars of a Codabar symbol, framed by the A/B start and stop characters.
+func encode(code string) ([]bar, int, error) {
+	var bars []bar
+	x := 0
+	for i, char := range "A" + code + "B" {
+		pattern, ok := codabarPatterns[char]
+		if !ok {
+			return nil, 0, fmt.Errorf("can't encode %q in codabar", char)
+		}
+		if i > 0 {
+			x += narrowWidth
+		}
+
+		for j, wide := range pattern {
+			width := narrowWidth
+			if wide == '1' {
+				width = wideWidth
+			}
+			if j%2 == 0 {
+				bars = append(bars, bar{x: x, width: width})
+			}
+			x += width
+		}
+	}
+
+	return bars, x, nil
+}
+
+// sheetSize - Pixel size of a sheet holding count labels.
+func sheetSize(count int) (int, int) {
+	rows := (count + sheetCols - 1) / sheetCols
+	cols := sheetCols
+	if count < sheetCols {
+		cols = count
+	}
+
+	return cols * labelWidth, rows * labelHeight
+}
+
+// labelOrigin - Top left corner of the i-th label on a sheet.
+func labelOrigin(i int) (int, int) {
+	return (i % sheetCols) * labelWidth, (i / sheetCols) * labelHeight
+}
+
+// WriteSVG - Render labels as an SVG sheet, three labels per row.
+func WriteSVG(w io.Writer, labels []Label) error {
+	width, height := sheetSize(len(labels))
+
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`, width, height, width, height)
+	fmt.Fprintf(&svg, `<rect width="%d" height="%d" fill="#fff"/>`, width, height)
+	for i, label := range labels {
+		bars, symbolWidth, err := encode(label.Code)
+		if err != nil {
+			return err
+		}
+
+		ox, oy := labelOrigin(i)
+		left := ox + (labelWidth-symbolWidth)/2
+		for _, b := range bars {
+			fmt.Fprintf(&svg, `<rect x="%d" y="%d" width="%d" height="%d" fill="#000"/>`, left+b.x, oy+10, b.width, barHeight)
+		}
+
+		center := ox + labelWidth/2
+		fmt.Fprintf(&svg, `<text x="%d" y="%d" font-family="monospace" font-size="14" text-anchor="middle">%s</text>`, center, oy+barHeight+28, html.EscapeString(label.Code))
+		if label.Caption != "" {
+			fmt.Fprintf(&svg, `<text x="%d" y="%d" font-family="sans-serif" font-size="11" text-anchor="middle">%s</text>`, center, oy+barHeight+45, html.EscapeString(truncate(label.Caption, 40)))
+		}
+	}
+	svg.WriteString(`</svg>`)
+
+	_, err := io.WriteString(w, svg.String())
+	return err
+}
+
+// digitGlyphs - A 3x5 pixel font for the human readable digits under PNG barcodes.
+var digitGlyphs = map[rune][5]string{
+	'0': {"111", "101", "101", "101", "111"},
+	'1': {"010", "110", "010", "010", "111"},
+	'2': {"111", "001", "111", "100", "111"},
+	'3': {"111", "001", "111", "001", "111"},
+	'4': {"101", "101", "111", "001", "001"},
+	'5': {"111", "100", "111", "001", "111"},
+	'6': {"111", "100", "111", "101", "111"},
+	'7': {"111", "001", "001", "001", "001"},
+	'8': {"111", "101", "111", "101", "111"},
+	'9': {"111", "101", "111", "001", "111"},
+}
+
+// WritePNG - Render labels as a PNG sheet, three labels per row. Captions are
+// left out since the PNG only carries a digit font.
+func WritePNG(w io.Writer, labels []Label) error {
+	width, height := sheetSize(len(labels))
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+
+	fill := func(x0, y0, x1, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				img.SetGray(x, y, color.Gray{Y: 0})
+			}
+		}
+	}
+
+	const scale = 3
+	for i, label := range labels {
+		bars, symbolWidth, err := encode(label.Code)
+		if err != nil {
+			return err
+		}
+
+		ox, oy := labelOrigin(i)
+		left := ox + (labelWidth-symbolWidth)/2
+		for _, b := range bars {
+			fill(left+b.x, oy+10, left+b.x+b.width, oy+10+barHeight)
+		}
+
+		// Digits are 3 glyph pixels wide plus one pixel of spacing.
+		textWidth := len(label.Code)*4*scale - scale
+		x := ox + (labelWidth-textWidth)/2
+		y := oy + barHeight + 20
+		for _, char := range label.Code {
+			glyph, ok := digitGlyphs[char]
+			if ok {
+				for row, bits := range glyph {
+					for col, bit := range bits {
+						if bit == '1' {
+							px, py := x+col*scale, y+row*scale
+							fill(px, py, px+scale, py+scale)
+						}
+					}
+				}
+			}
+			x += 4 * scale
+		}
+	}
+
+	return png.Encode(w, img)
+}
+
+// truncate - Shorten s to at most n runes, marking the cut with an ellipsis.
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+
+	return string(runes[:n-1]) + "…"
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/satori/go.uuid"
+	"log"
+	"main/db"
+	"main/generate"
+	"main/handlers"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// commandActor - Actor of the changes made by admin commands.
+const commandActor = "cli"
+
+// runCommand - Run an admin command by name and return the process exit code.
+func runCommand(name string, args []string) int {
+	switch name {
+	case "migrate":
+		return runMigrate(args)
+	case "events":
+		return runEvents(args)
+	case "users":
+		return runUsers(args)
+	case "seed":
+		return runSeed(args)
+	case "generate":
+		return runGenerate(args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+		fmt.Fprintln(os.Stderr, "usage: api [migrate up|down|status] [events verify] [users add] [seed] [generate]")
+		return 2
+	}
+}
+
+// runMigrate - Apply, roll back or report schema migrations.
+func runMigrate(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	steps := flags.Int("steps", 1, "number of migrations to roll back with down")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: api migrate [-steps n] up|down|status")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	client, err := openDB()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	defer client.Close()
+
+	switch flags.Arg(0) {
+	case "up":
+		ran, err := db.MigrateUp(client)
+		for _, m := range ran {
+			fmt.Printf("applied     %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		if len(ran) == 0 {
+			fmt.Println("no pending migrations")
+		}
+	case "down":
+		ran, err := db.MigrateDown(client, *steps)
+		for _, m := range ran {
+			fmt.Printf("rolled back %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+	case "status":
+		statuses, err := db.GetMigrationStatus(client)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-30s %s\n", status.Version, status.Name, appliedAt)
+		}
+	default:
+		flags.Usage()
+		return 2
+	}
+
+	return 0
+}
+
+// runEvents - Check the event log accounts for the current catalog.
+func runEvents(args []string) int {
+	flags := flag.NewFlagSet("events", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: api events verify")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 || flags.Arg(0) != "verify" {
+		flags.Usage()
+		return 2
+	}
+
+	client, err := openDB()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	store := db.NewGormStore(client)
+	defer store.Close()
+
+	mismatches, err := store.VerifyReplay()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	for _, mismatch := range mismatches {
+		for _, field := range sortedFields(mismatch.Changes) {
+			change := mismatch.Changes[field]
+			fmt.Printf("%-8s %-14s %-18s replayed %v, stored %v\n",
+				mismatch.EntityType, mismatch.EntityID, field, replayValue(change.Before), replayValue(change.After))
+		}
+	}
+	if len(mismatches) > 0 {
+		fmt.Printf("%d records differ from the event log\n", len(mismatches))
+		return 1
+	}
+
+	fmt.Println("event log matches the catalog")
+	return 0
+}
+
+// runUsers - Add an account, e.g. the first admin, who can then manage the rest over the api.
+// The password is read from the first line of stdin so it stays out of the process list.
+func runUsers(args []string) int {
+	flags := flag.NewFlagSet("users", flag.ContinueOnError)
+	username := flags.String("username", "", "name to sign in with")
+	role := flags.String("role", string(db.RoleAdmin), "admin, librarian or member")
+	memberID := flags.String("member-id", "", "member the account belongs to, for the member role")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: api users add -username name [-role role] [-member-id uuid] < password")
+		flags.PrintDefaults()
+	}
+	if len(args) == 0 || args[0] != "add" {
+		flags.Usage()
+		return 2
+	}
+	if err := flags.Parse(args[1:]); err != nil || flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	payload := handlers.PostUserPayload{
+		Username: *username,
+		Role:     db.Role(*role),
+	}
+	if *memberID != "" {
+		id, err := uuid.FromString(*memberID)
+		if err != nil {
+			log.Println("invalid -member-id:", err)
+			return 2
+		}
+		payload.MemberID = &id
+	}
+
+	fmt.Fprint(os.Stderr, "password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		log.Println("reading password:", err)
+		return 1
+	}
+	payload.Password = strings.TrimRight(password, "\r\n")
+
+	client, err := openDB()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	if _, err := db.MigrateUp(client); err != nil {
+		log.Println(err)
+		return 1
+	}
+	store := db.NewGormStore(client)
+	defer store.Close()
+
+	user, err := handlers.CreateUser(store.WithActor(commandActor), payload)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	fmt.Printf("\nadded %s %s (id %d)\n", user.Role, user.Username, user.ID)
+	return 0
+}
+
+// runSeed - Load a fixture set, wiping the catalog, members and circulation first unless appending.
+func runSeed(args []string) int {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	set := flags.String("set", handlers.DefaultSeedSet, "fixture set to load")
+	appendData := flags.Bool("append", false, "add the set to the existing data instead of wiping it")
+	dir := flags.String("dir", os.Getenv("SEED_DIR"), "directory of fixture sets (default seed_data, or SEED_DIR)")
+	list := flags.Bool("list", false, "list the fixture sets instead of loading one")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: api seed [-set name] [-append] [-dir path] [-list]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	client, err := openDB()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	if _, err := db.MigrateUp(client); err != nil {
+		log.Println(err)
+		return 1
+	}
+	store := db.NewGormStore(client)
+	defer store.Close()
+
+	server := handlers.NewServer(store)
+	if *dir != "" {
+		server.SeedDir = *dir
+	}
+	if err := configureBarcodes(server); err != nil {
+		log.Println(err)
+		return 1
+	}
+	if err := configureLoans(server); err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	if *list {
+		sets, err := handlers.SeedSets(server.SeedDir)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		for _, name := range sets {
+			fmt.Println(name)
+		}
+		return 0
+	}
+
+	data, err := server.LoadSeedSet(*set)
+	if err == nil {
+		err = server.SeedDatabase(store.WithActor(commandActor), data, *appendData)
+	}
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	verb := "loaded"
+	if *appendData {
+		verb = "appended"
+	}
+	fmt.Printf("%s %s: %d members, %d authors, %d books, %d copies, %d checkouts\n", verb, *set,
+		len(data.Members), len(data.Authors), len(data.Books), len(data.Copies), len(data.Checkouts))
+	return 0
+}
+
+// generateDateLayout - How the generate command's -from and -to dates are written.
+const generateDateLayout = "2006-01-02"
+
+// runGenerate - Make up a library of the given size with its loan history and load it, wiping the
+// catalog, members and circulation first unless appending. The same seed, sizes and dates always
+// load the same records.
+func runGenerate(args []string) int {
+	flags := flag.NewFlagSet("generate", flag.ContinueOnError)
+	seed := flags.Int64("seed", 1, "seed for the random choices, the same seed gives the same data")
+	authors := flags.Int("authors", 200, "number of authors")
+	books := flags.Int("books", 2000, "number of books")
+	members := flags.Int("members", 1000, "number of members")
+	copies := flags.String("copies", "1-4", "copies per book, as min-max, popular books get more")
+	from := flags.String("from", "", "first day of the loan history, YYYY-MM-DD (default two years before -to)")
+	to := flags.String("to", "", "day the loan history ends, YYYY-MM-DD (default today)")
+	appendData := flags.Bool("append", false, "add the data to the existing data instead of wiping it")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: api generate [-seed n] [-authors n] [-books n] [-members n] [-copies min-max] [-from date] [-to date] [-append]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	opts := generate.Options{
+		Seed:    *seed,
+		Authors: *authors,
+		Books:   *books,
+		Members: *members,
+	}
+	var err error
+	if opts.MinCopies, opts.MaxCopies, err = parseCopyRange(*copies); err != nil {
+		log.Println(err)
+		return 2
+	}
+	now := time.Now()
+	opts.To = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	if *to != "" {
+		if opts.To, err = time.ParseInLocation(generateDateLayout, *to, time.Local); err != nil {
+			log.Println("invalid -to:", err)
+			return 2
+		}
+	}
+	opts.From = opts.To.AddDate(-2, 0, 0)
+	if *from != "" {
+		if opts.From, err = time.ParseInLocation(generateDateLayout, *from, time.Local); err != nil {
+			log.Println("invalid -from:", err)
+			return 2
+		}
+	}
+
+	client, err := openDB()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	if _, err := db.MigrateUp(client); err != nil {
+		log.Println(err)
+		return 1
+	}
+	store := db.NewGormStore(client)
+	defer store.Close()
+
+	server := handlers.NewServer(store)
+	if err := configureBarcodes(server); err != nil {
+		log.Println(err)
+		return 1
+	}
+	if err := configureLoans(server); err != nil {
+		log.Println(err)
+		return 1
+	}
+	if err := configureFines(server); err != nil {
+		log.Println(err)
+		return 1
+	}
+	opts.LoanDays = server.LoanPolicy.LoanDays
+	opts.FinePerDay = server.Fines.PerDay
+	opts.FineCap = server.Fines.ItemCap
+	opts.ReplacementFee = server.Fines.Replacement
+
+	data, err := generate.Generate(opts)
+	if err != nil {
+		log.Println(err)
+		return 2
+	}
+	if err := server.SeedDatabase(store.WithActor(commandActor), data, *appendData); err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	verb := "generated"
+	if *appendData {
+		verb = "appended"
+	}
+	fmt.Printf("%s seed %d, %s to %s: %d members, %d authors, %d books, %d copies, %d checkouts, %d ledger entries\n",
+		verb, opts.Seed, opts.From.Format(generateDateLayout), opts.To.Format(generateDateLayout),
+		len(data.Members), len(data.Authors), len(data.Books), len(data.Copies), len(data.Checkouts), len(data.LedgerEntries))
+	return 0
+}
+
+// parseCopyRange - Read a min-max copies range, a single number meaning exactly that many.
+func parseCopyRange(value string) (int, int, error) {
+	parts := strings.SplitN(value, "-", 2)
+	min, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid -copies %q", value)
+	}
+	max := min
+	if len(parts) == 2 {
+		if max, err = strconv.Atoi(parts[1]); err != nil {
+			return 0, 0, fmt.Errorf("invalid -copies %q", value)
+		}
+	}
+
+	return min, max, nil
+}
+
+// sortedFields - The changed fields of a diff, in order.
+func sortedFields(changes db.Diff) []string {
+	var fields []string
+	for field := range changes {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	return fields
+}
+
+// replayValue - A replayed or stored value for printing, "missing" when there's none.
+func replayValue(value interface{}) string {
+	if value == nil {
+		return "missing"
+	}
+
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"database/sql/driver"
+	"strings"
+	"time"
+)
+
+// apiKeyTouchInterval - How stale an api key's last use gets before it's written again, so
+// busy keys don't cost a write per request.
+const apiKeyTouchInterval = time.Minute
+
+// ScopeList - Scopes an api key carries, stored comma separated.
+type ScopeList []Scope
+
+// Value - Store a scope list as comma separated text.
+func (l ScopeList) Value() (driver.Value, error) {
+	items := make([]string, len(l))
+	for i, scope := range l {
+		items[i] = string(scope)
+	}
+
+	return strings.Join(items, ","), nil
+}
+
+// Scan - Read a scope list back from its comma separated text.
+func (l *ScopeList) Scan(value interface{}) error {
+	items, err := scanCommaList(value)
+	*l = ScopeList{}
+	for _, item := range items {
+		*l = append(*l, Scope(item))
+	}
+
+	return err
+}
+
+// Has - Check the list grants a scope, admin grants every scope.
+func (l ScopeList) Has(scope Scope) bool {
+	for _, granted := range l {
+		if granted == scope || granted == ScopeAdmin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsScope - Check a string names a known scope.
+func IsScope(scope Scope) bool {
+	for _, known := range Scopes {
+		if scope == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetAllAPIKeys - Retrieve all api keys, revoked ones included.
+func (s *gormStore) GetAllAPIKeys() ([]APIKey, error) {
+	var keys []APIKey
+	err := s.db.Order("id").Find(&keys).Error
+	return keys, err
+}
+
+// GetAPIKey - Retrieve a single api key by id.
+func (s *gormStore) GetAPIKey(id uint) (APIKey, error) {
+	var key APIKey
+	err := s.db.Where("id = ?", id).First(&key).Error
+	return key, notFound(err)
+}
+
+// GetAPIKeyByHash - Retrieve the unrevoked api key with a key hash.
+func (s *gormStore) GetAPIKeyByHash(keyHash string) (APIKey, error) {
+	var key APIKey
+	err := s.db.Where("key_hash = ? AND revoked_at IS NULL", keyHash).First(&key).Error
+	return key, notFound(err)
+}
+
+// CreateAPIKey - Insert a new api key.
+func (s *gormStore) CreateAPIKey(key *APIKey) error {
+	return s.db.Create(key).Error
+}
+
+// RevokeAPIKey - Stop an api key from working as of now.
+func (s *gormStore) RevokeAPIKey(id uint, now time.Time) error {
+	return s.db.Model(&APIKey{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Updates(map[string]interface{}{"revoked_at": now, "updated_at": now}).Error
+}
+
+// TouchAPIKey - Record an api key was used now, unless that was already recorded in the last minute.
+func (s *gormStore) TouchAPIKey(id uint, now time.Time) error {
+	return s.db.Model(&APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, now.Add(-apiKeyTouchInterval)).
+		UpdateColumn("last_used_at", now).Error
+}
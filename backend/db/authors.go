@@ -0,0 +1,75 @@
+package db
+
+import (
+	"github.com/satori/go.uuid"
+)
+
+// authorList - Filters and sort orders of author lists.
+var authorList = listSpec{
+	Key:         sortField{Column: "id"},
+	DefaultSort: []string{"last_name", "first_name"},
+	Sorts: map[string]sortField{
+		"id":         {Column: "id"},
+		"first_name": {Column: "first_name"},
+		"last_name":  {Column: "last_name"},
+		"created_at": {Column: "created_at", Kind: kindTime},
+	},
+	Filters: map[string]filterFunc{
+		"first_name": equals("first_name"),
+		"last_name":  equals("last_name"),
+	},
+}
+
+// ListAuthors - Retrieve a page of authors, optionally with their books.
+func (s *gormStore) ListAuthors(withBooks bool, q ListQuery) ([]Author, Page, error) {
+	var authors []Author
+	query := s.db
+	if withBooks {
+		query = query.Preload("Books")
+	}
+
+	page, err := authorList.list(query, q, &authors)
+	return authors, page, err
+}
+
+// GetAuthor - Retrieve a single author by uuid.
+func (s *gormStore) GetAuthor(id uuid.UUID) (Author, error) {
+	var author Author
+	err := s.db.Where("id = ?", id).First(&author).Error
+	return author, notFound(err)
+}
+
+// GetAuthorBooks - Retrieve all books written by an author.
+func (s *gormStore) GetAuthorBooks(id uuid.UUID) ([]Book, error) {
+	var author Author
+	err := s.db.Preload("Books").Where("id = ?", id).First(&author).Error
+	return author.Books, notFound(err)
+}
+
+// FindAuthorByName - Retrieve an author matching the given names.
+func (s *gormStore) FindAuthorByName(first string, last string, middle string) (Author, error) {
+	var author Author
+	query := &Author{Person: Person{
+		FirstName: first,
+		LastName:  last,
+		Middle:    middle,
+	}}
+
+	err := s.db.Where(query).First(&author).Error
+	return author, notFound(err)
+}
+
+// CreateAuthor - Insert a new author record.
+func (s *gormStore) CreateAuthor(author *Author) error {
+	return s.db.Create(author).Error
+}
+
+// UpdateAuthor - Apply column updates to an author.
+func (s *gormStore) UpdateAuthor(id uuid.UUID, updates map[string]interface{}) error {
+	return s.db.Model(&Author{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// DeleteAuthor - Soft delete an author.
+func (s *gormStore) DeleteAuthor(id uuid.UUID) error {
+	return s.db.Where("id = ?", id).Delete(&Author{}).Error
+}
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/satori/go.uuid"
+	"github.com/t-tiger/gorm-bulk-insert"
+	"main/isbn"
+	"strconv"
+)
+
+// preloadBookRelations - Preload book query with Authors & circulating Copies relations.
+func preloadBookRelations(db *gorm.DB) *gorm.DB {
+	return db.Preload("Authors").Preload("Copies", "status <> ?", CopyWithdrawn)
+}
+
+// bookList - Filters and sort orders of book lists.
+var bookList = listSpec{
+	Key:         sortField{Column: "isbn"},
+	DefaultSort: []string{"title"},
+	Sorts: map[string]sortField{
+		"isbn":       {Column: "isbn"},
+		"title":      {Column: "title"},
+		"created_at": {Column: "created_at", Kind: kindTime},
+		"updated_at": {Column: "updated_at", Kind: kindTime},
+	},
+	Filters: map[string]filterFunc{
+		"isbn": func(query *gorm.DB, value string) (*gorm.DB, error) {
+			parsed, err := isbn.Parse(value)
+			if err != nil {
+				return nil, invalidList("isbn: %s", err.Error())
+			}
+			return query.Where("isbn = ?", parsed), nil
+		},
+		"author_id": func(query *gorm.DB, value string) (*gorm.DB, error) {
+			id, err := uuid.FromString(value)
+			if err != nil {
+				return nil, invalidList("author_id must be a uuid")
+			}
+			return query.Where("isbn IN (SELECT book_isbn FROM books_authors WHERE author_id = ?)", id), nil
+		},
+		"available": func(query *gorm.DB, value string) (*gorm.DB, error) {
+			available, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, invalidList("available must be true or false")
+			}
+			onShelf := "EXISTS (SELECT 1 FROM copies WHERE copies.isbn = books.isbn AND copies.status = ? " +
+				"AND copies.id NOT IN (SELECT copy_id FROM holds WHERE status = ? AND copy_id IS NOT NULL))"
+			if !available {
+				onShelf = "NOT " + onShelf
+			}
+			return query.Where(onShelf, CopyOnShelf, HoldReady), nil
+		},
+	},
+}
+
+// GetAllBooks - Retrieve a page of every book with relations, withdrawn or not.
+func (s *gormStore) GetAllBooks(q ListQuery) ([]Book, Page, error) {
+	var books []Book
+	page, err := bookList.list(preloadBookRelations(s.db), q, &books)
+	return books, page, err
+}
+
+// ListBooks - Retrieve a page of the books with circulating copies, with relations.
+func (s *gormStore) ListBooks(q ListQuery) ([]Book, Page, error) {
+	var books []Book
+	query := preloadBookRelations(s.db).
+		Where("EXISTS (SELECT 1 FROM copies WHERE copies.isbn = books.isbn AND copies.status <> ?)", CopyWithdrawn)
+
+	page, err := bookList.list(query, q, &books)
+	return books, page, err
+}
+
+// GetBook - Retrieve a single book with all relations.
+func (s *gormStore) GetBook(isbn string) (Book, error) {
+	var book Book
+	err := preloadBookRelations(s.db).Where(&Book{ISBN: isbn}).First(&book).Error
+	return book, notFound(err)
+}
+
+// GetBookUnscoped - Retrieve a single book including soft deleted ones.
+func (s *gormStore) GetBookUnscoped(isbn string) (Book, error) {
+	var book Book
+	err := s.db.Unscoped().Where(&Book{ISBN: isbn}).First(&book).Error
+	return book, notFound(err)
+}
+
+// CreateBook - Insert a new book record.
+func (s *gormStore) CreateBook(book *Book) error {
+	return s.db.Create(book).Error
+}
+
+// UpdateBook - Apply column updates to a book.
+func (s *gormStore) UpdateBook(isbn string, updates map[string]interface{}) error {
+	return s.db.Model(&Book{ISBN: isbn}).Updates(updates).Error
+}
+
+// DeleteBook - Soft delete a book.
+func (s *gormStore) DeleteBook(isbn string) error {
+	return s.db.Where(&Book{ISBN: isbn}).Delete(&Book{}).Error
+}
+
+// PurgeBook - Hard delete a book, including soft deleted rows.
+func (s *gormStore) PurgeBook(isbn string) error {
+	return s.db.Unscoped().Where(&Book{ISBN: isbn}).Delete(&Book{}).Error
+}
+
+// AddBookAuthors - Batch inserts to BooksAuthors relation table.
+func (s *gormStore) AddBookAuthors(isbn string, authorIDs []uuid.UUID) error {
+	var booksAuthorsRecords []interface{}
+	for _, id := range authorIDs {
+		var rel = BooksAuthors{
+			BookISBN: isbn,
+			AuthorID: id,
+		}
+
+		booksAuthorsRecords = append(booksAuthorsRecords, rel)
+	}
+
+	return gormbulk.BulkInsert(s.db, booksAuthorsRecords, 3000)
+}
+
+// RemoveBookAuthors - Delete BooksAuthors relations of a book.
+func (s *gormStore) RemoveBookAuthors(isbn string, authorIDs []uuid.UUID) error {
+	if len(authorIDs) == 0 {
+		return nil
+	}
+
+	return s.db.Unscoped().
+		Where("book_isbn = ? AND author_id IN (?)", isbn, authorIDs).
+		Delete(&BooksAuthors{}).Error
+}
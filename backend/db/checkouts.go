@@ -0,0 +1,135 @@
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/satori/go.uuid"
+	"strconv"
+	"time"
+)
+
+// CheckoutFilter - Narrows ListCheckouts by due date, zero values don't filter.
+type CheckoutFilter struct {
+	OpenOnly  bool
+	DueAfter  *time.Time
+	DueBefore *time.Time
+}
+
+// checkoutList - Filters and sort orders of checkout lists.
+var checkoutList = listSpec{
+	Key:         sortField{Column: "id", Kind: kindInt},
+	DefaultSort: []string{"id"},
+	Sorts: map[string]sortField{
+		"id":          {Column: "id", Kind: kindInt},
+		"checked_out": {Column: "checked_out", Kind: kindTime},
+		"due_at":      {Column: "due_at", Kind: kindTime, Nullable: true},
+		"returned":    {Column: "returned", Kind: kindTime, Nullable: true},
+	},
+	Filters: map[string]filterFunc{
+		"member_id": func(query *gorm.DB, value string) (*gorm.DB, error) {
+			id, err := uuid.FromString(value)
+			if err != nil {
+				return nil, invalidList("member_id must be a uuid")
+			}
+			return query.Where("member_id = ?", id), nil
+		},
+		"book_id": func(query *gorm.DB, value string) (*gorm.DB, error) {
+			id, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return nil, invalidList("book_id must be a copy id")
+			}
+			return query.Where("book_id = ?", id), nil
+		},
+		"returned": isNotNull("returned"),
+		"lost":     isNotNull("lost_at"),
+	},
+}
+
+// GetCheckout - Retrieve a single checkout by its loan ID.
+func (s *gormStore) GetCheckout(id uint) (Checkout, error) {
+	var checkout Checkout
+	err := s.db.Where("id = ?", id).First(&checkout).Error
+	return checkout, notFound(err)
+}
+
+// GetCheckoutForUpdate - Retrieve and lock a single checkout. Call inside Transaction before
+// closing or extending the loan, so it can't be returned or charged twice.
+func (s *gormStore) GetCheckoutForUpdate(id uint) (Checkout, error) {
+	var checkout Checkout
+	err := s.forUpdate().Where("id = ?", id).First(&checkout).Error
+	return checkout, notFound(err)
+}
+
+// GetOpenCheckout - Retrieve the not yet returned checkout of a copy by a member.
+func (s *gormStore) GetOpenCheckout(bookID uint, memberID uuid.UUID) (Checkout, error) {
+	var checkout Checkout
+	err := s.db.
+		Where("book_id = ? AND member_id = ? AND returned IS NULL", bookID, memberID).
+		First(&checkout).Error
+	return checkout, notFound(err)
+}
+
+// GetOpenCheckoutByCopy - Retrieve the not yet returned checkout of a copy.
+func (s *gormStore) GetOpenCheckoutByCopy(copyID uint) (Checkout, error) {
+	var checkout Checkout
+	err := s.db.
+		Where("book_id = ? AND returned IS NULL", copyID).
+		First(&checkout).Error
+	return checkout, notFound(err)
+}
+
+// GetCheckoutsByMember - Retrieve the loan history of a member, newest first.
+func (s *gormStore) GetCheckoutsByMember(memberID uuid.UUID) ([]Checkout, error) {
+	var checkouts []Checkout
+	err := s.db.
+		Where("member_id = ?", memberID).
+		Order("checked_out DESC").
+		Find(&checkouts).Error
+	return checkouts, err
+}
+
+// GetCheckoutsByCopy - Retrieve the loan history of a book copy, newest first.
+func (s *gormStore) GetCheckoutsByCopy(copyID uint) ([]Checkout, error) {
+	var checkouts []Checkout
+	err := s.db.
+		Where("book_id = ?", copyID).
+		Order("checked_out DESC").
+		Find(&checkouts).Error
+	return checkouts, err
+}
+
+// ListCheckouts - Retrieve a page of the checkouts matching a due date filter.
+func (s *gormStore) ListCheckouts(filter CheckoutFilter, q ListQuery) ([]Checkout, Page, error) {
+	query := s.db
+	if filter.OpenOnly {
+		query = query.Where("returned IS NULL")
+	}
+	if filter.DueAfter != nil {
+		query = query.Where("due_at >= ?", *filter.DueAfter)
+	}
+	if filter.DueBefore != nil {
+		query = query.Where("due_at < ?", *filter.DueBefore)
+	}
+
+	var checkouts []Checkout
+	page, err := checkoutList.list(query, q, &checkouts)
+	return checkouts, page, err
+}
+
+// CountOpenCheckouts - Count the loans a member hasn't returned yet.
+func (s *gormStore) CountOpenCheckouts(memberID uuid.UUID) (int, error) {
+	count := 0
+	err := s.db.Model(&Checkout{}).
+		Where("member_id = ? AND returned IS NULL", memberID).
+		Count(&count).Error
+	return count, err
+}
+
+// CreateCheckout - Insert a new checkout record, filling in its loan ID.
+func (s *gormStore) CreateCheckout(checkout *Checkout) error {
+	return s.db.Create(checkout).Error
+}
+
+// UpdateCheckout - Apply column updates to a checkout.
+func (s *gormStore) UpdateCheckout(id uint, updates map[string]interface{}) error {
+	return s.db.Model(&Checkout{}).Where("id = ?", id).Updates(updates).Error
+}
@@ -0,0 +1,156 @@
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/t-tiger/gorm-bulk-insert"
+)
+
+// GetCopiesByISBNs - Retrieve all copies for a range of ISBNs.
+func (s *gormStore) GetCopiesByISBNs(isbns []string) ([]Copy, error) {
+	var copies []Copy
+	err := s.db.Where("isbn IN (?)", isbns).Find(&copies).Error
+	return copies, err
+}
+
+// GetBookCopies - Retrieve the copies of a book, withdrawn ones only when asked for.
+func (s *gormStore) GetBookCopies(isbn string, withWithdrawn bool) ([]Copy, error) {
+	query := s.db.Where("isbn = ?", isbn)
+	if !withWithdrawn {
+		query = query.Where("status <> ?", CopyWithdrawn)
+	}
+
+	var copies []Copy
+	err := query.Order("id").Find(&copies).Error
+	return copies, err
+}
+
+// GetCopy - Retrieve a single copy by id.
+func (s *gormStore) GetCopy(id uint) (Copy, error) {
+	var bookCopy Copy
+	err := s.db.Where("id = ?", id).First(&bookCopy).Error
+	return bookCopy, notFound(err)
+}
+
+// GetCopyForUpdate - Retrieve and lock a single copy by id. Call inside Transaction before
+// changing the copy based on its status, so concurrent desks see each other's changes.
+func (s *gormStore) GetCopyForUpdate(id uint) (Copy, error) {
+	var bookCopy Copy
+	err := s.forUpdate().Where("id = ?", id).First(&bookCopy).Error
+	return bookCopy, notFound(err)
+}
+
+// GetCopyByBarcode - Retrieve a single copy by its barcode.
+func (s *gormStore) GetCopyByBarcode(barcode string) (Copy, error) {
+	var bookCopy Copy
+	err := s.db.Where("barcode = ?", barcode).First(&bookCopy).Error
+	return bookCopy, notFound(err)
+}
+
+// GetCopyByBarcodeForUpdate - Retrieve and lock a single copy by its barcode, like GetCopyForUpdate.
+func (s *gormStore) GetCopyByBarcodeForUpdate(barcode string) (Copy, error) {
+	var bookCopy Copy
+	err := s.forUpdate().Where("barcode = ?", barcode).First(&bookCopy).Error
+	return bookCopy, notFound(err)
+}
+
+// GetCopiesWithoutBarcode - Retrieve copies that haven't been labelled yet, oldest first.
+func (s *gormStore) GetCopiesWithoutBarcode() ([]Copy, error) {
+	var copies []Copy
+	err := s.db.Where("barcode IS NULL").Order("id").Find(&copies).Error
+	return copies, err
+}
+
+// GetLastCopyBarcode - Retrieve and lock the highest copy barcode starting with prefix, empty if
+// there's none. Call inside Transaction so concurrent creates can't hand out the same barcode.
+func (s *gormStore) GetLastCopyBarcode(prefix string) (string, error) {
+	return lastBarcode(s.forUpdate().Model(&Copy{}), prefix)
+}
+
+// lastBarcode - Highest barcode starting with prefix in the query's table.
+func lastBarcode(query *gorm.DB, prefix string) (string, error) {
+	var barcodes []string
+	err := query.
+		Where("barcode LIKE ?", prefix+"%").
+		Order("barcode DESC").
+		Limit(1).
+		Pluck("barcode", &barcodes).Error
+	if err != nil || len(barcodes) == 0 {
+		return "", err
+	}
+
+	return barcodes[0], nil
+}
+
+// CreateCopies - Insert count new copies of a book.
+func (s *gormStore) CreateCopies(isbn string, count int) error {
+	var copyRecords []interface{}
+	for i := 0; i < count; i++ {
+		copyRecords = append(copyRecords, Copy{ISBN: isbn, Status: CopyOnShelf})
+	}
+
+	return gormbulk.BulkInsert(s.db, copyRecords, 3000)
+}
+
+// CreateCopy - Insert a single copy, filling in its id.
+func (s *gormStore) CreateCopy(bookCopy *Copy) error {
+	return s.db.Create(bookCopy).Error
+}
+
+// UpdateCopy - Apply column updates to a copy.
+func (s *gormStore) UpdateCopy(id uint, updates map[string]interface{}) error {
+	return s.db.Model(&Copy{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// forUpdate - Lock selected rows until the transaction ends (SQLite already serializes writers).
+func (s *gormStore) forUpdate() *gorm.DB {
+	if s.db.Dialect().GetName() == sqliteDialect {
+		return s.db
+	}
+
+	return s.db.Set("gorm:query_option", "FOR UPDATE")
+}
+
+// openCheckoutCopyIDs - Sub query of copy IDs that currently have an open checkout. Lost copies
+// are kept off the shelf by their status, so one that's found can circulate again.
+func (s *gormStore) openCheckoutCopyIDs() interface{} {
+	return s.db.Table("checkouts").
+		Select("book_id").
+		Where("returned IS NULL").
+		QueryExpr()
+}
+
+// readyHoldCopyIDs - Sub query of copy IDs set aside for a member's hold.
+func (s *gormStore) readyHoldCopyIDs() interface{} {
+	return s.db.Table("holds").
+		Select("copy_id").
+		Where("status = ? AND copy_id IS NOT NULL", HoldReady).
+		QueryExpr()
+}
+
+// GetAvailableCopy - Retrieve and lock an on shelf copy of a book without an open checkout or ready hold.
+// Call inside Transaction so concurrent checkouts can't pick the same copy.
+func (s *gormStore) GetAvailableCopy(isbn string) (Copy, error) {
+	var bookCopy Copy
+	err := s.forUpdate().
+		Where("isbn = ? AND status = ?", isbn, CopyOnShelf).
+		Where("id NOT IN (?)", s.openCheckoutCopyIDs()).
+		Where("id NOT IN (?)", s.readyHoldCopyIDs()).
+		Order("id").
+		First(&bookCopy).Error
+	return bookCopy, notFound(err)
+}
+
+// GetCopyStatusChanges - Retrieve the status history of a copy, oldest first.
+func (s *gormStore) GetCopyStatusChanges(copyID uint) ([]CopyStatusChange, error) {
+	var changes []CopyStatusChange
+	err := s.db.
+		Where(&CopyStatusChange{CopyID: copyID}).
+		Order("id").
+		Find(&changes).Error
+	return changes, err
+}
+
+// CreateCopyStatusChange - Insert a step of a copy's status history.
+func (s *gormStore) CreateCopyStatusChange(change *CopyStatusChange) error {
+	return s.db.Create(change).Error
+}
@@ -1,38 +1,37 @@
 package db
 
 import (
+	"errors"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/mysql"
 	"log"
-	"os"
 	"time"
 )
 
-var mysqlConnectString = os.Getenv("MYSQL_CONNECT_STRING")
-
 // addConstraints - Create some additional constraints that are less readable in annotations..
-func addConstraints(db *gorm.DB) *gorm.DB {
+// Keys already in place are skipped, so it can run again over existing tables.
+func addConstraints(db *gorm.DB) error {
+	if db.Dialect().GetName() == sqliteDialect {
+		return addSQLiteConstraints(db).Error
+	}
+
 	log.Println("adding db table constraints")
-	db.Table("books_authors").AddForeignKey(
-		"book_isbn",
-		"books(isbn)",
-		"CASCADE",
-		"CASCADE",
-	)
-	db.Table("books_authors").AddForeignKey(
-		"author_id",
-		"authors(id)",
-		"CASCADE",
-		"CASCADE",
-	)
-	db.Model(&Checkout{}).AddForeignKey(
-		"member_id",
-		"members(id)",
-		"CASCADE",
-		"CASCADE",
-	)
+	foreignKeys := []struct {
+		table string
+		field string
+		dest  string
+	}{
+		{"books_authors", "book_isbn", "books(isbn)"},
+		{"books_authors", "author_id", "authors(id)"},
+		{"checkouts", "member_id", "members(id)"},
+	}
+	for _, fk := range foreignKeys {
+		if err := db.Table(fk.table).AddForeignKey(fk.field, fk.dest, "CASCADE", "CASCADE").Error; err != nil {
+			return err
+		}
+	}
 
-	return db
+	return nil
 }
 
 // logTableCreated - Util func to log successful table creations.
@@ -40,83 +39,31 @@ func logTableCreated(s string) {
 	log.Printf("DB:: successfully created '%s' table", s)
 }
 
-// initTables - Initialize all tables we need if not already present.
-func initTables(db *gorm.DB) *gorm.DB {
-	hasCheckout := db.HasTable(&Checkout{})
-	if !hasCheckout {
-		db.CreateTable(Checkout{})
-		logTableCreated("checkouts")
-	}
-
-	hasAuthors := db.HasTable(&Author{})
-	if !hasAuthors {
-		db.CreateTable(Author{})
-		logTableCreated("authors")
-	}
-
-	hasMembers := db.HasTable(&Member{})
-	if !hasMembers {
-		db.CreateTable(Member{})
-		logTableCreated("members")
-	}
-
-	hasEvents := db.HasTable(&Event{})
-	if !hasEvents {
-		db.CreateTable(Event{})
-		logTableCreated("events")
-	}
-
-	hasBooks := db.HasTable(&Book{})
-	if !hasBooks {
-		db.CreateTable(Book{})
-		logTableCreated("books")
-	}
-
-	hasCopies := db.HasTable(&Copy{})
-	if !hasCopies {
-		db.CreateTable(Copy{})
-		logTableCreated("copies")
-	}
-
-	// If the db is brand new, setup constraints.
-	if !hasAuthors || !hasEvents || !hasBooks {
-		return addConstraints(db)
-	}
-
-	return db
-}
-
-// getClient - Util function to create mysql gorm client (deferred Close() in root/db.go).
-func getClient() *gorm.DB {
+// getClient - Util function to create mysql gorm client, retrying while the database comes up.
+func getClient(connectString string) (*gorm.DB, error) {
 	interval := time.Duration(3) * time.Second
 	retries := 10
 
 	// Sometimes MySQL takes a little bit to be ready for connections.
-	var client *gorm.DB
 	for {
-		if retries == 0 {
-			// Exit application, docker-compose will restart to try again.
-			panic("Could not establish connection to database")
-		}
-
-		if client != nil {
-			break
+		client, err := gorm.Open("mysql", connectString)
+		if err == nil {
+			log.Println("db connection loop finished.")
+			return client, nil
 		}
 
-		db, err := gorm.Open("mysql", mysqlConnectString)
-		if err == nil {
-			client = db
-			break
+		retries--
+		if retries == 0 {
+			// Let the caller exit, docker-compose will restart to try again.
+			return nil, errors.New("could not establish connection to database")
 		}
 
 		log.Printf("Couldn't establish connection to db %d retries left", retries)
 		time.Sleep(interval)
-		retries--
 	}
-
-	log.Println("db connection loop finished.")
-	return initTables(client)
 }
 
-// Create global mysql gorm client
-var MySQL = getClient()
+// OpenMySQL - Connect to MySQL (Close() deferred in main.go), run MigrateUp before use.
+func OpenMySQL(connectString string) (*gorm.DB, error) {
+	return getClient(connectString)
+}
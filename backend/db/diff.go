@@ -0,0 +1,225 @@
+package db
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// SystemActor - Actor of changes made by the server itself, e.g. seeding.
+const SystemActor = "system"
+
+// FieldChange - The value of a field before and after a change, null when it didn't exist.
+// List fields also say which items were added and removed.
+type FieldChange struct {
+	Before  interface{}   `json:"before"`
+	After   interface{}   `json:"after"`
+	Added   []interface{} `json:"added,omitempty"`
+	Removed []interface{} `json:"removed,omitempty"`
+}
+
+// Diff - The fields an event changed, by their json name.
+type Diff map[string]FieldChange
+
+// ignoredFields - Fields every change touches, left out of diffs.
+var ignoredFields = map[string]bool{
+	"updated_at": true,
+}
+
+// Value - Store a diff as json, NULL when empty.
+func (d Diff) Value() (driver.Value, error) {
+	if len(d) == 0 {
+		return nil, nil
+	}
+
+	raw, err := json.Marshal(d)
+	return string(raw), err
+}
+
+// Scan - Read a diff back from its json.
+func (d *Diff) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case nil:
+		*d = nil
+		return nil
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return errors.New("can't scan diff from " + reflect.TypeOf(value).String())
+	}
+	if len(raw) == 0 {
+		*d = nil
+		return nil
+	}
+
+	return json.Unmarshal(raw, d)
+}
+
+// fieldsOf - The json fields of an entity, none for nil.
+func fieldsOf(entity interface{}) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if entity == nil || (reflect.ValueOf(entity).Kind() == reflect.Ptr && reflect.ValueOf(entity).IsNil()) {
+		return fields, nil
+	}
+
+	raw, err := json.Marshal(entity)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(raw, &fields)
+	return fields, err
+}
+
+// NewDiff - Compare the json fields of an entity before and after a change. before is nil
+// for creations and after is nil for deletions.
+func NewDiff(before, after interface{}) (Diff, error) {
+	old, err := fieldsOf(before)
+	if err != nil {
+		return nil, err
+	}
+	current, err := fieldsOf(after)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := Diff{}
+	for name, value := range old {
+		if !ignoredFields[name] && !reflect.DeepEqual(value, current[name]) {
+			diff[name] = FieldChange{Before: value, After: current[name]}
+		}
+	}
+	for name, value := range current {
+		if _, ok := old[name]; !ok && !ignoredFields[name] && value != nil {
+			diff[name] = FieldChange{Before: nil, After: value}
+		}
+	}
+
+	return diff, nil
+}
+
+// NewEvent - An event for action on an entity, with the fields it changed. Copy, checkout
+// and hold events also get the isbn and copy id they concern when the entity has them.
+func NewEvent(entityType EntityType, entityID string, action BookEventType, before, after interface{}) (Event, error) {
+	changes, err := NewDiff(before, after)
+	if err != nil {
+		return Event{}, err
+	}
+
+	now := time.Now()
+	event := Event{
+		Base: Base{
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		EventType:  action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Changes:    changes,
+	}
+
+	subject := after
+	if subject == nil {
+		subject = before
+	}
+	switch v := subject.(type) {
+	case Copy:
+		event.ISBN, event.BookID = v.ISBN, v.ID
+	case Checkout:
+		event.BookID = v.BookID
+	case Hold:
+		event.ISBN = v.ISBN
+		if v.CopyID != nil {
+			event.BookID = *v.CopyID
+		}
+	}
+
+	return event, nil
+}
+
+// bookState - The fields of a book its events track, authors by id.
+type bookState struct {
+	ISBN             string   `json:"isbn"`
+	Title            string   `json:"title"`
+	ImageURL         string   `json:"image_url"`
+	Description      string   `json:"description"`
+	ReplacementCents int64    `json:"replacement_cents"`
+	AuthorIDs        []string `json:"author_ids"`
+}
+
+// stateOfBook - The tracked fields of a book, nil when there's no book.
+func stateOfBook(book *Book) interface{} {
+	if book == nil {
+		return nil
+	}
+
+	state := bookState{
+		ISBN:             book.ISBN,
+		Title:            book.Title,
+		ImageURL:         book.ImageURL,
+		Description:      book.Description,
+		ReplacementCents: book.ReplacementCents,
+		AuthorIDs:        []string{},
+	}
+	for _, author := range book.Authors {
+		state.AuthorIDs = append(state.AuthorIDs, author.ID.String())
+	}
+	sort.Strings(state.AuthorIDs)
+
+	return state
+}
+
+// listChanges - Items of after missing from before, and items of before missing from after.
+func listChanges(before, after interface{}) (added, removed []interface{}) {
+	old, _ := before.([]interface{})
+	current, _ := after.([]interface{})
+	contains := func(items []interface{}, item interface{}) bool {
+		for _, i := range items {
+			if reflect.DeepEqual(i, item) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, item := range current {
+		if !contains(old, item) {
+			added = append(added, item)
+		}
+	}
+	for _, item := range old {
+		if !contains(current, item) {
+			removed = append(removed, item)
+		}
+	}
+
+	return added, removed
+}
+
+// NewBookEvent - A single event for a change to a book, whatever number of copies it has,
+// with the fields that changed and the author ids added and removed. before is nil for
+// creations and after is nil for deletions.
+func NewBookEvent(action BookEventType, before, after *Book) (Event, error) {
+	book := after
+	if book == nil {
+		book = before
+	}
+
+	event, err := NewEvent(EntityBook, book.ISBN, action, stateOfBook(before), stateOfBook(after))
+	if err != nil {
+		return event, err
+	}
+	if change, ok := event.Changes["author_ids"]; ok {
+		change.Added, change.Removed = listChanges(change.Before, change.After)
+		event.Changes["author_ids"] = change
+	}
+	event.ISBN = book.ISBN
+	event.BaseBook = book.BaseBook
+
+	return event, nil
+}
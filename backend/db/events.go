@@ -0,0 +1,97 @@
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+	"main/isbn"
+	"strconv"
+	"strings"
+)
+
+// eventList - Filters and sort orders of event lists.
+var eventList = listSpec{
+	Key:         sortField{Column: "id", Kind: kindInt},
+	DefaultSort: []string{"id"},
+	Sorts: map[string]sortField{
+		"id":          {Column: "id", Kind: kindInt},
+		"created_at":  {Column: "created_at", Kind: kindTime},
+		"event_type":  {Column: "event_type"},
+		"entity_type": {Column: "entity_type"},
+		"isbn":        {Column: "isbn"},
+	},
+	Filters: map[string]filterFunc{
+		"event_type": func(query *gorm.DB, value string) (*gorm.DB, error) {
+			return query.Where("event_type = ?", strings.ToUpper(value)), nil
+		},
+		"isbn": func(query *gorm.DB, value string) (*gorm.DB, error) {
+			parsed, err := isbn.Parse(value)
+			if err != nil {
+				return nil, invalidList("isbn: %s", err.Error())
+			}
+			return query.Where("isbn = ?", parsed), nil
+		},
+		"book_id": func(query *gorm.DB, value string) (*gorm.DB, error) {
+			id, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return nil, invalidList("book_id must be a copy id")
+			}
+			return query.Where("book_id = ?", id), nil
+		},
+		"entity_type": func(query *gorm.DB, value string) (*gorm.DB, error) {
+			return query.Where("entity_type IN (?)", strings.Split(value, ",")), nil
+		},
+		"entity_id": equals("entity_id"),
+		"actor":     equals("actor"),
+	},
+}
+
+// ListEvents - Retrieve a page of events from the events table, oldest first by default.
+func (s *gormStore) ListEvents(q ListQuery) ([]Event, Page, error) {
+	var events []Event
+	page, err := eventList.list(s.db, q, &events)
+	return events, page, err
+}
+
+// GetEventsAfter - Up to limit events recorded after the event with id afterID, oldest first,
+// filtered like event lists.
+func (s *gormStore) GetEventsAfter(afterID uint, filters map[string]string, limit int) ([]Event, error) {
+	query, err := eventList.filter(s.db.Where("id > ?", afterID), filters)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	err = query.Order("id").Limit(limit).Find(&events).Error
+	return events, err
+}
+
+// GetLastEventID - The id of the latest event, 0 when there are none.
+func (s *gormStore) GetLastEventID() (uint, error) {
+	var event Event
+	err := s.db.Select("id").Order("id DESC").First(&event).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return 0, nil
+	}
+
+	return event.ID, err
+}
+
+// GetEventsByISBN - Retrieve all events for a book.
+func (s *gormStore) GetEventsByISBN(isbn string) ([]Event, error) {
+	var events []Event
+	err := s.db.Where("isbn = ?", isbn).Find(&events).Error
+	return events, err
+}
+
+// CreateEvent - Insert an event, recorded as done by the store's actor unless it names one,
+// and queue it for the webhooks subscribed to it.
+func (s *gormStore) CreateEvent(event *Event) error {
+	if event.Actor == "" {
+		event.Actor = s.eventActor()
+	}
+
+	if err := s.db.Create(event).Error; err != nil {
+		return err
+	}
+
+	return s.enqueueWebhookDeliveries(*event)
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"github.com/satori/go.uuid"
+	"time"
+)
+
+// activeHoldStatuses - Holds still in the queue or waiting for pickup.
+var activeHoldStatuses = []HoldStatus{HoldWaiting, HoldReady}
+
+// GetHold - Retrieve a single hold by id.
+func (s *gormStore) GetHold(id uint) (Hold, error) {
+	var hold Hold
+	err := s.db.Where("id = ?", id).First(&hold).Error
+	return hold, notFound(err)
+}
+
+// GetHoldsByISBN - Retrieve the active hold queue of a book, first in line first.
+func (s *gormStore) GetHoldsByISBN(isbn string) ([]Hold, error) {
+	var holds []Hold
+	err := s.db.
+		Where("isbn = ? AND status IN (?)", isbn, activeHoldStatuses).
+		Order("id").
+		Find(&holds).Error
+	return holds, err
+}
+
+// GetHoldsByMember - Retrieve every hold a member has placed, newest first.
+func (s *gormStore) GetHoldsByMember(memberID uuid.UUID) ([]Hold, error) {
+	var holds []Hold
+	err := s.db.
+		Where("member_id = ?", memberID).
+		Order("id DESC").
+		Find(&holds).Error
+	return holds, err
+}
+
+// GetActiveHold - Retrieve a member's waiting or ready hold on a book.
+func (s *gormStore) GetActiveHold(isbn string, memberID uuid.UUID) (Hold, error) {
+	var hold Hold
+	err := s.db.
+		Where("isbn = ? AND member_id = ?", isbn, memberID).
+		Where("status IN (?)", activeHoldStatuses).
+		First(&hold).Error
+	return hold, notFound(err)
+}
+
+// GetNextHold - Retrieve and lock the first waiting hold on a book.
+func (s *gormStore) GetNextHold(isbn string) (Hold, error) {
+	var hold Hold
+	err := s.forUpdate().
+		Where("isbn = ? AND status = ?", isbn, HoldWaiting).
+		Order("id").
+		First(&hold).Error
+	return hold, notFound(err)
+}
+
+// GetCopyHold - Retrieve the ready hold a copy is set aside for.
+func (s *gormStore) GetCopyHold(copyID uint) (Hold, error) {
+	var hold Hold
+	err := s.db.
+		Where("copy_id = ? AND status = ?", copyID, HoldReady).
+		First(&hold).Error
+	return hold, notFound(err)
+}
+
+// GetReadyHoldCopyIDs - Retrieve the ids of the copies of some books set aside for a ready hold.
+func (s *gormStore) GetReadyHoldCopyIDs(isbns []string) ([]uint, error) {
+	var ids []uint
+	err := s.db.Model(&Hold{}).
+		Where("isbn IN (?) AND status = ? AND copy_id IS NOT NULL", isbns, HoldReady).
+		Pluck("copy_id", &ids).Error
+	return ids, err
+}
+
+// GetExpiredHolds - Retrieve ready holds whose pickup window closed before now.
+func (s *gormStore) GetExpiredHolds(now time.Time) ([]Hold, error) {
+	var holds []Hold
+	err := s.forUpdate().
+		Where("status = ? AND expires_at < ?", HoldReady, now).
+		Order("id").
+		Find(&holds).Error
+	return holds, err
+}
+
+// CreateHold - Insert a new hold, filling in its id.
+func (s *gormStore) CreateHold(hold *Hold) error {
+	return s.db.Create(hold).Error
+}
+
+// UpdateHold - Apply column updates to a hold.
+func (s *gormStore) UpdateHold(id uint, updates map[string]interface{}) error {
+	return s.db.Model(&Hold{}).Where("id = ?", id).Updates(updates).Error
+}
@@ -0,0 +1,31 @@
+package db
+
+import (
+	"github.com/satori/go.uuid"
+)
+
+// GetLedgerEntries - Retrieve a member's account ledger, newest first.
+func (s *gormStore) GetLedgerEntries(memberID uuid.UUID) ([]LedgerEntry, error) {
+	var entries []LedgerEntry
+	err := s.db.
+		Where(&LedgerEntry{MemberID: memberID}).
+		Order("id DESC").
+		Find(&entries).Error
+	return entries, err
+}
+
+// GetLedgerBalance - Sum a member's ledger, positive means the member owes the library.
+func (s *gormStore) GetLedgerBalance(memberID uuid.UUID) (int64, error) {
+	var balance int64
+	err := s.db.Model(&LedgerEntry{}).
+		Where(&LedgerEntry{MemberID: memberID}).
+		Select("COALESCE(SUM(amount_cents), 0)").
+		Row().
+		Scan(&balance)
+	return balance, err
+}
+
+// CreateLedgerEntry - Insert a new ledger entry, filling in its id.
+func (s *gormStore) CreateLedgerEntry(entry *LedgerEntry) error {
+	return s.db.Create(entry).Error
+}
@@ -0,0 +1,385 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page size limits of list queries.
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 200
+)
+
+// ErrInvalidListQuery - A list query names an unknown filter or sort field, or has a bad value or cursor.
+var ErrInvalidListQuery = errors.New("invalid list query")
+
+// ListQuery - Filters, sort order and page of a list request.
+type ListQuery struct {
+	Filters map[string]string // Filter name to value, e.g. returned=false.
+	Sort    []string          // Sort fields, a leading - sorts descending.
+	Limit   int               // Page size, DefaultListLimit when 0.
+	Cursor  string            // Opaque position from a previous Page, empty for the first page.
+}
+
+// Page - Where a page of results sits in the whole list.
+type Page struct {
+	Total int    // Rows matching the filters, over all pages.
+	Limit int    // Page size used.
+	Next  string // Cursor of the following page, empty on the last one.
+	Prev  string // Cursor of the preceding page, empty on the first one.
+}
+
+// fieldKind - How a sort field's values are carried in cursors.
+type fieldKind int
+
+const (
+	kindString fieldKind = iota
+	kindInt
+	kindTime
+)
+
+// sortField - A column rows can be ordered by.
+type sortField struct {
+	Column   string
+	Kind     fieldKind
+	Nullable bool
+}
+
+// filterFunc - Narrows a query by a filter value.
+type filterFunc func(query *gorm.DB, value string) (*gorm.DB, error)
+
+// listSpec - How the rows of a table can be filtered, sorted and paged.
+type listSpec struct {
+	Key         sortField // Unique column ending every sort order, so positions are exact.
+	DefaultSort []string
+	Sorts       map[string]sortField
+	Filters     map[string]filterFunc
+}
+
+// cursor - Sort values of the row a page starts after, or before when Backward.
+type cursor struct {
+	Values   []*string `json:"v"`
+	Backward bool      `json:"b,omitempty"`
+}
+
+// order - A resolved sort field and its direction.
+type order struct {
+	sortField
+	Desc bool
+}
+
+// invalidList - Wrap a message as an ErrInvalidListQuery.
+func invalidList(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidListQuery, fmt.Sprintf(format, args...))
+}
+
+// equals - Filter matching a column exactly.
+func equals(column string) filterFunc {
+	return func(query *gorm.DB, value string) (*gorm.DB, error) {
+		return query.Where(column+" = ?", value), nil
+	}
+}
+
+// isNull - Filter on whether a column is set, "true" matching rows where it's NULL.
+func isNull(column string) filterFunc {
+	return func(query *gorm.DB, value string) (*gorm.DB, error) {
+		null, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, invalidList("%s must be true or false", column)
+		}
+		if null {
+			return query.Where(column + " IS NULL"), nil
+		}
+		return query.Where(column + " IS NOT NULL"), nil
+	}
+}
+
+// isNotNull - Filter on whether a column is set, "true" matching rows where it isn't NULL.
+func isNotNull(column string) filterFunc {
+	return func(query *gorm.DB, value string) (*gorm.DB, error) {
+		set, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, invalidList("%s must be true or false", column)
+		}
+		return isNull(column)(query, strconv.FormatBool(!set))
+	}
+}
+
+// orders - Resolve the requested sort fields, ending with the spec's unique key.
+func (spec listSpec) orders(fields []string) ([]order, error) {
+	if len(fields) == 0 {
+		fields = spec.DefaultSort
+	}
+
+	var orders []order
+	hasKey := false
+	for _, name := range fields {
+		desc := strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+		field, ok := spec.Sorts[name]
+		if !ok {
+			return nil, invalidList("can't sort by %q", name)
+		}
+		if hasKey {
+			return nil, invalidList("can't sort by %q after the unique %q", name, spec.Key.Column)
+		}
+		orders = append(orders, order{field, desc})
+		hasKey = field.Column == spec.Key.Column
+	}
+	if !hasKey {
+		orders = append(orders, order{spec.Key, false})
+	}
+
+	return orders, nil
+}
+
+// encodeValue - Carry a column value of a row in a cursor, nil for NULL.
+func encodeValue(value interface{}) *string {
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		value = v.Elem().Interface()
+	}
+
+	var s string
+	switch typed := value.(type) {
+	case time.Time:
+		s = typed.UTC().Format(time.RFC3339Nano)
+	default:
+		s = fmt.Sprint(typed)
+	}
+	return &s
+}
+
+// decodeValue - Turn a cursor value back into a query argument.
+func decodeValue(field sortField, value *string) (interface{}, error) {
+	if value == nil {
+		if !field.Nullable {
+			return nil, invalidList("bad cursor")
+		}
+		return nil, nil
+	}
+
+	switch field.Kind {
+	case kindInt:
+		n, err := strconv.ParseInt(*value, 10, 64)
+		if err != nil {
+			return nil, invalidList("bad cursor")
+		}
+		return n, nil
+	case kindTime:
+		t, err := time.Parse(time.RFC3339Nano, *value)
+		if err != nil {
+			return nil, invalidList("bad cursor")
+		}
+		return t.Local(), nil
+	default:
+		return *value, nil
+	}
+}
+
+// encodeCursor - Opaque form of a position in a list.
+func encodeCursor(c cursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeCursor - Read a cursor back, checking it fits the sort order.
+func decodeCursor(s string, orders []order) (cursor, []interface{}, error) {
+	var c cursor
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(raw, &c) != nil || len(c.Values) != len(orders) {
+		return c, nil, invalidList("bad cursor")
+	}
+
+	args := make([]interface{}, len(orders))
+	for i, o := range orders {
+		if args[i], err = decodeValue(o.sortField, c.Values[i]); err != nil {
+			return c, nil, err
+		}
+	}
+
+	return c, args, nil
+}
+
+// orderClause - ORDER BY terms, NULLs sorting after every value.
+func orderClause(orders []order, reverse bool) string {
+	var terms []string
+	for _, o := range orders {
+		desc := o.Desc != reverse
+		if o.Nullable {
+			if desc {
+				terms = append(terms, o.Column+" IS NULL DESC")
+			} else {
+				terms = append(terms, o.Column+" IS NULL")
+			}
+		}
+		if desc {
+			terms = append(terms, o.Column+" DESC")
+		} else {
+			terms = append(terms, o.Column)
+		}
+	}
+
+	return strings.Join(terms, ", ")
+}
+
+// afterClause - Condition for rows that come after a position in the given order,
+// (a > ?) OR (a = ? AND b > ?) ... with NULL counting as the largest value.
+func afterClause(orders []order, values []interface{}, reverse bool) (string, []interface{}) {
+	var alternatives []string
+	var args []interface{}
+	for i, o := range orders {
+		var parts []string
+		var partArgs []interface{}
+		for j := 0; j < i; j++ {
+			if values[j] == nil {
+				parts = append(parts, orders[j].Column+" IS NULL")
+			} else {
+				parts = append(parts, orders[j].Column+" = ?")
+				partArgs = append(partArgs, values[j])
+			}
+		}
+
+		desc := o.Desc != reverse
+		switch {
+		case values[i] == nil && desc:
+			parts = append(parts, o.Column+" IS NOT NULL")
+		case values[i] == nil:
+			continue // Nothing sorts after NULL ascending.
+		case desc:
+			parts = append(parts, o.Column+" < ?")
+			partArgs = append(partArgs, values[i])
+		case o.Nullable:
+			parts = append(parts, "("+o.Column+" > ? OR "+o.Column+" IS NULL)")
+			partArgs = append(partArgs, values[i])
+		default:
+			parts = append(parts, o.Column+" > ?")
+			partArgs = append(partArgs, values[i])
+		}
+
+		alternatives = append(alternatives, "("+strings.Join(parts, " AND ")+")")
+		args = append(args, partArgs...)
+	}
+	if len(alternatives) == 0 {
+		return "1 = 0", nil
+	}
+
+	return "(" + strings.Join(alternatives, " OR ") + ")", args
+}
+
+// rowValues - Cursor values of a row for the sort order.
+func rowValues(query *gorm.DB, row interface{}, orders []order) []*string {
+	scope := query.NewScope(row)
+	values := make([]*string, len(orders))
+	for i, o := range orders {
+		column := o.Column
+		if dot := strings.LastIndex(column, "."); dot >= 0 {
+			column = column[dot+1:]
+		}
+		if field, ok := scope.FieldByName(column); ok {
+			values[i] = encodeValue(field.Field.Interface())
+		}
+	}
+
+	return values
+}
+
+// filter - Narrow a query down with the spec's filters, refusing ones it doesn't have.
+func (spec listSpec) filter(query *gorm.DB, filters map[string]string) (*gorm.DB, error) {
+	for name, value := range filters {
+		filter, ok := spec.Filters[name]
+		if !ok {
+			return nil, invalidList("can't filter by %q", name)
+		}
+		var err error
+		if query, err = filter(query, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return query, nil
+}
+
+// list - Run a filtered, sorted and paged query into out, a pointer to a slice of models.
+func (spec listSpec) list(query *gorm.DB, q ListQuery, out interface{}) (Page, error) {
+	page := Page{Limit: q.Limit}
+	if page.Limit <= 0 {
+		page.Limit = DefaultListLimit
+	}
+	if page.Limit > MaxListLimit {
+		page.Limit = MaxListLimit
+	}
+
+	query, err := spec.filter(query, q.Filters)
+	if err != nil {
+		return page, err
+	}
+
+	orders, err := spec.orders(q.Sort)
+	if err != nil {
+		return page, err
+	}
+
+	if err := query.Model(out).Count(&page.Total).Error; err != nil {
+		return page, err
+	}
+
+	var at cursor
+	if q.Cursor != "" {
+		var values []interface{}
+		if at, values, err = decodeCursor(q.Cursor, orders); err != nil {
+			return page, err
+		}
+		where, args := afterClause(orders, values, at.Backward)
+		query = query.Where(where, args...)
+	}
+
+	// One extra row tells if there's more past this page.
+	err = query.
+		Order(orderClause(orders, at.Backward)).
+		Limit(page.Limit + 1).
+		Find(out).Error
+	if err != nil {
+		return page, err
+	}
+
+	rows := reflect.ValueOf(out).Elem()
+	more := rows.Len() > page.Limit
+	if more {
+		rows.Set(rows.Slice(0, page.Limit))
+	}
+	if at.Backward {
+		for i, j := 0, rows.Len()-1; i < j; i, j = i+1, j-1 {
+			a, b := rows.Index(i).Interface(), rows.Index(j).Interface()
+			rows.Index(i).Set(reflect.ValueOf(b))
+			rows.Index(j).Set(reflect.ValueOf(a))
+		}
+	}
+	if rows.Len() == 0 {
+		return page, nil
+	}
+
+	first := rowValues(query, rows.Index(0).Addr().Interface(), orders)
+	last := rowValues(query, rows.Index(rows.Len()-1).Addr().Interface(), orders)
+	hasNext := more || (at.Backward && q.Cursor != "")
+	hasPrev := (more && at.Backward) || (!at.Backward && q.Cursor != "")
+	if hasNext {
+		page.Next = encodeCursor(cursor{Values: last})
+	}
+	if hasPrev {
+		page.Prev = encodeCursor(cursor{Values: first, Backward: true})
+	}
+
+	return page, nil
+}
@@ -0,0 +1,171 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAfterClause(t *testing.T) {
+	title := sortField{Column: "title", Kind: kindString}
+	dueAt := sortField{Column: "due_at", Kind: kindTime, Nullable: true}
+	id := sortField{Column: "id", Kind: kindInt}
+
+	tests := []struct {
+		name    string
+		orders  []order
+		values  []interface{}
+		reverse bool
+		want    string
+		args    []interface{}
+	}{
+		{
+			"key only",
+			[]order{{id, false}},
+			[]interface{}{int64(7)},
+			false,
+			"((id > ?))",
+			[]interface{}{int64(7)},
+		},
+		{
+			"key only, backward",
+			[]order{{id, false}},
+			[]interface{}{int64(7)},
+			true,
+			"((id < ?))",
+			[]interface{}{int64(7)},
+		},
+		{
+			"descending then key",
+			[]order{{title, true}, {id, false}},
+			[]interface{}{"Emma", int64(3)},
+			false,
+			"((title < ?) OR (title = ? AND id > ?))",
+			[]interface{}{"Emma", "Emma", int64(3)},
+		},
+		{
+			"nullable value, NULLs come last",
+			[]order{{dueAt, false}, {id, false}},
+			[]interface{}{"2020-01-02", int64(3)},
+			false,
+			"(((due_at > ? OR due_at IS NULL)) OR (due_at = ? AND id > ?))",
+			[]interface{}{"2020-01-02", "2020-01-02", int64(3)},
+		},
+		{
+			"at NULL, only the key moves on",
+			[]order{{dueAt, false}, {id, false}},
+			[]interface{}{nil, int64(3)},
+			false,
+			"((due_at IS NULL AND id > ?))",
+			[]interface{}{int64(3)},
+		},
+		{
+			"at NULL, backward every value comes before",
+			[]order{{dueAt, false}, {id, false}},
+			[]interface{}{nil, int64(3)},
+			true,
+			"((due_at IS NOT NULL) OR (due_at IS NULL AND id < ?))",
+			[]interface{}{int64(3)},
+		},
+		{
+			"nothing after NULL",
+			[]order{{dueAt, false}},
+			[]interface{}{nil},
+			false,
+			"1 = 0",
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, args := afterClause(tt.orders, tt.values, tt.reverse)
+			if got != tt.want || !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("afterClause() = %q, %v, want %q, %v", got, args, tt.want, tt.args)
+			}
+		})
+	}
+}
+
+func TestListOrders(t *testing.T) {
+	spec := listSpec{
+		Key:         sortField{Column: "id", Kind: kindInt},
+		DefaultSort: []string{"-created_at"},
+		Sorts: map[string]sortField{
+			"id":         {Column: "id", Kind: kindInt},
+			"title":      {Column: "title", Kind: kindString},
+			"created_at": {Column: "created_at", Kind: kindTime},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		fields []string
+		want   []string
+		err    bool
+	}{
+		{"default sort", nil, []string{"-created_at", "id"}, false},
+		{"key added last", []string{"title"}, []string{"title", "id"}, false},
+		{"key sorted on its own", []string{"-id"}, []string{"-id"}, false},
+		{"fields after the key", []string{"-id", "title"}, nil, true},
+		{"unknown field", []string{"isbn"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orders, err := spec.orders(tt.fields)
+			if (err != nil) != tt.err {
+				t.Fatalf("orders(%v) error = %v", tt.fields, err)
+			}
+			var got []string
+			for _, o := range orders {
+				name := o.Column
+				if o.Desc {
+					name = "-" + name
+				}
+				got = append(got, name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("orders(%v) = %v, want %v", tt.fields, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	orders := []order{
+		{sortField{Column: "due_at", Kind: kindTime, Nullable: true}, false},
+		{sortField{Column: "title", Kind: kindString}, true},
+		{sortField{Column: "id", Kind: kindInt}, false},
+	}
+	due := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	values := []*string{encodeValue(&due), encodeValue("Emma"), encodeValue(uint(42))}
+
+	c, args, err := decodeCursor(encodeCursor(cursor{Values: values, Backward: true}), orders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Backward {
+		t.Error("decodeCursor lost Backward")
+	}
+	if len(args) != 3 || !args[0].(time.Time).Equal(due) || args[1] != "Emma" || args[2] != int64(42) {
+		t.Errorf("decodeCursor args = %v", args)
+	}
+
+	var nilTime *time.Time
+	if encodeValue(nilTime) != nil {
+		t.Error("encodeValue(nil pointer) isn't nil")
+	}
+	bad := []string{
+		"not base64!",
+		encodeCursor(cursor{Values: values[:2]}),
+		encodeCursor(cursor{Values: []*string{nil, encodeValue("Emma"), nil}}),
+		encodeCursor(cursor{Values: []*string{encodeValue("soon"), encodeValue("Emma"), encodeValue(1)}}),
+	}
+	for _, s := range bad {
+		if _, _, err := decodeCursor(s, orders); !errors.Is(err, ErrInvalidListQuery) {
+			t.Errorf("decodeCursor(%q) error = %v, want ErrInvalidListQuery", s, err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"github.com/satori/go.uuid"
+)
+
+// memberList - Filters and sort orders of member lists.
+var memberList = listSpec{
+	Key:         sortField{Column: "id"},
+	DefaultSort: []string{"last_name", "first_name"},
+	Sorts: map[string]sortField{
+		"id":         {Column: "id"},
+		"first_name": {Column: "first_name"},
+		"last_name":  {Column: "last_name"},
+		"category":   {Column: "category"},
+		"created_at": {Column: "created_at", Kind: kindTime},
+	},
+	Filters: map[string]filterFunc{
+		"category":  equals("category"),
+		"last_name": equals("last_name"),
+	},
+}
+
+// ListMembers - Retrieve a page of members, optionally with their open checkouts.
+func (s *gormStore) ListMembers(withOpenCheckouts bool, q ListQuery) ([]Member, Page, error) {
+	var members []Member
+	query := s.db
+	if withOpenCheckouts {
+		query = query.Preload("Checkouts", "returned IS NULL")
+	}
+
+	page, err := memberList.list(query, q, &members)
+	return members, page, err
+}
+
+// GetMember - Retrieve a single member by uuid.
+func (s *gormStore) GetMember(id uuid.UUID) (Member, error) {
+	var member Member
+	err := s.db.Where("id = ?", id).First(&member).Error
+	return member, notFound(err)
+}
+
+// GetMemberByBarcode - Retrieve a single member by their card barcode.
+func (s *gormStore) GetMemberByBarcode(barcode string) (Member, error) {
+	var member Member
+	err := s.db.Where("barcode = ?", barcode).First(&member).Error
+	return member, notFound(err)
+}
+
+// GetMembersWithoutBarcode - Retrieve members without a card barcode, oldest first.
+func (s *gormStore) GetMembersWithoutBarcode() ([]Member, error) {
+	var members []Member
+	err := s.db.Where("barcode IS NULL").Order("created_at").Find(&members).Error
+	return members, err
+}
+
+// GetLastMemberBarcode - Retrieve and lock the highest card barcode starting with prefix, like
+// GetLastCopyBarcode.
+func (s *gormStore) GetLastMemberBarcode(prefix string) (string, error) {
+	return lastBarcode(s.forUpdate().Unscoped().Model(&Member{}), prefix)
+}
+
+// CreateMember - Insert a new member record.
+func (s *gormStore) CreateMember(member *Member) error {
+	return s.db.Create(member).Error
+}
+
+// UpdateMember - Apply column updates to a member.
+func (s *gormStore) UpdateMember(id uuid.UUID, updates map[string]interface{}) error {
+	return s.db.Model(&Member{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// DeleteMember - Soft delete a member.
+func (s *gormStore) DeleteMember(id uuid.UUID) error {
+	return s.db.Where("id = ?", id).Delete(&Member{}).Error
+}
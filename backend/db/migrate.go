@@ -0,0 +1,157 @@
+package db
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"log"
+	"sort"
+	"time"
+)
+
+// Migration - A single versioned schema change with its rollback.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration - A row of the schema_migrations table, one per applied migration.
+type SchemaMigration struct {
+	Version   int       `gorm:"primary_key;auto_increment:false" json:"version"`
+	Name      string    `json:"name"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+func (sm *SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// MigrationStatus - Whether a known migration has been applied.
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+// sortedMigrations - All registered migrations, oldest first.
+func sortedMigrations() []Migration {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+
+	return sorted
+}
+
+// appliedMigrations - Retrieve applied migrations keyed by version, creating schema_migrations if needed.
+func appliedMigrations(db *gorm.DB) (map[int]SchemaMigration, error) {
+	if !db.HasTable(&SchemaMigration{}) {
+		if err := db.CreateTable(&SchemaMigration{}).Error; err != nil {
+			return nil, err
+		}
+		logTableCreated("schema_migrations")
+	}
+
+	var rows []SchemaMigration
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	applied := map[int]SchemaMigration{}
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+
+	return applied, nil
+}
+
+// MigrateUp - Apply every pending migration in version order, returning the ones applied.
+func MigrateUp(db *gorm.DB) ([]Migration, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	for _, m := range sortedMigrations() {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		log.Printf("DB:: applying migration %d_%s", m.Version, m.Name)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+
+			return tx.Create(&SchemaMigration{
+				Version:   m.Version,
+				Name:      m.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return ran, fmt.Errorf("migration %d_%s failed:: %s", m.Version, m.Name, err.Error())
+		}
+
+		ran = append(ran, m)
+	}
+
+	return ran, nil
+}
+
+// MigrateDown - Roll back the most recently applied migrations, returning the ones rolled back.
+func MigrateDown(db *gorm.DB, steps int) ([]Migration, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	sorted := sortedMigrations()
+	var ran []Migration
+	for i := len(sorted) - 1; i >= 0 && len(ran) < steps; i-- {
+		m := sorted[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+
+		log.Printf("DB:: rolling back migration %d_%s", m.Version, m.Name)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+
+			return tx.Delete(&SchemaMigration{Version: m.Version}).Error
+		})
+		if err != nil {
+			return ran, fmt.Errorf("rollback of %d_%s failed:: %s", m.Version, m.Name, err.Error())
+		}
+
+		ran = append(ran, m)
+	}
+
+	return ran, nil
+}
+
+// GetMigrationStatus - Report every registered migration and when it was applied.
+func GetMigrationStatus(db *gorm.DB) ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, m := range sortedMigrations() {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if row, ok := applied[m.Version]; ok {
+			appliedAt := row.AppliedAt
+			status.AppliedAt = &appliedAt
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
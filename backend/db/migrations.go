@@ -0,0 +1,867 @@
+package db
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"github.com/satori/go.uuid"
+	"main/isbn"
+	"strings"
+	"time"
+)
+
+/* 		Migration Helpers
+============================= */
+
+// createTableIfMissing - Create a table from a frozen schema struct, reporting if it was new.
+func createTableIfMissing(tx *gorm.DB, table string, schema interface{}) (bool, error) {
+	if tx.HasTable(table) {
+		return false, nil
+	}
+
+	if err := tx.Table(table).CreateTable(schema).Error; err != nil {
+		return false, err
+	}
+
+	logTableCreated(table)
+	return true, nil
+}
+
+// dropTables - Drop tables in the given order, ignoring ones already gone.
+func dropTables(tx *gorm.DB, tables ...string) error {
+	for _, table := range tables {
+		if err := tx.DropTableIfExists(table).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isSQLite - Whether a migration is running against the sqlite dialect.
+func isSQLite(tx *gorm.DB) bool {
+	return tx.Dialect().GetName() == sqliteDialect
+}
+
+// execAll - Run raw statements in order, stopping at the first error.
+func execAll(tx *gorm.DB, statements ...string) error {
+	for _, stmt := range statements {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rebuildSQLiteTable - SQLite can't alter primary keys, so copy rows into a table with the new schema.
+func rebuildSQLiteTable(tx *gorm.DB, table string, schema interface{}, columns string) error {
+	old := table + "_old"
+
+	// Renaming would repoint the cascade triggers at the old copy, recreate them afterwards.
+	if err := dropSQLiteConstraints(tx); err != nil {
+		return err
+	}
+
+	// Indexes keep their names when a table is renamed, free them up for the new table.
+	var indexes []string
+	err := tx.Table("sqlite_master").
+		Where("type = 'index' AND tbl_name = ? AND sql IS NOT NULL", table).
+		Pluck("name", &indexes).Error
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if err := tx.Exec("DROP INDEX " + index).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Exec("ALTER TABLE " + table + " RENAME TO " + old).Error; err != nil {
+		return err
+	}
+	if err := tx.Table(table).CreateTable(schema).Error; err != nil {
+		return err
+	}
+
+	copyRows := "INSERT INTO " + table + " (" + columns + ") SELECT " + columns + " FROM " + old
+	if err := execAll(tx, copyRows, "DROP TABLE "+old); err != nil {
+		return err
+	}
+
+	addSQLiteConstraints(tx)
+	return nil
+}
+
+// addColumns - Add the columns (and their indexes) of a partial schema struct to a table.
+func addColumns(tx *gorm.DB, table string, schema interface{}) error {
+	return tx.Table(table).AutoMigrate(schema).Error
+}
+
+// sqliteColumn - A row of sqlite's PRAGMA table_info.
+type sqliteColumn struct {
+	Name      string
+	Type      string
+	NotNull   bool    `gorm:"column:notnull"`
+	DfltValue *string `gorm:"column:dflt_value"`
+	Pk        int
+}
+
+// dropColumns - Drop columns from a table, rebuilding it on sqlite which has no DROP COLUMN.
+func dropColumns(tx *gorm.DB, table string, columns ...string) error {
+	if !isSQLite(tx) {
+		var drops []string
+		for _, column := range columns {
+			drops = append(drops, "DROP COLUMN `"+column+"`")
+		}
+		return tx.Exec("ALTER TABLE " + table + " " + strings.Join(drops, ", ")).Error
+	}
+
+	var info []sqliteColumn
+	if err := tx.Raw("PRAGMA table_info(" + table + ")").Scan(&info).Error; err != nil {
+		return err
+	}
+
+	var tableSQL []string
+	err := tx.Table("sqlite_master").
+		Where("type = 'table' AND name = ?", table).
+		Pluck("sql", &tableSQL).Error
+	if err != nil || len(tableSQL) == 0 {
+		return fmt.Errorf("can't read schema of %s:: %v", table, err)
+	}
+	autoIncrement := strings.Contains(strings.ToLower(tableSQL[0]), "autoincrement")
+
+	// Rebuild the column definitions without the dropped columns.
+	var defs, keep, pks []string
+	for _, col := range info {
+		if containsName(columns, col.Name) {
+			continue
+		}
+
+		def := fmt.Sprintf(`"%s" %s`, col.Name, col.Type)
+		if col.Pk > 0 && autoIncrement {
+			def += " primary key autoincrement"
+		} else if col.Pk > 0 {
+			pks = append(pks, fmt.Sprintf(`"%s"`, col.Name))
+		}
+		if col.NotNull {
+			def += " NOT NULL"
+		}
+		if col.DfltValue != nil {
+			def += " DEFAULT " + *col.DfltValue
+		}
+
+		defs = append(defs, def)
+		keep = append(keep, fmt.Sprintf(`"%s"`, col.Name))
+	}
+	if len(pks) > 0 {
+		defs = append(defs, "PRIMARY KEY ("+strings.Join(pks, ",")+")")
+	}
+
+	// Keep the indexes that don't cover a dropped column.
+	type sqliteIndex struct {
+		Name string
+		SQL  string `gorm:"column:sql"`
+	}
+	var indexes []sqliteIndex
+	err = tx.Table("sqlite_master").
+		Select("name, sql").
+		Where("type = 'index' AND tbl_name = ? AND sql IS NOT NULL", table).
+		Scan(&indexes).Error
+	if err != nil {
+		return err
+	}
+
+	// Renaming would repoint the cascade triggers at the old copy, recreate them afterwards.
+	if err := dropSQLiteConstraints(tx); err != nil {
+		return err
+	}
+
+	var statements []string
+	var recreate []string
+	for _, index := range indexes {
+		var indexed []struct{ Name string }
+		if err := tx.Raw("PRAGMA index_info(" + index.Name + ")").Scan(&indexed).Error; err != nil {
+			return err
+		}
+		statements = append(statements, "DROP INDEX "+index.Name)
+
+		dropped := false
+		for _, col := range indexed {
+			dropped = dropped || containsName(columns, col.Name)
+		}
+		if !dropped {
+			recreate = append(recreate, index.SQL)
+		}
+	}
+
+	old := table + "_old"
+	cols := strings.Join(keep, ", ")
+	statements = append(statements,
+		"ALTER TABLE "+table+" RENAME TO "+old,
+		"CREATE TABLE \""+table+"\" ("+strings.Join(defs, ",")+")",
+		"INSERT INTO "+table+" ("+cols+") SELECT "+cols+" FROM "+old,
+		"DROP TABLE "+old,
+	)
+	if err := execAll(tx, append(statements, recreate...)...); err != nil {
+		return err
+	}
+
+	addSQLiteConstraints(tx)
+	return nil
+}
+
+// containsName - Util func to check a list of column names for a name.
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+/* 			Migrations
+=============================
+Schema structs inside a migration are frozen copies of the models at
+the time it was written, so later model changes don't alter old steps. */
+
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial_schema",
+		Up: func(tx *gorm.DB) error {
+			type checkout struct {
+				CreatedAt  time.Time
+				UpdatedAt  time.Time  `gorm:"index"`
+				DeletedAt  *time.Time `gorm:"index"`
+				BookID     uint       `gorm:"index;primary_key;auto_increment:false;"`
+				MemberID   uuid.UUID  `gorm:"index;primary_key;"`
+				CheckedOut time.Time  `gorm:"index;"`
+				Returned   *time.Time `gorm:"index;"`
+			}
+			type person struct {
+				CreatedAt time.Time
+				UpdatedAt time.Time  `gorm:"index"`
+				DeletedAt *time.Time `gorm:"index"`
+				ID        uuid.UUID  `gorm:"index;primary_key;"`
+				FirstName string
+				LastName  string
+				Middle    string
+			}
+			type member struct {
+				CreatedAt time.Time
+				UpdatedAt time.Time  `gorm:"index"`
+				DeletedAt *time.Time `gorm:"index"`
+				ID        uuid.UUID  `gorm:"index;primary_key;"`
+				FirstName string
+				LastName  string
+				Middle    string
+				ImageURL  string `gorm:"type:varchar(2083)"`
+			}
+			type event struct {
+				CreatedAt   time.Time
+				UpdatedAt   time.Time  `gorm:"index"`
+				DeletedAt   *time.Time `gorm:"index"`
+				Title       string     `gorm:"type:varchar(12000)"`
+				ImageURL    string     `gorm:"type:varchar(2083)"`
+				Description string     `gorm:"type:longtext"`
+				ID          uint       `gorm:"index;primary_key;"`
+				ISBN        string     `gorm:"index;"`
+				BookID      uint       `gorm:"index;auto_increment:false"`
+				EventType   string     `gorm:"index"`
+			}
+			type book struct {
+				CreatedAt   time.Time
+				UpdatedAt   time.Time  `gorm:"index"`
+				DeletedAt   *time.Time `gorm:"index"`
+				Title       string     `gorm:"type:varchar(12000)"`
+				ImageURL    string     `gorm:"type:varchar(2083)"`
+				Description string     `gorm:"type:longtext"`
+				ISBN        string     `gorm:"index;primary_key;type:char(13);"`
+			}
+			type bookCopy struct {
+				ID   uint   `gorm:"index;primary_key;"`
+				ISBN string `gorm:"index;type:char(13);"`
+			}
+			type booksAuthors struct {
+				AuthorID uuid.UUID `gorm:"primary_key;"`
+				BookISBN string    `gorm:"primary_key;type:char(13);"`
+			}
+
+			tables := []struct {
+				name   string
+				schema interface{}
+			}{
+				{"checkouts", &checkout{}},
+				{"authors", &person{}},
+				{"members", &member{}},
+				{"events", &event{}},
+				{"books", &book{}},
+				{"copies", &bookCopy{}},
+				{"books_authors", &booksAuthors{}},
+			}
+
+			// Databases created before migrations existed already have these tables.
+			for _, t := range tables {
+				if _, err := createTableIfMissing(tx, t.name, t.schema); err != nil {
+					return err
+				}
+			}
+
+			// Those tables may have no foreign keys yet, and links to books or authors purged
+			// without them would stop the keys from being added.
+			err := tx.Exec("DELETE FROM books_authors " +
+				"WHERE book_isbn NOT IN (SELECT isbn FROM books) OR author_id NOT IN (SELECT id FROM authors)").Error
+			if err != nil {
+				return err
+			}
+
+			return addConstraints(tx)
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropTables(tx,
+				"books_authors",
+				"checkouts",
+				"copies",
+				"events",
+				"books",
+				"authors",
+				"members",
+			)
+		},
+	},
+	{
+		Version: 2,
+		Name:    "checkout_loan_ids",
+		Up: func(tx *gorm.DB) error {
+			// MySQL commits each ALTER on its own, so a failed run can leave some of them applied.
+			// Skip the columns already there when it's run again.
+			if !isSQLite(tx) {
+				steps := []struct {
+					column string
+					stmt   string
+				}{
+					{"id", "ALTER TABLE checkouts DROP PRIMARY KEY, " +
+						"ADD COLUMN id INT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY FIRST, " +
+						"ADD INDEX idx_checkouts_id (id)"},
+					{"renewals", "ALTER TABLE checkouts ADD COLUMN renewals INT UNSIGNED NOT NULL DEFAULT 0"},
+					{"renewed_at", "ALTER TABLE checkouts ADD COLUMN renewed_at DATETIME NULL"},
+				}
+				for _, step := range steps {
+					if tx.Dialect().HasColumn("checkouts", step.column) {
+						continue
+					}
+					if err := tx.Exec(step.stmt).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+
+			type checkout struct {
+				CreatedAt  time.Time
+				UpdatedAt  time.Time  `gorm:"index"`
+				DeletedAt  *time.Time `gorm:"index"`
+				ID         uint       `gorm:"index;primary_key;"`
+				BookID     uint       `gorm:"index;"`
+				MemberID   uuid.UUID  `gorm:"index;"`
+				CheckedOut time.Time  `gorm:"index;"`
+				Returned   *time.Time `gorm:"index;"`
+				Renewals   uint       `gorm:"not null;default:0"`
+				RenewedAt  *time.Time
+			}
+
+			columns := "created_at, updated_at, deleted_at, book_id, member_id, checked_out, returned"
+			return rebuildSQLiteTable(tx, "checkouts", &checkout{}, columns)
+		},
+		Down: func(tx *gorm.DB) error {
+			if !isSQLite(tx) {
+				return execAll(tx,
+					"ALTER TABLE checkouts DROP COLUMN renewed_at, DROP COLUMN renewals",
+					"ALTER TABLE checkouts DROP COLUMN id, ADD PRIMARY KEY (book_id, member_id)",
+				)
+			}
+
+			type checkout struct {
+				CreatedAt  time.Time
+				UpdatedAt  time.Time  `gorm:"index"`
+				DeletedAt  *time.Time `gorm:"index"`
+				BookID     uint       `gorm:"index;primary_key;auto_increment:false;"`
+				MemberID   uuid.UUID  `gorm:"index;primary_key;"`
+				CheckedOut time.Time  `gorm:"index;"`
+				Returned   *time.Time `gorm:"index;"`
+			}
+
+			columns := "created_at, updated_at, deleted_at, book_id, member_id, checked_out, returned"
+			return rebuildSQLiteTable(tx, "checkouts", &checkout{}, columns)
+		},
+	},
+	{
+		Version: 3,
+		Name:    "loan_policies_and_due_dates",
+		Up: func(tx *gorm.DB) error {
+			type member struct {
+				Category string `gorm:"type:varchar(64);not null;default:'standard';index"`
+			}
+			type checkout struct {
+				DueAt *time.Time `gorm:"index;"`
+			}
+			type loanPolicy struct {
+				CreatedAt      time.Time
+				UpdatedAt      time.Time  `gorm:"index"`
+				DeletedAt      *time.Time `gorm:"index"`
+				ID             uint       `gorm:"index;primary_key;"`
+				MemberCategory string     `gorm:"type:varchar(64);index"`
+				ISBN           string     `gorm:"type:char(13);index"`
+				LoanDays       int
+				MaxRenewals    int
+				MaxItems       int
+			}
+
+			if err := addColumns(tx, "members", &member{}); err != nil {
+				return err
+			}
+			if err := addColumns(tx, "checkouts", &checkout{}); err != nil {
+				return err
+			}
+
+			// Loans already out fall due after the default loan length of the time, so they can
+			// be overdue and fined like new ones.
+			const defaultLoanDays = 14
+			type openLoan struct {
+				ID         uint
+				CheckedOut time.Time
+			}
+			var open []openLoan
+			err := tx.Table("checkouts").
+				Select("id, checked_out").
+				Where("returned IS NULL AND due_at IS NULL").
+				Scan(&open).Error
+			if err != nil {
+				return err
+			}
+			for _, loan := range open {
+				dueAt := loan.CheckedOut.AddDate(0, 0, defaultLoanDays)
+				if err := tx.Table("checkouts").Where("id = ?", loan.ID).UpdateColumn("due_at", dueAt).Error; err != nil {
+					return err
+				}
+			}
+
+			_, err = createTableIfMissing(tx, "loan_policies", &loanPolicy{})
+			return err
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := dropTables(tx, "loan_policies"); err != nil {
+				return err
+			}
+			if err := dropColumns(tx, "checkouts", "due_at"); err != nil {
+				return err
+			}
+
+			return dropColumns(tx, "members", "category")
+		},
+	},
+	{
+		Version: 4,
+		Name:    "holds",
+		Up: func(tx *gorm.DB) error {
+			type hold struct {
+				CreatedAt time.Time
+				UpdatedAt time.Time  `gorm:"index"`
+				DeletedAt *time.Time `gorm:"index"`
+				ID        uint       `gorm:"index;primary_key;"`
+				ISBN      string     `gorm:"type:char(13);index"`
+				MemberID  uuid.UUID  `gorm:"index;"`
+				Status    string     `gorm:"type:varchar(16);index"`
+				CopyID    *uint      `gorm:"index;"`
+				ReadyAt   *time.Time
+				ExpiresAt *time.Time `gorm:"index;"`
+			}
+
+			if _, err := createTableIfMissing(tx, "holds", &hold{}); err != nil {
+				return err
+			}
+
+			// Holds go away with their member, like checkouts.
+			if isSQLite(tx) {
+				return addSQLiteConstraints(tx).Error
+			}
+
+			return tx.Table("holds").AddForeignKey(
+				"member_id",
+				"members(id)",
+				"CASCADE",
+				"CASCADE",
+			).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if !isSQLite(tx) {
+				return dropTables(tx, "holds")
+			}
+
+			// Drop the holds triggers along with the table, the rest are recreated.
+			if err := dropSQLiteConstraints(tx); err != nil {
+				return err
+			}
+			if err := dropTables(tx, "holds"); err != nil {
+				return err
+			}
+
+			return addSQLiteConstraints(tx).Error
+		},
+	},
+	{
+		Version: 5,
+		Name:    "fines_and_ledger",
+		Up: func(tx *gorm.DB) error {
+			type book struct {
+				ReplacementCents int64
+			}
+			type checkout struct {
+				LostAt *time.Time `gorm:"index;"`
+			}
+			type ledgerEntry struct {
+				CreatedAt   time.Time
+				UpdatedAt   time.Time  `gorm:"index"`
+				DeletedAt   *time.Time `gorm:"index"`
+				ID          uint       `gorm:"index;primary_key;"`
+				MemberID    uuid.UUID  `gorm:"index;"`
+				CheckoutID  *uint      `gorm:"index;"`
+				EntryType   string     `gorm:"type:varchar(16);index"`
+				AmountCents int64
+				Note        string `gorm:"type:varchar(255)"`
+			}
+
+			if err := addColumns(tx, "books", &book{}); err != nil {
+				return err
+			}
+			if err := addColumns(tx, "checkouts", &checkout{}); err != nil {
+				return err
+			}
+			if _, err := createTableIfMissing(tx, "ledger_entries", &ledgerEntry{}); err != nil {
+				return err
+			}
+
+			// Ledger entries go away with their member, like checkouts.
+			if isSQLite(tx) {
+				return addSQLiteConstraints(tx).Error
+			}
+
+			return tx.Table("ledger_entries").AddForeignKey(
+				"member_id",
+				"members(id)",
+				"CASCADE",
+				"CASCADE",
+			).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if isSQLite(tx) {
+				if err := dropSQLiteConstraints(tx); err != nil {
+					return err
+				}
+			}
+			if err := dropTables(tx, "ledger_entries"); err != nil {
+				return err
+			}
+			if err := dropColumns(tx, "checkouts", "lost_at"); err != nil {
+				return err
+			}
+			if err := dropColumns(tx, "books", "replacement_cents"); err != nil {
+				return err
+			}
+			if isSQLite(tx) {
+				return addSQLiteConstraints(tx).Error
+			}
+
+			return nil
+		},
+	},
+	{
+		Version: 6,
+		Name:    "copy_management",
+		Up: func(tx *gorm.DB) error {
+			type bookCopy struct {
+				Condition   string     `gorm:"type:varchar(64)"`
+				Location    string     `gorm:"type:varchar(255)"`
+				WithdrawnAt *time.Time `gorm:"index;"`
+			}
+
+			return addColumns(tx, "copies", &bookCopy{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, "copies", "condition", "location", "withdrawn_at")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "copy_status",
+		Up: func(tx *gorm.DB) error {
+			type bookCopy struct {
+				Status          string `gorm:"type:varchar(16);not null;default:'on_shelf';index"`
+				StatusChangedAt *time.Time
+			}
+			type copyStatusChange struct {
+				CreatedAt  time.Time
+				UpdatedAt  time.Time  `gorm:"index"`
+				DeletedAt  *time.Time `gorm:"index"`
+				ID         uint       `gorm:"index;primary_key;"`
+				CopyID     uint       `gorm:"index;"`
+				FromStatus string     `gorm:"type:varchar(16)"`
+				ToStatus   string     `gorm:"type:varchar(16)"`
+				Reason     string     `gorm:"type:varchar(255)"`
+			}
+
+			if err := addColumns(tx, "copies", &bookCopy{}); err != nil {
+				return err
+			}
+			if _, err := createTableIfMissing(tx, "copy_status_changes", &copyStatusChange{}); err != nil {
+				return err
+			}
+
+			// Derive the status of existing copies from withdrawals and loans.
+			return execAll(tx,
+				"UPDATE copies SET status = 'on_shelf' WHERE status IS NULL OR status = ''",
+				"UPDATE copies SET status = 'withdrawn' WHERE withdrawn_at IS NOT NULL",
+				"UPDATE copies SET status = 'lost' WHERE id IN "+
+					"(SELECT book_id FROM checkouts WHERE lost_at IS NOT NULL)",
+				"UPDATE copies SET status = 'checked_out' WHERE id IN "+
+					"(SELECT book_id FROM checkouts WHERE returned IS NULL)",
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := dropTables(tx, "copy_status_changes"); err != nil {
+				return err
+			}
+
+			return dropColumns(tx, "copies", "status", "status_changed_at")
+		},
+	},
+	{
+		Version: 8,
+		Name:    "barcodes",
+		Up: func(tx *gorm.DB) error {
+			type labelled struct {
+				Barcode *string `gorm:"type:varchar(32);unique_index"`
+			}
+
+			// Existing rows are labelled by the server on startup, the format is configurable.
+			if err := addColumns(tx, "copies", &labelled{}); err != nil {
+				return err
+			}
+
+			return addColumns(tx, "members", &labelled{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := dropColumns(tx, "members", "barcode"); err != nil {
+				return err
+			}
+
+			return dropColumns(tx, "copies", "barcode")
+		},
+	},
+	{
+		Version: 9,
+		Name:    "normalize_isbns",
+		Up: func(tx *gorm.DB) error {
+			var stored []string
+			if err := tx.Table("books").Pluck("isbn", &stored).Error; err != nil {
+				return err
+			}
+
+			// Books go first, the other tables follow (books_authors cascades on mysql).
+			columns := [][2]string{
+				{"books", "isbn"},
+				{"copies", "isbn"},
+				{"books_authors", "book_isbn"},
+				{"events", "isbn"},
+				{"holds", "isbn"},
+				{"loan_policies", "isbn"},
+			}
+			// Every book must end up under its ISBN-13 and only once, or it couldn't be looked up
+			// any more. Books that can't are all reported, for someone to fix or remove first.
+			var problems []string
+			normalized := map[string]string{}
+			for _, old := range stored {
+				parsed, err := isbn.Parse(old)
+				if err != nil {
+					problems = append(problems, fmt.Sprintf("%q is not a valid isbn: %s", old, err.Error()))
+					continue
+				}
+				if other, ok := normalized[parsed]; ok {
+					problems = append(problems, fmt.Sprintf("%q and %q are the same book, %s", other, old, parsed))
+					continue
+				}
+				normalized[parsed] = old
+			}
+			if len(problems) > 0 {
+				return fmt.Errorf("can't normalize isbns, fix or remove these books and migrate again:\n  %s",
+					strings.Join(problems, "\n  "))
+			}
+
+			for _, old := range stored {
+				parsed, _ := isbn.Parse(old)
+				if parsed == old {
+					continue
+				}
+
+				for _, c := range columns {
+					query := fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ?", c[0], c[1], c[1])
+					if err := tx.Exec(query, parsed, old).Error; err != nil {
+						return err
+					}
+				}
+			}
+
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			// The ISBN-13 identifies the same book, there's nothing to undo.
+			return nil
+		},
+	},
+	{
+		Version: 10,
+		Name:    "audit_events",
+		Up: func(tx *gorm.DB) error {
+			type audited struct {
+				EntityType string `gorm:"type:varchar(32);index"`
+				EntityID   string `gorm:"type:varchar(64);index"`
+				Actor      string `gorm:"type:varchar(255)"`
+				Changes    string `gorm:"type:text"`
+			}
+			if err := addColumns(tx, "events", &audited{}); err != nil {
+				return err
+			}
+
+			// Earlier events were all about books, or copies of them.
+			return execAll(tx,
+				"UPDATE events SET entity_type = 'book', entity_id = isbn WHERE event_type IN ('CREATE', 'UPDATE', 'DELETE')",
+				"UPDATE events SET entity_type = 'copy', entity_id = book_id WHERE entity_type IS NULL OR entity_type = ''",
+				"UPDATE events SET actor = 'system'",
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, "events", "entity_type", "entity_id", "actor", "changes")
+		},
+	},
+	{
+		Version: 11,
+		Name:    "webhooks",
+		Up: func(tx *gorm.DB) error {
+			type webhook struct {
+				CreatedAt time.Time
+				UpdatedAt time.Time  `gorm:"index"`
+				DeletedAt *time.Time `gorm:"index"`
+				ID        uint       `gorm:"index;primary_key;"`
+				URL       string     `gorm:"type:varchar(2083)"`
+				Topics    string     `gorm:"type:varchar(1024)"`
+				Active    bool       `gorm:"index"`
+				Secret    string     `gorm:"type:varchar(255)"`
+			}
+			type webhookDelivery struct {
+				CreatedAt     time.Time
+				UpdatedAt     time.Time  `gorm:"index"`
+				DeletedAt     *time.Time `gorm:"index"`
+				ID            uint       `gorm:"index;primary_key;"`
+				WebhookID     uint       `gorm:"index;"`
+				EventID       uint       `gorm:"index;"`
+				Topic         string     `gorm:"type:varchar(64);index"`
+				Payload       string     `gorm:"type:longtext"`
+				Status        string     `gorm:"type:varchar(16);index"`
+				Attempts      int
+				NextAttemptAt *time.Time `gorm:"index;"`
+				DeliveredAt   *time.Time
+				LastError     string `gorm:"type:varchar(1024)"`
+			}
+			type webhookAttempt struct {
+				CreatedAt  time.Time
+				UpdatedAt  time.Time  `gorm:"index"`
+				DeletedAt  *time.Time `gorm:"index"`
+				ID         uint       `gorm:"index;primary_key;"`
+				DeliveryID uint       `gorm:"index;"`
+				Attempt    int
+				StatusCode int
+				Error      string `gorm:"type:varchar(1024)"`
+				DurationMS int64
+			}
+
+			tables := []struct {
+				name   string
+				schema interface{}
+			}{
+				{"webhooks", &webhook{}},
+				{"webhook_deliveries", &webhookDelivery{}},
+				{"webhook_attempts", &webhookAttempt{}},
+			}
+			for _, table := range tables {
+				if _, err := createTableIfMissing(tx, table.name, table.schema); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropTables(tx, "webhook_attempts", "webhook_deliveries", "webhooks")
+		},
+	},
+	{
+		Version: 12,
+		Name:    "users",
+		Up: func(tx *gorm.DB) error {
+			type user struct {
+				CreatedAt    time.Time
+				UpdatedAt    time.Time  `gorm:"index"`
+				DeletedAt    *time.Time `gorm:"index"`
+				ID           uint       `gorm:"index;primary_key;"`
+				Username     string     `gorm:"type:varchar(255);unique_index"`
+				PasswordHash string     `gorm:"type:varchar(255)"`
+				Role         string     `gorm:"type:varchar(16);index"`
+				MemberID     *uuid.UUID `gorm:"index;"`
+			}
+			type session struct {
+				CreatedAt time.Time
+				UpdatedAt time.Time  `gorm:"index"`
+				DeletedAt *time.Time `gorm:"index"`
+				ID        uint       `gorm:"index;primary_key;"`
+				UserID    uint       `gorm:"index;"`
+				TokenHash string     `gorm:"type:char(64);unique_index"`
+				ExpiresAt time.Time  `gorm:"index;"`
+			}
+
+			if _, err := createTableIfMissing(tx, "users", &user{}); err != nil {
+				return err
+			}
+			_, err := createTableIfMissing(tx, "sessions", &session{})
+			return err
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropTables(tx, "sessions", "users")
+		},
+	},
+	{
+		Version: 13,
+		Name:    "api_keys",
+		Up: func(tx *gorm.DB) error {
+			type apiKey struct {
+				CreatedAt  time.Time
+				UpdatedAt  time.Time  `gorm:"index"`
+				DeletedAt  *time.Time `gorm:"index"`
+				ID         uint       `gorm:"index;primary_key;"`
+				Name       string     `gorm:"type:varchar(255)"`
+				Prefix     string     `gorm:"type:varchar(16)"`
+				KeyHash    string     `gorm:"type:char(64);unique_index"`
+				Scopes     string     `gorm:"type:varchar(255)"`
+				CreatedBy  string     `gorm:"type:varchar(255)"`
+				LastUsedAt *time.Time
+				RevokedAt  *time.Time `gorm:"index;"`
+			}
+
+			_, err := createTableIfMissing(tx, "api_keys", &apiKey{})
+			return err
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropTables(tx, "api_keys")
+		},
+	},
+}
@@ -1,10 +1,8 @@
 package db
 
 import (
-	"github.com/jinzhu/gorm"
 	"github.com/satori/go.uuid"
-	"github.com/t-tiger/gorm-bulk-insert"
-	"log"
+	"main/isbn"
 	"time"
 )
 
@@ -18,10 +16,40 @@ type PostBookPayload struct {
 	AuthorIds []uuid.UUID `json:"author_ids"`
 }
 
+// DefaultMemberCategory - Category of members created without one.
+const DefaultMemberCategory = "standard"
+
 const (
 	CREATE BookEventType = "CREATE"
 	DELETE BookEventType = "DELETE"
 	UPDATE BookEventType = "UPDATE"
+	RENEW  BookEventType = "RENEW"
+
+	// Copy level events
+	ADD      BookEventType = "ADD"
+	WITHDRAW BookEventType = "WITHDRAW"
+
+	// Circulation events
+	CHECKOUT BookEventType = "CHECKOUT"
+	RETURN   BookEventType = "RETURN"
+	LOST     BookEventType = "LOST"
+)
+
+// EntityType - Kind of record an event is about.
+type EntityType string
+
+const (
+	EntityBook        EntityType = "book"
+	EntityCopy        EntityType = "copy"
+	EntityAuthor      EntityType = "author"
+	EntityMember      EntityType = "member"
+	EntityCheckout    EntityType = "checkout"
+	EntityHold        EntityType = "hold"
+	EntityLoanPolicy  EntityType = "loan_policy"
+	EntityLedgerEntry EntityType = "ledger_entry"
+	EntityWebhook     EntityType = "webhook"
+	EntityUser        EntityType = "user"
+	EntityAPIKey      EntityType = "api_key"
 )
 
 type Base struct {
@@ -50,7 +78,12 @@ type Person struct {
 type Member struct {
 	Person
 	ImageURL  string     `gorm:"type:varchar(2083)" json:"image_url"`
+	Barcode   *string    `gorm:"type:varchar(32);unique_index" json:"barcode"`
+	Category  string     `gorm:"type:varchar(64);not null;default:'standard';index" json:"category"`
 	Checkouts []Checkout `json:"checkouts,omitempty"`
+
+	// BalanceCents - What the member owes, only filled in when fetching a single member.
+	BalanceCents *int64 `gorm:"-" json:"balance_cents,omitempty"`
 }
 
 type Author struct {
@@ -64,18 +97,86 @@ type Book struct {
 	ISBN    string   `gorm:"index;primary_key;type:char(13);" json:"isbn"`
 	Authors []Author `gorm:"many2many:books_authors;" json:"authors"`
 	Copies  []Copy   `gorm:"foreignkey:ISBN;" json:"copies"`
+
+	// ReplacementCents - Fee charged when a copy is lost, 0 uses the library default.
+	ReplacementCents int64 `json:"replacement_cents"`
+
+	// Hyphenated - The isbn formatted for display, e.g. 978-1-59327-584-6.
+	Hyphenated string `gorm:"-" json:"isbn_hyphenated"`
+}
+
+// AfterFind - Fill in the display form of the isbn once a book is loaded.
+func (b *Book) AfterFind() error {
+	b.Hyphenated = isbn.Format(b.ISBN)
+	return nil
+}
+
+// CopyStatus - Where a physical copy is, only on_shelf copies can be checked out.
+type CopyStatus string
+
+const (
+	CopyOnShelf    CopyStatus = "on_shelf"
+	CopyCheckedOut CopyStatus = "checked_out"
+	CopyInTransit  CopyStatus = "in_transit"
+	CopyDamaged    CopyStatus = "damaged"
+	CopyInRepair   CopyStatus = "in_repair"
+	CopyLost       CopyStatus = "lost"
+	CopyWithdrawn  CopyStatus = "withdrawn"
+)
+
+// copyTransitions - The statuses a copy can move to from each status, withdrawn is final.
+var copyTransitions = map[CopyStatus][]CopyStatus{
+	CopyOnShelf:    {CopyCheckedOut, CopyInTransit, CopyDamaged, CopyInRepair, CopyLost, CopyWithdrawn},
+	CopyCheckedOut: {CopyOnShelf, CopyLost},
+	CopyInTransit:  {CopyOnShelf, CopyLost},
+	CopyDamaged:    {CopyOnShelf, CopyInRepair, CopyWithdrawn},
+	CopyInRepair:   {CopyOnShelf, CopyInTransit, CopyDamaged, CopyWithdrawn},
+	CopyLost:       {CopyOnShelf, CopyWithdrawn},
+}
+
+// CanTransition - Check if a copy may move from one status to another.
+func CanTransition(from CopyStatus, to CopyStatus) bool {
+	for _, allowed := range copyTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsCopyStatus - Check a string names a known copy status.
+func IsCopyStatus(status CopyStatus) bool {
+	_, ok := copyTransitions[status]
+	return ok || status == CopyWithdrawn
 }
 
 // Copy of a Book
 type Copy struct {
-	ID   uint   `gorm:"index;primary_key;" json:"id"`
-	ISBN string `gorm:"index;primary_key;type:char(13);" json:"isbn"`
+	ID              uint       `gorm:"index;primary_key;" json:"id"`
+	ISBN            string     `gorm:"index;type:char(13);" json:"isbn"`
+	Barcode         *string    `gorm:"type:varchar(32);unique_index" json:"barcode"`
+	Condition       string     `gorm:"type:varchar(64)" json:"condition"`
+	Location        string     `gorm:"type:varchar(255)" json:"location"`
+	Status          CopyStatus `gorm:"type:varchar(16);not null;default:'on_shelf';index" json:"status"`
+	StatusChangedAt *time.Time `json:"status_changed_at"`
+	WithdrawnAt     *time.Time `gorm:"index;" json:"withdrawn_at"`
 }
 
 func (ba *Copy) TableName() string {
 	return "copies"
 }
 
+// CopyStatusChange - One step of a copy's status history.
+type CopyStatusChange struct {
+	Base
+	ID         uint       `gorm:"index;primary_key;" json:"id"`
+	CopyID     uint       `gorm:"index;" json:"copy_id"`
+	FromStatus CopyStatus `gorm:"type:varchar(16)" json:"from_status"`
+	ToStatus   CopyStatus `gorm:"type:varchar(16)" json:"to_status"`
+	Reason     string     `gorm:"type:varchar(255)" json:"reason"`
+}
+
 type BooksAuthors struct {
 	BookISBN string    `gorm:"index:primary_key;type:char(13);" json:"book_isbn"`
 	AuthorID uuid.UUID `gorm:"index;primary_key;" json:"author_id"`
@@ -85,77 +186,207 @@ func (ba *BooksAuthors) TableName() string {
 	return "books_authors"
 }
 
+// Checkout - A single loan of a book copy to a member.
 type Checkout struct {
 	Base
-	BookID     uint       `gorm:"index;primary_key;auto_increment:false;" json:"book_id"`
-	MemberID   uuid.UUID  `gorm:"index;primary_key;" json:"member_id"`
+	ID         uint       `gorm:"index;primary_key;" json:"id"`
+	BookID     uint       `gorm:"index;" json:"book_id"`
+	MemberID   uuid.UUID  `gorm:"index;" json:"member_id"`
 	CheckedOut time.Time  `gorm:"index;" json:"checked_out"`
 	Returned   *time.Time `gorm:"index;" json:"returned"`
+	DueAt      *time.Time `gorm:"index;" json:"due_at"`
+	Renewals   uint       `json:"renewals"`
+	RenewedAt  *time.Time `json:"renewed_at"`
+	LostAt     *time.Time `gorm:"index;" json:"lost_at"`
 }
 
+// LoanPolicy - Loan rules for a member category and/or a book, empty fields match anything.
+type LoanPolicy struct {
+	Base
+	ID             uint   `gorm:"index;primary_key;" json:"id"`
+	MemberCategory string `gorm:"type:varchar(64);index" json:"member_category"`
+	ISBN           string `gorm:"type:char(13);index" json:"isbn"`
+	LoanDays       int    `json:"loan_days"`
+	MaxRenewals    int    `json:"max_renewals"`
+	MaxItems       int    `json:"max_items"`
+}
+
+// LedgerEntryType - Kind of charge or credit on a member account.
+type LedgerEntryType string
+
+const (
+	LedgerFine    LedgerEntryType = "fine"
+	LedgerLostFee LedgerEntryType = "lost_fee"
+	LedgerPayment LedgerEntryType = "payment"
+	LedgerWaiver  LedgerEntryType = "waiver"
+)
+
+// LedgerEntry - A charge (positive) or credit (negative) on a member account, in cents.
+type LedgerEntry struct {
+	Base
+	ID          uint            `gorm:"index;primary_key;" json:"id"`
+	MemberID    uuid.UUID       `gorm:"index;" json:"member_id"`
+	CheckoutID  *uint           `gorm:"index;" json:"checkout_id"`
+	EntryType   LedgerEntryType `gorm:"type:varchar(16);index" json:"entry_type"`
+	AmountCents int64           `json:"amount_cents"`
+	Note        string          `gorm:"type:varchar(255)" json:"note"`
+}
+
+// HoldStatus - Where a hold is in its lifecycle.
+type HoldStatus string
+
+const (
+	HoldWaiting   HoldStatus = "waiting"
+	HoldReady     HoldStatus = "ready"
+	HoldFulfilled HoldStatus = "fulfilled"
+	HoldCancelled HoldStatus = "cancelled"
+	HoldExpired   HoldStatus = "expired"
+)
+
+// Hold - A member's place in the queue for the next available copy of a book.
+type Hold struct {
+	Base
+	ID        uint       `gorm:"index;primary_key;" json:"id"`
+	ISBN      string     `gorm:"type:char(13);index" json:"isbn"`
+	MemberID  uuid.UUID  `gorm:"index;" json:"member_id"`
+	Status    HoldStatus `gorm:"type:varchar(16);index" json:"status"`
+	CopyID    *uint      `gorm:"index;" json:"copy_id"`
+	ReadyAt   *time.Time `json:"ready_at"`
+	ExpiresAt *time.Time `gorm:"index;" json:"expires_at"`
+}
+
+// Event - Something that happened to an entity, who did it and which fields it changed.
+// Book and copy events also carry the book's isbn, copy id and a snapshot of its details.
 type Event struct {
 	Base
 	BaseBook
-	ID        uint          `gorm:"index;primary_key;" json:"id"`
-	ISBN      string        `gorm:"index;primary_key;" json:"isbn"`
-	BookID    uint          `gorm:"index;auto_increment:false" json:"book_id"`
-	EventType BookEventType `gorm:"index" json:"event_type"`
+	ID         uint          `gorm:"index;primary_key;" json:"id"`
+	ISBN       string        `gorm:"index;" json:"isbn"`
+	BookID     uint          `gorm:"index;auto_increment:false" json:"book_id"`
+	EventType  BookEventType `gorm:"index" json:"event_type"`
+	EntityType EntityType    `gorm:"type:varchar(32);index" json:"entity_type"`
+	EntityID   string        `gorm:"type:varchar(64);index" json:"entity_id"`
+	Actor      string        `gorm:"type:varchar(255)" json:"actor"`
+	Changes    Diff          `gorm:"type:text" json:"changes,omitempty"`
 }
 
-/* 			Helpers
-============================= */
+// Webhook - A subscription pushing the events on its topics to a url, e.g. book.create,
+// checkout.* or * for everything.
+type Webhook struct {
+	Base
+	ID     uint      `gorm:"index;primary_key;" json:"id"`
+	URL    string    `gorm:"type:varchar(2083)" json:"url"`
+	Topics TopicList `gorm:"type:varchar(1024)" json:"topics"`
+	Active bool      `gorm:"index" json:"active"`
 
-// preloadBookRelations - Preload book query with Authors & Copies relations.
-func preloadBookRelations() *gorm.DB {
-	return MySQL.Preload("Authors").Preload("Copies")
+	// Secret - Key the payloads sent to the url are signed with, only shown when set.
+	Secret string `gorm:"type:varchar(255)" json:"-"`
 }
 
-// GetBookWithRelations - Retrieve a single book with all relations.
-func GetBookWithRelations(query *Book, book *Book) {
-	preloadBookRelations().Where(query).First(book)
+// DeliveryStatus - Where a webhook delivery is in the outbox.
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+// WebhookDelivery - An event queued for a webhook, written with the event and sent from this outbox.
+type WebhookDelivery struct {
+	Base
+	ID            uint           `gorm:"index;primary_key;" json:"id"`
+	WebhookID     uint           `gorm:"index;" json:"webhook_id"`
+	EventID       uint           `gorm:"index;" json:"event_id"`
+	Topic         string         `gorm:"type:varchar(64);index" json:"topic"`
+	Payload       string         `gorm:"type:longtext" json:"-"`
+	Status        DeliveryStatus `gorm:"type:varchar(16);index" json:"status"`
+	Attempts      int            `json:"attempts"`
+	NextAttemptAt *time.Time     `gorm:"index;" json:"next_attempt_at"`
+	DeliveredAt   *time.Time     `json:"delivered_at"`
+	LastError     string         `gorm:"type:varchar(1024)" json:"last_error"`
+
+	// History - Every attempt at sending the delivery, only filled in when fetching a single one.
+	History []WebhookAttempt `gorm:"foreignkey:DeliveryID" json:"history,omitempty"`
 }
 
-// GetAllBooksWithRelations - Retrieve all books with relations.
-func GetAllBooksWithRelations(books *[]Book) {
-	preloadBookRelations().Find(books)
+// WebhookAttempt - One try at sending a webhook delivery and how the url answered.
+type WebhookAttempt struct {
+	Base
+	ID         uint   `gorm:"index;primary_key;" json:"id"`
+	DeliveryID uint   `gorm:"index;" json:"delivery_id"`
+	Attempt    int    `json:"attempt"`
+	StatusCode int    `json:"status_code"`
+	Error      string `gorm:"type:varchar(1024)" json:"error"`
+	DurationMS int64  `json:"duration_ms"`
 }
 
-// CreateBookEvent - Create a new book update event record and inserts it to events table.
-func CreateNewBookEvents(book Book, eventType BookEventType) {
-	var eventRecords []interface{}
-	for _, bookCopy := range book.Copies {
-		event := Event{
-			EventType: eventType,
-			BaseBook:  book.BaseBook,
-			BookID:    bookCopy.ID,
-			ISBN:      book.ISBN,
-		}
-		eventRecords = append(eventRecords, event)
-	}
+// Role - What an account may do. Admins manage everything, librarians run the desk and the
+// catalog, members look up the catalog and their own loans.
+type Role string
 
-	errBulkBooksEvents := gormbulk.BulkInsert(MySQL, eventRecords, 3000)
-	if errBulkBooksEvents != nil {
-		log.Println(errBulkBooksEvents.Error())
-		return
-	}
+const (
+	RoleAdmin     Role = "admin"
+	RoleLibrarian Role = "librarian"
+	RoleMember    Role = "member"
+)
+
+// IsRole - Check a string names a known role.
+func IsRole(role Role) bool {
+	return role == RoleAdmin || role == RoleLibrarian || role == RoleMember
 }
 
-// BulkInsertBooksAuthors - Batch inserts to BooksAuthors relation table.
-func BulkInsertBooksAuthors(author_ids []uuid.UUID, isbn string) error {
-	var booksAuthorsRecords []interface{}
-	for _, id := range author_ids {
-		var rel = BooksAuthors{
-			BookISBN: isbn,
-			AuthorID: id,
-		}
+// User - An account that can sign in to the api, member accounts belong to a library member.
+type User struct {
+	Base
+	ID           uint       `gorm:"index;primary_key;" json:"id"`
+	Username     string     `gorm:"type:varchar(255);unique_index" json:"username"`
+	PasswordHash string     `gorm:"type:varchar(255)" json:"-"`
+	Role         Role       `gorm:"type:varchar(16);index" json:"role"`
+	MemberID     *uuid.UUID `gorm:"index;" json:"member_id"`
+}
 
-		booksAuthorsRecords = append(booksAuthorsRecords, rel)
-	}
+// Session - A signed in user, found by the bearer token handed out at login. Only a hash of
+// the token is stored.
+type Session struct {
+	Base
+	ID        uint      `gorm:"index;primary_key;" json:"id"`
+	UserID    uint      `gorm:"index;" json:"user_id"`
+	TokenHash string    `gorm:"type:char(64);unique_index" json:"-"`
+	ExpiresAt time.Time `gorm:"index;" json:"expires_at"`
+}
 
-	errBulk := gormbulk.BulkInsert(MySQL, booksAuthorsRecords, 3000)
-	if errBulk != nil {
-		return errBulk
-	}
+// Scope - What an api key may do. Reads and writes are split per area of the api, and admin
+// covers everything.
+type Scope string
 
-	return nil
+const (
+	ScopeCatalogRead      Scope = "catalog:read"
+	ScopeCatalogWrite     Scope = "catalog:write"
+	ScopeCirculationRead  Scope = "circulation:read"
+	ScopeCirculationWrite Scope = "circulation:write"
+	ScopeMembersRead      Scope = "members:read"
+	ScopeMembersWrite     Scope = "members:write"
+	ScopeEventsRead       Scope = "events:read"
+	ScopeAdmin            Scope = "admin"
+)
+
+// Scopes - Every scope an api key can carry.
+var Scopes = []Scope{
+	ScopeCatalogRead, ScopeCatalogWrite, ScopeCirculationRead, ScopeCirculationWrite,
+	ScopeMembersRead, ScopeMembersWrite, ScopeEventsRead, ScopeAdmin,
+}
+
+// APIKey - A key scripts and kiosks call the api with instead of signing in. Only a hash of
+// the key is stored, its prefix is kept to tell keys apart.
+type APIKey struct {
+	Base
+	ID         uint       `gorm:"index;primary_key;" json:"id"`
+	Name       string     `gorm:"type:varchar(255)" json:"name"`
+	Prefix     string     `gorm:"type:varchar(16)" json:"prefix"`
+	KeyHash    string     `gorm:"type:char(64);unique_index" json:"-"`
+	Scopes     ScopeList  `gorm:"type:varchar(255)" json:"scopes"`
+	CreatedBy  string     `gorm:"type:varchar(255)" json:"created_by"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `gorm:"index;" json:"revoked_at"`
 }
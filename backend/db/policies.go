@@ -0,0 +1,84 @@
+package db
+
+// DefaultLoanPolicy - Loan limits a server starts with, before the LOAN_DAYS and MAX_RENEWALS env vars.
+var DefaultLoanPolicy = LoanPolicy{
+	LoanDays:    14,
+	MaxRenewals: 2,
+	MaxItems:    5,
+}
+
+// policySpecificity - Rank how closely a policy matches, a book match beats a category match.
+func policySpecificity(policy LoanPolicy, category string, isbn string) int {
+	rank := 0
+	if policy.ISBN != "" && policy.ISBN == isbn {
+		rank += 2
+	}
+	if policy.MemberCategory != "" && policy.MemberCategory == category {
+		rank++
+	}
+
+	return rank
+}
+
+// GetAllLoanPolicies - Retrieve all loan policies.
+func (s *gormStore) GetAllLoanPolicies() ([]LoanPolicy, error) {
+	var policies []LoanPolicy
+	err := s.db.Find(&policies).Error
+	return policies, err
+}
+
+// GetLoanPolicy - Retrieve a single loan policy by id.
+func (s *gormStore) GetLoanPolicy(id uint) (LoanPolicy, error) {
+	var policy LoanPolicy
+	err := s.db.Where("id = ?", id).First(&policy).Error
+	return policy, notFound(err)
+}
+
+// FindLoanPolicy - Retrieve the policy set for exactly this member category and book.
+func (s *gormStore) FindLoanPolicy(category string, isbn string) (LoanPolicy, error) {
+	var policy LoanPolicy
+	err := s.db.
+		Where("member_category = ? AND isbn = ?", category, isbn).
+		First(&policy).Error
+	return policy, notFound(err)
+}
+
+// ResolveLoanPolicy - Pick the most specific policy for a member category and book, or fallback
+// when none matches, pass an empty isbn for member wide rules like MaxItems.
+func (s *gormStore) ResolveLoanPolicy(category string, isbn string, fallback LoanPolicy) (LoanPolicy, error) {
+	var candidates []LoanPolicy
+	err := s.db.
+		Where("member_category IN (?)", []string{category, ""}).
+		Where("isbn IN (?)", []string{isbn, ""}).
+		Find(&candidates).Error
+	if err != nil {
+		return fallback, err
+	}
+
+	best := fallback
+	bestRank := -1
+	for _, policy := range candidates {
+		rank := policySpecificity(policy, category, isbn)
+		if rank > bestRank {
+			best = policy
+			bestRank = rank
+		}
+	}
+
+	return best, nil
+}
+
+// CreateLoanPolicy - Insert a new loan policy.
+func (s *gormStore) CreateLoanPolicy(policy *LoanPolicy) error {
+	return s.db.Create(policy).Error
+}
+
+// UpdateLoanPolicy - Apply column updates to a loan policy.
+func (s *gormStore) UpdateLoanPolicy(id uint, updates map[string]interface{}) error {
+	return s.db.Model(&LoanPolicy{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// DeleteLoanPolicy - Soft delete a loan policy.
+func (s *gormStore) DeleteLoanPolicy(id uint) error {
+	return s.db.Where("id = ?", id).Delete(&LoanPolicy{}).Error
+}
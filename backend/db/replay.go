@@ -0,0 +1,410 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/satori/go.uuid"
+	"main/isbn"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// replayedFields - The json fields of an entity as rebuilt from its events.
+type replayedFields map[string]interface{}
+
+// apply - Set every field a change touched to its value after the change.
+func (f replayedFields) apply(changes Diff) {
+	for name, change := range changes {
+		f[name] = change.After
+	}
+}
+
+// decode - Fill an entity from the replayed fields.
+func (f replayedFields) decode(entity interface{}) error {
+	raw, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, entity)
+}
+
+// catalogReplay - Books and copies rebuilt from the event log, by isbn and copy id.
+type catalogReplay struct {
+	books  map[string]replayedFields
+	copies map[string]replayedFields
+}
+
+// copyFields - The replayed fields of a copy, started on the shelf when its events don't create it.
+func (c *catalogReplay) copyFields(id uint, bookISBN string) replayedFields {
+	key := strconv.FormatUint(uint64(id), 10)
+	if c.copies[key] == nil {
+		c.copies[key] = replayedFields{"id": id, "isbn": bookISBN, "status": CopyOnShelf}
+	}
+
+	return c.copies[key]
+}
+
+// applyBookEvent - Replay a change to a book. Events from before book changes were diffed
+// hold a snapshot of the book, one per copy, instead.
+func (c *catalogReplay) applyBookEvent(event Event) {
+	if event.EventType == DELETE {
+		delete(c.books, event.ISBN)
+		return
+	}
+
+	fields := c.books[event.ISBN]
+	if fields == nil || event.EventType == CREATE {
+		fields = replayedFields{"isbn": event.ISBN}
+		c.books[event.ISBN] = fields
+	}
+	if len(event.Changes) > 0 {
+		fields.apply(event.Changes)
+		return
+	}
+
+	fields["title"] = event.Title
+	fields["image_url"] = event.ImageURL
+	fields["description"] = event.Description
+	if event.EventType == CREATE && event.BookID != 0 {
+		c.copyFields(event.BookID, event.ISBN)
+	}
+}
+
+// applyCopyEvent - Replay a change to a copy, older events without a diff only add or withdraw it.
+func (c *catalogReplay) applyCopyEvent(event Event) {
+	fields := c.copyFields(event.BookID, event.ISBN)
+	if len(event.Changes) > 0 {
+		fields.apply(event.Changes)
+		return
+	}
+	if event.EventType == WITHDRAW {
+		fields["status"] = CopyWithdrawn
+	}
+}
+
+// ReplayCatalog - Rebuild books with their authors (ids only) and copies from events in the
+// order they were recorded. Books deleted by the events are left out.
+func ReplayCatalog(events []Event) ([]Book, error) {
+	replay := catalogReplay{
+		books:  map[string]replayedFields{},
+		copies: map[string]replayedFields{},
+	}
+	for _, event := range events {
+		switch event.EntityType {
+		case EntityBook:
+			replay.applyBookEvent(event)
+		case EntityCopy:
+			replay.applyCopyEvent(event)
+		}
+	}
+
+	copiesByISBN := map[string][]Copy{}
+	for _, fields := range replay.copies {
+		var bookCopy Copy
+		if err := fields.decode(&bookCopy); err != nil {
+			return nil, err
+		}
+		copiesByISBN[bookCopy.ISBN] = append(copiesByISBN[bookCopy.ISBN], bookCopy)
+	}
+
+	books := []Book{}
+	for bookISBN, fields := range replay.books {
+		var book Book
+		if err := fields.decode(&book); err != nil {
+			return nil, err
+		}
+		book.AfterFind()
+
+		authorIDs, _ := fields["author_ids"].([]interface{})
+		book.Authors = []Author{}
+		for _, id := range authorIDs {
+			authorID, err := uuid.FromString(fmt.Sprint(id))
+			if err != nil {
+				return nil, err
+			}
+			book.Authors = append(book.Authors, Author{Person: Person{ID: authorID}})
+		}
+
+		book.Copies = copiesByISBN[bookISBN]
+		sort.Slice(book.Copies, func(i, j int) bool { return book.Copies[i].ID < book.Copies[j].ID })
+		books = append(books, book)
+	}
+	sort.Slice(books, func(i, j int) bool {
+		if books[i].Title != books[j].Title {
+			return books[i].Title < books[j].Title
+		}
+		return books[i].ISBN < books[j].ISBN
+	})
+
+	return books, nil
+}
+
+// replayBooks - Replay the events recorded up to asOf, all of them when it's nil, and fill in
+// the authors of the books. With bookISBN set only the events of that book and its copies are
+// read, otherwise the whole log is.
+func (s *gormStore) replayBooks(asOf *time.Time, bookISBN string) ([]Book, error) {
+	query := s.db.Where("entity_type IN (?)", []EntityType{EntityBook, EntityCopy}).Order("id")
+	if bookISBN != "" {
+		query = query.Where("isbn = ?", bookISBN)
+	}
+	if asOf != nil {
+		// Events are stamped in server time, compare in the same zone.
+		query = query.Where("created_at <= ?", asOf.Local())
+	}
+
+	var events []Event
+	if err := query.Find(&events).Error; err != nil {
+		return nil, err
+	}
+	books, err := ReplayCatalog(events)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	byID := map[string]Author{}
+	for _, book := range books {
+		for _, author := range book.Authors {
+			if _, ok := byID[author.ID.String()]; !ok {
+				byID[author.ID.String()] = author
+				ids = append(ids, author.ID.String())
+			}
+		}
+	}
+
+	// Authors deleted since still show on the books they wrote back then. Loaded in batches to
+	// stay under SQLite's limit on query variables.
+	for start := 0; start < len(ids); start += 500 {
+		end := start + 500
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var authors []Author
+		if err := s.db.Unscoped().Where("id IN (?)", ids[start:end]).Find(&authors).Error; err != nil {
+			return nil, err
+		}
+		for _, author := range authors {
+			byID[author.ID.String()] = author
+		}
+	}
+	for i := range books {
+		for j, author := range books[i].Authors {
+			if found, ok := byID[author.ID.String()]; ok {
+				books[i].Authors[j] = found
+			}
+		}
+	}
+
+	return books, nil
+}
+
+// replayFilters - Filters of books rebuilt from events, the same as book lists take.
+var replayFilters = map[string]func(book Book, value string) (bool, error){
+	"isbn": func(book Book, value string) (bool, error) {
+		parsed, err := isbn.Parse(value)
+		if err != nil {
+			return false, invalidList("isbn: %s", err.Error())
+		}
+		return book.ISBN == parsed, nil
+	},
+	"author_id": func(book Book, value string) (bool, error) {
+		for _, author := range book.Authors {
+			if author.ID.String() == value {
+				return true, nil
+			}
+		}
+		return false, nil
+	},
+	"available": func(book Book, value string) (bool, error) {
+		available, err := strconv.ParseBool(value)
+		if err != nil {
+			return false, invalidList("available must be true or false")
+		}
+		onShelf := false
+		for _, bookCopy := range book.Copies {
+			onShelf = onShelf || bookCopy.Status == CopyOnShelf
+		}
+		return onShelf == available, nil
+	},
+}
+
+// GetBooksAsOf - The catalog as it was at asOf, rebuilt from the event log and filtered like
+// book lists. Books come with their authors and the copies they had in circulation. Filtering
+// by isbn replays just that book, anything else replays every event up to asOf.
+func (s *gormStore) GetBooksAsOf(asOf time.Time, filters map[string]string) ([]Book, error) {
+	for name := range filters {
+		if replayFilters[name] == nil {
+			return nil, invalidList("can't filter by %q", name)
+		}
+	}
+
+	// A single book only needs its own events replayed.
+	var bookISBN string
+	if value, ok := filters["isbn"]; ok {
+		parsed, err := isbn.Parse(value)
+		if err != nil {
+			return nil, invalidList("isbn: %s", err.Error())
+		}
+		bookISBN = parsed
+	}
+
+	replayed, err := s.replayBooks(&asOf, bookISBN)
+	if err != nil {
+		return nil, err
+	}
+
+	books := []Book{}
+	for _, book := range replayed {
+		matches := true
+		for name, value := range filters {
+			ok, err := replayFilters[name](book, value)
+			if err != nil {
+				return nil, err
+			}
+			matches = matches && ok
+		}
+		if !matches {
+			continue
+		}
+
+		circulating := []Copy{}
+		for _, bookCopy := range book.Copies {
+			if bookCopy.Status != CopyWithdrawn {
+				circulating = append(circulating, bookCopy)
+			}
+		}
+		book.Copies = circulating
+		books = append(books, book)
+	}
+
+	return books, nil
+}
+
+// ReplayMismatch - A book or copy whose replayed fields differ from its table row, Before
+// holding the replayed value and After the stored one. Missing records have every field differ.
+type ReplayMismatch struct {
+	EntityType EntityType `json:"entity_type"`
+	EntityID   string     `json:"entity_id"`
+	Changes    Diff       `json:"changes"`
+}
+
+// copyState - The fields of a copy replays are checked on.
+type copyState struct {
+	ISBN      string     `json:"isbn"`
+	Barcode   *string    `json:"barcode"`
+	Condition string     `json:"condition"`
+	Location  string     `json:"location"`
+	Status    CopyStatus `json:"status"`
+}
+
+// stateOfCopy - The checked fields of a copy, nil when there's no copy.
+func stateOfCopy(bookCopy *Copy) interface{} {
+	if bookCopy == nil {
+		return nil
+	}
+
+	return copyState{
+		ISBN:      bookCopy.ISBN,
+		Barcode:   bookCopy.Barcode,
+		Condition: bookCopy.Condition,
+		Location:  bookCopy.Location,
+		Status:    bookCopy.Status,
+	}
+}
+
+// VerifyReplay - Replay the whole event log and compare the books and copies it rebuilds with
+// the tables. No mismatches means the events account for the current catalog.
+func (s *gormStore) VerifyReplay() ([]ReplayMismatch, error) {
+	replayed, err := s.replayBooks(nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// Stored books are read a page at a time, like the search index, to keep their authors'
+	// preload under SQLite's limit on query variables.
+	var books []Book
+	q := ListQuery{Sort: []string{"isbn"}, Limit: MaxListLimit}
+	for {
+		var batch []Book
+		page, err := bookList.list(s.db.Preload("Authors"), q, &batch)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, batch...)
+		if page.Next == "" {
+			break
+		}
+		q.Cursor = page.Next
+	}
+	var copies []Copy
+	if err := s.db.Find(&copies).Error; err != nil {
+		return nil, err
+	}
+
+	bookIDs, copyIDs := map[string]bool{}, map[string]bool{}
+	replayedBooks, replayedCopies := map[string]*Book{}, map[string]*Copy{}
+	for i := range replayed {
+		replayedBooks[replayed[i].ISBN] = &replayed[i]
+		bookIDs[replayed[i].ISBN] = true
+		for j := range replayed[i].Copies {
+			id := fmt.Sprint(replayed[i].Copies[j].ID)
+			replayedCopies[id] = &replayed[i].Copies[j]
+			copyIDs[id] = true
+		}
+	}
+	storedBooks, storedCopies := map[string]*Book{}, map[string]*Copy{}
+	for i := range books {
+		storedBooks[books[i].ISBN] = &books[i]
+		bookIDs[books[i].ISBN] = true
+	}
+	for i := range copies {
+		// Replays only keep the copies of books that weren't deleted.
+		if storedBooks[copies[i].ISBN] == nil {
+			continue
+		}
+		id := fmt.Sprint(copies[i].ID)
+		storedCopies[id] = &copies[i]
+		copyIDs[id] = true
+	}
+
+	var mismatches []ReplayMismatch
+	compare := func(entityType EntityType, id string, before, after interface{}) error {
+		changes, err := NewDiff(before, after)
+		if err != nil || len(changes) == 0 {
+			return err
+		}
+		mismatches = append(mismatches, ReplayMismatch{EntityType: entityType, EntityID: id, Changes: changes})
+		return nil
+	}
+
+	for _, id := range sortedIDs(bookIDs) {
+		if err := compare(EntityBook, id, stateOfBook(replayedBooks[id]), stateOfBook(storedBooks[id])); err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range sortedIDs(copyIDs) {
+		if err := compare(EntityCopy, id, stateOfCopy(replayedCopies[id]), stateOfCopy(storedCopies[id])); err != nil {
+			return nil, err
+		}
+	}
+
+	return mismatches, nil
+}
+
+// sortedIDs - The ids of a set in order, numeric ids by value.
+func sortedIDs(ids map[string]bool) []string {
+	var sorted []string
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) < len(sorted[j])
+		}
+		return sorted[i] < sorted[j]
+	})
+	return sorted
+}
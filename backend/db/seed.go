@@ -0,0 +1,281 @@
+package db
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"github.com/t-tiger/gorm-bulk-insert"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// SeedData - Records to bulk insert when seeding the database.
+type SeedData struct {
+	Members      []Member
+	Checkouts    []Checkout
+	Authors      []Author
+	Books        []Book
+	Copies       []Copy
+	BooksAuthors []BooksAuthors
+
+	// LedgerEntries - Charges and payments, naming their checkout by its 1-based position in Checkouts.
+	LedgerEntries []LedgerEntry
+}
+
+// toRecords - Util func to convert typed slices for gormbulk.
+func toRecords(length int, at func(i int) interface{}) []interface{} {
+	records := make([]interface{}, 0, length)
+	for i := 0; i < length; i++ {
+		records = append(records, at(i))
+	}
+
+	return records
+}
+
+// maxBulkVariables - Bound values per bulk insert statement, within sqlite's default limit.
+const maxBulkVariables = 999
+
+// bulkInsert - Bulk insert records in chunks small enough for every supported database.
+func (s *gormStore) bulkInsert(records []interface{}) error {
+	if len(records) == 0 {
+		return nil
+	}
+	columns := len(s.db.NewScope(records[0]).Fields())
+
+	return gormbulk.BulkInsert(s.db, records, maxBulkVariables/columns)
+}
+
+// Wipe - Hard delete every record from every table.
+func (s *gormStore) Wipe() error {
+	tables := []interface{}{
+		&Book{},
+		&Copy{},
+		&Event{},
+		&Author{},
+		&Member{},
+		&Checkout{},
+		&Hold{},
+		&LedgerEntry{},
+		&CopyStatusChange{},
+		&WebhookDelivery{},
+		&WebhookAttempt{},
+	}
+
+	for _, table := range tables {
+		if err := s.db.Unscoped().Delete(table).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Seed - Bulk insert seed data and create events for the new books, copies and checkouts, stamped
+// when they happened. Checkouts name
+// the copy they lend by its 1-based position in Copies, and ledger entries their checkout by its
+// position in Checkouts, as ids aren't known until they're inserted. Only the seeded records are
+// touched, so data can be added to a database in use.
+func (s *gormStore) Seed(data SeedData) error {
+	var lastCopy Copy
+	err := s.db.Unscoped().Select("id").Order("id DESC").First(&lastCopy).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return err
+	}
+	var lastCheckout Checkout
+	err = s.db.Unscoped().Select("id").Order("id DESC").First(&lastCheckout).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return err
+	}
+
+	copies := toRecords(len(data.Copies), func(i int) interface{} { return data.Copies[i] })
+	if err := s.bulkInsert(copies); err != nil {
+		return err
+	}
+	var newCopies []Copy
+	if err := s.db.Where("id > ?", lastCopy.ID).Order("id").Find(&newCopies).Error; err != nil {
+		return err
+	}
+	if len(newCopies) != len(data.Copies) {
+		return fmt.Errorf("seeded %d copies but found %d new ones", len(data.Copies), len(newCopies))
+	}
+
+	checkouts := make([]Checkout, len(data.Checkouts))
+	for i, checkout := range data.Checkouts {
+		if checkout.BookID < 1 || int(checkout.BookID) > len(newCopies) {
+			return fmt.Errorf("seed checkout %d lends copy %d, there are %d seeded copies", i+1, checkout.BookID, len(newCopies))
+		}
+		checkout.BookID = newCopies[checkout.BookID-1].ID
+		checkouts[i] = checkout
+	}
+
+	records := toRecords(len(checkouts), func(i int) interface{} { return checkouts[i] })
+	if err := s.bulkInsert(records); err != nil {
+		return err
+	}
+	var newCheckouts []Checkout
+	if err := s.db.Select("id").Where("id > ?", lastCheckout.ID).Order("id").Find(&newCheckouts).Error; err != nil {
+		return err
+	}
+	if len(newCheckouts) != len(checkouts) {
+		return fmt.Errorf("seeded %d checkouts but found %d new ones", len(checkouts), len(newCheckouts))
+	}
+
+	entries := make([]LedgerEntry, len(data.LedgerEntries))
+	for i, entry := range data.LedgerEntries {
+		if entry.CheckoutID != nil {
+			position := *entry.CheckoutID
+			if position < 1 || int(position) > len(newCheckouts) {
+				return fmt.Errorf("seed ledger entry %d names checkout %d, there are %d seeded checkouts", i+1, position, len(newCheckouts))
+			}
+			entry.CheckoutID = &newCheckouts[position-1].ID
+		}
+		entries[i] = entry
+	}
+
+	recordSets := [][]interface{}{
+		toRecords(len(data.Books), func(i int) interface{} { return data.Books[i] }),
+		toRecords(len(data.Authors), func(i int) interface{} { return data.Authors[i] }),
+		toRecords(len(data.Members), func(i int) interface{} { return data.Members[i] }),
+		toRecords(len(data.BooksAuthors), func(i int) interface{} { return data.BooksAuthors[i] }),
+		toRecords(len(entries), func(i int) interface{} { return entries[i] }),
+	}
+
+	for _, records := range recordSets {
+		if err := s.bulkInsert(records); err != nil {
+			return err
+		}
+	}
+
+	// Copies lent out by the seeded checkouts aren't on the shelf, and those lost never come back.
+	err = s.db.Model(&Copy{}).
+		Where("id > ? AND id IN (?)", lastCopy.ID, s.openCheckoutCopyIDs()).
+		Update("status", CopyCheckedOut).Error
+	if err != nil {
+		return err
+	}
+	lostCopyIDs := s.db.Table("checkouts").Select("book_id").Where("lost_at IS NOT NULL").QueryExpr()
+	err = s.db.Model(&Copy{}).
+		Where("id > ? AND id IN (?)", lastCopy.ID, lostCopyIDs).
+		Update("status", CopyLost).Error
+	if err != nil {
+		return err
+	}
+
+	// Events for the books just created, their copies and the loans of those copies.
+	isbns := make([]string, len(data.Books))
+	for i, book := range data.Books {
+		isbns[i] = book.ISBN
+	}
+	var newBooks []Book
+	for start := 0; start < len(isbns); start += 500 {
+		end := start + 500
+		if end > len(isbns) {
+			end = len(isbns)
+		}
+		var batch []Book
+		if err := s.db.Preload("Authors").Where("isbn IN (?)", isbns[start:end]).Find(&batch).Error; err != nil {
+			return err
+		}
+		newBooks = append(newBooks, batch...)
+	}
+
+	for i, checkout := range newCheckouts {
+		checkouts[i].ID = checkout.ID
+	}
+	events, err := seedEvents(newBooks, newCopies, checkouts)
+	if err != nil {
+		return err
+	}
+	for i := range events {
+		if err := s.CreateEvent(&events[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// seedEvents - The history of seeded books, copies and checkouts, stamped when it happened rather
+// than when it was seeded so the catalog replays as of any time the seed covers. Copies are added
+// on the shelf with their book, or when first lent if that's earlier, and change status with every
+// loan.
+func seedEvents(books []Book, copies []Copy, checkouts []Checkout) ([]Event, error) {
+	var events []Event
+	record := func(event Event, err error, at time.Time) error {
+		if err != nil {
+			return err
+		}
+		event.CreatedAt, event.UpdatedAt = at, at
+		events = append(events, event)
+		return nil
+	}
+
+	for i := range books {
+		event, err := NewBookEvent(CREATE, nil, &books[i])
+		if err := record(event, err, books[i].CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+
+	created := map[string]time.Time{}
+	for _, book := range books {
+		created[book.ISBN] = book.CreatedAt
+	}
+	loans := map[uint][]Checkout{}
+	for _, checkout := range checkouts {
+		loans[checkout.BookID] = append(loans[checkout.BookID], checkout)
+	}
+	for _, bookCopy := range copies {
+		copyID := strconv.FormatUint(uint64(bookCopy.ID), 10)
+		lent := loans[bookCopy.ID]
+		sort.SliceStable(lent, func(i, j int) bool { return lent[i].CheckedOut.Before(lent[j].CheckedOut) })
+
+		added := created[bookCopy.ISBN]
+		if len(lent) > 0 && lent[0].CheckedOut.Before(added) {
+			added = lent[0].CheckedOut
+		}
+		event, err := NewEvent(EntityCopy, copyID, ADD, nil, bookCopy)
+		if err := record(event, err, added); err != nil {
+			return nil, err
+		}
+
+		for _, checkout := range lent {
+			out := checkout
+			out.UpdatedAt, out.Returned, out.LostAt = out.CheckedOut, nil, nil
+			event, err := NewEvent(EntityCheckout, fmt.Sprint(out.ID), CHECKOUT, nil, out)
+			event.ISBN = bookCopy.ISBN
+			if err := record(event, err, out.CheckedOut); err != nil {
+				return nil, err
+			}
+			before := bookCopy
+			bookCopy.Status = CopyCheckedOut
+			event, err = NewEvent(EntityCopy, copyID, UPDATE, before, bookCopy)
+			if err := record(event, err, out.CheckedOut); err != nil {
+				return nil, err
+			}
+			if checkout.Returned == nil {
+				continue
+			}
+
+			action, status := RETURN, CopyOnShelf
+			if checkout.LostAt != nil {
+				action, status = LOST, CopyLost
+			}
+			event, err = NewEvent(EntityCheckout, fmt.Sprint(checkout.ID), action, out, checkout)
+			event.ISBN = bookCopy.ISBN
+			if err := record(event, err, *checkout.Returned); err != nil {
+				return nil, err
+			}
+			before = bookCopy
+			bookCopy.Status = status
+			event, err = NewEvent(EntityCopy, copyID, UPDATE, before, bookCopy)
+			if err := record(event, err, *checkout.Returned); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Ids follow the order things happened in, like events recorded as they happen.
+	sort.SliceStable(events, func(i, j int) bool { return events[i].CreatedAt.Before(events[j].CreatedAt) })
+	return events, nil
+}
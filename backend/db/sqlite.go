@@ -0,0 +1,89 @@
+package db
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"log"
+)
+
+// sqliteDialect - Name gorm reports for the sqlite dialect.
+const sqliteDialect = "sqlite3"
+
+// sqliteCascade - An "ON DELETE/UPDATE CASCADE" foreign key emulated with triggers.
+type sqliteCascade struct {
+	table     string
+	column    string
+	refTable  string
+	refColumn string
+}
+
+// sqliteCascades - Mirrors the foreign keys created by addConstraints for mysql.
+var sqliteCascades = []sqliteCascade{
+	{table: "books_authors", column: "book_isbn", refTable: "books", refColumn: "isbn"},
+	{table: "books_authors", column: "author_id", refTable: "authors", refColumn: "id"},
+	{table: "checkouts", column: "member_id", refTable: "members", refColumn: "id"},
+	{table: "holds", column: "member_id", refTable: "members", refColumn: "id"},
+	{table: "ledger_entries", column: "member_id", refTable: "members", refColumn: "id"},
+}
+
+// name - Prefix of the trigger names emulating the cascade.
+func (c sqliteCascade) name() string {
+	return fmt.Sprintf("%s_%s_%s", c.table, c.column, c.refTable)
+}
+
+// addSQLiteConstraints - SQLite can't ALTER TABLE ADD CONSTRAINT, so cascade with triggers instead.
+func addSQLiteConstraints(db *gorm.DB) *gorm.DB {
+	log.Println("adding db table cascade triggers")
+	for _, c := range sqliteCascades {
+		// Tables added by later migrations get their triggers once they exist.
+		if !db.HasTable(c.table) {
+			continue
+		}
+
+		onDelete := fmt.Sprintf(
+			"CREATE TRIGGER IF NOT EXISTS %s_delete AFTER DELETE ON %s "+
+				"BEGIN DELETE FROM %s WHERE %s = OLD.%s; END",
+			c.name(), c.refTable, c.table, c.column, c.refColumn,
+		)
+		onUpdate := fmt.Sprintf(
+			"CREATE TRIGGER IF NOT EXISTS %s_update AFTER UPDATE OF %s ON %s "+
+				"BEGIN UPDATE %s SET %s = NEW.%s WHERE %s = OLD.%s; END",
+			c.name(), c.refColumn, c.refTable, c.table, c.column, c.refColumn, c.column, c.refColumn,
+		)
+
+		for _, stmt := range []string{onDelete, onUpdate} {
+			if err := db.Exec(stmt).Error; err != nil {
+				log.Printf("error creating trigger %s:: %s", c.name(), err.Error())
+			}
+		}
+	}
+
+	return db
+}
+
+// dropSQLiteConstraints - Drop the cascade triggers, e.g. before rebuilding a table they reference.
+func dropSQLiteConstraints(db *gorm.DB) error {
+	for _, c := range sqliteCascades {
+		for _, suffix := range []string{"_delete", "_update"} {
+			if err := db.Exec("DROP TRIGGER IF EXISTS " + c.name() + suffix).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// OpenSQLite - Open (or create) an SQLite database file, run MigrateUp before use.
+func OpenSQLite(path string) (*gorm.DB, error) {
+	client, err := gorm.Open(sqliteDialect, path)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite only allows a single writer, so share one connection between requests.
+	// This also keeps ":memory:" databases from being created per connection.
+	client.DB().SetMaxOpenConns(1)
+	return client, nil
+}
@@ -0,0 +1,240 @@
+package db
+
+import (
+	"errors"
+	"github.com/jinzhu/gorm"
+	"github.com/satori/go.uuid"
+	"time"
+)
+
+// ErrNotFound - Returned by a Store when the requested record doesn't exist.
+var ErrNotFound = errors.New("record not found")
+
+// BookStore - Persistence of books and their author relations.
+type BookStore interface {
+	GetAllBooks(q ListQuery) ([]Book, Page, error)
+	ListBooks(q ListQuery) ([]Book, Page, error)
+	GetBook(isbn string) (Book, error)
+	GetBookUnscoped(isbn string) (Book, error)
+	CreateBook(book *Book) error
+	UpdateBook(isbn string, updates map[string]interface{}) error
+	DeleteBook(isbn string) error
+	PurgeBook(isbn string) error
+	AddBookAuthors(isbn string, authorIDs []uuid.UUID) error
+	RemoveBookAuthors(isbn string, authorIDs []uuid.UUID) error
+}
+
+// CopyStore - Persistence of the physical copies of a book.
+type CopyStore interface {
+	GetCopiesByISBNs(isbns []string) ([]Copy, error)
+	GetBookCopies(isbn string, withWithdrawn bool) ([]Copy, error)
+	GetCopy(id uint) (Copy, error)
+	GetCopyForUpdate(id uint) (Copy, error)
+	GetCopyByBarcode(barcode string) (Copy, error)
+	GetCopyByBarcodeForUpdate(barcode string) (Copy, error)
+	GetCopiesWithoutBarcode() ([]Copy, error)
+	GetLastCopyBarcode(prefix string) (string, error)
+	GetAvailableCopy(isbn string) (Copy, error)
+	CreateCopies(isbn string, count int) error
+	CreateCopy(bookCopy *Copy) error
+	UpdateCopy(id uint, updates map[string]interface{}) error
+	GetCopyStatusChanges(copyID uint) ([]CopyStatusChange, error)
+	CreateCopyStatusChange(change *CopyStatusChange) error
+}
+
+// AuthorStore - Persistence of authors.
+type AuthorStore interface {
+	ListAuthors(withBooks bool, q ListQuery) ([]Author, Page, error)
+	GetAuthor(id uuid.UUID) (Author, error)
+	GetAuthorBooks(id uuid.UUID) ([]Book, error)
+	FindAuthorByName(first string, last string, middle string) (Author, error)
+	CreateAuthor(author *Author) error
+	UpdateAuthor(id uuid.UUID, updates map[string]interface{}) error
+	DeleteAuthor(id uuid.UUID) error
+}
+
+// MemberStore - Persistence of library members.
+type MemberStore interface {
+	ListMembers(withOpenCheckouts bool, q ListQuery) ([]Member, Page, error)
+	GetMember(id uuid.UUID) (Member, error)
+	GetMemberByBarcode(barcode string) (Member, error)
+	GetMembersWithoutBarcode() ([]Member, error)
+	GetLastMemberBarcode(prefix string) (string, error)
+	CreateMember(member *Member) error
+	UpdateMember(id uuid.UUID, updates map[string]interface{}) error
+	DeleteMember(id uuid.UUID) error
+}
+
+// CheckoutStore - Persistence of book checkouts.
+type CheckoutStore interface {
+	ListCheckouts(filter CheckoutFilter, q ListQuery) ([]Checkout, Page, error)
+	GetCheckout(id uint) (Checkout, error)
+	GetCheckoutForUpdate(id uint) (Checkout, error)
+	GetOpenCheckout(bookID uint, memberID uuid.UUID) (Checkout, error)
+	GetOpenCheckoutByCopy(copyID uint) (Checkout, error)
+	GetCheckoutsByMember(memberID uuid.UUID) ([]Checkout, error)
+	GetCheckoutsByCopy(copyID uint) ([]Checkout, error)
+	CountOpenCheckouts(memberID uuid.UUID) (int, error)
+	CreateCheckout(checkout *Checkout) error
+	UpdateCheckout(id uint, updates map[string]interface{}) error
+}
+
+// LoanPolicyStore - Persistence and resolution of loan policies.
+type LoanPolicyStore interface {
+	GetAllLoanPolicies() ([]LoanPolicy, error)
+	GetLoanPolicy(id uint) (LoanPolicy, error)
+	FindLoanPolicy(category string, isbn string) (LoanPolicy, error)
+	ResolveLoanPolicy(category string, isbn string, fallback LoanPolicy) (LoanPolicy, error)
+	CreateLoanPolicy(policy *LoanPolicy) error
+	UpdateLoanPolicy(id uint, updates map[string]interface{}) error
+	DeleteLoanPolicy(id uint) error
+}
+
+// HoldStore - Persistence of the per ISBN hold queues.
+type HoldStore interface {
+	GetHold(id uint) (Hold, error)
+	GetHoldsByISBN(isbn string) ([]Hold, error)
+	GetHoldsByMember(memberID uuid.UUID) ([]Hold, error)
+	GetActiveHold(isbn string, memberID uuid.UUID) (Hold, error)
+	GetNextHold(isbn string) (Hold, error)
+	GetCopyHold(copyID uint) (Hold, error)
+	GetReadyHoldCopyIDs(isbns []string) ([]uint, error)
+	GetExpiredHolds(now time.Time) ([]Hold, error)
+	CreateHold(hold *Hold) error
+	UpdateHold(id uint, updates map[string]interface{}) error
+}
+
+// LedgerStore - Persistence of member account ledgers.
+type LedgerStore interface {
+	GetLedgerEntries(memberID uuid.UUID) ([]LedgerEntry, error)
+	GetLedgerBalance(memberID uuid.UUID) (int64, error)
+	CreateLedgerEntry(entry *LedgerEntry) error
+}
+
+// EventStore - Persistence of the event log.
+type EventStore interface {
+	ListEvents(q ListQuery) ([]Event, Page, error)
+	GetEventsByISBN(isbn string) ([]Event, error)
+	GetEventsAfter(afterID uint, filters map[string]string, limit int) ([]Event, error)
+	GetLastEventID() (uint, error)
+	CreateEvent(event *Event) error
+	GetBooksAsOf(asOf time.Time, filters map[string]string) ([]Book, error)
+	VerifyReplay() ([]ReplayMismatch, error)
+}
+
+// WebhookStore - Persistence of webhook subscriptions and their delivery outbox and log.
+type WebhookStore interface {
+	GetAllWebhooks() ([]Webhook, error)
+	GetWebhook(id uint) (Webhook, error)
+	CreateWebhook(webhook *Webhook) error
+	UpdateWebhook(id uint, updates map[string]interface{}) error
+	DeleteWebhook(id uint) error
+	ListWebhookDeliveries(webhookID uint, q ListQuery) ([]WebhookDelivery, Page, error)
+	GetWebhookDelivery(id uint) (WebhookDelivery, error)
+	GetDueWebhookDeliveries(now time.Time, limit int) ([]WebhookDelivery, error)
+	ClaimWebhookDelivery(delivery WebhookDelivery, retryAt time.Time) (bool, error)
+	UpdateWebhookDelivery(id uint, updates map[string]interface{}) error
+	CreateWebhookAttempt(attempt *WebhookAttempt) error
+}
+
+// UserStore - Persistence of accounts and their sign in sessions.
+type UserStore interface {
+	GetAllUsers() ([]User, error)
+	GetUser(id uint) (User, error)
+	GetUserByUsername(username string) (User, error)
+	CountUsers() (int, error)
+	CreateUser(user *User) error
+	UpdateUser(id uint, updates map[string]interface{}) error
+	DeleteUser(id uint) error
+	GetSession(tokenHash string, now time.Time) (Session, error)
+	CreateSession(session *Session) error
+	DeleteSession(id uint) error
+	DeleteUserSessions(userID uint, expiredBy *time.Time) error
+}
+
+// APIKeyStore - Persistence of the keys machine clients call the api with.
+type APIKeyStore interface {
+	GetAllAPIKeys() ([]APIKey, error)
+	GetAPIKey(id uint) (APIKey, error)
+	GetAPIKeyByHash(keyHash string) (APIKey, error)
+	CreateAPIKey(key *APIKey) error
+	RevokeAPIKey(id uint, now time.Time) error
+	TouchAPIKey(id uint, now time.Time) error
+}
+
+// SeedStore - Bulk loading of mock/testing data.
+type SeedStore interface {
+	Wipe() error
+	Seed(data SeedData) error
+}
+
+// Store - Everything the http handlers need from a storage backend.
+type Store interface {
+	BookStore
+	CopyStore
+	AuthorStore
+	MemberStore
+	CheckoutStore
+	LoanPolicyStore
+	HoldStore
+	LedgerStore
+	EventStore
+	WebhookStore
+	UserStore
+	APIKeyStore
+	SeedStore
+
+	// Transaction - Run fn against a Store bound to a single transaction,
+	// committing if fn returns nil and rolling back otherwise.
+	Transaction(fn func(Store) error) error
+
+	// WithActor - A Store recording the events it writes as done by actor.
+	WithActor(actor string) Store
+	Close() error
+}
+
+// gormStore - Store implementation backed by a gorm client.
+type gormStore struct {
+	db    *gorm.DB
+	actor string
+}
+
+// NewGormStore - Wrap an already initialized gorm client as a Store.
+func NewGormStore(db *gorm.DB) Store {
+	return &gormStore{db: db}
+}
+
+// Transaction - Run fn inside a gorm transaction.
+func (s *gormStore) Transaction(fn func(Store) error) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&gormStore{db: tx, actor: s.actor})
+	})
+}
+
+// WithActor - Share the gorm client, recording events as done by actor.
+func (s *gormStore) WithActor(actor string) Store {
+	return &gormStore{db: s.db, actor: actor}
+}
+
+// eventActor - Who events written through the store are recorded as done by.
+func (s *gormStore) eventActor() string {
+	if s.actor == "" {
+		return SystemActor
+	}
+
+	return s.actor
+}
+
+// Close - Close the underlying database connection.
+func (s *gormStore) Close() error {
+	return s.db.Close()
+}
+
+// notFound - Translate gorm's not found error to ErrNotFound.
+func notFound(err error) error {
+	if gorm.IsRecordNotFoundError(err) {
+		return ErrNotFound
+	}
+
+	return err
+}
@@ -0,0 +1,516 @@
+package db
+
+import (
+	"errors"
+	"github.com/satori/go.uuid"
+	"testing"
+	"time"
+)
+
+// newTestStore - A store on a fresh in-memory SQLite database with every migration applied,
+// Close it when done.
+func newTestStore(t *testing.T) *gormStore {
+	t.Helper()
+	client, err := OpenSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateUp(client); err != nil {
+		client.Close()
+		t.Fatal(err)
+	}
+
+	return &gormStore{db: client}
+}
+
+// createMember - Insert a member of a category for a test.
+func createMember(t *testing.T, s *gormStore, category string) Member {
+	t.Helper()
+	member := Member{Person: Person{ID: uuid.NewV4(), FirstName: "Ada", LastName: "Lovelace"}, Category: category}
+	if err := s.CreateMember(&member); err != nil {
+		t.Fatal(err)
+	}
+
+	return member
+}
+
+// createBook - Insert a book with copies in the given statuses for a test.
+func createBook(t *testing.T, s *gormStore, isbn string, statuses ...CopyStatus) (Book, []Copy) {
+	t.Helper()
+	book := Book{ISBN: isbn, BaseBook: BaseBook{Title: "Book " + isbn}}
+	if err := s.CreateBook(&book); err != nil {
+		t.Fatal(err)
+	}
+
+	var copies []Copy
+	for _, status := range statuses {
+		bookCopy := Copy{ISBN: isbn, Status: status}
+		if err := s.CreateCopy(&bookCopy); err != nil {
+			t.Fatal(err)
+		}
+		copies = append(copies, bookCopy)
+	}
+
+	return book, copies
+}
+
+func TestMigrations(t *testing.T) {
+	s := newTestStore(t)
+	defer s.Close()
+
+	ran, err := MigrateUp(s.db)
+	if err != nil || len(ran) != 0 {
+		t.Fatalf("second MigrateUp ran %d migrations, err %v", len(ran), err)
+	}
+
+	// Every migration rolls back and applies again.
+	all := len(sortedMigrations())
+	if ran, err := MigrateDown(s.db, all); err != nil || len(ran) != all {
+		t.Fatalf("MigrateDown rolled back %d of %d migrations, err %v", len(ran), all, err)
+	}
+	if ran, err := MigrateUp(s.db); err != nil || len(ran) != all {
+		t.Fatalf("MigrateUp applied %d of %d migrations, err %v", len(ran), all, err)
+	}
+
+	status, err := GetMigrationStatus(s.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range status {
+		if m.AppliedAt == nil {
+			t.Errorf("migration %d_%s not applied", m.Version, m.Name)
+		}
+	}
+}
+
+func TestBooks(t *testing.T) {
+	s := newTestStore(t)
+	defer s.Close()
+
+	author := Author{Person: Person{ID: uuid.NewV4(), FirstName: "Mary", LastName: "Shelley"}}
+	if err := s.CreateAuthor(&author); err != nil {
+		t.Fatal(err)
+	}
+	createBook(t, s, "9780141439471", CopyOnShelf, CopyWithdrawn)
+	createBook(t, s, "9780199537150", CopyWithdrawn)
+	if err := s.AddBookAuthors("9780141439471", []uuid.UUID{author.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	book, err := s.GetBook("9780141439471")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(book.Authors) != 1 || book.Authors[0].LastName != "Shelley" {
+		t.Errorf("authors = %v, want Shelley", book.Authors)
+	}
+	if len(book.Copies) != 1 {
+		t.Errorf("got %d copies, want only the 1 circulating", len(book.Copies))
+	}
+
+	// Lists leave out books with nothing in circulation, the whole catalogue doesn't.
+	listed, page, err := s.ListBooks(ListQuery{})
+	if err != nil || len(listed) != 1 || page.Total != 1 {
+		t.Errorf("ListBooks = %d books (total %d), err %v, want 1", len(listed), page.Total, err)
+	}
+	all, _, err := s.GetAllBooks(ListQuery{Limit: 1})
+	if err != nil || len(all) != 1 {
+		t.Fatalf("GetAllBooks first page = %d books, err %v", len(all), err)
+	}
+
+	if err := s.DeleteBook("9780141439471"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetBook("9780141439471"); err != ErrNotFound {
+		t.Errorf("GetBook after delete err = %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetBookUnscoped("9780141439471"); err != nil {
+		t.Errorf("GetBookUnscoped after delete err = %v", err)
+	}
+
+	// Purging takes the author links with it.
+	if err := s.PurgeBook("9780141439471"); err != nil {
+		t.Fatal(err)
+	}
+	books, err := s.GetAuthorBooks(author.ID)
+	if err != nil || len(books) != 0 {
+		t.Errorf("author still has %d books after purge, err %v", len(books), err)
+	}
+}
+
+func TestQueriesScopedToID(t *testing.T) {
+	s := newTestStore(t)
+	defer s.Close()
+
+	_, copies := createBook(t, s, "9780141439471", CopyOnShelf, CopyOnShelf)
+	member := createMember(t, s, "standard")
+	createMember(t, s, "standard")
+	policy := LoanPolicy{MemberCategory: "student", LoanDays: 7, MaxItems: 3}
+	if err := s.CreateLoanPolicy(&policy); err != nil {
+		t.Fatal(err)
+	}
+
+	// A zero id would drop out of a gorm struct condition and match every row.
+	if err := s.UpdateCopy(0, map[string]interface{}{"location": "BASEMENT"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateCopy(copies[0].ID, map[string]interface{}{"location": "ATTIC"}); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"ATTIC", ""} {
+		got, err := s.GetCopy(copies[i].ID)
+		if err != nil || got.Location != want {
+			t.Errorf("copy %d location = %q, err %v, want %q", i, got.Location, err, want)
+		}
+	}
+	if _, err := s.GetCopy(0); err != ErrNotFound {
+		t.Errorf("GetCopy(0) err = %v, want ErrNotFound", err)
+	}
+
+	if _, err := s.GetMember(uuid.Nil); err != ErrNotFound {
+		t.Errorf("GetMember(uuid.Nil) err = %v, want ErrNotFound", err)
+	}
+	if err := s.DeleteMember(uuid.Nil); err != nil {
+		t.Fatal(err)
+	}
+	if members, _, err := s.ListMembers(false, ListQuery{}); err != nil || len(members) != 2 {
+		t.Errorf("%d members left after deleting uuid.Nil, err %v, want 2", len(members), err)
+	}
+	if err := s.UpdateMember(member.ID, map[string]interface{}{"category": "student"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.GetMember(member.ID); got.Category != "student" {
+		t.Errorf("category = %q, want student", got.Category)
+	}
+
+	if err := s.DeleteLoanPolicy(0); err != nil {
+		t.Fatal(err)
+	}
+	if policies, err := s.GetAllLoanPolicies(); err != nil || len(policies) != 1 {
+		t.Errorf("%d policies left after deleting id 0, err %v, want 1", len(policies), err)
+	}
+	if _, err := s.GetOpenCheckout(0, uuid.Nil); err != ErrNotFound {
+		t.Errorf("GetOpenCheckout(0, uuid.Nil) err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestGetAvailableCopy(t *testing.T) {
+	s := newTestStore(t)
+	defer s.Close()
+
+	_, copies := createBook(t, s, "9780141439471", CopyDamaged, CopyOnShelf, CopyOnShelf, CopyOnShelf)
+	member := createMember(t, s, "standard")
+
+	// The first shelved copy is on loan and the next is set aside for a hold.
+	loan := Checkout{BookID: copies[1].ID, MemberID: member.ID, CheckedOut: time.Now()}
+	if err := s.CreateCheckout(&loan); err != nil {
+		t.Fatal(err)
+	}
+	hold := Hold{ISBN: "9780141439471", MemberID: member.ID, Status: HoldReady, CopyID: &copies[2].ID}
+	if err := s.CreateHold(&hold); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetAvailableCopy("9780141439471")
+	if err != nil || got.ID != copies[3].ID {
+		t.Fatalf("GetAvailableCopy = copy %d, err %v, want copy %d", got.ID, err, copies[3].ID)
+	}
+
+	if err := s.UpdateCopy(copies[3].ID, map[string]interface{}{"status": CopyCheckedOut}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetAvailableCopy("9780141439471"); err != ErrNotFound {
+		t.Errorf("GetAvailableCopy with nothing on the shelf err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestCheckouts(t *testing.T) {
+	s := newTestStore(t)
+	defer s.Close()
+
+	_, copies := createBook(t, s, "9780141439471", CopyOnShelf, CopyOnShelf)
+	ada := createMember(t, s, "standard")
+	grace := createMember(t, s, "standard")
+
+	now := time.Now()
+	loans := []Checkout{
+		{BookID: copies[0].ID, MemberID: ada.ID, CheckedOut: now.AddDate(0, 0, -20), Returned: &now},
+		{BookID: copies[0].ID, MemberID: grace.ID, CheckedOut: now.AddDate(0, 0, -5)},
+		{BookID: copies[1].ID, MemberID: ada.ID, CheckedOut: now.AddDate(0, 0, -1)},
+	}
+	for i := range loans {
+		if err := s.CreateCheckout(&loans[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	open, err := s.GetOpenCheckout(copies[0].ID, ada.ID)
+	if err != ErrNotFound {
+		t.Errorf("GetOpenCheckout of a returned loan = %d, err %v, want ErrNotFound", open.ID, err)
+	}
+	open, err = s.GetOpenCheckout(copies[0].ID, grace.ID)
+	if err != nil || open.ID != loans[1].ID {
+		t.Errorf("GetOpenCheckout = %d, err %v, want %d", open.ID, err, loans[1].ID)
+	}
+	open, err = s.GetOpenCheckoutByCopy(copies[1].ID)
+	if err != nil || open.ID != loans[2].ID {
+		t.Errorf("GetOpenCheckoutByCopy = %d, err %v, want %d", open.ID, err, loans[2].ID)
+	}
+
+	if count, err := s.CountOpenCheckouts(ada.ID); err != nil || count != 1 {
+		t.Errorf("CountOpenCheckouts = %d, err %v, want 1", count, err)
+	}
+	history, err := s.GetCheckoutsByMember(ada.ID)
+	if err != nil || len(history) != 2 || history[0].ID != loans[2].ID {
+		t.Errorf("GetCheckoutsByMember = %v, err %v, want newest of 2 first", history, err)
+	}
+
+	// Only open loans that fall due in the window are listed.
+	dueAt := now.AddDate(0, 0, 2)
+	if err := s.UpdateCheckout(loans[1].ID, map[string]interface{}{"due_at": dueAt}); err != nil {
+		t.Fatal(err)
+	}
+	week := now.AddDate(0, 0, 7)
+	listed, _, err := s.ListCheckouts(CheckoutFilter{OpenOnly: true, DueBefore: &week}, ListQuery{})
+	if err != nil || len(listed) != 1 || listed[0].ID != loans[1].ID {
+		t.Errorf("ListCheckouts due within a week = %v, err %v, want loan %d", listed, err, loans[1].ID)
+	}
+}
+
+func TestResolveLoanPolicy(t *testing.T) {
+	s := newTestStore(t)
+	defer s.Close()
+
+	policies := []LoanPolicy{
+		{MemberCategory: "student", LoanDays: 21, MaxItems: 5},
+		{ISBN: "9780141439471", LoanDays: 7, MaxItems: 5},
+		{MemberCategory: "student", ISBN: "9780141439471", LoanDays: 3, MaxItems: 5},
+	}
+	for i := range policies {
+		if err := s.CreateLoanPolicy(&policies[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fallback := LoanPolicy{LoanDays: 14, MaxItems: 5}
+
+	tests := []struct {
+		name     string
+		category string
+		isbn     string
+		loanDays int
+	}{
+		{"category and book", "student", "9780141439471", 3},
+		{"book beats category", "staff", "9780141439471", 7},
+		{"category", "student", "9780199537150", 21},
+		{"member wide", "student", "", 21},
+		{"fallback", "staff", "9780199537150", 14},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := s.ResolveLoanPolicy(tt.category, tt.isbn, fallback)
+			if err != nil || policy.LoanDays != tt.loanDays {
+				t.Errorf("loan days = %d, err %v, want %d", policy.LoanDays, err, tt.loanDays)
+			}
+		})
+	}
+}
+
+func TestHolds(t *testing.T) {
+	s := newTestStore(t)
+	defer s.Close()
+
+	_, copies := createBook(t, s, "9780141439471", CopyOnShelf)
+	ada := createMember(t, s, "standard")
+	grace := createMember(t, s, "standard")
+
+	now := time.Now()
+	expired := now.Add(-time.Hour)
+	holds := []Hold{
+		{ISBN: "9780141439471", MemberID: ada.ID, Status: HoldReady, CopyID: &copies[0].ID, ExpiresAt: &expired},
+		{ISBN: "9780141439471", MemberID: grace.ID, Status: HoldWaiting},
+		{ISBN: "9780141439471", MemberID: ada.ID, Status: HoldCancelled},
+	}
+	for i := range holds {
+		if err := s.CreateHold(&holds[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	next, err := s.GetNextHold("9780141439471")
+	if err != nil || next.ID != holds[1].ID {
+		t.Errorf("GetNextHold = %d, err %v, want %d", next.ID, err, holds[1].ID)
+	}
+	active, err := s.GetActiveHold("9780141439471", ada.ID)
+	if err != nil || active.ID != holds[0].ID {
+		t.Errorf("GetActiveHold = %d, err %v, want %d", active.ID, err, holds[0].ID)
+	}
+	onCopy, err := s.GetCopyHold(copies[0].ID)
+	if err != nil || onCopy.ID != holds[0].ID {
+		t.Errorf("GetCopyHold = %d, err %v, want %d", onCopy.ID, err, holds[0].ID)
+	}
+
+	due, err := s.GetExpiredHolds(now)
+	if err != nil || len(due) != 1 || due[0].ID != holds[0].ID {
+		t.Errorf("GetExpiredHolds = %v, err %v, want hold %d", due, err, holds[0].ID)
+	}
+
+	if err := s.UpdateHold(0, map[string]interface{}{"status": HoldExpired}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateHold(holds[0].ID, map[string]interface{}{"status": HoldExpired}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetActiveHold("9780141439471", ada.ID); err != ErrNotFound {
+		t.Errorf("GetActiveHold after expiry err = %v, want ErrNotFound", err)
+	}
+	if waiting, _ := s.GetHold(holds[1].ID); waiting.Status != HoldWaiting {
+		t.Errorf("hold %d status = %s after updating hold 0, want waiting", waiting.ID, waiting.Status)
+	}
+}
+
+func TestLedgerBalance(t *testing.T) {
+	s := newTestStore(t)
+	defer s.Close()
+
+	ada := createMember(t, s, "standard")
+	grace := createMember(t, s, "standard")
+
+	entries := []LedgerEntry{
+		{MemberID: ada.ID, EntryType: LedgerFine, AmountCents: 150},
+		{MemberID: ada.ID, EntryType: LedgerLostFee, AmountCents: 2000},
+		{MemberID: ada.ID, EntryType: LedgerPayment, AmountCents: -500},
+		{MemberID: grace.ID, EntryType: LedgerFine, AmountCents: 75},
+	}
+	for i := range entries {
+		if err := s.CreateLedgerEntry(&entries[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if balance, err := s.GetLedgerBalance(ada.ID); err != nil || balance != 1650 {
+		t.Errorf("balance = %d, err %v, want 1650", balance, err)
+	}
+	if got, err := s.GetLedgerEntries(ada.ID); err != nil || len(got) != 3 || got[0].ID != entries[2].ID {
+		t.Errorf("GetLedgerEntries = %v, err %v, want newest of 3 first", got, err)
+	}
+	if balance, err := s.GetLedgerBalance(uuid.NewV4()); err != nil || balance != 0 {
+		t.Errorf("balance without entries = %d, err %v, want 0", balance, err)
+	}
+}
+
+func TestSessionsAndAPIKeys(t *testing.T) {
+	s := newTestStore(t)
+	defer s.Close()
+
+	user := User{Username: "librarian", Role: RoleLibrarian}
+	if err := s.CreateUser(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	sessions := []Session{
+		{UserID: user.ID, TokenHash: "old", ExpiresAt: now.Add(-time.Minute)},
+		{UserID: user.ID, TokenHash: "new", ExpiresAt: now.Add(time.Hour)},
+	}
+	for i := range sessions {
+		if err := s.CreateSession(&sessions[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.GetSession("old", now); err != ErrNotFound {
+		t.Errorf("expired session err = %v, want ErrNotFound", err)
+	}
+	if got, err := s.GetSession("new", now); err != nil || got.ID != sessions[1].ID {
+		t.Errorf("GetSession = %d, err %v, want %d", got.ID, err, sessions[1].ID)
+	}
+
+	// Clearing out expired sessions keeps the live one.
+	if err := s.DeleteUserSessions(user.ID, &now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetSession("new", now); err != nil {
+		t.Errorf("live session gone after clearing expired ones: %v", err)
+	}
+	if err := s.DeleteUser(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := s.CountUsers(); count != 0 {
+		t.Errorf("%d users left, want 0", count)
+	}
+
+	key := APIKey{Name: "catalog sync", KeyHash: "hash", Scopes: ScopeList{ScopeCatalogRead}}
+	if err := s.CreateAPIKey(&key); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.TouchAPIKey(key.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.GetAPIKeyByHash("hash"); err != nil || got.LastUsedAt == nil {
+		t.Errorf("GetAPIKeyByHash = %+v, err %v, want it marked used", got, err)
+	}
+	if err := s.RevokeAPIKey(key.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetAPIKeyByHash("hash"); err != ErrNotFound {
+		t.Errorf("revoked key err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	s := newTestStore(t)
+	defer s.Close()
+
+	errAbort := errors.New("abort")
+	err := s.Transaction(func(tx Store) error {
+		if err := tx.CreateBook(&Book{ISBN: "9780141439471"}); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("Transaction err = %v, want %v", err, errAbort)
+	}
+	if _, err := s.GetBook("9780141439471"); err != ErrNotFound {
+		t.Errorf("book created in a rolled back transaction, err %v", err)
+	}
+
+	err = s.Transaction(func(tx Store) error {
+		return tx.CreateBook(&Book{ISBN: "9780141439471"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetBook("9780141439471"); err != nil {
+		t.Errorf("book missing after commit: %v", err)
+	}
+}
+
+func TestEventActor(t *testing.T) {
+	s := newTestStore(t)
+	defer s.Close()
+
+	system := Event{ISBN: "9780141439471", EventType: CREATE, EntityType: EntityBook, EntityID: "9780141439471"}
+	if err := s.CreateEvent(&system); err != nil {
+		t.Fatal(err)
+	}
+	err := s.WithActor("librarian").Transaction(func(tx Store) error {
+		return tx.CreateEvent(&Event{ISBN: "9780141439471", EventType: UPDATE, EntityType: EntityBook, EntityID: "9780141439471"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := s.GetEventsAfter(0, nil, 10)
+	if err != nil || len(events) != 2 {
+		t.Fatalf("GetEventsAfter = %d events, err %v, want 2", len(events), err)
+	}
+	for i, want := range []string{SystemActor, "librarian"} {
+		if events[i].Actor != want {
+			t.Errorf("event %d actor = %q, want %q", i, events[i].Actor, want)
+		}
+	}
+	if last, err := s.GetLastEventID(); err != nil || last != events[1].ID {
+		t.Errorf("GetLastEventID = %d, err %v, want %d", last, err, events[1].ID)
+	}
+}
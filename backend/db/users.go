@@ -0,0 +1,77 @@
+package db
+
+import (
+	"time"
+)
+
+// GetAllUsers - Retrieve all accounts.
+func (s *gormStore) GetAllUsers() ([]User, error) {
+	var users []User
+	err := s.db.Order("username").Find(&users).Error
+	return users, err
+}
+
+// GetUser - Retrieve a single account by id.
+func (s *gormStore) GetUser(id uint) (User, error) {
+	var user User
+	err := s.db.Where("id = ?", id).First(&user).Error
+	return user, notFound(err)
+}
+
+// GetUserByUsername - Retrieve the account signing in with a username.
+func (s *gormStore) GetUserByUsername(username string) (User, error) {
+	var user User
+	err := s.db.Where("username = ?", username).First(&user).Error
+	return user, notFound(err)
+}
+
+// CountUsers - Count the accounts, none means nobody can sign in yet.
+func (s *gormStore) CountUsers() (int, error) {
+	var count int
+	err := s.db.Model(&User{}).Count(&count).Error
+	return count, err
+}
+
+// CreateUser - Insert a new account.
+func (s *gormStore) CreateUser(user *User) error {
+	return s.db.Create(user).Error
+}
+
+// UpdateUser - Apply column updates to an account.
+func (s *gormStore) UpdateUser(id uint, updates map[string]interface{}) error {
+	return s.db.Model(&User{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// DeleteUser - Hard delete an account, freeing its username.
+func (s *gormStore) DeleteUser(id uint) error {
+	return s.db.Unscoped().Where("id = ?", id).Delete(&User{}).Error
+}
+
+// GetSession - Retrieve the session of a token hash, unless it expired by now.
+func (s *gormStore) GetSession(tokenHash string, now time.Time) (Session, error) {
+	var session Session
+	err := s.db.
+		Where("token_hash = ? AND expires_at > ?", tokenHash, now).
+		First(&session).Error
+	return session, notFound(err)
+}
+
+// CreateSession - Insert a new session.
+func (s *gormStore) CreateSession(session *Session) error {
+	return s.db.Create(session).Error
+}
+
+// DeleteSession - Hard delete a session, signing it out.
+func (s *gormStore) DeleteSession(id uint) error {
+	return s.db.Unscoped().Where("id = ?", id).Delete(&Session{}).Error
+}
+
+// DeleteUserSessions - Hard delete a user's sessions, only those expired by expiredBy when given.
+func (s *gormStore) DeleteUserSessions(userID uint, expiredBy *time.Time) error {
+	query := s.db.Unscoped().Where("user_id = ?", userID)
+	if expiredBy != nil {
+		query = query.Where("expires_at <= ?", *expiredBy)
+	}
+
+	return query.Delete(&Session{}).Error
+}
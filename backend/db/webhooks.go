@@ -0,0 +1,264 @@
+package db
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"github.com/jinzhu/gorm"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TopicList - Topics a webhook subscribes to, stored comma separated.
+type TopicList []string
+
+// Value - Store a topic list as comma separated text.
+func (t TopicList) Value() (driver.Value, error) {
+	return strings.Join(t, ","), nil
+}
+
+// Scan - Read a topic list back from its comma separated text.
+func (t *TopicList) Scan(value interface{}) error {
+	items, err := scanCommaList(value)
+	*t = items
+	return err
+}
+
+// scanCommaList - Split a comma separated text column into its items.
+func scanCommaList(value interface{}) ([]string, error) {
+	var raw string
+	switch v := value.(type) {
+	case nil:
+	case []byte:
+		raw = string(v)
+	case string:
+		raw = v
+	default:
+		return nil, errors.New("can't scan a list from " + reflect.TypeOf(value).String())
+	}
+
+	items := []string{}
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items, nil
+}
+
+// Matches - Check a topic is covered by the list, as itself, by entity.*, *.action or by *.
+func (t TopicList) Matches(topic string) bool {
+	entity, action := splitTopic(topic)
+	for _, pattern := range t {
+		if pattern == "*" || pattern == topic {
+			return true
+		}
+		patternEntity, patternAction := splitTopic(pattern)
+		if (patternEntity == "*" || patternEntity == entity) && (patternAction == "*" || patternAction == action) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitTopic - The entity and action parts of a topic, empty when it has no action.
+func splitTopic(topic string) (string, string) {
+	parts := strings.SplitN(topic, ".", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+
+	return parts[0], parts[1]
+}
+
+// topicEntities - Entity types webhooks can subscribe to.
+var topicEntities = []EntityType{
+	EntityBook, EntityCopy, EntityAuthor, EntityMember, EntityCheckout,
+	EntityHold, EntityLoanPolicy, EntityLedgerEntry, EntityWebhook, EntityUser, EntityAPIKey,
+}
+
+// topicActions - Event types webhooks can subscribe to.
+var topicActions = []BookEventType{
+	CREATE, UPDATE, DELETE, ADD, WITHDRAW, CHECKOUT, RETURN, RENEW, LOST,
+}
+
+// IsTopic - Check a webhook topic names a known entity and action, either can be *.
+func IsTopic(topic string) bool {
+	if topic == "*" {
+		return true
+	}
+
+	parts := strings.Split(topic, ".")
+	if len(parts) != 2 {
+		return false
+	}
+	entityOK, actionOK := parts[0] == "*", parts[1] == "*"
+	for _, entity := range topicEntities {
+		entityOK = entityOK || parts[0] == string(entity)
+	}
+	for _, action := range topicActions {
+		actionOK = actionOK || parts[1] == strings.ToLower(string(action))
+	}
+
+	return entityOK && actionOK
+}
+
+// Topic - The webhook topic of an event, its entity and action, e.g. book.create or checkout.return.
+func (e Event) Topic() string {
+	return string(e.EntityType) + "." + strings.ToLower(string(e.EventType))
+}
+
+// WebhookPayload - Body posted to webhooks for an event.
+type WebhookPayload struct {
+	Topic string `json:"topic"`
+	Event Event  `json:"event"`
+}
+
+// deliveryList - Filters and sort orders of webhook delivery lists.
+var deliveryList = listSpec{
+	Key:         sortField{Column: "id", Kind: kindInt},
+	DefaultSort: []string{"-id"},
+	Sorts: map[string]sortField{
+		"id":              {Column: "id", Kind: kindInt},
+		"created_at":      {Column: "created_at", Kind: kindTime},
+		"next_attempt_at": {Column: "next_attempt_at", Kind: kindTime, Nullable: true},
+	},
+	Filters: map[string]filterFunc{
+		"status": equals("status"),
+		"topic":  equals("topic"),
+		"event_id": func(query *gorm.DB, value string) (*gorm.DB, error) {
+			id, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return nil, invalidList("event_id must be an event id")
+			}
+			return query.Where("event_id = ?", id), nil
+		},
+	},
+}
+
+// enqueueWebhookDeliveries - Queue an event for every active webhook subscribed to its topic,
+// in the same transaction as the event.
+func (s *gormStore) enqueueWebhookDeliveries(event Event) error {
+	var webhooks []Webhook
+	if err := s.db.Where("active = ?", true).Find(&webhooks).Error; err != nil {
+		return err
+	}
+
+	topic := event.Topic()
+	var payload []byte
+	for _, webhook := range webhooks {
+		if !webhook.Topics.Matches(topic) {
+			continue
+		}
+		if payload == nil {
+			var err error
+			if payload, err = json.Marshal(WebhookPayload{Topic: topic, Event: event}); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		delivery := WebhookDelivery{
+			Base: Base{
+				CreatedAt: now,
+				UpdatedAt: now,
+			},
+			WebhookID:     webhook.ID,
+			EventID:       event.ID,
+			Topic:         topic,
+			Payload:       string(payload),
+			Status:        DeliveryPending,
+			NextAttemptAt: &now,
+		}
+		if err := s.db.Create(&delivery).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAllWebhooks - Retrieve all webhook subscriptions.
+func (s *gormStore) GetAllWebhooks() ([]Webhook, error) {
+	var webhooks []Webhook
+	err := s.db.Order("id").Find(&webhooks).Error
+	return webhooks, err
+}
+
+// GetWebhook - Retrieve a single webhook subscription by id.
+func (s *gormStore) GetWebhook(id uint) (Webhook, error) {
+	var webhook Webhook
+	err := s.db.Where("id = ?", id).First(&webhook).Error
+	return webhook, notFound(err)
+}
+
+// CreateWebhook - Insert a new webhook subscription.
+func (s *gormStore) CreateWebhook(webhook *Webhook) error {
+	return s.db.Create(webhook).Error
+}
+
+// UpdateWebhook - Apply column updates to a webhook subscription.
+func (s *gormStore) UpdateWebhook(id uint, updates map[string]interface{}) error {
+	return s.db.Model(&Webhook{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// DeleteWebhook - Soft delete a webhook subscription, its queued deliveries fail when they come up.
+func (s *gormStore) DeleteWebhook(id uint) error {
+	return s.db.Where("id = ?", id).Delete(&Webhook{}).Error
+}
+
+// ListWebhookDeliveries - Retrieve a page of a webhook's delivery log, newest first by default.
+func (s *gormStore) ListWebhookDeliveries(webhookID uint, q ListQuery) ([]WebhookDelivery, Page, error) {
+	var deliveries []WebhookDelivery
+	page, err := deliveryList.list(s.db.Where("webhook_id = ?", webhookID), q, &deliveries)
+	return deliveries, page, err
+}
+
+// GetWebhookDelivery - Retrieve a single delivery with the history of its attempts.
+func (s *gormStore) GetWebhookDelivery(id uint) (WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	err := s.db.
+		Preload("History", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Where("id = ?", id).
+		First(&delivery).Error
+	return delivery, notFound(err)
+}
+
+// GetDueWebhookDeliveries - Pending deliveries whose next attempt is due by now, oldest first.
+func (s *gormStore) GetDueWebhookDeliveries(now time.Time, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := s.db.
+		Where("status = ? AND next_attempt_at <= ?", DeliveryPending, now).
+		Order("next_attempt_at, id").
+		Limit(limit).
+		Find(&deliveries).Error
+	return deliveries, err
+}
+
+// ClaimWebhookDelivery - Count a new attempt at a delivery and hold it off until retryAt, in case
+// the attempt never finishes. False when another attempt got to it first.
+func (s *gormStore) ClaimWebhookDelivery(delivery WebhookDelivery, retryAt time.Time) (bool, error) {
+	result := s.db.Model(&WebhookDelivery{}).
+		Where("id = ? AND status = ? AND attempts = ?", delivery.ID, DeliveryPending, delivery.Attempts).
+		Updates(map[string]interface{}{
+			"attempts":        delivery.Attempts + 1,
+			"next_attempt_at": retryAt,
+			"updated_at":      time.Now(),
+		})
+
+	return result.RowsAffected == 1, result.Error
+}
+
+// UpdateWebhookDelivery - Apply column updates to a delivery.
+func (s *gormStore) UpdateWebhookDelivery(id uint, updates map[string]interface{}) error {
+	return s.db.Model(&WebhookDelivery{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// CreateWebhookAttempt - Log an attempt at sending a delivery.
+func (s *gormStore) CreateWebhookAttempt(attempt *WebhookAttempt) error {
+	return s.db.Create(attempt).Error
+}
@@ -0,0 +1,482 @@
+// Package generate builds synthetic libraries for load tests and demos: authors, books, copies,
+// members and the loan history between them over a date range. The same options and seed always
+// give the same library.
+package generate
+
+import (
+	"errors"
+	"fmt"
+	"github.com/satori/go.uuid"
+	"main/db"
+	"main/isbn"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Options - Size and shape of a generated library.
+type Options struct {
+	// Seed - The same seed and options give the same library.
+	Seed int64
+
+	Authors int
+	Books   int
+	Members int
+
+	// MinCopies, MaxCopies - Copies bought of each book, popular books get more.
+	MinCopies int
+	MaxCopies int
+
+	// From, To - Range the loan history covers. Loans still out at To are left open.
+	From time.Time
+	To   time.Time
+
+	// LoanDays - Length of a loan, and of each renewal.
+	LoanDays int
+
+	// FinePerDay, FineCap, ReplacementFee - What late and lost loans are charged, in cents.
+	FinePerDay     int64
+	FineCap        int64
+	ReplacementFee int64
+}
+
+// Shape of the loan history.
+const (
+	renewOnceRate  = 0.20  // Loans renewed at least once.
+	renewTwiceRate = 0.05  // Loans renewed twice.
+	lostRate       = 0.003 // Loans never coming back.
+	lateRate       = 0.15  // Loans returned after their due date.
+	meanDaysLate   = 6.0   // How late late returns are on average.
+	paidRate       = 0.75  // Charges paid off within a month.
+	backlistRate   = 0.70  // Books already on the shelves when the history starts.
+	longtimeRate   = 0.40  // Members who joined before the history starts.
+
+	// Days between loans of a copy of the most popular book, less popular books wait longer.
+	busiestGapDays = 3.0
+	quietestGap    = 400.0
+)
+
+// day - One day, the unit loan lengths and gaps are counted in.
+const day = 24 * time.Hour
+
+// Common generate errors
+var errorCounts = errors.New("authors and books must be at least 1, members at least 0")
+var errorCopies = errors.New("copies must be a range of at least 1, e.g. 1-4")
+var errorRange = errors.New("history must end after it starts")
+var errorLoanDays = errors.New("loan days must be at least 1")
+
+// Validate - Make sure the options describe a library that can be generated.
+func (o Options) Validate() error {
+	switch {
+	case o.Authors < 1 || o.Books < 1 || o.Members < 0:
+		return errorCounts
+	case o.MinCopies < 1 || o.MaxCopies < o.MinCopies:
+		return errorCopies
+	case !o.To.After(o.From):
+		return errorRange
+	case o.LoanDays < 1:
+		return errorLoanDays
+	}
+
+	return nil
+}
+
+// generator - State of a single run, all randomness comes from rand so runs can be repeated.
+type generator struct {
+	opts Options
+	rand *rand.Rand
+	data db.SeedData
+
+	isbns map[string]bool
+
+	// authorWeights, memberWeights - Cumulative weights to pick authors and borrowers with,
+	// members ordered by when they joined.
+	authorWeights []float64
+	memberWeights []float64
+
+	// loans - Loans in the order they were made up, with the charges against each.
+	loans []loan
+}
+
+// loan - A checkout and the ledger entries it led to.
+type loan struct {
+	checkout db.Checkout
+	entries  []db.LedgerEntry
+}
+
+// Generate - Make up a library. Checkouts name their copy, and ledger entries their checkout, by
+// position in the returned data, the way db.Seed expects.
+func Generate(opts Options) (db.SeedData, error) {
+	if err := opts.Validate(); err != nil {
+		return db.SeedData{}, err
+	}
+
+	g := &generator{
+		opts:  opts,
+		rand:  rand.New(rand.NewSource(opts.Seed)),
+		isbns: map[string]bool{},
+	}
+	g.authors()
+	popularity, err := g.books()
+	if err != nil {
+		return db.SeedData{}, err
+	}
+	g.members()
+	g.circulation(popularity)
+
+	return g.data, nil
+}
+
+// uuid - A random version 4 uuid drawn from the run's randomness.
+func (g *generator) uuid() uuid.UUID {
+	var id uuid.UUID
+	g.rand.Read(id[:])
+	id.SetVersion(uuid.V4)
+	id.SetVariant(uuid.VariantRFC4122)
+
+	return id
+}
+
+// word - A random entry of a word list.
+func (g *generator) word(words []string) string {
+	return words[g.rand.Intn(len(words))]
+}
+
+// between - A random time in [from, to).
+func (g *generator) between(from, to time.Time) time.Time {
+	if !to.After(from) {
+		return from
+	}
+
+	return from.Add(time.Duration(g.rand.Int63n(int64(to.Sub(from)))))
+}
+
+// expDays - An exponentially distributed wait averaging mean days.
+func (g *generator) expDays(mean float64) time.Duration {
+	return time.Duration(g.rand.ExpFloat64() * mean * float64(day))
+}
+
+// zipf - Weights falling off with rank like real popularity, in a random order, most popular 1.
+func (g *generator) zipf(n int, exponent float64) []float64 {
+	weights := make([]float64, n)
+	for i, rank := range g.rand.Perm(n) {
+		weights[i] = 1 / math.Pow(float64(rank+1), exponent)
+	}
+
+	return weights
+}
+
+// cumulative - Running totals of weights, to pick from with pick.
+func cumulative(weights []float64) []float64 {
+	totals := make([]float64, len(weights))
+	sum := 0.0
+	for i, weight := range weights {
+		sum += weight
+		totals[i] = sum
+	}
+
+	return totals
+}
+
+// pick - A random index into the first n entries of cumulative weights, likelier the heavier it is.
+func (g *generator) pick(totals []float64, n int) int {
+	x := g.rand.Float64() * totals[n-1]
+	return sort.SearchFloat64s(totals[:n], x)
+}
+
+// person - A made up name, with a middle initial now and then.
+func (g *generator) person(base db.Base) db.Person {
+	person := db.Person{
+		Base:      base,
+		ID:        g.uuid(),
+		FirstName: g.word(firstNames),
+		LastName:  g.word(lastNames),
+	}
+	if g.rand.Float64() < 0.3 {
+		person.Middle = string(rune('A' + g.rand.Intn(26)))
+	}
+
+	return person
+}
+
+// stamp - Created and updated at t.
+func stamp(t time.Time) db.Base {
+	return db.Base{CreatedAt: t, UpdatedAt: t}
+}
+
+// authors - Make up the authors, a few of them far more prolific than the rest.
+func (g *generator) authors() {
+	for i := 0; i < g.opts.Authors; i++ {
+		created := g.between(g.opts.From.AddDate(-1, 0, 0), g.opts.From)
+		g.data.Authors = append(g.data.Authors, db.Author{Person: g.person(stamp(created))})
+	}
+	g.authorWeights = cumulative(g.zipf(g.opts.Authors, 1.1))
+}
+
+// newISBN - A valid isbn not used yet in this run.
+func (g *generator) newISBN() (string, error) {
+	for {
+		prefix := "978"
+		if g.rand.Float64() < 0.1 {
+			prefix = "979"
+		}
+		code, err := isbn.Complete(fmt.Sprintf("%s%09d", prefix, g.rand.Int63n(1e9)))
+		if err != nil {
+			return "", err
+		}
+		if !g.isbns[code] {
+			g.isbns[code] = true
+			return code, nil
+		}
+	}
+}
+
+// books - Make up the books, their authors and copies. Returns how popular each book is, from
+// 1 for the most popular down, popular books get more copies.
+func (g *generator) books() ([]float64, error) {
+	popularity := g.zipf(g.opts.Books, 0.8)
+	for i := 0; i < g.opts.Books; i++ {
+		code, err := g.newISBN()
+		if err != nil {
+			return nil, err
+		}
+
+		// Most of the collection predates the history, the rest is bought during it.
+		created := g.between(g.opts.From.AddDate(-3, 0, 0), g.opts.From)
+		if g.rand.Float64() >= backlistRate {
+			created = g.between(g.opts.From, g.opts.To)
+		}
+
+		words := []interface{}{g.word(adjectives), g.word(nouns), g.word(nouns), g.word(places)}
+		book := db.Book{
+			Base: stamp(created),
+			ISBN: code,
+			BaseBook: db.BaseBook{
+				Title:       fmt.Sprintf(g.word(titleTemplates), words...),
+				Description: fmt.Sprintf(g.word(descriptionTemplates), words...),
+			},
+		}
+		if g.rand.Float64() < 0.4 {
+			book.ReplacementCents = int64(15+g.rand.Intn(46)) * 100
+		}
+		g.data.Books = append(g.data.Books, book)
+
+		// Mostly one author, sometimes two or three.
+		authorCount := 1
+		if r := g.rand.Float64(); r < 0.05 {
+			authorCount = 3
+		} else if r < 0.20 {
+			authorCount = 2
+		}
+		picked := map[int]bool{}
+		for tries := 0; len(picked) < authorCount && tries < 10; tries++ {
+			author := g.pick(g.authorWeights, len(g.authorWeights))
+			if picked[author] {
+				continue
+			}
+			picked[author] = true
+			g.data.BooksAuthors = append(g.data.BooksAuthors, db.BooksAuthors{
+				BookISBN: code,
+				AuthorID: g.data.Authors[author].ID,
+			})
+		}
+
+		spread := float64(g.opts.MaxCopies - g.opts.MinCopies)
+		copies := g.opts.MinCopies + int(math.Round(spread*math.Pow(popularity[i], 0.25)))
+		for c := 0; c < copies; c++ {
+			g.data.Copies = append(g.data.Copies, db.Copy{ISBN: code, Status: db.CopyOnShelf})
+		}
+	}
+
+	return popularity, nil
+}
+
+// members - Make up the members, ordered by when they joined, some borrowing far more than others.
+func (g *generator) members() {
+	for i := 0; i < g.opts.Members; i++ {
+		joined := g.between(g.opts.From.AddDate(-3, 0, 0), g.opts.From)
+		if g.rand.Float64() >= longtimeRate {
+			joined = g.between(g.opts.From, g.opts.To)
+		}
+		g.data.Members = append(g.data.Members, db.Member{
+			Person:   g.person(stamp(joined)),
+			Category: db.DefaultMemberCategory,
+		})
+	}
+	sort.SliceStable(g.data.Members, func(i, j int) bool {
+		return g.data.Members[i].CreatedAt.Before(g.data.Members[j].CreatedAt)
+	})
+
+	weights := make([]float64, len(g.data.Members))
+	for i := range weights {
+		weights[i] = math.Exp(g.rand.NormFloat64())
+	}
+	g.memberWeights = cumulative(weights)
+}
+
+// borrower - A member who had joined by t, active members likelier. False when nobody had.
+func (g *generator) borrower(t time.Time) (db.Member, bool) {
+	joined := sort.Search(len(g.data.Members), func(i int) bool {
+		return g.data.Members[i].CreatedAt.After(t)
+	})
+	if joined == 0 {
+		return db.Member{}, false
+	}
+
+	return g.data.Members[g.pick(g.memberWeights, joined)], true
+}
+
+// circulation - Lend every copy out over the history, one loan after another, more often the more
+// popular its book. Copies end up lost or still out when their last loan is.
+func (g *generator) circulation(popularity []float64) {
+	copyPosition := 0
+	for i, book := range g.data.Books {
+		meanGap := math.Min(busiestGapDays/math.Sqrt(popularity[i]), quietestGap)
+		replacement := g.opts.ReplacementFee
+		if book.ReplacementCents > 0 {
+			replacement = book.ReplacementCents
+		}
+
+		for _, bookCopy := range g.data.Copies[copyPosition:] {
+			if bookCopy.ISBN != book.ISBN {
+				break
+			}
+			copyPosition++
+
+			start := g.opts.From
+			if book.CreatedAt.After(start) {
+				start = book.CreatedAt
+			}
+			g.lendCopy(uint(copyPosition), start, meanGap, replacement)
+		}
+	}
+
+	g.collect()
+}
+
+// lendCopy - Make up the loans of the copy at position, starting after start.
+func (g *generator) lendCopy(position uint, start time.Time, meanGap float64, replacement int64) {
+	loanLength := time.Duration(g.opts.LoanDays) * day
+	t := start.Add(g.expDays(meanGap))
+	for t.Before(g.opts.To) {
+		member, ok := g.borrower(t)
+		if !ok {
+			t = t.Add(7 * day)
+			continue
+		}
+
+		due := t.Add(loanLength)
+		checkout := db.Checkout{
+			Base:       stamp(t),
+			BookID:     position,
+			MemberID:   member.ID,
+			CheckedOut: t,
+		}
+		renewals := 0
+		if r := g.rand.Float64(); r < renewTwiceRate {
+			renewals = 2
+		} else if r < renewOnceRate {
+			renewals = 1
+		}
+		for r := 0; r < renewals; r++ {
+			renewedAt := g.between(due.Add(-2*day), due)
+			checkout.RenewedAt = &renewedAt
+			checkout.Renewals++
+			due = due.Add(loanLength)
+		}
+		checkout.DueAt = &due
+
+		var back time.Time
+		lost := false
+		switch r := g.rand.Float64(); {
+		case r < lostRate:
+			lost = true
+			back = g.between(due.Add(30*day), due.Add(60*day))
+		case r < lostRate+lateRate:
+			back = due.Add(time.Hour + g.expDays(meanDaysLate))
+		default:
+			back = g.between(t.Add(day), due)
+		}
+
+		// Still out when the history ends.
+		if !back.Before(g.opts.To) {
+			g.loans = append(g.loans, loan{checkout: checkout})
+			return
+		}
+
+		current := loan{checkout: checkout}
+		current.checkout.UpdatedAt = back
+		current.checkout.Returned = &back
+		if lost {
+			current.checkout.LostAt = &back
+			g.charge(&current, db.LedgerFine, g.fine(due, back), back)
+			g.charge(&current, db.LedgerLostFee, replacement, back)
+			g.loans = append(g.loans, current)
+			return
+		}
+		g.charge(&current, db.LedgerFine, g.fine(due, back), back)
+		g.loans = append(g.loans, current)
+
+		t = back.Add(time.Hour + g.expDays(meanGap))
+	}
+}
+
+// fine - The overdue fine of a loan due at due and back at back, per started day up to the cap.
+func (g *generator) fine(due, back time.Time) int64 {
+	if !back.After(due) {
+		return 0
+	}
+
+	days := int64((back.Sub(due) + day - 1) / day)
+	fine := days * g.opts.FinePerDay
+	if fine > g.opts.FineCap {
+		return g.opts.FineCap
+	}
+
+	return fine
+}
+
+// charge - Charge a loan's member an amount at t, usually paid off within a month.
+func (g *generator) charge(current *loan, entryType db.LedgerEntryType, amount int64, t time.Time) {
+	if amount <= 0 {
+		return
+	}
+
+	current.entries = append(current.entries, db.LedgerEntry{
+		Base:        stamp(t),
+		MemberID:    current.checkout.MemberID,
+		EntryType:   entryType,
+		AmountCents: amount,
+	})
+
+	paidAt := g.between(t, t.Add(30*day))
+	if g.rand.Float64() < paidRate && paidAt.Before(g.opts.To) {
+		current.entries = append(current.entries, db.LedgerEntry{
+			Base:        stamp(paidAt),
+			MemberID:    current.checkout.MemberID,
+			EntryType:   db.LedgerPayment,
+			AmountCents: -amount,
+		})
+	}
+}
+
+// collect - Put the loans into the seed data oldest first, so ids follow time as they would in a
+// real library, pointing ledger entries at their checkout's position.
+func (g *generator) collect() {
+	sort.SliceStable(g.loans, func(i, j int) bool {
+		return g.loans[i].checkout.CheckedOut.Before(g.loans[j].checkout.CheckedOut)
+	})
+
+	for i, current := range g.loans {
+		g.data.Checkouts = append(g.data.Checkouts, current.checkout)
+		for _, entry := range current.entries {
+			position := uint(i + 1)
+			entry.CheckoutID = &position
+			g.data.LedgerEntries = append(g.data.LedgerEntries, entry)
+		}
+	}
+	sort.SliceStable(g.data.LedgerEntries, func(i, j int) bool {
+		return g.data.LedgerEntries[i].CreatedAt.Before(g.data.LedgerEntries[j].CreatedAt)
+	})
+}
@@ -0,0 +1,129 @@
+package generate
+
+import (
+	"main/isbn"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testOptions - A small library over a year of history.
+func testOptions(seed int64) Options {
+	return Options{
+		Seed:           seed,
+		Authors:        20,
+		Books:          60,
+		Members:        40,
+		MinCopies:      1,
+		MaxCopies:      3,
+		From:           time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:             time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		LoanDays:       14,
+		FinePerDay:     25,
+		FineCap:        1000,
+		ReplacementFee: 2500,
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(o *Options)
+		err    error
+	}{
+		{"valid", func(o *Options) {}, nil},
+		{"no members", func(o *Options) { o.Members = 0 }, nil},
+		{"no books", func(o *Options) { o.Books = 0 }, errorCounts},
+		{"no authors", func(o *Options) { o.Authors = 0 }, errorCounts},
+		{"no copies", func(o *Options) { o.MinCopies = 0 }, errorCopies},
+		{"copies range backwards", func(o *Options) { o.MinCopies, o.MaxCopies = 3, 2 }, errorCopies},
+		{"empty history", func(o *Options) { o.To = o.From }, errorRange},
+		{"no loan days", func(o *Options) { o.LoanDays = 0 }, errorLoanDays},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions(1)
+			tt.change(&opts)
+			if err := opts.Validate(); err != tt.err {
+				t.Errorf("Validate() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestGenerateIsRepeatable(t *testing.T) {
+	first, err := Generate(testOptions(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Generate(testOptions(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Error("the same options generated different data")
+	}
+
+	other, err := Generate(testOptions(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(first.Books, other.Books) {
+		t.Error("another seed generated the same books")
+	}
+}
+
+func TestGenerateShape(t *testing.T) {
+	opts := testOptions(3)
+	data, err := Generate(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data.Authors) != opts.Authors || len(data.Books) != opts.Books || len(data.Members) != opts.Members {
+		t.Fatalf("generated %d authors, %d books, %d members", len(data.Authors), len(data.Books), len(data.Members))
+	}
+	if len(data.Checkouts) == 0 {
+		t.Fatal("generated no checkouts")
+	}
+
+	seen := map[string]bool{}
+	for _, book := range data.Books {
+		if !isbn.Valid(book.ISBN) || seen[book.ISBN] {
+			t.Errorf("book isbn %q is invalid or used twice", book.ISBN)
+		}
+		seen[book.ISBN] = true
+	}
+
+	// Loans of a copy follow each other without overlapping, inside the history.
+	lastBack := map[uint]time.Time{}
+	for i, checkout := range data.Checkouts {
+		if checkout.BookID < 1 || int(checkout.BookID) > len(data.Copies) {
+			t.Fatalf("checkout %d lends copy %d of %d", i+1, checkout.BookID, len(data.Copies))
+		}
+		if checkout.CheckedOut.Before(opts.From) || !checkout.CheckedOut.Before(opts.To) {
+			t.Errorf("checkout %d starts at %v, outside the history", i+1, checkout.CheckedOut)
+		}
+		if back, ok := lastBack[checkout.BookID]; ok && checkout.CheckedOut.Before(back) {
+			t.Errorf("checkout %d lends copy %d before it's back", i+1, checkout.BookID)
+		}
+		if checkout.DueAt == nil || !checkout.DueAt.After(checkout.CheckedOut) {
+			t.Errorf("checkout %d is due at %v", i+1, checkout.DueAt)
+		}
+		if checkout.LostAt != nil && checkout.Returned == nil {
+			t.Errorf("checkout %d is lost but still open", i+1)
+		}
+		if checkout.Returned == nil {
+			lastBack[checkout.BookID] = opts.To
+		} else {
+			lastBack[checkout.BookID] = *checkout.Returned
+		}
+	}
+
+	for i, entry := range data.LedgerEntries {
+		if entry.CheckoutID != nil && (*entry.CheckoutID < 1 || int(*entry.CheckoutID) > len(data.Checkouts)) {
+			t.Errorf("ledger entry %d names checkout %d of %d", i+1, *entry.CheckoutID, len(data.Checkouts))
+		}
+	}
+}
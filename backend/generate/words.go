@@ -0,0 +1,61 @@
+package generate
+
+// firstNames, lastNames - Names people and authors are made up from.
+var firstNames = []string{
+	"Ada", "Alan", "Alice", "Amara", "Ana", "Andre", "Aisha", "Ben", "Carlos", "Chen", "Chloe",
+	"Daniel", "Diego", "Elena", "Emma", "Farah", "Felix", "Grace", "Hana", "Hugo", "Ines", "Isaac",
+	"Ivy", "James", "Jonas", "Julia", "Kai", "Kenji", "Laila", "Leo", "Lina", "Lucas", "Maya",
+	"Mei", "Mateo", "Nadia", "Noah", "Nora", "Omar", "Olivia", "Priya", "Quinn", "Rafael", "Rosa",
+	"Sam", "Sara", "Sofia", "Tariq", "Theo", "Uma", "Victor", "Wen", "Yara", "Yusuf", "Zoe",
+}
+
+var lastNames = []string{
+	"Abbott", "Adeyemi", "Alvarez", "Bauer", "Becker", "Bianchi", "Brooks", "Campbell", "Chen",
+	"Costa", "Dubois", "Edwards", "Fischer", "Fontaine", "Garcia", "Gupta", "Haddad", "Hansen",
+	"Ito", "Jensen", "Kim", "Kowalski", "Larsen", "Lee", "Lopez", "Martin", "Mendes", "Moreau",
+	"Murphy", "Nakamura", "Nguyen", "Novak", "Okafor", "Olsen", "Park", "Patel", "Petrov",
+	"Quinn", "Reyes", "Rossi", "Sato", "Schmidt", "Silva", "Singh", "Sousa", "Tanaka", "Torres",
+	"Vargas", "Walker", "Weber", "Wright", "Yilmaz", "Young", "Zhang",
+}
+
+// adjectives, nouns, places - Words titles and descriptions are made up from.
+var adjectives = []string{
+	"Silent", "Hidden", "Broken", "Golden", "Last", "Lost", "Burning", "Quiet", "Distant", "Crimson",
+	"Forgotten", "Endless", "Hollow", "Secret", "Wandering", "Bright", "Winter", "Summer", "Wild",
+	"Practical", "Complete", "Modern", "Gentle", "Restless", "Final", "Curious", "Long", "Dark",
+	"Little", "Iron", "Glass", "Paper", "Electric", "Northern", "Invisible", "Open",
+}
+
+var nouns = []string{
+	"Garden", "River", "Kingdom", "Machine", "Letter", "Harbor", "Forest", "Engine", "Mountain",
+	"Lighthouse", "Library", "Island", "Orchard", "Algorithm", "Window", "Compass", "Archive",
+	"Ocean", "Bridge", "Clockmaker", "Storm", "Voyage", "Map", "Station", "Memory", "Shadow",
+	"Signal", "Atlas", "Tower", "Meadow", "Empire", "Promise", "Theory", "Network", "Kitchen",
+	"Frontier", "Mirror", "Winter", "Song", "Code",
+}
+
+var places = []string{
+	"Lisbon", "the North", "Kyoto", "the Valley", "Marrakesh", "the City", "Oslo", "the Coast",
+	"Buenos Aires", "the Old Town", "Cairo", "the Highlands", "Hanoi", "the Border", "Dublin",
+	"the Islands", "Lagos", "the Desert",
+}
+
+// titleTemplates - Shapes of made up titles, %[1]s an adjective, %[2]s and %[3]s nouns, %[4]s a place.
+var titleTemplates = []string{
+	"The %[1]s %[2]s",
+	"%[1]s %[2]s",
+	"The %[2]s of %[4]s",
+	"A %[2]s in %[4]s",
+	"The %[2]s and the %[3]s",
+	"%[2]s of the %[1]s %[3]s",
+	"Beyond the %[1]s %[2]s",
+	"Notes on the %[2]s",
+}
+
+// descriptionTemplates - Shapes of made up descriptions, with the same arguments as titleTemplates.
+var descriptionTemplates = []string{
+	"A %[1]s story of a %[2]s and the %[3]s that changed it, set in %[4]s.",
+	"From %[4]s to the edge of the %[3]s, an account of the %[1]s %[2]s.",
+	"Part history, part guide, this book follows the %[2]s through %[4]s and the %[1]s years after.",
+	"An introduction to the %[2]s, with worked examples and a %[1]s look at the %[3]s.",
+}
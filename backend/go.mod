@@ -5,6 +5,8 @@ go 1.13
 require (
 	github.com/gorilla/mux v1.7.3
 	github.com/jinzhu/gorm v1.9.12
+	github.com/mattn/go-sqlite3 v2.0.3+incompatible // indirect
 	github.com/satori/go.uuid v1.2.0
 	github.com/t-tiger/gorm-bulk-insert v1.3.0
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
 )
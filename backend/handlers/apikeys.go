@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"main/db"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type APIKeysResponse struct {
+	Data []db.APIKey `json:"data"`
+}
+
+type APIKeyResponse struct {
+	Data db.APIKey `json:"data"`
+}
+
+// APIKeyWithSecret - A new api key along with the key itself, which is only ever sent back once.
+type APIKeyWithSecret struct {
+	db.APIKey
+	Key string `json:"key"`
+}
+
+type APIKeySecretResponse struct {
+	Data APIKeyWithSecret `json:"data"`
+}
+
+type PostAPIKeyPayload struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// apiKeyPrefix - Start of every api key, telling them apart from login tokens.
+const apiKeyPrefix = "lk_"
+
+// apiKeyShownLength - Characters of a key kept in the clear, to tell keys apart in listings.
+const apiKeyShownLength = len(apiKeyPrefix) + 8
+
+// Common request errors
+var errorAPIKeyID = errors.New("api key id missing or invalid in request")
+var errorAPIKeyName = errors.New("api key name can't be empty")
+var errorAPIKeyScopes = errors.New("scopes must list catalog:read, catalog:write, circulation:read, circulation:write, members:read, members:write, events:read or admin")
+
+// queryAPIKeyWithParamID - Build gorm api key query with id from url params.
+func queryAPIKeyWithParamID(r *http.Request) (*db.APIKey, error) {
+	id, ok := parseID(mux.Vars(r)["id"])
+	if !ok {
+		return nil, errorAPIKeyID
+	}
+
+	return &db.APIKey{ID: id}, nil
+}
+
+// apiKeyActor - Who changes made with an api key are recorded as done by.
+func apiKeyActor(key db.APIKey) string {
+	return "api-key:" + key.Name
+}
+
+// parseAPIKeyScopes - Clean up and check the scopes an api key carries.
+func parseAPIKeyScopes(scopes []string) (db.ScopeList, error) {
+	parsed := db.ScopeList{}
+	for _, scope := range scopes {
+		scope := db.Scope(strings.ToLower(strings.TrimSpace(scope)))
+		if !db.IsScope(scope) {
+			return nil, errorAPIKeyScopes
+		}
+		parsed = append(parsed, scope)
+	}
+	if len(parsed) == 0 {
+		return nil, errorAPIKeyScopes
+	}
+
+	return parsed, nil
+}
+
+// GetAllAPIKeys - Retrieve all api keys, revoked ones included.
+func (s *Server) GetAllAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.Store.GetAllAPIKeys()
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(APIKeysResponse{
+		Data: keys,
+	})
+}
+
+// GetAPIKeyByID - Retrieve a single api key.
+func (s *Server) GetAPIKeyByID(w http.ResponseWriter, r *http.Request) {
+	query, err := queryAPIKeyWithParamID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	key, err := s.Store.GetAPIKey(query.ID)
+	if err == db.ErrNotFound {
+		json.NewEncoder(w).Encode(&EmptyItemResponse{})
+		return
+	}
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(APIKeyResponse{
+		Data: key,
+	})
+}
+
+// PostNewAPIKey - Issue an api key with a name and scopes. The key is only sent back in this
+// response, just its hash is stored.
+func (s *Server) PostNewAPIKey(w http.ResponseWriter, r *http.Request) {
+	var payload PostAPIKeyPayload
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(payload.Name)
+	if name == "" {
+		HandleErrorResponse(w, errorAPIKeyName, http.StatusBadRequest)
+		return
+	}
+	scopes, err := parseAPIKeyScopes(payload.Scopes)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	token, err := newToken()
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+	secret := apiKeyPrefix + token
+
+	now := time.Now()
+	key := db.APIKey{
+		Base: db.Base{
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		Name:      name,
+		Prefix:    secret[:apiKeyShownLength],
+		KeyHash:   hashToken(secret),
+		Scopes:    scopes,
+		CreatedBy: requestActor(r),
+	}
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		if err := tx.CreateAPIKey(&key); err != nil {
+			return err
+		}
+
+		return recordChange(tx, db.EntityAPIKey, key.ID, db.CREATE, nil, key)
+	})
+	if errTx != nil {
+		HandleErrorResponse(w, errTx, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(APIKeySecretResponse{
+		Data: APIKeyWithSecret{APIKey: key, Key: secret},
+	})
+}
+
+// DeleteAPIKeyByID - Revoke an api key, it's kept in the listing with when it was revoked.
+func (s *Server) DeleteAPIKeyByID(w http.ResponseWriter, r *http.Request) {
+	query, err := queryAPIKeyWithParamID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		key, err := tx.GetAPIKey(query.ID)
+		if err == db.ErrNotFound || (err == nil && key.RevokedAt != nil) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.RevokeAPIKey(query.ID, time.Now()); err != nil {
+			return err
+		}
+
+		revokedKey, err := tx.GetAPIKey(query.ID)
+		if err != nil {
+			return err
+		}
+
+		return recordChange(tx, db.EntityAPIKey, query.ID, db.UPDATE, key, revokedKey)
+	})
+	if errTx != nil {
+		HandleErrorResponse(w, errTx, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"fmt"
+	"main/db"
+	"net/http"
+)
+
+// anonymousActor - Actor of changes made by requests that aren't signed in.
+const anonymousActor = "anonymous"
+
+// requestActor - Who the changes made by a request are recorded as done by, the signed in user.
+func requestActor(r *http.Request) string {
+	if principal := principalOf(r); principal != nil {
+		return principal.Name
+	}
+
+	return anonymousActor
+}
+
+// storeFor - The store to make a request's changes through, its events name the request's actor.
+func (s *Server) storeFor(r *http.Request) db.Store {
+	return s.Store.WithActor(requestActor(r))
+}
+
+// recordChange - Log a change to an entity in the event log, in the same transaction as the change.
+// before is nil for creations and after is nil for deletions.
+func recordChange(tx db.Store, entityType db.EntityType, id interface{}, action db.BookEventType, before, after interface{}) error {
+	event, err := db.NewEvent(entityType, fmt.Sprint(id), action, before, after)
+	if err != nil {
+		return err
+	}
+
+	return tx.CreateEvent(&event)
+}
+
+// recordLoanChange - Log a change to a loan, under the isbn of the lent copy.
+func recordLoanChange(tx db.Store, bookISBN string, action db.BookEventType, before interface{}, after db.Checkout) error {
+	event, err := db.NewEvent(db.EntityCheckout, fmt.Sprint(after.ID), action, before, after)
+	if err != nil {
+		return err
+	}
+	event.ISBN = bookISBN
+
+	return tx.CreateEvent(&event)
+}
+
+// recordBookChange - Log a change to a book, including the authors added and removed.
+func recordBookChange(tx db.Store, action db.BookEventType, before, after *db.Book) error {
+	event, err := db.NewBookEvent(action, before, after)
+	if err != nil {
+		return err
+	}
+
+	return tx.CreateEvent(&event)
+}
@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/satori/go.uuid"
+	"golang.org/x/crypto/bcrypt"
+	"main/db"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type LoginPayload struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Login - A new session's bearer token, only ever shown at login.
+type Login struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	User      db.User   `json:"user"`
+}
+
+type LoginResponse struct {
+	Data Login `json:"data"`
+}
+
+// Principal - Who a request is made by, a signed in user or an api key.
+type Principal struct {
+	// Name - Recorded as the actor of the request's changes.
+	Name     string
+	Role     db.Role
+	MemberID *uuid.UUID
+
+	UserID    uint
+	SessionID uint
+
+	// KeyID - The api key the request was made with, its Scopes say what it may do.
+	KeyID  uint
+	Scopes db.ScopeList
+}
+
+// principalKey - Request context key of the request's Principal.
+type principalKey struct{}
+
+// defaultSessionTTL - How long a login lasts unless configured otherwise.
+const defaultSessionTTL = 12 * time.Hour
+
+// Common request errors
+var errorUnauthenticated = errors.New("sign in required")
+var errorBadToken = errors.New("invalid or expired token")
+var errorForbidden = errors.New("not allowed for your role")
+var errorLogin = errors.New("wrong username or password")
+
+// StaffRoles - Roles that run the library, as opposed to members.
+var StaffRoles = []db.Role{db.RoleAdmin, db.RoleLibrarian}
+
+// AllRoles - Every role, for routes open to anyone signed in.
+var AllRoles = []db.Role{db.RoleAdmin, db.RoleLibrarian, db.RoleMember}
+
+// principalOf - Who made a request, nil when it isn't signed in.
+func principalOf(r *http.Request) *Principal {
+	principal, _ := r.Context().Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// bearerToken - The token of an "Authorization: Bearer" header, empty without one.
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "bearer ") {
+		return ""
+	}
+
+	return strings.TrimSpace(header[7:])
+}
+
+// hashToken - What's stored of a token, so a leaked table can't be used to sign in.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newToken - A random bearer token.
+func newToken() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(key), nil
+}
+
+// unauthorized - Ask the client to sign in.
+func unauthorized(w http.ResponseWriter, err error) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="local-library"`)
+	HandleErrorResponse(w, err, http.StatusUnauthorized)
+}
+
+// Authenticate - Middleware finding who a request is from by its bearer token, an api key or a
+// login session. Requests without a token carry on anonymously and are stopped by Allow, bad or
+// expired tokens are refused.
+func (s *Server) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var principal *Principal
+		var err error
+		if strings.HasPrefix(token, apiKeyPrefix) {
+			principal, err = s.keyPrincipal(token, time.Now())
+		} else {
+			principal, err = s.sessionPrincipal(token, time.Now())
+		}
+		if err == db.ErrNotFound {
+			unauthorized(w, errorBadToken)
+			return
+		}
+		if err != nil {
+			HandleErrorResponse(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	})
+}
+
+// sessionPrincipal - The user signed in with a login token.
+func (s *Server) sessionPrincipal(token string, now time.Time) (*Principal, error) {
+	session, err := s.Store.GetSession(hashToken(token), now)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.Store.GetUser(session.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Principal{
+		Name:      user.Username,
+		Role:      user.Role,
+		MemberID:  user.MemberID,
+		UserID:    user.ID,
+		SessionID: session.ID,
+	}, nil
+}
+
+// keyPrincipal - The api key a token is, recording it was used.
+func (s *Server) keyPrincipal(token string, now time.Time) (*Principal, error) {
+	key, err := s.Store.GetAPIKeyByHash(hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Store.TouchAPIKey(key.ID, now); err != nil {
+		return nil, err
+	}
+
+	return &Principal{
+		Name:   apiKeyActor(key),
+		KeyID:  key.ID,
+		Scopes: key.Scopes,
+	}, nil
+}
+
+// Allow - Wrap a handler so only signed in users with one of the roles, or api keys with the
+// scope, can call it. An empty scope keeps api keys out.
+func Allow(scope db.Scope, roles ...db.Role) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			principal := principalOf(r)
+			if principal == nil {
+				unauthorized(w, errorUnauthenticated)
+				return
+			}
+			if principal.KeyID != 0 {
+				if scope != "" && principal.Scopes.Has(scope) {
+					next(w, r)
+					return
+				}
+				HandleErrorResponse(w, errorScope(scope), http.StatusForbidden)
+				return
+			}
+			for _, role := range roles {
+				if principal.Role == role {
+					next(w, r)
+					return
+				}
+			}
+
+			HandleErrorResponse(w, errorForbidden, http.StatusForbidden)
+		}
+	}
+}
+
+// errorScope - Why an api key was refused a route.
+func errorScope(scope db.Scope) error {
+	if scope == "" {
+		return errors.New("api keys can't call this route, sign in instead")
+	}
+
+	return fmt.Errorf("api key is missing the %s scope", scope)
+}
+
+// isMemberRequest - Whether a request is made by a member, who only gets to see their own records.
+func isMemberRequest(r *http.Request) bool {
+	principal := principalOf(r)
+	return principal != nil && principal.Role == db.RoleMember
+}
+
+// ownMemberID - The member a member's request is limited to, uuid.Nil when the account has none.
+func ownMemberID(r *http.Request) uuid.UUID {
+	if principal := principalOf(r); principal != nil && principal.MemberID != nil {
+		return *principal.MemberID
+	}
+
+	return uuid.Nil
+}
+
+// canSeeMember - Staff see every member's records, members only their own.
+func canSeeMember(r *http.Request, memberID uuid.UUID) bool {
+	if !isMemberRequest(r) {
+		return true
+	}
+
+	return ownMemberID(r) != uuid.Nil && uuid.Equal(ownMemberID(r), memberID)
+}
+
+// PostLogin - Sign in with a username and password, answering with a bearer token for the
+// Authorization header of later requests.
+func (s *Server) PostLogin(w http.ResponseWriter, r *http.Request) {
+	var payload LoginPayload
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.Store.GetUserByUsername(strings.TrimSpace(payload.Username))
+	if err != nil && err != db.ErrNotFound {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+	if err == db.ErrNotFound {
+		// Take as long as a wrong password would, so usernames can't be probed.
+		bcrypt.CompareHashAndPassword(missingUserHash, []byte(payload.Password))
+		unauthorized(w, errorLogin)
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(payload.Password)) != nil {
+		unauthorized(w, errorLogin)
+		return
+	}
+
+	token, err := newToken()
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	session := db.Session{
+		Base: db.Base{
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(s.SessionTTL),
+	}
+	errTx := s.Store.Transaction(func(tx db.Store) error {
+		if err := tx.DeleteUserSessions(user.ID, &now); err != nil {
+			return err
+		}
+
+		return tx.CreateSession(&session)
+	})
+	if errTx != nil {
+		HandleErrorResponse(w, errTx, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(LoginResponse{
+		Data: Login{Token: token, ExpiresAt: session.ExpiresAt, User: user},
+	})
+}
+
+// PostLogout - Sign out the session a request was made with.
+func (s *Server) PostLogout(w http.ResponseWriter, r *http.Request) {
+	if err := s.Store.DeleteSession(principalOf(r).SessionID); err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+	}
+}
+
+// GetCurrentUser - The account a request is signed in as.
+func (s *Server) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
+	user, err := s.Store.GetUser(principalOf(r).UserID)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(UserResponse{
+		Data: user,
+	})
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"encoding/json"
+	"main/db"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// withToken - Serve a request through Authenticate with a bearer token, none when it's empty.
+func withToken(s *Server, handler http.HandlerFunc, method string, target string, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.Authenticate(handler).ServeHTTP(w, r)
+	return w
+}
+
+// login - Sign in as username, returning the status and the session token.
+func login(s *Server, username string, password string) (int, string) {
+	w := request(s.PostLogin, http.MethodPost, "/login", nil, LoginPayload{Username: username, Password: password}, nil)
+	var res LoginResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	return w.Code, res.Data.Token
+}
+
+func TestPostLogin(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Store.Close()
+
+	if _, err := CreateUser(s.Store, PostUserPayload{Username: "root", Password: "password1", Role: db.RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		status   int
+	}{
+		{"wrong password", "root", "password2", http.StatusUnauthorized},
+		{"unknown user", "nobody", "password1", http.StatusUnauthorized},
+		{"signed in", " root ", "password1", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, token := login(s, tt.username, tt.password)
+			if status != tt.status {
+				t.Fatalf("status = %d, want %d", status, tt.status)
+			}
+			if (token != "") != (status == http.StatusOK) {
+				t.Errorf("token = %q with status %d", token, status)
+			}
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Store.Close()
+
+	if _, err := CreateUser(s.Store, PostUserPayload{Username: "root", Password: "password1", Role: db.RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	_, token := login(s, "root", "password1")
+
+	me := Allow("", AllRoles...)(s.GetCurrentUser)
+	logout := Allow("", AllRoles...)(s.PostLogout)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		token   string
+		status  int
+	}{
+		{"anonymous", me, "", http.StatusUnauthorized},
+		{"bad token", me, "not-a-token", http.StatusUnauthorized},
+		{"unknown api key", me, apiKeyPrefix + "0000", http.StatusUnauthorized},
+		{"signed in", me, token, http.StatusOK},
+		{"signed out", logout, token, http.StatusOK},
+		{"after sign out", me, token, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := withToken(s, tt.handler, http.MethodGet, "/me", tt.token)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestAllow(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Store.Close()
+
+	// Api keys are issued by an admin, the secret is only in the response.
+	admin := &Principal{Name: "admin", Role: db.RoleAdmin}
+	issue := func(scopes ...string) string {
+		w := request(s.PostNewAPIKey, http.MethodPost, "/api-keys", nil, PostAPIKeyPayload{Name: "kiosk", Scopes: scopes}, admin)
+		var res APIKeySecretResponse
+		decode(t, w, &res)
+		return res.Data.Key
+	}
+	reader := issue(string(db.ScopeCirculationRead))
+	writer := issue(string(db.ScopeCatalogWrite))
+
+	ada := addMember(t, s, "Ada", "standard")
+	staffOnly := Allow(db.ScopeCirculationRead, StaffRoles...)(s.GetAllCheckouts)
+	signInOnly := Allow("", StaffRoles...)(s.GetAllCheckouts)
+
+	tests := []struct {
+		name      string
+		handler   http.HandlerFunc
+		principal *Principal
+		status    int
+	}{
+		{"anonymous", staffOnly, nil, http.StatusUnauthorized},
+		{"member", staffOnly, memberPrincipal(ada), http.StatusForbidden},
+		{"librarian", staffOnly, librarian, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(tt.handler, http.MethodGet, "/checkouts", nil, nil, tt.principal)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+
+	keys := []struct {
+		name    string
+		handler http.HandlerFunc
+		token   string
+		status  int
+	}{
+		{"key with the scope", staffOnly, reader, http.StatusOK},
+		{"key without the scope", staffOnly, writer, http.StatusForbidden},
+		{"key on a sign in only route", signInOnly, reader, http.StatusForbidden},
+	}
+
+	for _, tt := range keys {
+		t.Run(tt.name, func(t *testing.T) {
+			w := withToken(s, tt.handler, http.MethodGet, "/checkouts", tt.token)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestGetCheckoutsByMemberID(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Store.Close()
+
+	ada := addMember(t, s, "Ada", "standard")
+	grace := addMember(t, s, "Grace", "standard")
+
+	tests := []struct {
+		name      string
+		memberID  string
+		principal *Principal
+		status    int
+	}{
+		{"invalid id", "abc", librarian, http.StatusBadRequest},
+		{"nil id", "00000000-0000-0000-0000-000000000000", librarian, http.StatusBadRequest},
+		{"own loans", ada.ID.String(), memberPrincipal(ada), http.StatusOK},
+		{"someone else's loans", grace.ID.String(), memberPrincipal(ada), http.StatusForbidden},
+		{"staff", grace.ID.String(), librarian, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars := map[string]string{"member_id": tt.memberID}
+			w := request(s.GetCheckoutsByMemberID, http.MethodGet, "/checkouts/"+tt.memberID, vars, nil, tt.principal)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
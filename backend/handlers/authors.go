@@ -15,11 +15,16 @@ type AuthorsResponse struct {
 	Data []db.Author `json:"data"`
 }
 
+type AuthorListResponse struct {
+	Data []db.Author `json:"data"`
+	ListPage
+}
+
 type AuthorResponse struct {
 	Data db.Author `json:"data"`
 }
 
-var errorAuthorID = errors.New("author id missing in request")
+var errorAuthorID = errors.New("author id missing or invalid in request")
 
 // IsInvalidPerson - Check if author provided is missing data.
 func IsInvalidPerson(person db.Person) bool {
@@ -32,17 +37,11 @@ func IsInvalidPerson(person db.Person) bool {
 
 // queryAuthorWithParamID - Build gorm author query with id from url params.
 func queryAuthorWithParamID(r *http.Request) (*db.Author, error) {
-	params := mux.Vars(r)
-	id := params["id"]
-	if id == "" {
+	uid, ok := parseUUID(mux.Vars(r)["id"])
+	if !ok {
 		return nil, errorAuthorID
 	}
 
-	uid, err := uuid.FromString(id)
-	if err != nil {
-		return nil, err
-	}
-
 	query := &db.Author{
 		Person: db.Person{
 			ID: uid,
@@ -52,36 +51,45 @@ func queryAuthorWithParamID(r *http.Request) (*db.Author, error) {
 	return query, nil
 }
 
-// GetAllAuthors - Retrieve all authors records.
-func GetAllAuthors(w http.ResponseWriter, r *http.Request) {
-	var allAuthors []db.Author
+// GetAllAuthors - Retrieve a page of authors records, filtered by ?last_name= and ?first_name=.
+func (s *Server) GetAllAuthors(w http.ResponseWriter, r *http.Request) {
 	queryParams := r.URL.Query()
-	authors := queryParams.Get("books")
-	if authors != "" {
-		db.MySQL.Preload("Books").Find(&allAuthors)
-	} else {
-		db.MySQL.Find(&allAuthors)
+	withBooks := queryParams.Get("books") != ""
+	listQuery, err := parseListQuery(r, "books")
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	allAuthors, page, err := s.Store.ListAuthors(withBooks, listQuery)
+	if err != nil {
+		handleListError(w, err)
+		return
 	}
 
-	json.NewEncoder(w).Encode(AuthorsResponse{
-		Data: allAuthors,
+	json.NewEncoder(w).Encode(AuthorListResponse{
+		Data:     allAuthors,
+		ListPage: listPage(r, page, len(allAuthors)),
 	})
 }
 
 // GetAuthorByID - Retrieve a single author record by it's uuid.
-func GetAuthorByID(w http.ResponseWriter, r *http.Request) {
-	var author db.Author
+func (s *Server) GetAuthorByID(w http.ResponseWriter, r *http.Request) {
 	query, err := queryAuthorWithParamID(r)
 	if err != nil {
 		HandleErrorResponse(w, err, http.StatusBadRequest)
 		return
 	}
 
-	db.MySQL.Where(query).First(&author)
-	if IsInvalidPerson(author.Person) {
+	author, err := s.Store.GetAuthor(query.ID)
+	if err == db.ErrNotFound || IsInvalidPerson(author.Person) {
 		json.NewEncoder(w).Encode(EmptyItemResponse{})
 		return
 	}
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
 
 	json.NewEncoder(w).Encode(AuthorResponse{
 		Data: author,
@@ -89,22 +97,26 @@ func GetAuthorByID(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetAuthorBooks - Retrieve all books written by an author.
-func GetAuthorBooks(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetAuthorBooks(w http.ResponseWriter, r *http.Request) {
 	query, err := queryAuthorWithParamID(r)
 	if err != nil {
 		HandleErrorResponse(w, err, http.StatusBadRequest)
 		return
 	}
 
-	var author db.Author
-	db.MySQL.Preload("Books").Where(query).First(&author)
+	books, err := s.Store.GetAuthorBooks(query.ID)
+	if err != nil && err != db.ErrNotFound {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
 	json.NewEncoder(w).Encode(BooksResponse{
-		Data: author.Books,
+		Data: books,
 	})
 }
 
 // PostNewAuthor - Creates a new author record.
-func PostNewAuthor(w http.ResponseWriter, r *http.Request) {
+func (s *Server) PostNewAuthor(w http.ResponseWriter, r *http.Request) {
 	var author db.Author
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&author)
@@ -114,27 +126,34 @@ func PostNewAuthor(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check the author doesn't already exist
-	var presentAuthor db.Author
-	query := &db.Author{Person: db.Person{
-		FirstName: author.FirstName,
-		LastName:  author.LastName,
-		Middle:    author.Middle,
-	}}
-
-	// Handle conflicts
-	db.MySQL.Where(query).First(&presentAuthor)
-	if presentAuthor.FirstName != "" {
+	_, errPresent := s.Store.FindAuthorByName(author.FirstName, author.LastName, author.Middle)
+	if errPresent == nil {
 		responseErr := errors.New("author with that name already exists")
 		HandleErrorResponse(w, responseErr, http.StatusConflict)
 		return
 	}
+	if errPresent != db.ErrNotFound {
+		HandleErrorResponse(w, errPresent, http.StatusInternalServerError)
+		return
+	}
 
 	// Create new Author
 	now := time.Now()
 	author.CreatedAt = now
 	author.UpdatedAt = now
 	author.ID = uuid.NewV4()
-	db.MySQL.Create(&author)
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		if err := tx.CreateAuthor(&author); err != nil {
+			return err
+		}
+
+		return recordChange(tx, db.EntityAuthor, author.ID, db.CREATE, nil, author)
+	})
+	if errTx != nil {
+		HandleErrorResponse(w, errTx, http.StatusInternalServerError)
+		return
+	}
+	s.reindexBooks(author.Books)
 
 	// Return the newly created author in response
 	json.NewEncoder(w).Encode(AuthorResponse{
@@ -143,7 +162,7 @@ func PostNewAuthor(w http.ResponseWriter, r *http.Request) {
 }
 
 // PatchUpdateAuthor - Update an author record.
-func PatchUpdateAuthor(w http.ResponseWriter, r *http.Request) {
+func (s *Server) PatchUpdateAuthor(w http.ResponseWriter, r *http.Request) {
 	query, errQ := queryAuthorWithParamID(r)
 	if errQ != nil {
 		HandleErrorResponse(w, errQ, http.StatusBadRequest)
@@ -159,21 +178,18 @@ func PatchUpdateAuthor(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	queryAuthorByID := db.Author{
-		Person: db.Person{
-			ID: query.ID,
-		},
-	}
-
 	// Check the authors current state from db and handle errors.
-	var currentAuthor db.Author
-	db.MySQL.Unscoped().Where(queryAuthorByID).First(&currentAuthor)
-	if currentAuthor.DeletedAt != nil || IsInvalidPerson(currentAuthor.Person) {
+	currentAuthor, errCurrent := s.Store.GetAuthor(query.ID)
+	if errCurrent == db.ErrNotFound || IsInvalidPerson(currentAuthor.Person) {
 		msg := fmt.Sprintf("no author with id %s found", query.ID)
 		err := errors.New(msg)
 		HandleErrorResponse(w, err, http.StatusNotFound)
 		return
 	}
+	if errCurrent != nil {
+		HandleErrorResponse(w, errCurrent, http.StatusInternalServerError)
+		return
+	}
 
 	// Update only what's supplied
 	updates := map[string]interface{}{}
@@ -187,27 +203,64 @@ func PatchUpdateAuthor(w http.ResponseWriter, r *http.Request) {
 		updates["middle"] = author.Middle
 	}
 
-	// Apply updates to author.
-	author.ID = query.ID
+	// Apply updates to author and get the updated author object to return.
+	var updatedAuthor db.Author
 	updates["updated_at"] = time.Now()
-	db.MySQL.Model(&author).Updates(updates)
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		if err := tx.UpdateAuthor(query.ID, updates); err != nil {
+			return err
+		}
+
+		var err error
+		if updatedAuthor, err = tx.GetAuthor(query.ID); err != nil {
+			return err
+		}
+
+		return recordChange(tx, db.EntityAuthor, query.ID, db.UPDATE, currentAuthor, updatedAuthor)
+	})
+	if errTx != nil {
+		HandleErrorResponse(w, errTx, http.StatusInternalServerError)
+		return
+	}
+	s.reindexAuthorBooks(query.ID)
 
-	// Get the updated author object to return.
-	var updatedAuthor db.Author
-	db.MySQL.Where(query).First(&updatedAuthor)
 	json.NewEncoder(w).Encode(AuthorResponse{
 		Data: updatedAuthor,
 	})
 }
 
 // DeleteAuthorByID - Deletes an author record by it's uuid.
-func DeleteAuthorByID(w http.ResponseWriter, r *http.Request) {
-	var author db.Author
+func (s *Server) DeleteAuthorByID(w http.ResponseWriter, r *http.Request) {
 	query, err := queryAuthorWithParamID(r)
 	if err != nil {
 		HandleErrorResponse(w, err, http.StatusBadRequest)
 		return
 	}
 
-	db.MySQL.Where(query).Delete(&author)
+	// Their books are looked up first, they drop out of the author's books with the delete.
+	books, err := s.Store.GetAuthorBooks(query.ID)
+	if err != nil && err != db.ErrNotFound {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		author, err := tx.GetAuthor(query.ID)
+		if err == db.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.DeleteAuthor(query.ID); err != nil {
+			return err
+		}
+
+		return recordChange(tx, db.EntityAuthor, query.ID, db.DELETE, author, nil)
+	})
+	if errTx != nil {
+		HandleErrorResponse(w, errTx, http.StatusInternalServerError)
+		return
+	}
+	s.reindexBooks(books)
 }
@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	uuid "github.com/satori/go.uuid"
+	"main/barcode"
+	"main/db"
+	"main/isbn"
+	"net/http"
+	"strings"
+)
+
+// BarcodeLookup - What a scanned barcode belongs to.
+type BarcodeLookup struct {
+	Barcode string     `json:"barcode"`
+	Kind    string     `json:"kind"`
+	Copy    *db.Copy   `json:"copy,omitempty"`
+	Member  *db.Member `json:"member,omitempty"`
+}
+
+type BarcodeLookupResponse struct {
+	Data BarcodeLookup `json:"data"`
+}
+
+// Common request errors
+var errorBarcode = errors.New("barcode missing in request")
+var errorBarcodeTaken = errors.New("barcode is already in use")
+var errorBarcodeKind = errors.New("barcode is for the wrong kind of record")
+var errorLabelFormat = errors.New("format must be svg or png")
+var errorNoLabels = errors.New("no barcodes to print, pass barcode, isbn or member_id")
+
+// isBarcodeError - Check if err came from barcode validation.
+func isBarcodeError(err error) bool {
+	switch err {
+	case barcode.ErrFormat, barcode.ErrLength, barcode.ErrKind, barcode.ErrInstitution,
+		barcode.ErrCheckDigit, barcode.ErrSequence, errorBarcodeKind:
+		return true
+	}
+
+	return false
+}
+
+// handleBarcodeError - Respond with the status matching a barcode lookup/validation error.
+func handleBarcodeError(w http.ResponseWriter, err error) {
+	switch {
+	case err == db.ErrNotFound:
+		HandleErrorResponse(w, err, http.StatusNotFound)
+	case isBarcodeError(err):
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+	case err == errorBarcodeTaken:
+		HandleErrorResponse(w, err, http.StatusConflict)
+	default:
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+	}
+}
+
+// parseBarcode - Validate a scanned barcode against the configured format and expected kind.
+func (s *Server) parseBarcode(code string, kind barcode.Kind) (string, error) {
+	code = strings.TrimSpace(code)
+	parsed, _, err := s.Barcodes.Parse(code)
+	if err != nil {
+		return code, err
+	}
+	if parsed != kind {
+		return code, errorBarcodeKind
+	}
+
+	return code, nil
+}
+
+// nextBarcode - The barcode following last in its sequence, or the first one. last may have been
+// printed under an earlier check digit setting, only its sequence number matters.
+func (s *Server) nextBarcode(kind barcode.Kind, last string) (string, error) {
+	var sequence uint
+	if last != "" {
+		var err error
+		if sequence, err = s.Barcodes.Sequence(last); err != nil {
+			return "", err
+		}
+	}
+
+	return s.Barcodes.Generate(kind, sequence+1)
+}
+
+// checkCopyBarcode - Validate a barcode for a copy and make sure no other copy has it.
+func (s *Server) checkCopyBarcode(tx db.Store, code string, copyID uint) (string, error) {
+	code, err := s.parseBarcode(code, barcode.Item)
+	if err != nil {
+		return code, err
+	}
+
+	other, err := tx.GetCopyByBarcode(code)
+	if err == nil && other.ID != copyID {
+		return code, errorBarcodeTaken
+	}
+	if err != nil && err != db.ErrNotFound {
+		return code, err
+	}
+
+	return code, nil
+}
+
+// checkMemberBarcode - Validate a card barcode and make sure no other member has it.
+func (s *Server) checkMemberBarcode(tx db.Store, code string, memberID uuid.UUID) (string, error) {
+	code, err := s.parseBarcode(code, barcode.Patron)
+	if err != nil {
+		return code, err
+	}
+
+	other, err := tx.GetMemberByBarcode(code)
+	if err == nil && other.ID != memberID {
+		return code, errorBarcodeTaken
+	}
+	if err != nil && err != db.ErrNotFound {
+		return code, err
+	}
+
+	return code, nil
+}
+
+// assignMissingBarcodes - Give every unlabelled copy and member the next barcode in their sequence.
+func (s *Server) assignMissingBarcodes(tx db.Store) error {
+	copies, err := tx.GetCopiesWithoutBarcode()
+	if err != nil {
+		return err
+	}
+	if len(copies) > 0 {
+		last, err := tx.GetLastCopyBarcode(s.Barcodes.Prefix(barcode.Item))
+		if err != nil {
+			return err
+		}
+		for _, bookCopy := range copies {
+			if last, err = s.nextBarcode(barcode.Item, last); err != nil {
+				return err
+			}
+			if err := tx.UpdateCopy(bookCopy.ID, map[string]interface{}{"barcode": last}); err != nil {
+				return err
+			}
+			labelled := bookCopy
+			labelled.Barcode = &last
+			if err := recordChange(tx, db.EntityCopy, bookCopy.ID, db.UPDATE, bookCopy, labelled); err != nil {
+				return err
+			}
+		}
+	}
+
+	members, err := tx.GetMembersWithoutBarcode()
+	if err != nil {
+		return err
+	}
+	if len(members) > 0 {
+		last, err := tx.GetLastMemberBarcode(s.Barcodes.Prefix(barcode.Patron))
+		if err != nil {
+			return err
+		}
+		for _, member := range members {
+			if last, err = s.nextBarcode(barcode.Patron, last); err != nil {
+				return err
+			}
+			if err := tx.UpdateMember(member.ID, map[string]interface{}{"barcode": last}); err != nil {
+				return err
+			}
+			labelled := member
+			labelled.Barcode = &last
+			if err := recordChange(tx, db.EntityMember, member.ID, db.UPDATE, member, labelled); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// AssignMissingBarcodes - Label copies and members created before barcodes existed, run on startup.
+func (s *Server) AssignMissingBarcodes() error {
+	return s.Store.Transaction(s.assignMissingBarcodes)
+}
+
+// GetBarcodeLookup - Find the copy or member a scanned barcode belongs to.
+func (s *Server) GetBarcodeLookup(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimSpace(mux.Vars(r)["barcode"])
+	if code == "" {
+		HandleErrorResponse(w, errorBarcode, http.StatusBadRequest)
+		return
+	}
+
+	kind, _, err := s.Barcodes.Parse(code)
+	if err != nil {
+		handleBarcodeError(w, err)
+		return
+	}
+
+	lookup := BarcodeLookup{Barcode: code, Kind: kind.String()}
+	switch kind {
+	case barcode.Item:
+		bookCopy, errCopy := s.Store.GetCopyByBarcode(code)
+		lookup.Copy, err = &bookCopy, errCopy
+	case barcode.Patron:
+		member, errMember := s.Store.GetMemberByBarcode(code)
+		lookup.Member, err = &member, errMember
+	}
+	if err == db.ErrNotFound {
+		json.NewEncoder(w).Encode(EmptyItemResponse{})
+		return
+	}
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(BarcodeLookupResponse{
+		Data: lookup,
+	})
+}
+
+// GetBarcodeLabels - Render a label sheet for the given barcodes (?barcode=, repeatable), the copies
+// of a book (?isbn=) or a member card (?member_id=), as svg (default) or ?format=png.
+func (s *Server) GetBarcodeLabels(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+	format := queryParams.Get("format")
+	if format == "" {
+		format = "svg"
+	}
+	if format != "svg" && format != "png" {
+		HandleErrorResponse(w, errorLabelFormat, http.StatusBadRequest)
+		return
+	}
+
+	var labels []barcode.Label
+	for _, code := range queryParams["barcode"] {
+		code = strings.TrimSpace(code)
+		if _, _, err := s.Barcodes.Parse(code); err != nil {
+			handleBarcodeError(w, err)
+			return
+		}
+		labels = append(labels, barcode.Label{Code: code})
+	}
+
+	if queryParams.Get("isbn") != "" {
+		bookISBN, err := isbn.Parse(queryParams.Get("isbn"))
+		if err != nil {
+			HandleErrorResponse(w, err, http.StatusBadRequest)
+			return
+		}
+		book, err := s.Store.GetBook(bookISBN)
+		if err != nil {
+			handleBarcodeError(w, err)
+			return
+		}
+		for _, bookCopy := range book.Copies {
+			if bookCopy.Barcode != nil {
+				labels = append(labels, barcode.Label{Code: *bookCopy.Barcode, Caption: book.Title})
+			}
+		}
+	}
+
+	if memberID := queryParams.Get("member_id"); memberID != "" {
+		member, err := s.Store.GetMember(uuid.FromStringOrNil(memberID))
+		if err != nil {
+			handleBarcodeError(w, err)
+			return
+		}
+		if member.Barcode != nil {
+			caption := strings.TrimSpace(member.FirstName + " " + member.LastName)
+			labels = append(labels, barcode.Label{Code: *member.Barcode, Caption: caption})
+		}
+	}
+
+	if len(labels) == 0 {
+		HandleErrorResponse(w, errorNoLabels, http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if format == "png" {
+		w.Header().Set("Content-Type", "image/png")
+		err = barcode.WritePNG(w, labels)
+	} else {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		err = barcode.WriteSVG(w, labels)
+	}
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+	}
+}
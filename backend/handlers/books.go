@@ -6,11 +6,9 @@ import (
 	"fmt"
 	"github.com/gorilla/mux"
 	uuid "github.com/satori/go.uuid"
-	gormbulk "github.com/t-tiger/gorm-bulk-insert"
-	"log"
 	"main/db"
+	"main/isbn"
 	"net/http"
-	"strings"
 	"time"
 )
 
@@ -21,10 +19,11 @@ type PostBookPayload struct {
 }
 
 type PatchBookPayload struct {
-	Title       string      `json:"title"`
-	ImageURL    string      `json:"image_url"`
-	Description string      `json:"description"`
-	AuthorIds   []uuid.UUID `json:"author_ids"`
+	Title            string      `json:"title"`
+	ImageURL         string      `json:"image_url"`
+	Description      string      `json:"description"`
+	ReplacementCents *int64      `json:"replacement_cents"`
+	AuthorIds        []uuid.UUID `json:"author_ids"`
 }
 
 type CopiesResponse struct {
@@ -40,9 +39,11 @@ type BookResponse struct {
 }
 
 type BookAggregates struct {
-	NumberOfCopies   int `json:"number_of_copies"`
-	NumberCheckedOut int `json:"number_checked_out"`
-	NumberAvailable  int `json:"number_available"`
+	NumberOfCopies   int                   `json:"number_of_copies"`
+	NumberCheckedOut int                   `json:"number_checked_out"`
+	NumberAvailable  int                   `json:"number_available"`
+	NumberOnHold     int                   `json:"number_on_hold"`
+	ByStatus         map[db.CopyStatus]int `json:"by_status"`
 }
 
 type BookWithAggregates struct {
@@ -50,12 +51,37 @@ type BookWithAggregates struct {
 	Aggregates BookAggregates `json:"aggregates,omitempty"`
 }
 
-type BooksAggregatesResponse struct {
+type BookListResponse struct {
 	Data []BookWithAggregates `json:"data"`
+	ListPage
 }
 
 // Common request errors
 var errorBookISBN = errors.New("book isbn missing in request")
+var errorCopyID = errors.New("copy id missing or invalid in request")
+var errorAsOf = errors.New("as_of must be an RFC 3339 time or a date (YYYY-MM-DD)")
+var errorAsOfPaging = errors.New("as_of lists hold every matching book and can't be sorted or paged")
+
+// asOfParam - Query param asking for the catalog as it was at a point in time.
+const asOfParam = "as_of"
+
+// parseAsOf - Read ?as_of=, nil when it isn't given. A date stands for the end of that day (UTC).
+func parseAsOf(r *http.Request) (*time.Time, error) {
+	value := r.URL.Query().Get(asOfParam)
+	if value == "" {
+		return nil, nil
+	}
+
+	if asOf, err := time.Parse(time.RFC3339, value); err == nil {
+		return &asOf, nil
+	}
+	day, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, errorAsOf
+	}
+	asOf := day.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	return &asOf, nil
+}
 
 // sliceContainsUUID - Util function to check a slice of strings for a string.
 func sliceContainsUUID(s []uuid.UUID, e uuid.UUID) bool {
@@ -67,47 +93,49 @@ func sliceContainsUUID(s []uuid.UUID, e uuid.UUID) bool {
 	return false
 }
 
-// queryBookWithParamISBN - Build gorm book query with id from url params.
+// queryBookWithParamISBN - Build gorm book query with the isbn from url params, ISBN-10s
+// and hyphenated forms resolve to the stored ISBN-13.
 func queryBookWithParamISBN(r *http.Request) (*db.Book, error) {
 	params := mux.Vars(r)
-	isbn := params["isbn"]
-	if isbn == "" {
+	if params["isbn"] == "" {
 		return nil, errorBookISBN
 	}
 
-	query := &db.Book{ISBN: isbn}
+	parsed, err := isbn.Parse(params["isbn"])
+	if err != nil {
+		return nil, err
+	}
+
+	query := &db.Book{ISBN: parsed}
 	return query, nil
 }
 
 // queryBookWithParamID - Build gorm book query with id from url params.
 func queryBookWithParamID(r *http.Request) (*db.Copy, error) {
-	params := mux.Vars(r)
-	id := params["id"]
-	if id == "" {
-		return nil, errorBookISBN
+	id, ok := parseID(mux.Vars(r)["id"])
+	if !ok {
+		return nil, errorCopyID
 	}
 
-	idInt := StringToUInt(id)
-	query := &db.Copy{ID: idInt}
-
-	return query, nil
+	return &db.Copy{ID: id}, nil
 }
 
-// getBooksWithAggregates - Builds aggregate data on book copy checkout statuses.
-func getBooksWithAggregates(allBooks []db.Book, checkouts []db.Checkout) []BookWithAggregates {
-	var allBooksWithAggs []BookWithAggregates
+// getBooksWithAggregates - Builds aggregate data on book copy statuses. Copies on the shelf but set
+// aside for a ready hold, in onHold, count as on hold rather than available.
+func getBooksWithAggregates(allBooks []db.Book, onHold map[uint]bool) []BookWithAggregates {
+	allBooksWithAggs := []BookWithAggregates{}
 	for _, book := range allBooks {
 		totalCopies := len(book.Copies)
 		if totalCopies < 1 {
 			continue
 		}
 
-		numCheckedOut := 0
+		byStatus := map[db.CopyStatus]int{}
+		numberOnHold := 0
 		for _, bookCopy := range book.Copies {
-			for _, checkout := range checkouts {
-				if checkout.BookID == bookCopy.ID {
-					numCheckedOut++
-				}
+			byStatus[bookCopy.Status]++
+			if bookCopy.Status == db.CopyOnShelf && onHold[bookCopy.ID] {
+				numberOnHold++
 			}
 		}
 
@@ -115,8 +143,10 @@ func getBooksWithAggregates(allBooks []db.Book, checkouts []db.Checkout) []BookW
 			Book: book,
 			Aggregates: BookAggregates{
 				NumberOfCopies:   totalCopies,
-				NumberCheckedOut: numCheckedOut,
-				NumberAvailable:  totalCopies - numCheckedOut,
+				NumberCheckedOut: byStatus[db.CopyCheckedOut],
+				NumberAvailable:  byStatus[db.CopyOnShelf] - numberOnHold,
+				NumberOnHold:     numberOnHold,
+				ByStatus:         byStatus,
 			},
 		}
 
@@ -126,36 +156,128 @@ func getBooksWithAggregates(allBooks []db.Book, checkouts []db.Checkout) []BookW
 	return allBooksWithAggs
 }
 
-// GetAllBooks - Get all books records.
-func GetAllBooks(w http.ResponseWriter, r *http.Request) {
-	var allCheckouts []db.Checkout
-	db.MySQL.Find(&allCheckouts)
+// readyHoldCopies - The copies of some books set aside for a ready hold.
+func (s *Server) readyHoldCopies(books []db.Book) (map[uint]bool, error) {
+	onHold := map[uint]bool{}
+	if len(books) == 0 {
+		return onHold, nil
+	}
 
-	var allBooks []db.Book
-	db.GetAllBooksWithRelations(&allBooks)
+	isbns := make([]string, len(books))
+	for i, book := range books {
+		isbns[i] = book.ISBN
+	}
+	ids, err := s.Store.GetReadyHoldCopyIDs(isbns)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		onHold[id] = true
+	}
+
+	return onHold, nil
+}
+
+// GetAllBooks - Get a page of the books with circulating copies, filtered by
+// ?author_id=, ?available= and ?isbn=. With ?as_of= every book as it was then.
+func (s *Server) GetAllBooks(w http.ResponseWriter, r *http.Request) {
+	listQuery, err := parseListQuery(r, asOfParam)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	if asOf != nil {
+		s.getBooksAsOf(w, listQuery, *asOf)
+		return
+	}
 
-	allBooksWithAggs := getBooksWithAggregates(allBooks, allCheckouts)
-	json.NewEncoder(w).Encode(BooksAggregatesResponse{
-		Data: allBooksWithAggs,
+	allBooks, page, err := s.Store.ListBooks(listQuery)
+	if err != nil {
+		handleListError(w, err)
+		return
+	}
+
+	onHold, err := s.readyHoldCopies(allBooks)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	allBooksWithAggs := getBooksWithAggregates(allBooks, onHold)
+	json.NewEncoder(w).Encode(BookListResponse{
+		Data:     allBooksWithAggs,
+		ListPage: listPage(r, page, len(allBooksWithAggs)),
+	})
+}
+
+// getBooksAsOf - Respond with all the books with circulating copies at asOf, rebuilt from events.
+func (s *Server) getBooksAsOf(w http.ResponseWriter, listQuery db.ListQuery, asOf time.Time) {
+	if listQuery.Limit != 0 || listQuery.Cursor != "" || len(listQuery.Sort) > 0 {
+		HandleErrorResponse(w, errorAsOfPaging, http.StatusBadRequest)
+		return
+	}
+
+	allBooks, err := s.Store.GetBooksAsOf(asOf, listQuery.Filters)
+	if err != nil {
+		handleListError(w, err)
+		return
+	}
+
+	// Holds aren't in the event log, so past copies are never counted as on hold.
+	allBooksWithAggs := getBooksWithAggregates(allBooks, nil)
+	count := len(allBooksWithAggs)
+	json.NewEncoder(w).Encode(BookListResponse{
+		Data:     allBooksWithAggs,
+		ListPage: ListPage{Meta: ListMeta{Total: count, Count: count, Limit: count}},
 	})
 }
 
-// GetBookByISBN - Retrieve a single book record by it's BookID (ID).
-func GetBookByISBN(w http.ResponseWriter, r *http.Request) {
+// getBookAsOf - Find a book as it was at asOf, rebuilt from events.
+func (s *Server) getBookAsOf(bookISBN string, asOf time.Time) (db.Book, error) {
+	books, err := s.Store.GetBooksAsOf(asOf, map[string]string{"isbn": bookISBN})
+	if err != nil {
+		return db.Book{}, err
+	}
+	if len(books) == 0 {
+		return db.Book{}, db.ErrNotFound
+	}
+
+	return books[0], nil
+}
+
+// GetBookByISBN - Retrieve a single book record by it's BookID (ID), as it was at ?as_of=
+// when given.
+func (s *Server) GetBookByISBN(w http.ResponseWriter, r *http.Request) {
 	query, err := queryBookWithParamISBN(r)
 	if err != nil {
 		HandleErrorResponse(w, err, http.StatusBadRequest)
 		return
 	}
+	asOf, err := parseAsOf(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
 
 	var book db.Book
-	db.GetBookWithRelations(query, &book)
-
-	noRecord := book.ISBN == ""
-	if noRecord {
+	if asOf != nil {
+		book, err = s.getBookAsOf(query.ISBN, *asOf)
+	} else {
+		book, err = s.Store.GetBook(query.ISBN)
+	}
+	if err == db.ErrNotFound {
 		json.NewEncoder(w).Encode(&EmptyItemResponse{})
 		return
 	}
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
 
 	json.NewEncoder(w).Encode(BookResponse{
 		Data: book,
@@ -163,22 +285,26 @@ func GetBookByISBN(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetBookAuthors - Retrieve all authors of a book by it's BookID.
-func GetBookAuthors(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetBookAuthors(w http.ResponseWriter, r *http.Request) {
 	query, err := queryBookWithParamISBN(r)
 	if err != nil {
 		HandleErrorResponse(w, err, http.StatusBadRequest)
 		return
 	}
 
-	var book db.Book
-	db.MySQL.Preload("Authors").Where(query).First(&book)
+	book, err := s.Store.GetBook(query.ISBN)
+	if err != nil && err != db.ErrNotFound {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
 	json.NewEncoder(w).Encode(AuthorsResponse{
 		Data: book.Authors,
 	})
 }
 
 // PostNewBook - Create a new book record.
-func PostNewBook(w http.ResponseWriter, r *http.Request) {
+func (s *Server) PostNewBook(w http.ResponseWriter, r *http.Request) {
 	var payload PostBookPayload
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&payload)
@@ -187,13 +313,21 @@ func PostNewBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Sanitize ISBNs as they are inserted.
-	isbn := strings.Replace(payload.ISBN, "-", "", -1)
+	// Store every book under its ISBN-13 so ISBN-10 and hyphenated forms can't duplicate it.
+	bookISBN, err := isbn.Parse(payload.ISBN)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	if payload.Copies < 0 || payload.Copies > maxNewCopies {
+		HandleErrorResponse(w, errorCopyCount, http.StatusBadRequest)
+		return
+	}
 
 	// Create new Book object
 	var book db.Book
 	now := time.Now()
-	book.ISBN = isbn
+	book.ISBN = bookISBN
 	book.CreatedAt = now
 	book.UpdatedAt = now
 	book.Title = payload.Title
@@ -201,79 +335,80 @@ func PostNewBook(w http.ResponseWriter, r *http.Request) {
 	book.Description = payload.Description
 
 	// Check the book doesn't already exist (including soft "deletes")
-	var presentBook db.Book
-	query := &db.Book{ISBN: isbn}
-	db.MySQL.Unscoped().Where(query).First(&presentBook)
-	isDeleted := presentBook.DeletedAt != nil
-	if isDeleted {
-		// FIXME: If there's time, this is a hack.
-		// If it's been soft deleted we can just do a hard delete of
-		//	the duplicate row so we can re-insert with normal flow.
-		db.MySQL.Unscoped().Where(query).Delete(&db.Book{})
+	presentBook, errPresent := s.Store.GetBookUnscoped(bookISBN)
+	if errPresent != nil && errPresent != db.ErrNotFound {
+		HandleErrorResponse(w, errPresent, http.StatusInternalServerError)
+		return
 	}
 
 	// If the ISBN already exists we create a new book copy.
+	isDeleted := presentBook.DeletedAt != nil
 	if presentBook.ISBN != "" && !isDeleted {
 		errMsg := "book with that isbn already exists, creating new copy"
 		HandleErrorResponse(w, errors.New(errMsg), http.StatusConflict)
 		return
 	}
 
-	// Build book copies for insertion
-	var copyRecords []interface{}
-	for i := 0; i < payload.Copies; i++ {
-		copyRecords = append(copyRecords, db.Copy{ISBN: isbn})
-	}
+	var bookWithAll db.Book
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		if isDeleted {
+			// FIXME: If there's time, this is a hack.
+			// If it's been soft deleted we can just do a hard delete of
+			//	the duplicate row so we can re-insert with normal flow.
+			if err := tx.PurgeBook(bookISBN); err != nil {
+				return err
+			}
+		}
 
-	// Insert book copies
-	errBulkCopies := gormbulk.BulkInsert(db.MySQL, copyRecords, 3000)
-	if errBulkCopies != nil {
-		log.Println(errBulkCopies.Error())
-		return
-	}
+		// Insert book copies
+		if err := tx.CreateCopies(bookISBN, payload.Copies); err != nil {
+			return err
+		}
 
-	// Insert and retrieve new book
-	var newBook db.Book
-	db.MySQL.Create(&book)
-	db.GetBookWithRelations(query, &newBook)
-
-	// Build book w/copies CREATE events
-	var eventRecords []interface{}
-	for _, bookCopy := range newBook.Copies {
-		event := db.Event{
-			BaseBook:  newBook.BaseBook,
-			BookID:    bookCopy.ID,
-			EventType: db.CREATE,
-			ISBN:      isbn,
+		// Insert new book
+		if err := tx.CreateBook(&book); err != nil {
+			return err
+		}
+		copies, err := tx.GetBookCopies(bookISBN, false)
+		if err != nil {
+			return err
+		}
+		for _, bookCopy := range copies {
+			if err := recordChange(tx, db.EntityCopy, bookCopy.ID, db.ADD, nil, bookCopy); err != nil {
+				return err
+			}
+		}
+		if err := s.assignMissingBarcodes(tx); err != nil {
+			return err
 		}
 
-		eventRecords = append(eventRecords, event)
-	}
+		// Insert BooksAuthors relations from payload.
+		if err := tx.AddBookAuthors(bookISBN, payload.AuthorIds); err != nil {
+			return err
+		}
 
-	// Insert creation events for all copies.
-	errBulkBooksEvents := gormbulk.BulkInsert(db.MySQL, eventRecords, 3000)
-	if errBulkBooksEvents != nil {
-		log.Println(errBulkBooksEvents.Error())
-		return
-	}
+		bookWithAll, err = tx.GetBook(bookISBN)
+		if err != nil {
+			return err
+		}
 
-	// Insert BooksAuthors relations from payload.
-	errBulkAuthors := db.BulkInsertBooksAuthors(payload.AuthorIds, payload.ISBN)
-	if errBulkAuthors != nil {
-		HandleErrorResponse(w, errBulkAuthors, http.StatusBadRequest)
+		// A single creation event, however many copies were added.
+		return recordBookChange(tx, db.CREATE, nil, &bookWithAll)
+	})
+	if errTx != nil {
+		HandleErrorResponse(w, errTx, http.StatusBadRequest)
 		return
 	}
+	s.indexBook(bookWithAll)
 
 	// Return the newly created book with all relations in response
-	var bookWithAll db.Book
-	db.GetBookWithRelations(query, &bookWithAll)
 	json.NewEncoder(w).Encode(BookResponse{
 		Data: bookWithAll,
 	})
 }
 
 // PatchUpdateBook - Update a book record by it's BookID.
-func PatchUpdateBook(w http.ResponseWriter, r *http.Request) {
+func (s *Server) PatchUpdateBook(w http.ResponseWriter, r *http.Request) {
 	query, err := queryBookWithParamISBN(r)
 	if err != nil {
 		HandleErrorResponse(w, err, http.StatusBadRequest)
@@ -290,14 +425,17 @@ func PatchUpdateBook(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Make sure book exists already.
-	var book db.Book
-	db.GetBookWithRelations(query, &book)
-	if book.DeletedAt != nil || book.ISBN == "" {
+	book, errBook := s.Store.GetBook(query.ISBN)
+	if errBook == db.ErrNotFound {
 		msg := fmt.Sprintf("no book with isbn %s found", query.ISBN)
 		err := errors.New(msg)
 		HandleErrorResponse(w, err, http.StatusNotFound)
 		return
 	}
+	if errBook != nil {
+		HandleErrorResponse(w, errBook, http.StatusInternalServerError)
+		return
+	}
 
 	// Update only what's supplied
 	updates := map[string]interface{}{}
@@ -310,56 +448,58 @@ func PatchUpdateBook(w http.ResponseWriter, r *http.Request) {
 	if patchPayload.Description != "" {
 		updates["description"] = patchPayload.Description
 	}
+	if patchPayload.ReplacementCents != nil {
+		updates["replacement_cents"] = *patchPayload.ReplacementCents
+	}
 
-	// Bulk Replace BooksAuthors relations from payload.
-	if len(patchPayload.AuthorIds) > 0 {
+	var newBook db.Book
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
 
-		// IDs already present
-		var currentIDs []uuid.UUID
-		for _, author := range book.Authors {
-			currentIDs = append(currentIDs, author.ID)
-		}
-		log.Println("currentIDs::", currentIDs)
+		// Bulk Replace BooksAuthors relations from payload.
+		if len(patchPayload.AuthorIds) > 0 {
 
-		// New IDs from payload
-		var insertIDs []uuid.UUID
-		for _, id := range patchPayload.AuthorIds {
-			insertIDs = append(insertIDs, id)
-		}
-		log.Println("insertIDs::", insertIDs)
+			// IDs already present
+			var currentIDs []uuid.UUID
+			for _, author := range book.Authors {
+				currentIDs = append(currentIDs, author.ID)
+			}
 
-		// Delete the old BooksAuthors records.
-		for _, id := range currentIDs {
-			deleteQuery := &db.BooksAuthors{
-				BookISBN: query.ISBN,
-				AuthorID: id,
+			// Delete the old BooksAuthors records and insert the new ones.
+			if err := tx.RemoveBookAuthors(query.ISBN, currentIDs); err != nil {
+				return err
+			}
+			if err := tx.AddBookAuthors(query.ISBN, patchPayload.AuthorIds); err != nil {
+				return err
 			}
-			db.MySQL.Unscoped().Delete(deleteQuery)
 		}
 
-		// Insert new BooksAuthors records.
-		errBulkAuthors := db.BulkInsertBooksAuthors(insertIDs, query.ISBN)
-		if errBulkAuthors != nil {
-			HandleErrorResponse(w, errBulkAuthors, http.StatusBadRequest)
-			return
+		// Apply updates and record the fields and authors that changed
+		updates["updated_at"] = time.Now()
+		if err := tx.UpdateBook(query.ISBN, updates); err != nil {
+			return err
 		}
-	}
 
-	// Apply updates and record requestBook event
-	updates["updated_at"] = time.Now()
-	db.MySQL.Model(&db.Book{ISBN: query.ISBN}).Updates(updates)
+		var err error
+		newBook, err = tx.GetBook(query.ISBN)
+		if err != nil {
+			return err
+		}
+
+		return recordBookChange(tx, db.UPDATE, &book, &newBook)
+	})
+	if errTx != nil {
+		HandleErrorResponse(w, errTx, http.StatusBadRequest)
+		return
+	}
+	s.indexBook(newBook)
 
-	var newBook db.Book
-	db.GetBookWithRelations(query, &newBook)
-	db.CreateNewBookEvents(newBook, db.UPDATE)
 	json.NewEncoder(w).Encode(BookResponse{
 		Data: newBook,
 	})
 }
 
 // DeleteBookByISBN - Deletes a book by it's BookID.
-func DeleteBookByISBN(w http.ResponseWriter, r *http.Request) {
-	var book db.Book
+func (s *Server) DeleteBookByISBN(w http.ResponseWriter, r *http.Request) {
 	query, err := queryBookWithParamISBN(r)
 	if err != nil {
 		HandleErrorResponse(w, err, http.StatusBadRequest)
@@ -367,7 +507,24 @@ func DeleteBookByISBN(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Delete and record the event
-	db.MySQL.Where(query).First(&book)
-	db.MySQL.Where(query).Delete(&db.Book{})
-	db.CreateNewBookEvents(book, db.DELETE)
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		book, err := tx.GetBook(query.ISBN)
+		if err != nil {
+			return err
+		}
+		if err := tx.DeleteBook(query.ISBN); err != nil {
+			return err
+		}
+
+		return recordBookChange(tx, db.DELETE, &book, nil)
+	})
+	if errTx == db.ErrNotFound {
+		HandleErrorResponse(w, errTx, http.StatusNotFound)
+		return
+	}
+	if errTx != nil {
+		HandleErrorResponse(w, errTx, http.StatusInternalServerError)
+		return
+	}
+	s.SearchIndex.Remove(query.ISBN)
 }
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"main/barcode"
+	"main/db"
+	"net/http"
+	"strings"
+)
+
+type PostCheckinsPayload struct {
+	Barcodes []string `json:"barcodes"`
+	CopyIDs  []uint   `json:"copy_ids"`
+}
+
+// CheckinAction - One step taken while checking an item in, e.g. a fine charged.
+type CheckinAction struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+// Kinds of check-in actions.
+const (
+	actionLoanClosed = "loan_closed"
+	actionFined      = "fine_charged"
+	actionHoldReady  = "hold_ready"
+	actionOnShelf    = "on_shelf"
+)
+
+// CheckinReceipt - What happened to one item handed in at the desk.
+type CheckinReceipt struct {
+	Barcode   string          `json:"barcode,omitempty"`
+	CopyID    uint            `json:"copy_id,omitempty"`
+	ISBN      string          `json:"isbn,omitempty"`
+	Title     string          `json:"title,omitempty"`
+	CheckedIn bool            `json:"checked_in"`
+	Checkout  *db.Checkout    `json:"checkout,omitempty"`
+	Actions   []CheckinAction `json:"actions,omitempty"`
+	Reason    string          `json:"reason,omitempty"`
+}
+
+type CheckinReceiptsResponse struct {
+	Data []CheckinReceipt `json:"data"`
+}
+
+// Reasons an item wasn't checked in.
+const (
+	reasonUnknownCopy = "unknown_copy"
+	reasonNotOnLoan   = "not_checked_out"
+)
+
+// Common request errors
+var errorCheckinItems = errors.New("barcodes or copy_ids missing in request")
+
+// checkinCopy - Close the open loan of a copy and describe everything that followed on a receipt.
+func (s *Server) checkinCopy(tx db.Store, bookCopy db.Copy) (CheckinReceipt, error) {
+	receipt := CheckinReceipt{CopyID: bookCopy.ID, ISBN: bookCopy.ISBN}
+	if bookCopy.Barcode != nil {
+		receipt.Barcode = *bookCopy.Barcode
+	}
+	if book, err := tx.GetBookUnscoped(bookCopy.ISBN); err == nil {
+		receipt.Title = book.Title
+	} else if err != db.ErrNotFound {
+		return receipt, err
+	}
+
+	open, err := tx.GetOpenCheckoutByCopy(bookCopy.ID)
+	if err == db.ErrNotFound {
+		receipt.Reason = reasonNotOnLoan
+		return receipt, nil
+	}
+	if err != nil {
+		return receipt, err
+	}
+
+	checkout, err := s.returnCheckout(tx, open.ID)
+	if err != nil {
+		return receipt, err
+	}
+	receipt.CheckedIn = true
+	receipt.Checkout = &checkout
+
+	// Rebuild what the return did from the closed loan and the copy's new state.
+	borrower := open.MemberID.String()
+	if member, err := tx.GetMember(open.MemberID); err == nil {
+		borrower = strings.TrimSpace(member.FirstName + " " + member.LastName)
+	}
+	receipt.Actions = append(receipt.Actions, CheckinAction{
+		Type:   actionLoanClosed,
+		Detail: fmt.Sprintf("loan %d of %s returned", checkout.ID, borrower),
+	})
+	if fine := s.Fines.overdueFine(open, *checkout.Returned); fine > 0 {
+		receipt.Actions = append(receipt.Actions, CheckinAction{
+			Type:   actionFined,
+			Detail: fmt.Sprintf("%d cents overdue fine charged to %s", fine, borrower),
+		})
+	}
+
+	hold, err := tx.GetCopyHold(bookCopy.ID)
+	if err != nil && err != db.ErrNotFound {
+		return receipt, err
+	}
+	if err == nil {
+		receipt.Actions = append(receipt.Actions, CheckinAction{
+			Type:   actionHoldReady,
+			Detail: fmt.Sprintf("set aside for hold %d, pick up by %s", hold.ID, hold.ExpiresAt.Format("2006-01-02")),
+		})
+		return receipt, nil
+	}
+
+	current, err := tx.GetCopy(bookCopy.ID)
+	if err != nil {
+		return receipt, err
+	}
+	if current.Status == db.CopyOnShelf {
+		detail := "back on the shelf"
+		if current.Location != "" {
+			detail += " at " + current.Location
+		}
+		receipt.Actions = append(receipt.Actions, CheckinAction{Type: actionOnShelf, Detail: detail})
+	}
+
+	return receipt, nil
+}
+
+// PostNewCheckins - Check in a batch of items handed in at the desk by copy barcode or id,
+// no member needed, returning a receipt per item.
+func (s *Server) PostNewCheckins(w http.ResponseWriter, r *http.Request) {
+	var payload PostCheckinsPayload
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	if len(payload.Barcodes) == 0 && len(payload.CopyIDs) == 0 {
+		HandleErrorResponse(w, errorCheckinItems, http.StatusBadRequest)
+		return
+	}
+
+	var receipts []CheckinReceipt
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		for _, code := range payload.Barcodes {
+			code, err := s.parseBarcode(code, barcode.Item)
+			if err != nil {
+				receipts = append(receipts, CheckinReceipt{Barcode: code, Reason: reasonInvalidBarcode})
+				continue
+			}
+
+			bookCopy, err := tx.GetCopyByBarcodeForUpdate(code)
+			if err == db.ErrNotFound {
+				receipts = append(receipts, CheckinReceipt{Barcode: code, Reason: reasonUnknownBarcode})
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			receipt, err := s.checkinCopy(tx, bookCopy)
+			if err != nil {
+				return err
+			}
+			receipts = append(receipts, receipt)
+		}
+
+		for _, id := range payload.CopyIDs {
+			if id == 0 {
+				receipts = append(receipts, CheckinReceipt{Reason: reasonUnknownCopy})
+				continue
+			}
+
+			bookCopy, err := tx.GetCopyForUpdate(id)
+			if err == db.ErrNotFound {
+				receipts = append(receipts, CheckinReceipt{CopyID: id, Reason: reasonUnknownCopy})
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			receipt, err := s.checkinCopy(tx, bookCopy)
+			if err != nil {
+				return err
+			}
+			receipts = append(receipts, receipt)
+		}
+
+		return nil
+	})
+	if errTx != nil {
+		HandleErrorResponse(w, errTx, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(CheckinReceiptsResponse{
+		Data: receipts,
+	})
+}
@@ -3,18 +3,22 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
 	uuid "github.com/satori/go.uuid"
-	gormbulk "github.com/t-tiger/gorm-bulk-insert"
-	"log"
+	"main/barcode"
 	"main/db"
+	"main/isbn"
 	"net/http"
+	"strconv"
 	"time"
 )
 
 type PostCheckouts struct {
-	MemberID uuid.UUID `json:"member_id"`
-	ISBNs    []string  `json:"isbns"`
+	MemberID      uuid.UUID `json:"member_id"`
+	MemberBarcode string    `json:"member_barcode"`
+	ISBNs         []string  `json:"isbns"`
+	Barcodes      []string  `json:"barcodes"`
 }
 
 type CheckoutResponse struct {
@@ -25,17 +29,51 @@ type CheckoutsResponse struct {
 	Data []db.Checkout `json:"data"`
 }
 
-type CheckoutsResponseInterface struct {
-	Data []interface{} `json:"data"`
+type CheckoutListResponse struct {
+	Data []db.Checkout `json:"data"`
+	ListPage
+}
+
+// CheckoutResult - Outcome of checking out one requested ISBN or scanned copy barcode.
+type CheckoutResult struct {
+	ISBN      string       `json:"isbn"`
+	Barcode   string       `json:"barcode,omitempty"`
+	Fulfilled bool         `json:"fulfilled"`
+	Checkout  *db.Checkout `json:"checkout,omitempty"`
+	Reason    string       `json:"reason,omitempty"`
+}
+
+// Reasons a requested ISBN or barcode wasn't checked out.
+const (
+	reasonUnavailable    = "unavailable"
+	reasonMaxItems       = "max_items_reached"
+	reasonInvalidISBN    = "invalid_isbn"
+	reasonInvalidBarcode = "invalid_barcode"
+	reasonUnknownBarcode = "unknown_barcode"
+)
+
+type CheckoutResultsResponse struct {
+	Data []CheckoutResult `json:"data"`
 }
 
 type CheckoutQueryPayload struct {
 	BookID   uint      `json:"book_id"`
 	MemberID uuid.UUID `json:"member_id"`
+	Barcode  string    `json:"barcode"`
 }
 
 // Common request errors
 var errorBookID = errors.New("book id missing in request")
+var errorCheckoutID = errors.New("checkout id missing or invalid in request")
+var errorCheckoutMember = errors.New("member_barcode or member_id needed to check out")
+var errorReturnQuery = errors.New("barcode, or book_id and member_id, needed to find the loan")
+var errorCheckoutReturned = errors.New("checkout has already been returned")
+var errorRenewalLimit = errors.New("checkout has reached its renewal limit")
+var errorRenewalOverdue = errors.New("checkout is overdue past its renewal grace period")
+var errorCheckoutLost = errors.New("checkout was reported lost")
+
+// dueSoonDays - Default window of ?due_soon=true when no days are given.
+const dueSoonDays = 3
 
 // containsString - Helper func to check slice for presence of an isbn.
 func containsString(s []string, e string) bool {
@@ -70,48 +108,123 @@ func queryCheckoutWithParams(r *http.Request) (*db.Checkout, error) {
 	return query, nil
 }
 
+// queryCheckoutWithParamID - Build gorm checkout query with loan id from url params.
+func queryCheckoutWithParamID(r *http.Request) (*db.Checkout, error) {
+	id, ok := parseID(mux.Vars(r)["id"])
+	if !ok {
+		return nil, errorCheckoutID
+	}
+
+	return &db.Checkout{ID: id}, nil
+}
+
+// checkoutFilterFromQuery - Build the due date filter for the overdue and due_soon query params.
+func checkoutFilterFromQuery(overdue bool, dueSoon bool, days string) db.CheckoutFilter {
+	now := time.Now()
+	filter := db.CheckoutFilter{OpenOnly: true}
+	if overdue {
+		filter.DueBefore = &now
+	}
+	if dueSoon {
+		window := dueSoonDays
+		if d, err := strconv.Atoi(days); err == nil && d > 0 {
+			window = d
+		}
+
+		soon := now.AddDate(0, 0, window)
+		filter.DueBefore = &soon
+		if !overdue {
+			filter.DueAfter = &now
+		}
+	}
+
+	return filter
+}
+
+// handleCheckoutError - Respond with the status matching a checkout lookup/update error.
+func handleCheckoutError(w http.ResponseWriter, err error) {
+	switch err {
+	case db.ErrNotFound:
+		HandleErrorResponse(w, err, http.StatusNotFound)
+	case errorCheckoutReturned, errorCheckoutLost, errorRenewalLimit, errorRenewalOverdue:
+		HandleErrorResponse(w, err, http.StatusConflict)
+	default:
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+	}
+}
+
 // queryCheckoutWithParamMemberID - Build gorm book query with id from url params.
 func queryCheckoutWithParamMemberID(r *http.Request) (*db.Checkout, error) {
-	params := mux.Vars(r)
-	memberId := params["member_id"]
-	if memberId == "" {
+	memberID, ok := parseUUID(mux.Vars(r)["member_id"])
+	if !ok {
 		return nil, errorMemberID
 	}
 
-	query := &db.Checkout{
-		MemberID: uuid.FromStringOrNil(memberId),
+	return &db.Checkout{MemberID: memberID}, nil
+}
+
+// GetAllCheckouts - Get a page of checkout records, filtered by ?member_id=, ?book_id=,
+// ?returned= and ?lost=, or only open loans that are overdue (?overdue=true) or due
+// within days (?due_soon=true&days=3), soonest due first.
+func (s *Server) GetAllCheckouts(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+	overdue := queryParams.Get("overdue") == "true"
+	dueSoon := queryParams.Get("due_soon") == "true"
+	listQuery, err := parseListQuery(r, "overdue", "due_soon", "days")
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
 	}
 
-	return query, nil
-}
+	var filter db.CheckoutFilter
+	if overdue || dueSoon {
+		filter = checkoutFilterFromQuery(overdue, dueSoon, queryParams.Get("days"))
+		if len(listQuery.Sort) == 0 {
+			listQuery.Sort = []string{"due_at"}
+		}
+	}
+	if isMemberRequest(r) {
+		listQuery.Filters["member_id"] = ownMemberID(r).String()
+	}
 
-// GetAllCheckouts - Get all checkout records.
-func GetAllCheckouts(w http.ResponseWriter, r *http.Request) {
-	var allCheckouts []db.Checkout
-	db.MySQL.Find(&allCheckouts)
-	json.NewEncoder(w).Encode(CheckoutsResponse{
-		Data: allCheckouts,
+	allCheckouts, page, err := s.Store.ListCheckouts(filter, listQuery)
+	if err != nil {
+		handleListError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(CheckoutListResponse{
+		Data:     allCheckouts,
+		ListPage: listPage(r, page, len(allCheckouts)),
 	})
 }
 
 // GetCheckoutsByMemberID - Get all checkout records.
-func GetCheckoutsByMemberID(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetCheckoutsByMemberID(w http.ResponseWriter, r *http.Request) {
 	query, err := queryCheckoutWithParamMemberID(r)
 	if err != nil {
 		HandleErrorResponse(w, err, http.StatusBadRequest)
 		return
 	}
+	if !canSeeMember(r, query.MemberID) {
+		HandleErrorResponse(w, errorForbidden, http.StatusForbidden)
+		return
+	}
+
+	allCheckouts, err := s.Store.GetCheckoutsByMember(query.MemberID)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
 
-	var allCheckouts []db.Checkout
-	db.MySQL.Where(query).Find(&allCheckouts)
 	json.NewEncoder(w).Encode(CheckoutsResponse{
 		Data: allCheckouts,
 	})
 
 }
 
-// PostNewCheckouts - Checkout a multiple books for a member.
-func PostNewCheckouts(w http.ResponseWriter, r *http.Request) {
+// PostNewCheckouts - Checkout a multiple books for a member, one available copy per ISBN.
+func (s *Server) PostNewCheckouts(w http.ResponseWriter, r *http.Request) {
 	var postCheckouts PostCheckouts
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&postCheckouts)
@@ -120,49 +233,376 @@ func PostNewCheckouts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get all copies for a range of ISBNs
-	var bookCopies []db.Copy
-	var copyQueries []string
-	for _, isbn := range postCheckouts.ISBNs {
-		copyQueries = append(copyQueries, isbn)
+	// Make sure the member exists before lending anything out, by card barcode when one was scanned.
+	var member db.Member
+	var errMember error
+	if postCheckouts.MemberBarcode != "" {
+		code, err := s.parseBarcode(postCheckouts.MemberBarcode, barcode.Patron)
+		if err != nil {
+			HandleErrorResponse(w, err, http.StatusBadRequest)
+			return
+		}
+		member, errMember = s.Store.GetMemberByBarcode(code)
+		if errMember == db.ErrNotFound {
+			msg := fmt.Sprintf("no member with barcode %s found", code)
+			HandleErrorResponse(w, errors.New(msg), http.StatusNotFound)
+			return
+		}
+	} else if postCheckouts.MemberID == uuid.Nil {
+		HandleErrorResponse(w, errorCheckoutMember, http.StatusBadRequest)
+		return
+	} else {
+		member, errMember = s.Store.GetMember(postCheckouts.MemberID)
+		if errMember == db.ErrNotFound {
+			msg := fmt.Sprintf("no member with id %s found", postCheckouts.MemberID)
+			HandleErrorResponse(w, errors.New(msg), http.StatusNotFound)
+			return
+		}
+	}
+	if errMember != nil {
+		HandleErrorResponse(w, errMember, http.StatusInternalServerError)
+		return
+	}
+
+	// Members owing more than the threshold have to settle up first.
+	balance, errBalance := s.memberBalance(s.Store, member.ID, time.Now())
+	if errBalance != nil {
+		HandleErrorResponse(w, errBalance, http.StatusInternalServerError)
+		return
+	}
+	if balance > s.Fines.Threshold {
+		msg := fmt.Sprintf("member owes %d cents, over the %d cent checkout limit", balance, s.Fines.Threshold)
+		HandleErrorResponse(w, errors.New(msg), http.StatusForbidden)
+		return
 	}
-	db.MySQL.Where("isbn IN (?)", copyQueries).Find(&bookCopies)
 
-	var usedISBNs []string
-	var checkouts []interface{}
-	for _, bookCopy := range bookCopies {
+	// Pick and lend copies in one transaction so concurrent requests can't get the same copy.
+	var results []CheckoutResult
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
 
-		// Only get one bookCopy per isbn
-		if containsString(usedISBNs, bookCopy.ISBN) {
-			continue
+		// Copies set aside for holds that were never picked up go to the next in line first.
+		if err := s.expireHolds(tx, time.Now()); err != nil {
+			return err
 		}
 
-		newCheckout := db.Checkout{
-			Base: db.Base{
-				CreatedAt: time.Now(),
-				UpdatedAt: time.Now(),
-			},
-			BookID:     bookCopy.ID,
-			MemberID:   postCheckouts.MemberID,
-			CheckedOut: time.Now(),
+		// Member wide limits come from the category policy.
+		memberPolicy, err := tx.ResolveLoanPolicy(member.Category, "", s.LoanPolicy)
+		if err != nil {
+			return err
 		}
-		checkouts = append(checkouts, newCheckout)
-		usedISBNs = append(usedISBNs, bookCopy.ISBN)
+		openLoans, err := tx.CountOpenCheckouts(member.ID)
+		if err != nil {
+			return err
+		}
+
+		var usedISBNs []string
+		for _, requested := range postCheckouts.ISBNs {
+			bookISBN, err := isbn.Parse(requested)
+			if err != nil {
+				results = append(results, CheckoutResult{ISBN: requested, Reason: reasonInvalidISBN})
+				continue
+			}
+
+			// Only get one bookCopy per isbn
+			if containsString(usedISBNs, bookISBN) {
+				continue
+			}
+			usedISBNs = append(usedISBNs, bookISBN)
+
+			if openLoans >= memberPolicy.MaxItems {
+				results = append(results, CheckoutResult{ISBN: bookISBN, Reason: reasonMaxItems})
+				continue
+			}
+
+			// A copy set aside for the member's own hold comes before the shelf.
+			hold, err := tx.GetActiveHold(bookISBN, member.ID)
+			if err != nil && err != db.ErrNotFound {
+				return err
+			}
+			hasHold := err == nil
+			held := hasHold && hold.Status == db.HoldReady && hold.CopyID != nil
+
+			var bookCopy db.Copy
+			if held {
+				bookCopy, err = tx.GetCopyForUpdate(*hold.CopyID)
+			} else {
+				bookCopy, err = tx.GetAvailableCopy(bookISBN)
+			}
+			if err == db.ErrNotFound {
+				results = append(results, CheckoutResult{ISBN: bookISBN, Reason: reasonUnavailable})
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			var fulfils *db.Hold
+			if hasHold {
+				fulfils = &hold
+			}
+			newCheckout, err := s.lendCopy(tx, member, bookCopy, fulfils, time.Now())
+			if err != nil {
+				return err
+			}
+
+			openLoans++
+			results = append(results, CheckoutResult{
+				ISBN:      bookISBN,
+				Fulfilled: true,
+				Checkout:  &newCheckout,
+			})
+		}
+
+		// Scanned copies are lent as they are, provided they're on the shelf and not held for someone else.
+		var usedBarcodes []string
+		for _, scanned := range postCheckouts.Barcodes {
+			code, err := s.parseBarcode(scanned, barcode.Item)
+			if err != nil {
+				results = append(results, CheckoutResult{Barcode: scanned, Reason: reasonInvalidBarcode})
+				continue
+			}
+			if containsString(usedBarcodes, code) {
+				continue
+			}
+			usedBarcodes = append(usedBarcodes, code)
+
+			bookCopy, err := tx.GetCopyByBarcodeForUpdate(code)
+			if err == db.ErrNotFound {
+				results = append(results, CheckoutResult{Barcode: code, Reason: reasonUnknownBarcode})
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			result := CheckoutResult{ISBN: bookCopy.ISBN, Barcode: code}
+			if openLoans >= memberPolicy.MaxItems {
+				result.Reason = reasonMaxItems
+				results = append(results, result)
+				continue
+			}
+			if bookCopy.Status != db.CopyOnShelf {
+				result.Reason = reasonUnavailable
+				results = append(results, result)
+				continue
+			}
+			copyHold, err := tx.GetCopyHold(bookCopy.ID)
+			if err != nil && err != db.ErrNotFound {
+				return err
+			}
+			if err == nil && copyHold.MemberID != member.ID {
+				result.Reason = reasonUnavailable
+				results = append(results, result)
+				continue
+			}
+
+			// Any copy of the book fulfils the member's own hold on it.
+			now := time.Now()
+			hold, err := tx.GetActiveHold(bookCopy.ISBN, member.ID)
+			if err != nil && err != db.ErrNotFound {
+				return err
+			}
+			hasHold := err == nil
+			newCheckout, err := s.lendCopy(tx, member, bookCopy, nil, now)
+			if err != nil {
+				return err
+			}
+			if hasHold {
+				if hold.CopyID != nil && *hold.CopyID == bookCopy.ID {
+					hold.CopyID = nil
+				}
+				if err := s.releaseHold(tx, hold, db.HoldFulfilled, now); err != nil {
+					return err
+				}
+			}
+
+			openLoans++
+			result.Fulfilled = true
+			result.Checkout = &newCheckout
+			results = append(results, result)
+		}
+
+		return nil
+	})
+	if errTx != nil {
+		HandleErrorResponse(w, errTx, http.StatusInternalServerError)
+		return
 	}
 
-	errBulkCheckouts := gormbulk.BulkInsert(db.MySQL, checkouts, 3000)
-	if errBulkCheckouts != nil {
-		log.Println(errBulkCheckouts.Error())
+	json.NewEncoder(w).Encode(CheckoutResultsResponse{
+		Data: results,
+	})
+}
+
+// lendCopy - Check a copy out to a member under the matching loan policy, fulfilling hold if given.
+func (s *Server) lendCopy(tx db.Store, member db.Member, bookCopy db.Copy, hold *db.Hold, now time.Time) (db.Checkout, error) {
+	policy, err := tx.ResolveLoanPolicy(member.Category, bookCopy.ISBN, s.LoanPolicy)
+	if err != nil {
+		return db.Checkout{}, err
+	}
+
+	dueAt := now.AddDate(0, 0, policy.LoanDays)
+	newCheckout := db.Checkout{
+		Base: db.Base{
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		BookID:     bookCopy.ID,
+		MemberID:   member.ID,
+		CheckedOut: now,
+		DueAt:      &dueAt,
+	}
+	if err := tx.CreateCheckout(&newCheckout); err != nil {
+		return newCheckout, err
+	}
+	if err := recordLoanChange(tx, bookCopy.ISBN, db.CHECKOUT, nil, newCheckout); err != nil {
+		return newCheckout, err
+	}
+	reason := fmt.Sprintf("checkout %d", newCheckout.ID)
+	if err := changeCopyStatus(tx, bookCopy, db.CopyCheckedOut, reason, now); err != nil {
+		return newCheckout, err
+	}
+	if hold != nil {
+		updates := map[string]interface{}{
+			"status":     db.HoldFulfilled,
+			"updated_at": now,
+		}
+		if err := updateHold(tx, *hold, updates); err != nil {
+			return newCheckout, err
+		}
+	}
+
+	return newCheckout, nil
+}
+
+// GetCheckoutByID - Get a single checkout record by its loan ID.
+func (s *Server) GetCheckoutByID(w http.ResponseWriter, r *http.Request) {
+	query, err := queryCheckoutWithParamID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
 		return
 	}
 
-	json.NewEncoder(w).Encode(CheckoutsResponseInterface{
-		Data: checkouts,
+	checkout, err := s.Store.GetCheckout(query.ID)
+	if err != nil {
+		handleCheckoutError(w, err)
+		return
+	}
+	if !canSeeMember(r, checkout.MemberID) {
+		HandleErrorResponse(w, errorForbidden, http.StatusForbidden)
+		return
+	}
+
+	json.NewEncoder(w).Encode(CheckoutResponse{
+		Data: checkout,
 	})
 }
 
-// PatchReturnCheckout - Update to return a checked out item.
-func PatchReturnCheckout(w http.ResponseWriter, r *http.Request) {
+// GetCheckoutsByCopyID - Get the loan history of a book copy.
+func (s *Server) GetCheckoutsByCopyID(w http.ResponseWriter, r *http.Request) {
+	query, err := queryBookWithParamID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	allCheckouts, err := s.Store.GetCheckoutsByCopy(query.ID)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(CheckoutsResponse{
+		Data: allCheckouts,
+	})
+}
+
+// lockLoan - Lock a loan and the copy it lends. The copy is locked first, the same order as the
+// desk paths that start from a scanned copy, so concurrent returns can't deadlock.
+func lockLoan(tx db.Store, id uint) (db.Checkout, db.Copy, error) {
+	checkout, err := tx.GetCheckout(id)
+	if err != nil {
+		return checkout, db.Copy{}, err
+	}
+	bookCopy, err := tx.GetCopyForUpdate(checkout.BookID)
+	if err != nil {
+		return checkout, bookCopy, err
+	}
+	checkout, err = tx.GetCheckoutForUpdate(id)
+
+	return checkout, bookCopy, err
+}
+
+// returnCheckout - Close an open loan, fine it if overdue, set the copy aside for the next hold
+// and return the loan's updated state.
+func (s *Server) returnCheckout(tx db.Store, id uint) (db.Checkout, error) {
+	checkout, bookCopy, err := lockLoan(tx, id)
+	if err != nil {
+		return checkout, err
+	}
+	if checkout.Returned != nil {
+		return checkout, errorCheckoutReturned
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"returned":   now,
+		"updated_at": now,
+	}
+	if err := tx.UpdateCheckout(id, updates); err != nil {
+		return checkout, err
+	}
+	returned, err := tx.GetCheckout(id)
+	if err != nil {
+		return checkout, err
+	}
+	if err := recordLoanChange(tx, bookCopy.ISBN, db.RETURN, checkout, returned); err != nil {
+		return checkout, err
+	}
+	if err := chargeMember(tx, checkout, db.LedgerFine, s.Fines.overdueFine(checkout, now), now); err != nil {
+		return checkout, err
+	}
+
+	if bookCopy.Status == db.CopyCheckedOut {
+		reason := fmt.Sprintf("checkout %d returned", id)
+		if err := changeCopyStatus(tx, bookCopy, db.CopyOnShelf, reason, now); err != nil {
+			return checkout, err
+		}
+		bookCopy.Status = db.CopyOnShelf
+	}
+	if err := s.expireHolds(tx, now); err != nil {
+		return checkout, err
+	}
+	if bookCopy.Status == db.CopyOnShelf {
+		if err := s.promoteNextHold(tx, bookCopy, now); err != nil {
+			return checkout, err
+		}
+	}
+
+	return returned, nil
+}
+
+// resolveCheckoutPolicy - Find the loan policy covering a checkout's member and book copy.
+func (s *Server) resolveCheckoutPolicy(tx db.Store, checkout db.Checkout, bookCopy db.Copy) (db.LoanPolicy, error) {
+	member, err := tx.GetMember(checkout.MemberID)
+	if err != nil {
+		return s.LoanPolicy, err
+	}
+
+	return tx.ResolveLoanPolicy(member.Category, bookCopy.ISBN, s.LoanPolicy)
+}
+
+// pastGracePeriod - Check if a loan is overdue by more than graceDays.
+func pastGracePeriod(checkout db.Checkout, graceDays int, now time.Time) bool {
+	if checkout.DueAt == nil {
+		return false
+	}
+
+	return now.After(checkout.DueAt.AddDate(0, 0, graceDays))
+}
+
+// PatchReturnCheckout - Update to return a checked out item by book and member, or by copy barcode.
+func (s *Server) PatchReturnCheckout(w http.ResponseWriter, r *http.Request) {
 	var payload CheckoutQueryPayload
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&payload)
@@ -171,17 +611,197 @@ func PatchReturnCheckout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := &db.Checkout{
-		BookID:   payload.BookID,
-		MemberID: payload.MemberID,
+	// A scanned barcode is enough to find the loan, no member needed.
+	var code string
+	if payload.Barcode != "" {
+		code, err = s.parseBarcode(payload.Barcode, barcode.Item)
+		if err != nil {
+			HandleErrorResponse(w, err, http.StatusBadRequest)
+			return
+		}
+	} else if payload.BookID == 0 || payload.MemberID == uuid.Nil {
+		HandleErrorResponse(w, errorReturnQuery, http.StatusBadRequest)
+		return
 	}
 
 	var checkout db.Checkout
-	db.MySQL.Model(query).
-		Update("returned", time.Now())
-	db.MySQL.Model(query).
-		Where("book_id = ?", payload.BookID).
-		First(&checkout)
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		var open db.Checkout
+		var err error
+		if code != "" {
+			var bookCopy db.Copy
+			if bookCopy, err = tx.GetCopyByBarcodeForUpdate(code); err != nil {
+				return err
+			}
+			open, err = tx.GetOpenCheckoutByCopy(bookCopy.ID)
+		} else {
+			open, err = tx.GetOpenCheckout(payload.BookID, payload.MemberID)
+		}
+		if err != nil {
+			return err
+		}
+
+		checkout, err = s.returnCheckout(tx, open.ID)
+		return err
+	})
+	if errTx != nil {
+		handleCheckoutError(w, errTx)
+		return
+	}
+
+	json.NewEncoder(w).Encode(CheckoutResponse{
+		Data: checkout,
+	})
+}
+
+// PatchReturnCheckoutByID - Return a checked out item by its loan ID.
+func (s *Server) PatchReturnCheckoutByID(w http.ResponseWriter, r *http.Request) {
+	query, err := queryCheckoutWithParamID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var checkout db.Checkout
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		var err error
+		checkout, err = s.returnCheckout(tx, query.ID)
+		return err
+	})
+	if errTx != nil {
+		handleCheckoutError(w, errTx)
+		return
+	}
+
+	json.NewEncoder(w).Encode(CheckoutResponse{
+		Data: checkout,
+	})
+}
+
+// PatchRenewCheckout - Renew an open loan by its loan ID, refused once the renewal
+// limit is reached or the loan is overdue past the renewal grace period.
+func (s *Server) PatchRenewCheckout(w http.ResponseWriter, r *http.Request) {
+	query, err := queryCheckoutWithParamID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var checkout db.Checkout
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		current, err := tx.GetCheckoutForUpdate(query.ID)
+		if err != nil {
+			return err
+		}
+		if current.Returned != nil {
+			return errorCheckoutReturned
+		}
+
+		bookCopy, err := tx.GetCopy(current.BookID)
+		if err != nil {
+			return err
+		}
+		policy, err := s.resolveCheckoutPolicy(tx, current, bookCopy)
+		if err != nil {
+			return err
+		}
+		if int(current.Renewals) >= policy.MaxRenewals {
+			return errorRenewalLimit
+		}
+
+		now := time.Now()
+		if pastGracePeriod(current, s.RenewalGraceDays, now) {
+			return errorRenewalOverdue
+		}
+
+		dueAt := now.AddDate(0, 0, policy.LoanDays)
+		updates := map[string]interface{}{
+			"renewals":   current.Renewals + 1,
+			"renewed_at": now,
+			"due_at":     dueAt,
+			"updated_at": now,
+		}
+		if err := tx.UpdateCheckout(query.ID, updates); err != nil {
+			return err
+		}
+
+		if checkout, err = tx.GetCheckout(query.ID); err != nil {
+			return err
+		}
+
+		return recordLoanChange(tx, bookCopy.ISBN, db.RENEW, current, checkout)
+	})
+	if errTx != nil {
+		handleCheckoutError(w, errTx)
+		return
+	}
+
+	json.NewEncoder(w).Encode(CheckoutResponse{
+		Data: checkout,
+	})
+}
+
+// PatchLostCheckout - Close an open loan as lost, charging the overdue fine so far and the replacement fee.
+func (s *Server) PatchLostCheckout(w http.ResponseWriter, r *http.Request) {
+	query, err := queryCheckoutWithParamID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var checkout db.Checkout
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		current, bookCopy, err := lockLoan(tx, query.ID)
+		if err != nil {
+			return err
+		}
+		if current.LostAt != nil {
+			return errorCheckoutLost
+		}
+		if current.Returned != nil {
+			return errorCheckoutReturned
+		}
+
+		book, err := tx.GetBookUnscoped(bookCopy.ISBN)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		updates := map[string]interface{}{
+			"returned":   now,
+			"lost_at":    now,
+			"updated_at": now,
+		}
+		if err := tx.UpdateCheckout(query.ID, updates); err != nil {
+			return err
+		}
+		if checkout, err = tx.GetCheckout(query.ID); err != nil {
+			return err
+		}
+		if err := recordLoanChange(tx, bookCopy.ISBN, db.LOST, current, checkout); err != nil {
+			return err
+		}
+		if err := chargeMember(tx, current, db.LedgerFine, s.Fines.overdueFine(current, now), now); err != nil {
+			return err
+		}
+		if err := chargeMember(tx, current, db.LedgerLostFee, s.Fines.replacementFee(book), now); err != nil {
+			return err
+		}
+		if bookCopy.Status == db.CopyCheckedOut {
+			reason := fmt.Sprintf("checkout %d reported lost", current.ID)
+			if err := changeCopyStatus(tx, bookCopy, db.CopyLost, reason, now); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if errTx != nil {
+		handleCheckoutError(w, errTx)
+		return
+	}
+
 	json.NewEncoder(w).Encode(CheckoutResponse{
 		Data: checkout,
 	})
@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/satori/go.uuid"
+	"main/db"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// openLoan - Lend a copy to a member through the store, due at dueAt.
+func openLoan(t *testing.T, s *Server, bookCopy db.Copy, member db.Member, dueAt time.Time) db.Checkout {
+	t.Helper()
+	checkout := db.Checkout{BookID: bookCopy.ID, MemberID: member.ID, CheckedOut: dueAt.AddDate(0, 0, -14), DueAt: &dueAt}
+	if err := s.Store.CreateCheckout(&checkout); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Store.UpdateCopy(bookCopy.ID, map[string]interface{}{"status": db.CopyCheckedOut}); err != nil {
+		t.Fatal(err)
+	}
+
+	return checkout
+}
+
+// countLoans - Count every loan in a test server's store.
+func countLoans(t *testing.T, s *Server) int {
+	t.Helper()
+	loans, page, err := s.Store.ListCheckouts(db.CheckoutFilter{}, db.ListQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != len(loans) {
+		t.Fatalf("more loans than fit a page: %d", page.Total)
+	}
+
+	return len(loans)
+}
+
+func TestPostNewCheckouts(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Store.Close()
+
+	addBook(t, s, "9781593275846", 1)
+	addBook(t, s, "9780306406157", 1)
+	addBook(t, s, "9780141439471", 1)
+	ada := addMember(t, s, "Ada", "student")
+	if err := s.Store.CreateLoanPolicy(&db.LoanPolicy{MemberCategory: "student", LoanDays: 21, MaxItems: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a member the lookup would match whichever member comes first.
+	for _, body := range []interface{}{
+		map[string]interface{}{"isbns": []string{"9781593275846"}},
+		map[string]interface{}{"member_id": "00000000-0000-0000-0000-000000000000", "isbns": []string{"9781593275846"}},
+	} {
+		w := request(s.PostNewCheckouts, http.MethodPost, "/checkouts", nil, body, librarian)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%v: status = %d, want 400: %s", body, w.Code, w.Body)
+		}
+	}
+	if n := countLoans(t, s); n != 0 {
+		t.Fatalf("%d loans made without a member", n)
+	}
+
+	unknown := PostCheckouts{MemberID: uuid.NewV4(), ISBNs: []string{"9781593275846"}}
+	if w := request(s.PostNewCheckouts, http.MethodPost, "/checkouts", nil, unknown, librarian); w.Code != http.StatusNotFound {
+		t.Errorf("unknown member status = %d, want 404: %s", w.Code, w.Body)
+	}
+
+	body := PostCheckouts{
+		MemberID: ada.ID,
+		ISBNs:    []string{"9781593275846", "9781593275846", "12345", "9780306406157", "9780141439471"},
+		Barcodes: []string{" 123x "},
+	}
+	w := request(s.PostNewCheckouts, http.MethodPost, "/checkouts", nil, body, librarian)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+
+	var res CheckoutResultsResponse
+	decode(t, w, &res)
+	want := []struct {
+		isbn      string
+		barcode   string
+		fulfilled bool
+		reason    string
+	}{
+		{"9781593275846", "", true, ""},
+		{"12345", "", false, reasonInvalidISBN},
+		{"9780306406157", "", true, ""},
+		{"9780141439471", "", false, reasonMaxItems},
+		{"", " 123x ", false, reasonInvalidBarcode},
+	}
+	if len(res.Data) != len(want) {
+		t.Fatalf("got %d results, want %d: %+v", len(res.Data), len(want), res.Data)
+	}
+	for i, result := range res.Data {
+		if result.ISBN != want[i].isbn || result.Barcode != want[i].barcode ||
+			result.Fulfilled != want[i].fulfilled || result.Reason != want[i].reason {
+			t.Errorf("result %d = %+v, want %+v", i, result, want[i])
+		}
+	}
+
+	// Repeated isbns are lent once.
+	if n := countLoans(t, s); n != 2 {
+		t.Errorf("%d loans made, want 2", n)
+	}
+
+	// Every copy of the first book is out now.
+	grace := addMember(t, s, "Grace", "standard")
+	body = PostCheckouts{MemberID: grace.ID, ISBNs: []string{"9781593275846"}}
+	w = request(s.PostNewCheckouts, http.MethodPost, "/checkouts", nil, body, librarian)
+	var again CheckoutResultsResponse
+	decode(t, w, &again)
+	if len(again.Data) != 1 || again.Data[0].Reason != reasonUnavailable {
+		t.Errorf("results = %+v, want the book unavailable", again.Data)
+	}
+
+	// Loans fall due after the student policy's loan length.
+	loan := res.Data[0].Checkout
+	if loan == nil || loan.DueAt == nil {
+		t.Fatalf("fulfilled result without a due date: %+v", res.Data[0])
+	}
+	if days := loan.DueAt.Sub(loan.CheckedOut).Hours() / 24; days != 21 {
+		t.Errorf("loan is %v days long, want 21", days)
+	}
+}
+
+func TestPostNewCheckoutsOverBalance(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Store.Close()
+
+	addBook(t, s, "9781593275846", 1)
+	ada := addMember(t, s, "Ada", "standard")
+	fine := db.LedgerEntry{MemberID: ada.ID, EntryType: db.LedgerFine, AmountCents: s.Fines.Threshold + 1}
+	if err := s.Store.CreateLedgerEntry(&fine); err != nil {
+		t.Fatal(err)
+	}
+
+	body := PostCheckouts{MemberID: ada.ID, ISBNs: []string{"9781593275846"}}
+	w := request(s.PostNewCheckouts, http.MethodPost, "/checkouts", nil, body, librarian)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403: %s", w.Code, w.Body)
+	}
+}
+
+func TestPatchReturnCheckout(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Store.Close()
+
+	copies := addBook(t, s, "9781593275846", 2)
+	ada := addMember(t, s, "Ada", "standard")
+	grace := addMember(t, s, "Grace", "standard")
+
+	// Three days late, due an hour short of three days ago.
+	late := openLoan(t, s, copies[0], ada, time.Now().Add(-71*time.Hour))
+	other := openLoan(t, s, copies[1], grace, time.Now().AddDate(0, 0, 7))
+
+	tests := []struct {
+		name   string
+		body   interface{}
+		status int
+	}{
+		{"no loan named", map[string]interface{}{}, http.StatusBadRequest},
+		{"no member", map[string]interface{}{"book_id": copies[1].ID}, http.StatusBadRequest},
+		{"no book", map[string]interface{}{"member_id": grace.ID}, http.StatusBadRequest},
+		{"not lent to the member", CheckoutQueryPayload{BookID: copies[1].ID, MemberID: ada.ID}, http.StatusNotFound},
+		{"unknown barcode", CheckoutQueryPayload{Barcode: "30001000000010"}, http.StatusNotFound},
+		{"returned", CheckoutQueryPayload{BookID: copies[0].ID, MemberID: ada.ID}, http.StatusOK},
+		{"returned already", CheckoutQueryPayload{BookID: copies[0].ID, MemberID: ada.ID}, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(s.PatchReturnCheckout, http.MethodPatch, "/checkouts", nil, tt.body, librarian)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+
+	// Only the named loan was returned, and its fine charged.
+	if got, _ := s.Store.GetCheckout(late.ID); got.Returned == nil {
+		t.Error("late loan wasn't returned")
+	}
+	if got, _ := s.Store.GetCheckout(other.ID); got.Returned != nil {
+		t.Error("another member's loan was returned")
+	}
+	if got, _ := s.Store.GetCopy(copies[0].ID); got.Status != db.CopyOnShelf {
+		t.Errorf("returned copy status = %s, want on_shelf", got.Status)
+	}
+	entries, err := s.Store.GetLedgerEntries(ada.ID)
+	if err != nil || len(entries) != 1 || entries[0].AmountCents != 3*s.Fines.PerDay {
+		t.Errorf("ledger = %+v, err %v, want a fine of 3 days", entries, err)
+	}
+}
+
+func TestPatchReturnCheckoutByID(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Store.Close()
+
+	copies := addBook(t, s, "9781593275846", 1)
+	loan := openLoan(t, s, copies[0], addMember(t, s, "Ada", "standard"), time.Now().AddDate(0, 0, 7))
+	id := loanID(loan)
+
+	tests := []struct {
+		name   string
+		id     string
+		status int
+	}{
+		{"non-numeric id", "abc", http.StatusBadRequest},
+		{"zero id", "0", http.StatusBadRequest},
+		{"unknown id", "99", http.StatusNotFound},
+		{"returned", id, http.StatusOK},
+		{"returned already", id, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars := map[string]string{"id": tt.id}
+			w := request(s.PatchReturnCheckoutByID, http.MethodPatch, "/checkouts/loans/"+tt.id+"/return", vars, nil, librarian)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestPatchRenewCheckout(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Store.Close()
+	s.LoanPolicy = db.LoanPolicy{LoanDays: 14, MaxRenewals: 1, MaxItems: 5}
+
+	copies := addBook(t, s, "9781593275846", 3)
+	ada := addMember(t, s, "Ada", "standard")
+	current := openLoan(t, s, copies[0], ada, time.Now().AddDate(0, 0, 2))
+	overdue := openLoan(t, s, copies[1], ada, time.Now().AddDate(0, 0, -1))
+	returned := openLoan(t, s, copies[2], ada, time.Now().AddDate(0, 0, 2))
+	if err := s.Store.UpdateCheckout(returned.ID, map[string]interface{}{"returned": time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		id     string
+		status int
+	}{
+		{"non-numeric id", "abc", http.StatusBadRequest},
+		{"zero id", "0", http.StatusBadRequest},
+		{"unknown id", "99", http.StatusNotFound},
+		{"renewed", loanID(current), http.StatusOK},
+		{"renewal limit", loanID(current), http.StatusConflict},
+		{"overdue past grace", loanID(overdue), http.StatusConflict},
+		{"returned", loanID(returned), http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars := map[string]string{"id": tt.id}
+			w := request(s.PatchRenewCheckout, http.MethodPatch, "/checkouts/loans/"+tt.id+"/renew", vars, nil, librarian)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+
+	renewed, err := s.Store.GetCheckout(current.ID)
+	if err != nil || renewed.Renewals != 1 {
+		t.Fatalf("renewals = %d, err %v, want 1", renewed.Renewals, err)
+	}
+	if days := time.Until(*renewed.DueAt).Hours() / 24; days < 13.9 || days > 14 {
+		t.Errorf("renewed loan due in %.1f days, want 14", days)
+	}
+
+	// A grace period lets overdue loans be renewed for a while.
+	s.RenewalGraceDays = 2
+	vars := map[string]string{"id": loanID(overdue)}
+	w := request(s.PatchRenewCheckout, http.MethodPatch, "/checkouts/loans/"+loanID(overdue)+"/renew", vars, nil, librarian)
+	if w.Code != http.StatusOK {
+		t.Errorf("renewal within grace status = %d, want 200: %s", w.Code, w.Body)
+	}
+}
+
+func TestPatchLostCheckout(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Store.Close()
+
+	copies := addBook(t, s, "9781593275846", 1)
+	ada := addMember(t, s, "Ada", "standard")
+	grace := addMember(t, s, "Grace", "standard")
+	loan := openLoan(t, s, copies[0], ada, time.Now().Add(-23*time.Hour))
+
+	tests := []struct {
+		name   string
+		id     string
+		status int
+	}{
+		{"non-numeric id", "abc", http.StatusBadRequest},
+		{"zero id", "0", http.StatusBadRequest},
+		{"lost", loanID(loan), http.StatusOK},
+		{"lost already", loanID(loan), http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars := map[string]string{"id": tt.id}
+			w := request(s.PatchLostCheckout, http.MethodPatch, "/checkouts/loans/"+tt.id+"/lost", vars, nil, librarian)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+
+	if got, _ := s.Store.GetCopy(copies[0].ID); got.Status != db.CopyLost {
+		t.Errorf("copy status = %s, want lost", got.Status)
+	}
+
+	// The member owes a day's fine and the replacement fee, and only they can see it.
+	vars := map[string]string{"id": ada.ID.String()}
+	w := request(s.GetMemberLedger, http.MethodGet, "/members/"+ada.ID.String()+"/ledger", vars, nil, memberPrincipal(ada))
+	if w.Code != http.StatusOK {
+		t.Fatalf("ledger status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var res MemberLedgerResponse
+	decode(t, w, &res)
+	if want := s.Fines.PerDay + s.Fines.Replacement; res.Data.BalanceCents != want || len(res.Data.Entries) != 2 {
+		t.Errorf("balance = %d over %d entries, want %d over 2", res.Data.BalanceCents, len(res.Data.Entries), want)
+	}
+
+	w = request(s.GetMemberLedger, http.MethodGet, "/members/"+ada.ID.String()+"/ledger", vars, nil, memberPrincipal(grace))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("another member's ledger status = %d, want 403", w.Code)
+	}
+}
+
+// loanID - A loan's id as it appears in urls.
+func loanID(checkout db.Checkout) string {
+	return fmt.Sprint(checkout.ID)
+}
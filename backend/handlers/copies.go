@@ -0,0 +1,446 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"main/db"
+	"net/http"
+	"time"
+)
+
+type PostCopiesPayload struct {
+	Count     int    `json:"count"`
+	Condition string `json:"condition"`
+	Location  string `json:"location"`
+}
+
+type PatchCopyPayload struct {
+	Barcode   string `json:"barcode"`
+	Condition string `json:"condition"`
+	Location  string `json:"location"`
+}
+
+type PatchCopyStatusPayload struct {
+	Status db.CopyStatus `json:"status"`
+	Reason string        `json:"reason"`
+}
+
+type CopyResponse struct {
+	Data db.Copy `json:"data"`
+}
+
+type CopyStatusChangesResponse struct {
+	Data []db.CopyStatusChange `json:"data"`
+}
+
+// maxNewCopies - Most copies added by a single request, each is written with its own event.
+const maxNewCopies = 100
+
+// Common request errors
+var errorCopyCount = fmt.Errorf("copies must be added %d at most at a time", maxNewCopies)
+var errorCopyWithdrawn = errors.New("copy has already been withdrawn")
+var errorCopyOnLoan = errors.New("copy is checked out")
+var errorCopyHeld = errors.New("copy is set aside for a hold")
+var errorCopyStatus = errors.New("unknown copy status")
+var errorCopyLoanStatus = errors.New("checked_out is only set by checkouts and returns")
+var errorCopyTransition = errors.New("copy can't move to that status from its current one")
+
+// handleCopyError - Respond with the status matching a copy lookup/update error.
+func handleCopyError(w http.ResponseWriter, err error) {
+	if isBarcodeError(err) || err == errorBarcodeTaken {
+		handleBarcodeError(w, err)
+		return
+	}
+
+	switch err {
+	case db.ErrNotFound:
+		HandleErrorResponse(w, err, http.StatusNotFound)
+	case errorCopyStatus, errorCopyLoanStatus:
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+	case errorCopyWithdrawn, errorCopyOnLoan, errorCopyHeld, errorCopyTransition:
+		HandleErrorResponse(w, err, http.StatusConflict)
+	default:
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+	}
+}
+
+// checkCopyIdle - Make sure a copy isn't out on loan or waiting for a hold pickup.
+func checkCopyIdle(tx db.Store, bookCopy db.Copy) error {
+	loans, err := tx.GetCheckoutsByCopy(bookCopy.ID)
+	if err != nil {
+		return err
+	}
+	for _, loan := range loans {
+		if loan.Returned == nil {
+			return errorCopyOnLoan
+		}
+	}
+
+	_, err = tx.GetCopyHold(bookCopy.ID)
+	if err == nil {
+		return errorCopyHeld
+	}
+	if err != db.ErrNotFound {
+		return err
+	}
+
+	return nil
+}
+
+// changeCopyStatus - Move a copy along the status state machine and record the step in its history
+// and the event log, withdrawals as WITHDRAW events.
+func changeCopyStatus(tx db.Store, bookCopy db.Copy, to db.CopyStatus, reason string, now time.Time) error {
+	if !db.CanTransition(bookCopy.Status, to) {
+		return errorCopyTransition
+	}
+
+	updates := map[string]interface{}{
+		"status":            to,
+		"status_changed_at": now,
+	}
+	if to == db.CopyWithdrawn {
+		updates["withdrawn_at"] = now
+	}
+	if err := tx.UpdateCopy(bookCopy.ID, updates); err != nil {
+		return err
+	}
+
+	change := db.CopyStatusChange{
+		Base: db.Base{
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		CopyID:     bookCopy.ID,
+		FromStatus: bookCopy.Status,
+		ToStatus:   to,
+		Reason:     reason,
+	}
+	if err := tx.CreateCopyStatusChange(&change); err != nil {
+		return err
+	}
+
+	updated, err := tx.GetCopy(bookCopy.ID)
+	if err != nil {
+		return err
+	}
+	action := db.UPDATE
+	if to == db.CopyWithdrawn {
+		action = db.WITHDRAW
+	}
+
+	return recordChange(tx, db.EntityCopy, bookCopy.ID, action, bookCopy, updated)
+}
+
+// setCopyStatus - Apply a status change requested through the api, handing copies back on
+// the shelf to the hold queue.
+func (s *Server) setCopyStatus(tx db.Store, bookCopy db.Copy, to db.CopyStatus, reason string) error {
+	if bookCopy.Status == db.CopyWithdrawn {
+		return errorCopyWithdrawn
+	}
+	if bookCopy.Status == db.CopyCheckedOut || to == db.CopyCheckedOut {
+		return errorCopyLoanStatus
+	}
+	if err := checkCopyIdle(tx, bookCopy); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := changeCopyStatus(tx, bookCopy, to, reason, now); err != nil {
+		return err
+	}
+
+	if to == db.CopyOnShelf {
+		bookCopy.Status = to
+		return s.promoteNextHold(tx, bookCopy, now)
+	}
+
+	return nil
+}
+
+// GetBookCopies - Get the copies of a book, including withdrawn ones with ?withdrawn=true.
+func (s *Server) GetBookCopies(w http.ResponseWriter, r *http.Request) {
+	query, err := queryBookWithParamISBN(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if _, err := s.Store.GetBook(query.ISBN); err != nil {
+		handleCopyError(w, err)
+		return
+	}
+
+	withWithdrawn := r.URL.Query().Get("withdrawn") == "true"
+	copies, err := s.Store.GetBookCopies(query.ISBN, withWithdrawn)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(CopiesResponse{
+		Data: copies,
+	})
+}
+
+// PostNewBookCopies - Add copies to an existing book, one by default.
+func (s *Server) PostNewBookCopies(w http.ResponseWriter, r *http.Request) {
+	query, err := queryBookWithParamISBN(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var payload PostCopiesPayload
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	if payload.Count == 0 {
+		payload.Count = 1
+	}
+	if payload.Count < 0 || payload.Count > maxNewCopies {
+		HandleErrorResponse(w, errorCopyCount, http.StatusBadRequest)
+		return
+	}
+
+	// Create the copies and record an event for each, then label them.
+	var copies []db.Copy
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		book, err := tx.GetBook(query.ISBN)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for i := 0; i < payload.Count; i++ {
+			bookCopy := db.Copy{
+				ISBN:            book.ISBN,
+				Condition:       payload.Condition,
+				Location:        payload.Location,
+				Status:          db.CopyOnShelf,
+				StatusChangedAt: &now,
+			}
+			if err := tx.CreateCopy(&bookCopy); err != nil {
+				return err
+			}
+			if err := recordChange(tx, db.EntityCopy, bookCopy.ID, db.ADD, nil, bookCopy); err != nil {
+				return err
+			}
+			copies = append(copies, bookCopy)
+		}
+		if err := s.assignMissingBarcodes(tx); err != nil {
+			return err
+		}
+
+		for i := range copies {
+			if copies[i], err = tx.GetCopy(copies[i].ID); err != nil {
+				return err
+			}
+
+			// A new copy goes to the first member waiting for the book.
+			if err := s.promoteNextHold(tx, copies[i], now); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if errTx == db.ErrNotFound {
+		msg := fmt.Sprintf("no book with isbn %s found", query.ISBN)
+		HandleErrorResponse(w, errors.New(msg), http.StatusNotFound)
+		return
+	}
+	if errTx != nil {
+		HandleErrorResponse(w, errTx, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(CopiesResponse{
+		Data: copies,
+	})
+}
+
+// GetCopyByID - Get a single copy, withdrawn or not.
+func (s *Server) GetCopyByID(w http.ResponseWriter, r *http.Request) {
+	query, err := queryBookWithParamID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	bookCopy, err := s.Store.GetCopy(query.ID)
+	if err == db.ErrNotFound {
+		json.NewEncoder(w).Encode(EmptyItemResponse{})
+		return
+	}
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(CopyResponse{
+		Data: bookCopy,
+	})
+}
+
+// PatchUpdateCopy - Update the barcode, condition or shelf location of a copy.
+func (s *Server) PatchUpdateCopy(w http.ResponseWriter, r *http.Request) {
+	query, err := queryBookWithParamID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var payload PatchCopyPayload
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// Update only what's supplied
+	updates := map[string]interface{}{}
+	if payload.Condition != "" {
+		updates["condition"] = payload.Condition
+	}
+	if payload.Location != "" {
+		updates["location"] = payload.Location
+	}
+
+	var bookCopy db.Copy
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		current, err := tx.GetCopy(query.ID)
+		if err != nil {
+			return err
+		}
+
+		// Relabelling a copy, e.g. after its label was damaged.
+		if payload.Barcode != "" {
+			code, err := s.checkCopyBarcode(tx, payload.Barcode, query.ID)
+			if err != nil {
+				return err
+			}
+			updates["barcode"] = code
+		}
+		if len(updates) == 0 {
+			bookCopy = current
+			return nil
+		}
+		if err := tx.UpdateCopy(query.ID, updates); err != nil {
+			return err
+		}
+
+		if bookCopy, err = tx.GetCopy(query.ID); err != nil {
+			return err
+		}
+
+		return recordChange(tx, db.EntityCopy, query.ID, db.UPDATE, current, bookCopy)
+	})
+	if errTx != nil {
+		handleCopyError(w, errTx)
+		return
+	}
+
+	json.NewEncoder(w).Encode(CopyResponse{
+		Data: bookCopy,
+	})
+}
+
+// DeleteCopyByID - Withdraw a copy from circulation, keeping its loan history.
+func (s *Server) DeleteCopyByID(w http.ResponseWriter, r *http.Request) {
+	query, err := queryBookWithParamID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var bookCopy db.Copy
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		current, err := tx.GetCopy(query.ID)
+		if err != nil {
+			return err
+		}
+		if err := s.setCopyStatus(tx, current, db.CopyWithdrawn, "withdrawn"); err != nil {
+			return err
+		}
+
+		bookCopy, err = tx.GetCopy(query.ID)
+		return err
+	})
+	if errTx != nil {
+		handleCopyError(w, errTx)
+		return
+	}
+
+	json.NewEncoder(w).Encode(CopyResponse{
+		Data: bookCopy,
+	})
+}
+
+// PatchCopyStatus - Move a copy to a new status, e.g. damaged or in_repair, with a reason.
+func (s *Server) PatchCopyStatus(w http.ResponseWriter, r *http.Request) {
+	query, err := queryBookWithParamID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var payload PatchCopyStatusPayload
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	if !db.IsCopyStatus(payload.Status) {
+		HandleErrorResponse(w, errorCopyStatus, http.StatusBadRequest)
+		return
+	}
+
+	var bookCopy db.Copy
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		current, err := tx.GetCopy(query.ID)
+		if err != nil {
+			return err
+		}
+		if err := s.setCopyStatus(tx, current, payload.Status, payload.Reason); err != nil {
+			return err
+		}
+
+		bookCopy, err = tx.GetCopy(query.ID)
+		return err
+	})
+	if errTx != nil {
+		handleCopyError(w, errTx)
+		return
+	}
+
+	json.NewEncoder(w).Encode(CopyResponse{
+		Data: bookCopy,
+	})
+}
+
+// GetCopyHistory - Get the status history of a copy, oldest first.
+func (s *Server) GetCopyHistory(w http.ResponseWriter, r *http.Request) {
+	query, err := queryBookWithParamID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if _, err := s.Store.GetCopy(query.ID); err != nil {
+		handleCopyError(w, err)
+		return
+	}
+
+	changes, err := s.Store.GetCopyStatusChanges(query.ID)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(CopyStatusChangesResponse{
+		Data: changes,
+	})
+}
@@ -2,29 +2,44 @@ package handlers
 
 import (
 	"encoding/json"
-	"main/db"
 	"net/http"
 )
 
-// GetAllEvents - Retrieve all events from the events table..
-func GetAllEvents(w http.ResponseWriter, r *http.Request) {
-	var allEvents []db.Event
-	db.MySQL.Model(&db.Event{}).Find(&allEvents)
-	json.NewEncoder(w).Encode(EventsResponse{
-		Data: allEvents,
+// GetAllEvents - Retrieve a page of events from the events table, filtered by
+// ?event_type=, ?isbn= and ?book_id=.
+func (s *Server) GetAllEvents(w http.ResponseWriter, r *http.Request) {
+	listQuery, err := parseListQuery(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	allEvents, page, err := s.Store.ListEvents(listQuery)
+	if err != nil {
+		handleListError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(EventListResponse{
+		Data:     allEvents,
+		ListPage: listPage(r, page, len(allEvents)),
 	})
 }
 
 // GetEventsByBookISBN - Retrieve all events for a book by it's BookID.
-func GetEventsByBookISBN(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetEventsByBookISBN(w http.ResponseWriter, r *http.Request) {
 	query, err := queryBookWithParamISBN(r)
 	if err != nil {
 		HandleErrorResponse(w, err, http.StatusBadRequest)
 		return
 	}
 
-	var allEvents []db.Event
-	db.MySQL.Where("isbn = ?", query.ISBN).Find(&allEvents)
+	allEvents, err := s.Store.GetEventsByISBN(query.ISBN)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
 	json.NewEncoder(w).Encode(EventsResponse{
 		Data: allEvents,
 	})
@@ -0,0 +1,413 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	uuid "github.com/satori/go.uuid"
+	"log"
+	"main/db"
+	"main/isbn"
+	"net/http"
+	"time"
+)
+
+type PostHoldPayload struct {
+	MemberID uuid.UUID `json:"member_id"`
+	ISBN     string    `json:"isbn"`
+}
+
+type HoldResponse struct {
+	Data db.Hold `json:"data"`
+}
+
+type HoldsResponse struct {
+	Data []db.Hold `json:"data"`
+}
+
+// defaultHoldPickupDays - Days a copy set aside for a hold waits before the hold expires.
+const defaultHoldPickupDays = 3
+
+// HoldExpiryInterval - How often holds past their pickup window are expired in the background.
+const HoldExpiryInterval = time.Minute
+
+// Common request errors
+var errorHoldID = errors.New("hold id missing or invalid in request")
+var errorHoldMember = errors.New("member_id needed to place a hold")
+var errorHoldExists = errors.New("member already has an active hold on this isbn")
+var errorHoldClosed = errors.New("hold is no longer active")
+var errorCopyAvailable = errors.New("a copy of this isbn is available, check it out instead")
+var errorHoldOnLoan = errors.New("member already has this isbn checked out")
+
+// queryHoldWithParamID - Build gorm hold query with id from url params.
+func queryHoldWithParamID(r *http.Request) (*db.Hold, error) {
+	id, ok := parseID(mux.Vars(r)["id"])
+	if !ok {
+		return nil, errorHoldID
+	}
+
+	return &db.Hold{ID: id}, nil
+}
+
+// queryHoldWithParamMemberID - Build gorm hold query with member id from url params.
+func queryHoldWithParamMemberID(r *http.Request) (*db.Hold, error) {
+	memberID, ok := parseUUID(mux.Vars(r)["member_id"])
+	if !ok {
+		return nil, errorMemberID
+	}
+
+	return &db.Hold{MemberID: memberID}, nil
+}
+
+// handleHoldError - Respond with the status matching a hold lookup/update error.
+func handleHoldError(w http.ResponseWriter, err error) {
+	switch err {
+	case db.ErrNotFound:
+		HandleErrorResponse(w, err, http.StatusNotFound)
+	case errorHoldExists, errorHoldClosed, errorCopyAvailable, errorHoldOnLoan:
+		HandleErrorResponse(w, err, http.StatusConflict)
+	case errorForbidden:
+		HandleErrorResponse(w, err, http.StatusForbidden)
+	default:
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+	}
+}
+
+// hasOpenLoan - Check if a member currently has any copy of a book checked out.
+func hasOpenLoan(tx db.Store, memberID uuid.UUID, isbn string) (bool, error) {
+	copies, err := tx.GetCopiesByISBNs([]string{isbn})
+	if err != nil {
+		return false, err
+	}
+	loans, err := tx.GetCheckoutsByMember(memberID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, loan := range loans {
+		if loan.Returned != nil {
+			continue
+		}
+		for _, bookCopy := range copies {
+			if loan.BookID == bookCopy.ID {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// promoteNextHold - Set a copy aside for the first member waiting on its ISBN, if any.
+func (s *Server) promoteNextHold(tx db.Store, bookCopy db.Copy, now time.Time) error {
+	next, err := tx.GetNextHold(bookCopy.ISBN)
+	if err == db.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	expiresAt := now.AddDate(0, 0, s.HoldPickupDays)
+	updates := map[string]interface{}{
+		"status":     db.HoldReady,
+		"copy_id":    bookCopy.ID,
+		"ready_at":   now,
+		"expires_at": expiresAt,
+		"updated_at": now,
+	}
+
+	return updateHold(tx, next, updates)
+}
+
+// updateHold - Apply column updates to a hold and log the change.
+func updateHold(tx db.Store, hold db.Hold, updates map[string]interface{}) error {
+	if err := tx.UpdateHold(hold.ID, updates); err != nil {
+		return err
+	}
+	updated, err := tx.GetHold(hold.ID)
+	if err != nil {
+		return err
+	}
+
+	return recordChange(tx, db.EntityHold, hold.ID, db.UPDATE, hold, updated)
+}
+
+// releaseHold - Close an active hold, passing a copy it had set aside down the queue.
+func (s *Server) releaseHold(tx db.Store, hold db.Hold, status db.HoldStatus, now time.Time) error {
+	updates := map[string]interface{}{
+		"status":     status,
+		"updated_at": now,
+	}
+	if err := updateHold(tx, hold, updates); err != nil {
+		return err
+	}
+	if hold.Status != db.HoldReady || hold.CopyID == nil {
+		return nil
+	}
+
+	bookCopy, err := tx.GetCopy(*hold.CopyID)
+	if err != nil {
+		return err
+	}
+
+	return s.promoteNextHold(tx, bookCopy, now)
+}
+
+// expireHolds - Expire ready holds past their pickup window, their copies go to the next in line.
+func (s *Server) expireHolds(tx db.Store, now time.Time) error {
+	expired, err := tx.GetExpiredHolds(now)
+	if err != nil {
+		return err
+	}
+
+	for _, hold := range expired {
+		if err := s.releaseHold(tx, hold, db.HoldExpired, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ExpireHolds - Expire ready holds past their pickup window in a transaction of its own.
+func (s *Server) ExpireHolds(now time.Time) error {
+	return s.Store.Transaction(func(tx db.Store) error {
+		return s.expireHolds(tx, now)
+	})
+}
+
+// RunHoldExpiry - Expire holds every interval until ctx is cancelled. Reads don't expire holds
+// themselves, so a hold can show as ready for up to an interval after its pickup window closes.
+func (s *Server) RunHoldExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.ExpireHolds(time.Now()); err != nil {
+			log.Println("holds::", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// GetHoldByID - Get a single hold.
+func (s *Server) GetHoldByID(w http.ResponseWriter, r *http.Request) {
+	query, err := queryHoldWithParamID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	hold, err := s.Store.GetHold(query.ID)
+	if err == db.ErrNotFound {
+		json.NewEncoder(w).Encode(EmptyItemResponse{})
+		return
+	}
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+	if !canSeeMember(r, hold.MemberID) {
+		HandleErrorResponse(w, errorForbidden, http.StatusForbidden)
+		return
+	}
+
+	json.NewEncoder(w).Encode(HoldResponse{
+		Data: hold,
+	})
+}
+
+// GetHoldsByISBN - Get the active hold queue of a book in FIFO order.
+func (s *Server) GetHoldsByISBN(w http.ResponseWriter, r *http.Request) {
+	query, err := queryBookWithParamISBN(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	holds, err := s.Store.GetHoldsByISBN(query.ISBN)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(HoldsResponse{
+		Data: holds,
+	})
+}
+
+// GetHoldsByMemberID - Get every hold a member has placed, newest first.
+func (s *Server) GetHoldsByMemberID(w http.ResponseWriter, r *http.Request) {
+	query, err := queryHoldWithParamMemberID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	if !canSeeMember(r, query.MemberID) {
+		HandleErrorResponse(w, errorForbidden, http.StatusForbidden)
+		return
+	}
+
+	holds, err := s.Store.GetHoldsByMember(query.MemberID)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(HoldsResponse{
+		Data: holds,
+	})
+}
+
+// PostNewHold - Queue a member for the next copy of a book with no copies available.
+func (s *Server) PostNewHold(w http.ResponseWriter, r *http.Request) {
+	var payload PostHoldPayload
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&payload)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	bookISBN, err := isbn.Parse(payload.ISBN)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	if payload.MemberID == uuid.Nil {
+		HandleErrorResponse(w, errorHoldMember, http.StatusBadRequest)
+		return
+	}
+	if !canSeeMember(r, payload.MemberID) {
+		HandleErrorResponse(w, errorForbidden, http.StatusForbidden)
+		return
+	}
+
+	member, errMember := s.Store.GetMember(payload.MemberID)
+	if errMember == db.ErrNotFound {
+		msg := fmt.Sprintf("no member with id %s found", payload.MemberID)
+		HandleErrorResponse(w, errors.New(msg), http.StatusNotFound)
+		return
+	}
+	if errMember != nil {
+		HandleErrorResponse(w, errMember, http.StatusInternalServerError)
+		return
+	}
+
+	_, errBook := s.Store.GetBook(bookISBN)
+	if errBook == db.ErrNotFound {
+		msg := fmt.Sprintf("no book with isbn %s found", bookISBN)
+		HandleErrorResponse(w, errors.New(msg), http.StatusNotFound)
+		return
+	}
+	if errBook != nil {
+		HandleErrorResponse(w, errBook, http.StatusInternalServerError)
+		return
+	}
+
+	var hold db.Hold
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		now := time.Now()
+		if err := s.expireHolds(tx, now); err != nil {
+			return err
+		}
+
+		_, err := tx.GetActiveHold(bookISBN, member.ID)
+		if err == nil {
+			return errorHoldExists
+		}
+		if err != db.ErrNotFound {
+			return err
+		}
+
+		onLoan, err := hasOpenLoan(tx, member.ID, bookISBN)
+		if err != nil {
+			return err
+		}
+		if onLoan {
+			return errorHoldOnLoan
+		}
+
+		// Holds are only for books that can't be checked out right now.
+		_, err = tx.GetAvailableCopy(bookISBN)
+		if err == nil {
+			return errorCopyAvailable
+		}
+		if err != db.ErrNotFound {
+			return err
+		}
+
+		hold = db.Hold{
+			Base: db.Base{
+				CreatedAt: now,
+				UpdatedAt: now,
+			},
+			ISBN:     bookISBN,
+			MemberID: member.ID,
+			Status:   db.HoldWaiting,
+		}
+		if err := tx.CreateHold(&hold); err != nil {
+			return err
+		}
+
+		return recordChange(tx, db.EntityHold, hold.ID, db.CREATE, nil, hold)
+	})
+	if errTx != nil {
+		handleHoldError(w, errTx)
+		return
+	}
+
+	json.NewEncoder(w).Encode(HoldResponse{
+		Data: hold,
+	})
+}
+
+// PatchCancelHold - Cancel an active hold, a copy set aside for it goes to the next in line.
+func (s *Server) PatchCancelHold(w http.ResponseWriter, r *http.Request) {
+	query, err := queryHoldWithParamID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var hold db.Hold
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		now := time.Now()
+		if err := s.expireHolds(tx, now); err != nil {
+			return err
+		}
+
+		current, err := tx.GetHold(query.ID)
+		if err != nil {
+			return err
+		}
+		if !canSeeMember(r, current.MemberID) {
+			return errorForbidden
+		}
+		if current.Status != db.HoldWaiting && current.Status != db.HoldReady {
+			return errorHoldClosed
+		}
+		if err := s.releaseHold(tx, current, db.HoldCancelled, now); err != nil {
+			return err
+		}
+
+		hold, err = tx.GetHold(query.ID)
+		return err
+	})
+	if errTx != nil {
+		handleHoldError(w, errTx)
+		return
+	}
+
+	json.NewEncoder(w).Encode(HoldResponse{
+		Data: hold,
+	})
+}
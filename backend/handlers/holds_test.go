@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"main/db"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// placeHold - Post a hold as principal, returning the status and the hold placed.
+func placeHold(s *Server, body interface{}, principal *Principal) (int, db.Hold) {
+	w := request(s.PostNewHold, http.MethodPost, "/holds", nil, body, principal)
+	var res HoldResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	return w.Code, res.Data
+}
+
+func TestPostNewHold(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Store.Close()
+
+	addBook(t, s, "9781593275846", 1)
+	copies := addBook(t, s, "9780306406157", 1)
+	ada := addMember(t, s, "Ada", "standard")
+	grace := addMember(t, s, "Grace", "standard")
+	openLoan(t, s, copies[0], grace, time.Now().AddDate(0, 0, 7))
+
+	tests := []struct {
+		name      string
+		body      interface{}
+		principal *Principal
+		status    int
+	}{
+		{"no member", map[string]interface{}{"isbn": "9780306406157"}, librarian, http.StatusBadRequest},
+		{"nil member", map[string]interface{}{"isbn": "9780306406157", "member_id": "00000000-0000-0000-0000-000000000000"}, librarian, http.StatusBadRequest},
+		{"invalid isbn", PostHoldPayload{MemberID: ada.ID, ISBN: "12345"}, librarian, http.StatusBadRequest},
+		{"for another member", PostHoldPayload{MemberID: grace.ID, ISBN: "9780306406157"}, memberPrincipal(ada), http.StatusForbidden},
+		{"unknown book", PostHoldPayload{MemberID: ada.ID, ISBN: "9780141439471"}, librarian, http.StatusNotFound},
+		{"copy available", PostHoldPayload{MemberID: ada.ID, ISBN: "9781593275846"}, librarian, http.StatusConflict},
+		{"already on loan", PostHoldPayload{MemberID: grace.ID, ISBN: "9780306406157"}, librarian, http.StatusConflict},
+		{"placed", PostHoldPayload{MemberID: ada.ID, ISBN: "9780306406157"}, memberPrincipal(ada), http.StatusOK},
+		{"placed already", PostHoldPayload{MemberID: ada.ID, ISBN: "9780306406157"}, librarian, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, _ := placeHold(s, tt.body, tt.principal); status != tt.status {
+				t.Fatalf("status = %d, want %d", status, tt.status)
+			}
+		})
+	}
+
+	holds, err := s.Store.GetHoldsByISBN("9780306406157")
+	if err != nil || len(holds) != 1 || holds[0].MemberID != ada.ID || holds[0].Status != db.HoldWaiting {
+		t.Errorf("holds = %+v, err %v, want one waiting hold for Ada", holds, err)
+	}
+}
+
+func TestHoldQueue(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Store.Close()
+
+	copies := addBook(t, s, "9780306406157", 1)
+	ada := addMember(t, s, "Ada", "standard")
+	grace := addMember(t, s, "Grace", "standard")
+	linus := addMember(t, s, "Linus", "standard")
+	loan := openLoan(t, s, copies[0], grace, time.Now().AddDate(0, 0, 7))
+
+	status, hold := placeHold(s, PostHoldPayload{MemberID: ada.ID, ISBN: "9780306406157"}, librarian)
+	if status != http.StatusOK {
+		t.Fatalf("hold status = %d, want 200", status)
+	}
+
+	// The returned copy is set aside for the hold.
+	vars := map[string]string{"id": loanID(loan)}
+	if w := request(s.PatchReturnCheckoutByID, http.MethodPatch, "/checkouts/loans/"+loanID(loan)+"/return", vars, nil, librarian); w.Code != http.StatusOK {
+		t.Fatalf("return status = %d, want 200: %s", w.Code, w.Body)
+	}
+	ready, err := s.Store.GetHold(hold.ID)
+	if err != nil || ready.Status != db.HoldReady || ready.CopyID == nil || *ready.CopyID != copies[0].ID {
+		t.Fatalf("hold = %+v, err %v, want the returned copy ready", ready, err)
+	}
+
+	checkout := func(member db.Member) CheckoutResult {
+		body := PostCheckouts{MemberID: member.ID, ISBNs: []string{"9780306406157"}}
+		w := request(s.PostNewCheckouts, http.MethodPost, "/checkouts", nil, body, librarian)
+		var res CheckoutResultsResponse
+		decode(t, w, &res)
+		if len(res.Data) != 1 {
+			t.Fatalf("results = %+v, want one", res.Data)
+		}
+		return res.Data[0]
+	}
+
+	if result := checkout(linus); result.Fulfilled || result.Reason != reasonUnavailable {
+		t.Errorf("someone else's result = %+v, want unavailable", result)
+	}
+	if result := checkout(ada); !result.Fulfilled || result.Checkout.BookID != copies[0].ID {
+		t.Errorf("hold member's result = %+v, want the held copy", result)
+	}
+	if fulfilled, _ := s.Store.GetHold(hold.ID); fulfilled.Status != db.HoldFulfilled {
+		t.Errorf("hold status = %s, want fulfilled", fulfilled.Status)
+	}
+}
+
+func TestPatchCancelHold(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Store.Close()
+
+	copies := addBook(t, s, "9780306406157", 1)
+	ada := addMember(t, s, "Ada", "standard")
+	grace := addMember(t, s, "Grace", "standard")
+	openLoan(t, s, copies[0], grace, time.Now().AddDate(0, 0, 7))
+	_, hold := placeHold(s, PostHoldPayload{MemberID: ada.ID, ISBN: "9780306406157"}, librarian)
+	id := fmt.Sprint(hold.ID)
+
+	tests := []struct {
+		name      string
+		id        string
+		principal *Principal
+		status    int
+	}{
+		{"non-numeric id", "abc", librarian, http.StatusBadRequest},
+		{"zero id", "0", librarian, http.StatusBadRequest},
+		{"unknown id", "99", librarian, http.StatusNotFound},
+		{"someone else's hold", id, memberPrincipal(grace), http.StatusForbidden},
+		{"cancelled", id, memberPrincipal(ada), http.StatusOK},
+		{"cancelled already", id, librarian, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars := map[string]string{"id": tt.id}
+			w := request(s.PatchCancelHold, http.MethodPatch, "/holds/"+tt.id+"/cancel", vars, nil, tt.principal)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+
+	if cancelled, _ := s.Store.GetHold(hold.ID); cancelled.Status != db.HoldCancelled {
+		t.Errorf("hold status = %s, want cancelled", cancelled.Status)
+	}
+}
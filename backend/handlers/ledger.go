@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	uuid "github.com/satori/go.uuid"
+	"main/db"
+	"net/http"
+	"time"
+)
+
+// FineSchedule - What the library charges, all amounts in cents.
+type FineSchedule struct {
+	PerDay      int64 // Charged per started day a loan is overdue.
+	ItemCap     int64 // Most a single loan can be fined for being overdue.
+	Replacement int64 // Lost copy fee for books without a replacement cost of their own.
+	Threshold   int64 // Checkouts are refused once a balance goes over this.
+}
+
+// defaultFineSchedule - Used unless overridden by the FINE_* env vars.
+var defaultFineSchedule = FineSchedule{
+	PerDay:      25,
+	ItemCap:     1000,
+	Replacement: 2500,
+	Threshold:   1000,
+}
+
+type PostLedgerEntryPayload struct {
+	EntryType   db.LedgerEntryType `json:"entry_type"`
+	AmountCents int64              `json:"amount_cents"`
+	CheckoutID  *uint              `json:"checkout_id"`
+	Note        string             `json:"note"`
+}
+
+type MemberLedger struct {
+	BalanceCents int64            `json:"balance_cents"`
+	AccruedCents int64            `json:"accrued_cents"`
+	Entries      []db.LedgerEntry `json:"entries"`
+}
+
+type MemberLedgerResponse struct {
+	Data MemberLedger `json:"data"`
+}
+
+type LedgerEntryResponse struct {
+	Data db.LedgerEntry `json:"data"`
+}
+
+// Common request errors
+var errorLedgerEntry = errors.New("entry_type must be payment or waiver with a positive amount_cents")
+
+// overdueFine - Fine for a loan that is still overdue at until, capped per item.
+func (f FineSchedule) overdueFine(checkout db.Checkout, until time.Time) int64 {
+	if checkout.DueAt == nil || !until.After(*checkout.DueAt) {
+		return 0
+	}
+
+	late := until.Sub(*checkout.DueAt)
+	days := int64(late / (24 * time.Hour))
+	if late%(24*time.Hour) > 0 {
+		days++
+	}
+
+	fine := days * f.PerDay
+	if fine > f.ItemCap {
+		return f.ItemCap
+	}
+
+	return fine
+}
+
+// replacementFee - Lost copy fee of a book.
+func (f FineSchedule) replacementFee(book db.Book) int64 {
+	if book.ReplacementCents > 0 {
+		return book.ReplacementCents
+	}
+
+	return f.Replacement
+}
+
+// chargeMember - Add a charge for a loan to its member's ledger, skipping zero amounts.
+func chargeMember(tx db.Store, checkout db.Checkout, entryType db.LedgerEntryType, amount int64, now time.Time) error {
+	if amount <= 0 {
+		return nil
+	}
+
+	checkoutID := checkout.ID
+	entry := db.LedgerEntry{
+		Base: db.Base{
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		MemberID:    checkout.MemberID,
+		CheckoutID:  &checkoutID,
+		EntryType:   entryType,
+		AmountCents: amount,
+	}
+	if err := tx.CreateLedgerEntry(&entry); err != nil {
+		return err
+	}
+
+	return recordChange(tx, db.EntityLedgerEntry, entry.ID, db.CREATE, nil, entry)
+}
+
+// accruedFines - Fines building up on a member's open overdue loans, not yet in the ledger.
+func (s *Server) accruedFines(store db.Store, memberID uuid.UUID, now time.Time) (int64, error) {
+	loans, err := store.GetCheckoutsByMember(memberID)
+	if err != nil {
+		return 0, err
+	}
+
+	var accrued int64
+	for _, loan := range loans {
+		if loan.Returned == nil {
+			accrued += s.Fines.overdueFine(loan, now)
+		}
+	}
+
+	return accrued, nil
+}
+
+// memberBalance - Ledger balance plus fines accruing on open loans.
+func (s *Server) memberBalance(store db.Store, memberID uuid.UUID, now time.Time) (int64, error) {
+	balance, err := store.GetLedgerBalance(memberID)
+	if err != nil {
+		return 0, err
+	}
+	accrued, err := s.accruedFines(store, memberID, now)
+	if err != nil {
+		return 0, err
+	}
+
+	return balance + accrued, nil
+}
+
+// GetMemberLedger - Get a member's account entries and balance.
+func (s *Server) GetMemberLedger(w http.ResponseWriter, r *http.Request) {
+	query, err := queryMemberWithParamsMemberID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	if !canSeeMember(r, query.ID) {
+		HandleErrorResponse(w, errorForbidden, http.StatusForbidden)
+		return
+	}
+
+	if _, err := s.Store.GetMember(query.ID); err != nil {
+		handleMemberError(w, query.ID, err)
+		return
+	}
+
+	entries, err := s.Store.GetLedgerEntries(query.ID)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+	balance, err := s.Store.GetLedgerBalance(query.ID)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+	accrued, err := s.accruedFines(s.Store, query.ID, time.Now())
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(MemberLedgerResponse{
+		Data: MemberLedger{
+			BalanceCents: balance + accrued,
+			AccruedCents: accrued,
+			Entries:      entries,
+		},
+	})
+}
+
+// PostNewLedgerEntry - Record a payment or waiver against a member's balance.
+func (s *Server) PostNewLedgerEntry(w http.ResponseWriter, r *http.Request) {
+	query, err := queryMemberWithParamsMemberID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var payload PostLedgerEntryPayload
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	isCredit := payload.EntryType == db.LedgerPayment || payload.EntryType == db.LedgerWaiver
+	if !isCredit || payload.AmountCents <= 0 {
+		HandleErrorResponse(w, errorLedgerEntry, http.StatusBadRequest)
+		return
+	}
+
+	if _, err := s.Store.GetMember(query.ID); err != nil {
+		handleMemberError(w, query.ID, err)
+		return
+	}
+
+	// Credits are stored negative so the ledger sums to the balance owed.
+	now := time.Now()
+	entry := db.LedgerEntry{
+		Base: db.Base{
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		MemberID:    query.ID,
+		CheckoutID:  payload.CheckoutID,
+		EntryType:   payload.EntryType,
+		AmountCents: -payload.AmountCents,
+		Note:        payload.Note,
+	}
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		if err := tx.CreateLedgerEntry(&entry); err != nil {
+			return err
+		}
+
+		return recordChange(tx, db.EntityLedgerEntry, entry.ID, db.CREATE, nil, entry)
+	})
+	if errTx != nil {
+		HandleErrorResponse(w, errTx, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(LedgerEntryResponse{
+		Data: entry,
+	})
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"errors"
+	"main/db"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ListMeta - Totals of a paged list response.
+type ListMeta struct {
+	Total int `json:"total"`
+	Count int `json:"count"`
+	Limit int `json:"limit"`
+}
+
+// ListLinks - Urls of the neighbouring pages, null at either end of the list.
+type ListLinks struct {
+	Next *string `json:"next"`
+	Prev *string `json:"prev"`
+}
+
+// ListPage - Paging info sent next to the data of every list response.
+type ListPage struct {
+	Meta  ListMeta  `json:"meta"`
+	Links ListLinks `json:"links"`
+}
+
+// Query params of list endpoints that aren't filters.
+const (
+	listLimitParam  = "limit"
+	listCursorParam = "cursor"
+	listSortParam   = "sort"
+)
+
+// parseListQuery - Read ?limit=, ?cursor=, ?sort=title,-created_at and field filters
+// from a request. Params in skip are handled by the endpoint and aren't filters.
+func parseListQuery(r *http.Request, skip ...string) (db.ListQuery, error) {
+	params := r.URL.Query()
+	q := db.ListQuery{
+		Cursor:  params.Get(listCursorParam),
+		Filters: map[string]string{},
+	}
+
+	if limit := params.Get(listLimitParam); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return q, errors.New("limit must be a positive number")
+		}
+		q.Limit = n
+	}
+
+	for _, field := range strings.Split(params.Get(listSortParam), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			q.Sort = append(q.Sort, field)
+		}
+	}
+
+	reserved := map[string]bool{listLimitParam: true, listCursorParam: true, listSortParam: true}
+	for _, name := range skip {
+		reserved[name] = true
+	}
+	for name := range params {
+		if !reserved[name] {
+			q.Filters[name] = params.Get(name)
+		}
+	}
+
+	return q, nil
+}
+
+// pageLink - The request url pointing at another cursor.
+func pageLink(r *http.Request, cursor string) *string {
+	if cursor == "" {
+		return nil
+	}
+
+	params := r.URL.Query()
+	params.Set(listCursorParam, cursor)
+	link := r.URL.Path + "?" + params.Encode()
+	return &link
+}
+
+// listPage - Paging info for a page of count rows.
+func listPage(r *http.Request, page db.Page, count int) ListPage {
+	return ListPage{
+		Meta: ListMeta{
+			Total: page.Total,
+			Count: count,
+			Limit: page.Limit,
+		},
+		Links: ListLinks{
+			Next: pageLink(r, page.Next),
+			Prev: pageLink(r, page.Prev),
+		},
+	}
+}
+
+// handleListError - Respond with the status matching a list query error.
+func handleListError(w http.ResponseWriter, err error) {
+	if errors.Is(err, db.ErrInvalidListQuery) {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	HandleErrorResponse(w, err, http.StatusInternalServerError)
+}
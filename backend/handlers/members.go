@@ -11,8 +11,9 @@ import (
 	"time"
 )
 
-type MembersResponse struct {
+type MemberListResponse struct {
 	Data []db.Member `json:"data"`
+	ListPage
 }
 
 type MemberResponse struct {
@@ -20,56 +21,83 @@ type MemberResponse struct {
 }
 
 // Common request errors
-var errorMemberID = errors.New("member_id id missing in request")
+var errorMemberID = errors.New("member id missing or invalid in request")
 
 // queryMemberWithParamsMemberID - Build gorm book query with id from url params.
 func queryMemberWithParamsMemberID(r *http.Request) (*db.Member, error) {
-	params := mux.Vars(r)
-	memberID := params["id"]
-	if memberID == "" {
+	memberID, ok := parseUUID(mux.Vars(r)["id"])
+	if !ok {
 		return nil, errorMemberID
 	}
 
-	mID := uuid.FromStringOrNil(memberID)
 	query := &db.Member{Person: db.Person{
-		ID: mID,
+		ID: memberID,
 	}}
 
 	return query, nil
 }
 
-// GetAllMembers - Get all library members.
-func GetAllMembers(w http.ResponseWriter, r *http.Request) {
-	var allMembers []db.Member
+// handleMemberError - Respond with the status matching a member lookup error.
+func handleMemberError(w http.ResponseWriter, id uuid.UUID, err error) {
+	if err == db.ErrNotFound {
+		msg := fmt.Sprintf("no member with id %s found", id)
+		HandleErrorResponse(w, errors.New(msg), http.StatusNotFound)
+		return
+	}
+
+	HandleErrorResponse(w, err, http.StatusInternalServerError)
+}
+
+// GetAllMembers - Get a page of library members, filtered by ?category= and ?last_name=.
+func (s *Server) GetAllMembers(w http.ResponseWriter, r *http.Request) {
 	queryParams := r.URL.Query()
-	checkouts := queryParams.Get("checkouts")
-	if checkouts != "" {
-		db.MySQL.
-			Preload("Checkouts", "`checkouts`.`returned` IS NULL").
-			Find(&allMembers)
-	} else {
-		db.MySQL.Find(&allMembers)
+	withCheckouts := queryParams.Get("checkouts") != ""
+	listQuery, err := parseListQuery(r, "checkouts")
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	allMembers, page, err := s.Store.ListMembers(withCheckouts, listQuery)
+	if err != nil {
+		handleListError(w, err)
+		return
 	}
 
-	json.NewEncoder(w).Encode(MembersResponse{
-		Data: allMembers,
+	json.NewEncoder(w).Encode(MemberListResponse{
+		Data:     allMembers,
+		ListPage: listPage(r, page, len(allMembers)),
 	})
 }
 
 // GetMemberByID - Get a member by their ID.
-func GetMemberByID(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetMemberByID(w http.ResponseWriter, r *http.Request) {
 	query, err := queryMemberWithParamsMemberID(r)
 	if err != nil {
 		HandleErrorResponse(w, err, http.StatusBadRequest)
 		return
 	}
+	if !canSeeMember(r, query.ID) {
+		HandleErrorResponse(w, errorForbidden, http.StatusForbidden)
+		return
+	}
 
-	var member db.Member
-	db.MySQL.Unscoped().Where(query).First(&member)
-	if member.DeletedAt != nil {
+	member, err := s.Store.GetMember(query.ID)
+	if err == db.ErrNotFound {
 		json.NewEncoder(w).Encode(EmptyItemResponse{})
 		return
 	}
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	balance, err := s.memberBalance(s.Store, member.ID, time.Now())
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+	member.BalanceCents = &balance
 
 	json.NewEncoder(w).Encode(MemberResponse{
 		Data: member,
@@ -77,7 +105,7 @@ func GetMemberByID(w http.ResponseWriter, r *http.Request) {
 }
 
 // PostNewMember - Create a new library member.
-func PostNewMember(w http.ResponseWriter, r *http.Request) {
+func (s *Server) PostNewMember(w http.ResponseWriter, r *http.Request) {
 	var member db.Member
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&member)
@@ -87,14 +115,47 @@ func PostNewMember(w http.ResponseWriter, r *http.Request) {
 	}
 
 	member.ID = uuid.NewV4()
-	db.MySQL.Create(&member)
+	if member.Category == "" {
+		member.Category = db.DefaultMemberCategory
+	}
+
+	// Use the card barcode handed out at the desk, or give the member the next one.
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		if member.Barcode != nil && *member.Barcode != "" {
+			code, err := s.checkMemberBarcode(tx, *member.Barcode, member.ID)
+			if err != nil {
+				return err
+			}
+			member.Barcode = &code
+		} else {
+			member.Barcode = nil
+		}
+		if err := tx.CreateMember(&member); err != nil {
+			return err
+		}
+		if err := s.assignMissingBarcodes(tx); err != nil {
+			return err
+		}
+
+		var err error
+		if member, err = tx.GetMember(member.ID); err != nil {
+			return err
+		}
+
+		return recordChange(tx, db.EntityMember, member.ID, db.CREATE, nil, member)
+	})
+	if errTx != nil {
+		handleBarcodeError(w, errTx)
+		return
+	}
+
 	json.NewEncoder(w).Encode(MemberResponse{
 		Data: member,
 	})
 }
 
 // PatchUpdateMember - Update to members data.
-func PatchUpdateMember(w http.ResponseWriter, r *http.Request) {
+func (s *Server) PatchUpdateMember(w http.ResponseWriter, r *http.Request) {
 	query, errQ := queryMemberWithParamsMemberID(r)
 	if errQ != nil {
 		HandleErrorResponse(w, errQ, http.StatusBadRequest)
@@ -110,21 +171,18 @@ func PatchUpdateMember(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	queryMemberByID := db.Member{
-		Person: db.Person{
-			ID: query.ID,
-		},
-	}
-
 	// Check the members current state from db and handle errors.
-	var currentMember db.Member
-	db.MySQL.Unscoped().Where(queryMemberByID).First(&currentMember)
-	if currentMember.DeletedAt != nil || IsInvalidPerson(currentMember.Person) {
+	currentMember, errCurrent := s.Store.GetMember(query.ID)
+	if errCurrent == db.ErrNotFound || IsInvalidPerson(currentMember.Person) {
 		msg := fmt.Sprintf("no member with id %s found", query.ID)
 		err := errors.New(msg)
 		HandleErrorResponse(w, err, http.StatusNotFound)
 		return
 	}
+	if errCurrent != nil {
+		HandleErrorResponse(w, errCurrent, http.StatusInternalServerError)
+		return
+	}
 
 	// Update only what's supplied
 	updates := map[string]interface{}{}
@@ -140,28 +198,65 @@ func PatchUpdateMember(w http.ResponseWriter, r *http.Request) {
 	if member.Middle != "" {
 		updates["middle"] = member.Middle
 	}
+	if member.Category != "" {
+		updates["category"] = member.Category
+	}
 
-	// Apply updates to member.
-	member.ID = query.ID
+	// Apply updates to member and get the updated member object to return.
+	var updatedMember db.Member
 	updates["updated_at"] = time.Now()
-	db.MySQL.Model(&member).Updates(updates)
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		if member.Barcode != nil && *member.Barcode != "" {
+			code, err := s.checkMemberBarcode(tx, *member.Barcode, query.ID)
+			if err != nil {
+				return err
+			}
+			updates["barcode"] = code
+		}
+		if err := tx.UpdateMember(query.ID, updates); err != nil {
+			return err
+		}
+
+		var err error
+		if updatedMember, err = tx.GetMember(query.ID); err != nil {
+			return err
+		}
+
+		return recordChange(tx, db.EntityMember, query.ID, db.UPDATE, currentMember, updatedMember)
+	})
+	if errTx != nil {
+		handleBarcodeError(w, errTx)
+		return
+	}
 
-	// Get the updated member object to return.
-	var updatedMember db.Member
-	db.MySQL.Where(query).First(&updatedMember)
 	json.NewEncoder(w).Encode(MemberResponse{
 		Data: updatedMember,
 	})
 }
 
 // DeleteMemberByID - Deletes an member record by their uuid.
-func DeleteMemberByID(w http.ResponseWriter, r *http.Request) {
-	var member db.Member
+func (s *Server) DeleteMemberByID(w http.ResponseWriter, r *http.Request) {
 	query, err := queryMemberWithParamsMemberID(r)
 	if err != nil {
 		HandleErrorResponse(w, err, http.StatusBadRequest)
 		return
 	}
 
-	db.MySQL.Where(query).Delete(&member)
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		member, err := tx.GetMember(query.ID)
+		if err == db.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.DeleteMember(query.ID); err != nil {
+			return err
+		}
+
+		return recordChange(tx, db.EntityMember, query.ID, db.DELETE, member, nil)
+	})
+	if errTx != nil {
+		HandleErrorResponse(w, errTx, http.StatusInternalServerError)
+	}
 }
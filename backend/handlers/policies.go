@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"main/db"
+	"main/isbn"
+	"net/http"
+	"time"
+)
+
+type LoanPoliciesResponse struct {
+	Data []db.LoanPolicy `json:"data"`
+}
+
+type LoanPolicyResponse struct {
+	Data db.LoanPolicy `json:"data"`
+}
+
+type PatchLoanPolicyPayload struct {
+	LoanDays    *int `json:"loan_days"`
+	MaxRenewals *int `json:"max_renewals"`
+	MaxItems    *int `json:"max_items"`
+}
+
+// Common request errors
+var errorPolicyID = errors.New("policy id missing or invalid in request")
+var errorPolicyLimits = errors.New("loan_days and max_items must be positive, max_renewals can't be negative")
+
+// queryPolicyWithParamID - Build gorm loan policy query with id from url params.
+func queryPolicyWithParamID(r *http.Request) (*db.LoanPolicy, error) {
+	id, ok := parseID(mux.Vars(r)["id"])
+	if !ok {
+		return nil, errorPolicyID
+	}
+
+	return &db.LoanPolicy{ID: id}, nil
+}
+
+// isInvalidPolicy - Check a policy's limits make sense.
+func isInvalidPolicy(policy db.LoanPolicy) bool {
+	return policy.LoanDays < 1 || policy.MaxRenewals < 0 || policy.MaxItems < 1
+}
+
+// GetAllLoanPolicies - Retrieve all loan policies.
+func (s *Server) GetAllLoanPolicies(w http.ResponseWriter, r *http.Request) {
+	policies, err := s.Store.GetAllLoanPolicies()
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(LoanPoliciesResponse{
+		Data: policies,
+	})
+}
+
+// GetResolvedLoanPolicy - Show the policy that applies to a member category and optional isbn.
+func (s *Server) GetResolvedLoanPolicy(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+	category := queryParams.Get("member_category")
+	if category == "" {
+		category = db.DefaultMemberCategory
+	}
+
+	var bookISBN string
+	if queryParams.Get("isbn") != "" {
+		var err error
+		if bookISBN, err = isbn.Parse(queryParams.Get("isbn")); err != nil {
+			HandleErrorResponse(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	policy, err := s.Store.ResolveLoanPolicy(category, bookISBN, s.LoanPolicy)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(LoanPolicyResponse{
+		Data: policy,
+	})
+}
+
+// PostNewLoanPolicy - Create a loan policy for a member category and/or book.
+func (s *Server) PostNewLoanPolicy(w http.ResponseWriter, r *http.Request) {
+	var policy db.LoanPolicy
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&policy)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if policy.ISBN != "" {
+		if policy.ISBN, err = isbn.Parse(policy.ISBN); err != nil {
+			HandleErrorResponse(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+	if isInvalidPolicy(policy) {
+		HandleErrorResponse(w, errorPolicyLimits, http.StatusBadRequest)
+		return
+	}
+
+	// Only one policy per member category and book pair.
+	_, errPresent := s.Store.FindLoanPolicy(policy.MemberCategory, policy.ISBN)
+	if errPresent == nil {
+		responseErr := errors.New("a policy for that member category and isbn already exists")
+		HandleErrorResponse(w, responseErr, http.StatusConflict)
+		return
+	}
+	if errPresent != db.ErrNotFound {
+		HandleErrorResponse(w, errPresent, http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	policy.ID = 0
+	policy.CreatedAt = now
+	policy.UpdatedAt = now
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		if err := tx.CreateLoanPolicy(&policy); err != nil {
+			return err
+		}
+
+		return recordChange(tx, db.EntityLoanPolicy, policy.ID, db.CREATE, nil, policy)
+	})
+	if errTx != nil {
+		HandleErrorResponse(w, errTx, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(LoanPolicyResponse{
+		Data: policy,
+	})
+}
+
+// PatchUpdateLoanPolicy - Update the limits of a loan policy.
+func (s *Server) PatchUpdateLoanPolicy(w http.ResponseWriter, r *http.Request) {
+	query, errQ := queryPolicyWithParamID(r)
+	if errQ != nil {
+		HandleErrorResponse(w, errQ, http.StatusBadRequest)
+		return
+	}
+
+	var payload PatchLoanPolicyPayload
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&payload)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	currentPolicy, errCurrent := s.Store.GetLoanPolicy(query.ID)
+	if errCurrent == db.ErrNotFound {
+		msg := fmt.Sprintf("no policy with id %d found", query.ID)
+		HandleErrorResponse(w, errors.New(msg), http.StatusNotFound)
+		return
+	}
+	if errCurrent != nil {
+		HandleErrorResponse(w, errCurrent, http.StatusInternalServerError)
+		return
+	}
+
+	// Update only what's supplied
+	policy := currentPolicy
+	updates := map[string]interface{}{}
+	if payload.LoanDays != nil {
+		policy.LoanDays = *payload.LoanDays
+		updates["loan_days"] = policy.LoanDays
+	}
+	if payload.MaxRenewals != nil {
+		policy.MaxRenewals = *payload.MaxRenewals
+		updates["max_renewals"] = policy.MaxRenewals
+	}
+	if payload.MaxItems != nil {
+		policy.MaxItems = *payload.MaxItems
+		updates["max_items"] = policy.MaxItems
+	}
+	if isInvalidPolicy(policy) {
+		HandleErrorResponse(w, errorPolicyLimits, http.StatusBadRequest)
+		return
+	}
+
+	var updatedPolicy db.LoanPolicy
+	updates["updated_at"] = time.Now()
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		if err := tx.UpdateLoanPolicy(query.ID, updates); err != nil {
+			return err
+		}
+
+		var err error
+		if updatedPolicy, err = tx.GetLoanPolicy(query.ID); err != nil {
+			return err
+		}
+
+		return recordChange(tx, db.EntityLoanPolicy, query.ID, db.UPDATE, currentPolicy, updatedPolicy)
+	})
+	if errTx != nil {
+		HandleErrorResponse(w, errTx, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(LoanPolicyResponse{
+		Data: updatedPolicy,
+	})
+}
+
+// DeleteLoanPolicyByID - Deletes a loan policy by id.
+func (s *Server) DeleteLoanPolicyByID(w http.ResponseWriter, r *http.Request) {
+	query, err := queryPolicyWithParamID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		policy, err := tx.GetLoanPolicy(query.ID)
+		if err == db.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.DeleteLoanPolicy(query.ID); err != nil {
+			return err
+		}
+
+		return recordChange(tx, db.EntityLoanPolicy, query.ID, db.DELETE, policy, nil)
+	})
+	if errTx != nil {
+		HandleErrorResponse(w, errTx, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	uuid "github.com/satori/go.uuid"
+	"log"
+	"main/db"
+	"main/isbn"
+	"main/search"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// SearchResult - A book matching a search, with the matched words highlighted per field.
+type SearchResult struct {
+	ISBN       string            `json:"isbn"`
+	Title      string            `json:"title"`
+	Authors    []string          `json:"authors"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights"`
+}
+
+type SearchResponse struct {
+	Data  []SearchResult `json:"data"`
+	Total int            `json:"total"`
+}
+
+// Search result limits.
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// Common request errors
+var errorSearchQuery = errors.New("q missing in request")
+
+// bookDocument - The searchable text of a book.
+func bookDocument(book db.Book) search.Document {
+	doc := search.Document{
+		ISBN:        book.ISBN,
+		Title:       book.Title,
+		Description: book.Description,
+	}
+	for _, author := range book.Authors {
+		name := strings.Join(strings.Fields(author.FirstName+" "+author.Middle+" "+author.LastName), " ")
+		doc.Authors = append(doc.Authors, name)
+	}
+
+	return doc
+}
+
+// indexBook - Add or refresh a book in the search index.
+func (s *Server) indexBook(book db.Book) {
+	s.SearchIndex.Put(bookDocument(book))
+}
+
+// reindexBooks - Refresh books in the search index from the store, dropping ones
+// that no longer exist. The index is only a cache, so failures are logged.
+func (s *Server) reindexBooks(books []db.Book) {
+	for _, book := range books {
+		current, err := s.Store.GetBook(book.ISBN)
+		if err == db.ErrNotFound {
+			s.SearchIndex.Remove(book.ISBN)
+			continue
+		}
+		if err != nil {
+			log.Printf("search:: can't reindex book %s:: %s", book.ISBN, err.Error())
+			continue
+		}
+		s.indexBook(current)
+	}
+}
+
+// reindexAuthorBooks - Refresh the books of an author, e.g. after a name change.
+func (s *Server) reindexAuthorBooks(id uuid.UUID) {
+	books, err := s.Store.GetAuthorBooks(id)
+	if err != nil && err != db.ErrNotFound {
+		log.Printf("search:: can't reindex books of author %s:: %s", id, err.Error())
+		return
+	}
+	s.reindexBooks(books)
+}
+
+// RebuildSearchIndex - Index the whole catalogue from scratch, run on startup and after seeding.
+// Books are read a page at a time, loading their relations for the whole catalogue at once
+// would go past SQLite's limit on query variables.
+func (s *Server) RebuildSearchIndex() error {
+	var docs []search.Document
+	q := db.ListQuery{Sort: []string{"isbn"}, Limit: db.MaxListLimit}
+	for {
+		books, page, err := s.Store.GetAllBooks(q)
+		if err != nil {
+			return err
+		}
+		for _, book := range books {
+			docs = append(docs, bookDocument(book))
+		}
+		if page.Next == "" {
+			break
+		}
+		q.Cursor = page.Next
+	}
+	s.SearchIndex.Rebuild(docs)
+
+	return nil
+}
+
+// GetSearch - Full-text search of the catalogue by title, description, isbn and
+// author names (?q=), best matches first, at most ?limit= results.
+func (s *Server) GetSearch(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+	q := strings.TrimSpace(queryParams.Get("q"))
+	if q == "" {
+		HandleErrorResponse(w, errorSearchQuery, http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultSearchLimit
+	if l, err := strconv.Atoi(queryParams.Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	// A hyphenated or ISBN-10 query finds the book under its stored ISBN-13.
+	if parsed, err := isbn.Parse(q); err == nil {
+		q = parsed
+	}
+
+	hits := s.SearchIndex.Search(q)
+	results := []SearchResult{}
+	for i, hit := range hits {
+		if i == limit {
+			break
+		}
+		results = append(results, SearchResult{
+			ISBN:       hit.Document.ISBN,
+			Title:      hit.Document.Title,
+			Authors:    hit.Document.Authors,
+			Score:      hit.Score,
+			Highlights: hit.Highlights,
+		})
+	}
+
+	json.NewEncoder(w).Encode(SearchResponse{
+		Data:  results,
+		Total: len(hits),
+	})
+}
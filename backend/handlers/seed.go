@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"main/db"
+	"main/isbn"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+type SeedBook struct {
+	db.Book
+	Author string `json:"author"`
+}
+
+type PostSeedPayload struct {
+	Set    string `json:"set"`
+	Append bool   `json:"append"`
+}
+
+// SeedResult - How many records a seed loaded.
+type SeedResult struct {
+	Set       string `json:"set"`
+	Appended  bool   `json:"appended"`
+	Members   int    `json:"members"`
+	Authors   int    `json:"authors"`
+	Books     int    `json:"books"`
+	Copies    int    `json:"copies"`
+	Checkouts int    `json:"checkouts"`
+}
+
+type SeedResponse struct {
+	Data SeedResult `json:"data"`
+}
+
+// SeedConflict - A record appended by a seed that's already in the database.
+type SeedConflict struct {
+	Kind string
+	ID   string
+}
+
+func (c SeedConflict) Error() string {
+	return fmt.Sprintf("can't append, %s %s is already in the database", c.Kind, c.ID)
+}
+
+// DefaultSeedSet - Fixture set loaded unless another is named.
+const DefaultSeedSet = "default"
+
+// defaultSeedDir - Directory holding a directory of json fixtures per set.
+const defaultSeedDir = "seed_data"
+
+// seedSetName - What fixture set names look like, so they can't point outside the seed directory.
+var seedSetName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Common request errors
+var errorSeedSetName = errors.New("set must be a fixture set name of letters, digits, - and _")
+var errorSeedSetNotFound = errors.New("no fixture set with that name in the seed directory")
+
+// getRandomNumber - Generate random number for books copies.
+func getRandomNumber(min int, max int) int {
+	rand.Seed(time.Now().UnixNano())
+	return rand.Intn(max-min+1) + min
+}
+
+// SeedSets - Names of the fixture sets in a seed directory.
+func SeedSets(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var sets []string
+	for _, entry := range entries {
+		if entry.IsDir() && seedSetName.MatchString(entry.Name()) {
+			sets = append(sets, entry.Name())
+		}
+	}
+	sort.Strings(sets)
+
+	return sets, nil
+}
+
+// readSeedFile - Decode a json fixture file of a set into v, a missing file leaves v empty.
+func readSeedFile(setDir, name string, v interface{}) error {
+	file := filepath.Join(setDir, name)
+	byteSlice, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(byteSlice, v); err != nil {
+		return fmt.Errorf("error unmarshalling %s:: %s", file, err.Error())
+	}
+
+	return nil
+}
+
+// LoadSeedSet - Read a fixture set from the seed directory and build the records to insert. Books
+// name their author by id and get one copy each, checkouts name the copy they lend by its
+// position in the set, 1 being the first book's copy.
+func (s *Server) LoadSeedSet(set string) (db.SeedData, error) {
+	var data db.SeedData
+	if !seedSetName.MatchString(set) {
+		return data, errorSeedSetName
+	}
+	setDir := filepath.Join(s.SeedDir, set)
+	if info, err := os.Stat(setDir); err != nil || !info.IsDir() {
+		return data, errorSeedSetNotFound
+	}
+
+	var members []db.Member
+	var checkouts []db.Checkout
+	var authors []db.Author
+	var books []SeedBook
+	files := map[string]interface{}{
+		"members.json":   &members,
+		"checkouts.json": &checkouts,
+		"authors.json":   &authors,
+		"books.json":     &books,
+	}
+	for name, v := range files {
+		if err := readSeedFile(setDir, name, v); err != nil {
+			return data, err
+		}
+	}
+
+	now := time.Now()
+	base := db.Base{
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	// Members mock data builder
+	for _, member := range members {
+		newMember := db.Member{
+			ImageURL: member.ImageURL,
+			Category: db.DefaultMemberCategory,
+			Person: db.Person{
+				Base:      base,
+				ID:        member.ID,
+				FirstName: member.FirstName,
+				LastName:  member.LastName,
+				Middle:    member.Middle,
+			},
+		}
+
+		data.Members = append(data.Members, newMember)
+	}
+
+	// Checkouts mock data builder
+	for _, checkout := range checkouts {
+		hoursOut := getRandomNumber(24, 360)
+		hoursReturned := getRandomNumber(24, 360)
+		checkedOutTime := now.Add(time.Hour * time.Duration(-hoursOut))
+		returnedAtTime := now.Add(time.Hour * time.Duration(-hoursReturned))
+		dueAtTime := checkedOutTime.AddDate(0, 0, s.LoanPolicy.LoanDays)
+		newCheckout := db.Checkout{
+			Base:       base,
+			BookID:     checkout.BookID,
+			MemberID:   checkout.MemberID,
+			CheckedOut: checkedOutTime,
+			DueAt:      &dueAtTime,
+		}
+		if returnedAtTime.Unix() > checkedOutTime.Unix() {
+			newCheckout.Returned = &returnedAtTime
+		}
+
+		data.Checkouts = append(data.Checkouts, newCheckout)
+	}
+
+	// Authors/Books mock data builder
+	for _, author := range authors {
+		data.Authors = append(data.Authors, db.Author{
+			Person: db.Person{
+				Base:      base,
+				ID:        author.ID,
+				FirstName: author.FirstName,
+				LastName:  author.LastName,
+				Middle:    author.Middle,
+			},
+		})
+	}
+	for _, book := range books {
+		bookISBN, err := isbn.Parse(book.ISBN)
+		if err != nil {
+			return data, fmt.Errorf("seed book %s:: %s", book.ISBN, err.Error())
+		}
+
+		data.Books = append(data.Books, db.Book{
+			Base: base,
+			ISBN: bookISBN,
+			BaseBook: db.BaseBook{
+				Description: book.Description,
+				Title:       book.Title,
+				ImageURL:    book.ImageURL,
+			},
+		})
+		data.Copies = append(data.Copies, db.Copy{
+			ISBN:   bookISBN,
+			Status: db.CopyOnShelf,
+		})
+		for _, author := range data.Authors {
+			if book.Author == author.ID.String() {
+				data.BooksAuthors = append(data.BooksAuthors, db.BooksAuthors{
+					BookISBN: bookISBN,
+					AuthorID: author.ID,
+				})
+			}
+		}
+	}
+
+	return data, nil
+}
+
+// checkSeedConflicts - Make sure none of the books, authors or members about to be appended exist yet.
+func checkSeedConflicts(tx db.Store, data db.SeedData) error {
+	for _, book := range data.Books {
+		if _, err := tx.GetBook(book.ISBN); err != db.ErrNotFound {
+			if err != nil {
+				return err
+			}
+			return SeedConflict{Kind: "book", ID: book.ISBN}
+		}
+	}
+	for _, author := range data.Authors {
+		if _, err := tx.GetAuthor(author.ID); err != db.ErrNotFound {
+			if err != nil {
+				return err
+			}
+			return SeedConflict{Kind: "author", ID: author.ID.String()}
+		}
+	}
+	for _, member := range data.Members {
+		if _, err := tx.GetMember(member.ID); err != db.ErrNotFound {
+			if err != nil {
+				return err
+			}
+			return SeedConflict{Kind: "member", ID: member.ID.String()}
+		}
+	}
+
+	return nil
+}
+
+// handleSeedError - Respond with the status matching a seeding error.
+func handleSeedError(w http.ResponseWriter, err error) {
+	if _, ok := err.(SeedConflict); ok {
+		HandleErrorResponse(w, err, http.StatusConflict)
+		return
+	}
+
+	switch err {
+	case errorSeedSetName:
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+	case errorSeedSetNotFound:
+		HandleErrorResponse(w, err, http.StatusNotFound)
+	default:
+		responseErr := fmt.Errorf("something went wrong seeding database:: %s", err.Error())
+		HandleErrorResponse(w, responseErr, http.StatusInternalServerError)
+	}
+}
+
+// SeedDatabase - Load seed data in a single transaction, so a failed seed leaves the database as it
+// was. Catalog, members and circulation are wiped first unless appending, accounts, api keys,
+// webhooks and loan policies are kept.
+func (s *Server) SeedDatabase(store db.Store, data db.SeedData, appendData bool) error {
+	errTx := store.Transaction(func(tx db.Store) error {
+		if appendData {
+			if err := checkSeedConflicts(tx, data); err != nil {
+				return err
+			}
+		} else if err := tx.Wipe(); err != nil {
+			return err
+		}
+
+		if err := tx.Seed(data); err != nil {
+			return err
+		}
+
+		return s.assignMissingBarcodes(tx)
+	})
+	if errTx != nil {
+		return errTx
+	}
+
+	return s.RebuildSearchIndex()
+}
+
+// PostSeedDatabase - Load a fixture set from the seed directory, "default" unless named, wiping
+// the database first unless appending. Only routed in dev mode.
+func (s *Server) PostSeedDatabase(w http.ResponseWriter, r *http.Request) {
+	payload := PostSeedPayload{Set: DefaultSeedSet}
+	if r.ContentLength != 0 {
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&payload); err != nil {
+			HandleErrorResponse(w, err, http.StatusBadRequest)
+			return
+		}
+		if payload.Set == "" {
+			payload.Set = DefaultSeedSet
+		}
+	}
+
+	data, err := s.LoadSeedSet(payload.Set)
+	if err == nil {
+		err = s.SeedDatabase(s.storeFor(r), data, payload.Append)
+	}
+	if err != nil {
+		handleSeedError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(SeedResponse{
+		Data: SeedResult{
+			Set:       payload.Set,
+			Appended:  payload.Append,
+			Members:   len(data.Members),
+			Authors:   len(data.Authors),
+			Books:     len(data.Books),
+			Copies:    len(data.Copies),
+			Checkouts: len(data.Checkouts),
+		},
+	})
+}
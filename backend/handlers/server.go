@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"main/barcode"
+	"main/db"
+	"main/search"
+	"time"
+)
+
+// Server - Holds the dependencies shared by all http handlers.
+type Server struct {
+	Store db.Store
+
+	// LoanPolicy - Loan limits used when no stored policy matches a member category or book.
+	LoanPolicy db.LoanPolicy
+
+	// RenewalGraceDays - How many days past its due date a loan can still be renewed.
+	RenewalGraceDays int
+
+	// HoldPickupDays - How many days a copy set aside for a hold waits for pickup.
+	HoldPickupDays int
+
+	// Fines - Overdue and lost item charges, and the balance that blocks checkouts.
+	Fines FineSchedule
+
+	// Barcodes - Layout of the barcodes printed on copies and member cards.
+	Barcodes barcode.Format
+
+	// SearchIndex - Full-text index of the catalogue, kept up to date by the book and author handlers.
+	SearchIndex *search.Index
+
+	// SessionTTL - How long a login lasts before its token stops working.
+	SessionTTL time.Duration
+
+	// SeedDir - Directory of the fixture sets seeding loads, a directory of json files per set.
+	SeedDir string
+
+	// DevMode - Enables routes only meant for development, like seeding over http.
+	DevMode bool
+}
+
+// NewServer - Create a Server with handlers backed by the given store.
+func NewServer(store db.Store) *Server {
+	return &Server{
+		Store:          store,
+		LoanPolicy:     db.DefaultLoanPolicy,
+		HoldPickupDays: defaultHoldPickupDays,
+		Fines:          defaultFineSchedule,
+		Barcodes:       barcode.Default,
+		SearchIndex:    search.NewIndex(),
+		SessionTTL:     defaultSessionTTL,
+		SeedDir:        defaultSeedDir,
+	}
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/satori/go.uuid"
+	"main/db"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeStore - A Store kept in memory for handler tests. Only the methods a test sets data for
+// are implemented, calling any other panics on the nil embedded Store.
+type fakeStore struct {
+	db.Store
+	books    map[string]db.Book
+	policies []db.LoanPolicy
+}
+
+// GetBook - Retrieve a book from the fake's books.
+func (f *fakeStore) GetBook(isbn string) (db.Book, error) {
+	book, ok := f.books[isbn]
+	if !ok {
+		return db.Book{}, db.ErrNotFound
+	}
+
+	return book, nil
+}
+
+// ResolveLoanPolicy - Pick the first policy for exactly this member category and book, or fallback.
+func (f *fakeStore) ResolveLoanPolicy(category string, isbn string, fallback db.LoanPolicy) (db.LoanPolicy, error) {
+	for _, policy := range f.policies {
+		if policy.MemberCategory == category && policy.ISBN == isbn {
+			return policy, nil
+		}
+	}
+
+	return fallback, nil
+}
+
+// newTestServer - A server on a fresh in-memory SQLite store with every migration applied, for
+// tests that go all the way to the database. Close its Store when done.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	client, err := db.OpenSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.MigrateUp(client); err != nil {
+		client.Close()
+		t.Fatal(err)
+	}
+
+	return NewServer(db.NewGormStore(client))
+}
+
+// librarian - Staff member making the requests of most tests.
+var librarian = &Principal{Name: "librarian", Role: db.RoleLibrarian}
+
+// memberPrincipal - A member signed in to their own account.
+func memberPrincipal(member db.Member) *Principal {
+	return &Principal{Name: member.FirstName, Role: db.RoleMember, MemberID: &member.ID}
+}
+
+// request - Run a handler on a request with a json body and url vars, made by principal unless
+// it's nil, returning the recorded response.
+func request(handler http.HandlerFunc, method string, target string, vars map[string]string, body interface{}, principal *Principal) *httptest.ResponseRecorder {
+	var payload bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&payload).Encode(body)
+	}
+
+	r := httptest.NewRequest(method, target, &payload)
+	if principal != nil {
+		r = r.WithContext(context.WithValue(r.Context(), principalKey{}, principal))
+	}
+	w := httptest.NewRecorder()
+	handler(w, mux.SetURLVars(r, vars))
+	return w
+}
+
+// serve - Run a handler on a request with the given url vars, returning the recorded response.
+func serve(handler http.HandlerFunc, target string, vars map[string]string) *httptest.ResponseRecorder {
+	return request(handler, http.MethodGet, target, vars, nil, nil)
+}
+
+// decode - Read a json response into v, failing the test when it can't.
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body, err)
+	}
+}
+
+// addBook - Add a book with some copies on the shelf to a test server's store.
+func addBook(t *testing.T, s *Server, isbn string, copies int) []db.Copy {
+	t.Helper()
+	if err := s.Store.CreateBook(&db.Book{ISBN: isbn, BaseBook: db.BaseBook{Title: "Book " + isbn}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var created []db.Copy
+	for i := 0; i < copies; i++ {
+		bookCopy := db.Copy{ISBN: isbn, Status: db.CopyOnShelf}
+		if err := s.Store.CreateCopy(&bookCopy); err != nil {
+			t.Fatal(err)
+		}
+		created = append(created, bookCopy)
+	}
+
+	return created
+}
+
+// addMember - Add a member of a category to a test server's store.
+func addMember(t *testing.T, s *Server, name string, category string) db.Member {
+	t.Helper()
+	member := db.Member{Person: db.Person{ID: uuid.NewV4(), FirstName: name}, Category: category}
+	if err := s.Store.CreateMember(&member); err != nil {
+		t.Fatal(err)
+	}
+
+	return member
+}
+
+func TestGetBookByISBN(t *testing.T) {
+	s := NewServer(&fakeStore{books: map[string]db.Book{
+		"9781593275846": {ISBN: "9781593275846", BaseBook: db.BaseBook{Title: "Eloquent JavaScript"}},
+	}})
+
+	tests := []struct {
+		name   string
+		isbn   string
+		status int
+		title  string
+	}{
+		{"found", "9781593275846", http.StatusOK, "Eloquent JavaScript"},
+		{"isbn-10 with a wrong check digit", "1-59327-584-X", http.StatusBadRequest, ""},
+		{"found by hyphenated isbn-10", "1-59327-584-6", http.StatusOK, "Eloquent JavaScript"},
+		{"missing", "9780306406157", http.StatusOK, ""},
+		{"invalid", "12345", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(s.GetBookByISBN, "/books/"+tt.isbn, map[string]string{"isbn": tt.isbn})
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var res struct {
+				Data *db.Book `json:"data"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+			title := ""
+			if res.Data != nil {
+				title = res.Data.Title
+			}
+			if title != tt.title {
+				t.Errorf("title = %q, want %q", title, tt.title)
+			}
+		})
+	}
+}
+
+func TestGetResolvedLoanPolicy(t *testing.T) {
+	s := NewServer(&fakeStore{policies: []db.LoanPolicy{
+		{MemberCategory: "student", LoanDays: 28, MaxRenewals: 1, MaxItems: 10},
+	}})
+	s.LoanPolicy.LoanDays = 7
+
+	tests := []struct {
+		name     string
+		query    string
+		status   int
+		loanDays int
+	}{
+		{"stored policy", "?member_category=student", http.StatusOK, 28},
+		{"server default", "?member_category=staff", http.StatusOK, 7},
+		{"default category", "", http.StatusOK, 7},
+		{"invalid isbn", "?isbn=12345", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(s.GetResolvedLoanPolicy, "/policies/resolve"+tt.query, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var res LoanPolicyResponse
+			if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Data.LoanDays != tt.loanDays {
+				t.Errorf("loan_days = %d, want %d", res.Data.LoanDays, tt.loanDays)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lastEventIDHeader - Header EventSource clients send on reconnect, naming the last event they got.
+const lastEventIDHeader = "Last-Event-ID"
+
+// lastEventIDParam - Query param to resume a stream from, for the first connection of a client.
+const lastEventIDParam = "last_event_id"
+
+const (
+	// streamPollInterval - How often a stream checks the event log for new events.
+	streamPollInterval = time.Second
+
+	// streamBatchSize - Most events read from the log at once.
+	streamBatchSize = 100
+
+	// streamSettle - How old an event must be before it's sent. Event ids are handed out when
+	// events are written but become visible when their transaction commits, so a younger event
+	// can still be followed by a lower id. Waiting for events to settle keeps the stream from
+	// moving past ids that are about to appear, which a resuming client would never get.
+	streamSettle = 2 * time.Second
+
+	// streamWriteTimeout - How long a write to a stream may take before the client is given up on.
+	streamWriteTimeout = 15 * time.Second
+
+	// streamRetry - How long clients wait before reconnecting once a stream closes.
+	streamRetry = time.Second
+)
+
+// Common request errors
+var errorLastEventID = errors.New("last event id must be an event id")
+var errorStreaming = errors.New("streaming isn't supported by this connection")
+
+// connKey - Context key of the connection a request came in on.
+type connKey struct{}
+
+// ConnContext - Keep the connection of every request in its context, so streams can outlast the
+// server's write timeout. Set as the http.Server's ConnContext.
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, conn)
+}
+
+// extendWriteDeadline - Give the next write to a stream streamWriteTimeout, in place of the
+// server's write timeout counted from the start of the request.
+func extendWriteDeadline(r *http.Request) error {
+	conn, ok := r.Context().Value(connKey{}).(net.Conn)
+	if !ok {
+		return errorStreaming
+	}
+
+	return conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+}
+
+// streamStart - The event id a stream continues after: the Last-Event-ID header, then
+// ?last_event_id=, and otherwise the latest event so only new ones are sent.
+func (s *Server) streamStart(r *http.Request) (uint, error) {
+	value := r.Header.Get(lastEventIDHeader)
+	if value == "" {
+		value = r.URL.Query().Get(lastEventIDParam)
+	}
+	if value == "" {
+		return s.Store.GetLastEventID()
+	}
+
+	id, err := strconv.ParseUint(strings.TrimSpace(value), 10, 32)
+	if err != nil {
+		return 0, errorLastEventID
+	}
+
+	return uint(id), nil
+}
+
+// GetEventStream - Push events to the client as they're recorded, as server-sent events named
+// after the entity they're about (book, copy, checkout, member...). Takes the same filters as
+// event lists, e.g. ?entity_type=checkout,member or ?isbn=, and resumes after Last-Event-ID.
+func (s *Server) GetEventStream(w http.ResponseWriter, r *http.Request) {
+	listQuery, err := parseListQuery(r, lastEventIDParam)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	lastID, err := s.streamStart(r)
+	if err == errorLastEventID {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Check the filters before committing to a stream.
+	if _, err := s.Store.GetEventsAfter(lastID, listQuery.Filters, 1); err != nil {
+		handleListError(w, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok || extendWriteDeadline(r) != nil {
+		HandleErrorResponse(w, errorStreaming, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry.Milliseconds())
+	flusher.Flush()
+
+	ticker := time.NewTicker(streamPollInterval)
+	defer ticker.Stop()
+
+	for {
+		events, err := s.Store.GetEventsAfter(lastID, listQuery.Filters, streamBatchSize)
+		if err != nil {
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", err.Error())
+			flusher.Flush()
+			return
+		}
+		if err := extendWriteDeadline(r); err != nil {
+			return
+		}
+
+		// Stop at the first event that hasn't settled, it's sent once it has.
+		settled := time.Now().Add(-streamSettle)
+		sent := 0
+		for _, event := range events {
+			if event.CreatedAt.After(settled) {
+				break
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.EntityType, data)
+			lastID = event.ID
+			sent++
+		}
+		if sent == 0 {
+			// Keeps proxies from closing an idle connection.
+			fmt.Fprint(w, ": ping\n\n")
+		}
+		flusher.Flush()
+
+		// Read the next batch straight away when there's a backlog to catch up on.
+		if sent == streamBatchSize && r.Context().Err() == nil {
+			continue
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
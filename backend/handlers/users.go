@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/satori/go.uuid"
+	"golang.org/x/crypto/bcrypt"
+	"main/db"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type UsersResponse struct {
+	Data []db.User `json:"data"`
+}
+
+type UserResponse struct {
+	Data db.User `json:"data"`
+}
+
+type PostUserPayload struct {
+	Username string     `json:"username"`
+	Password string     `json:"password"`
+	Role     db.Role    `json:"role"`
+	MemberID *uuid.UUID `json:"member_id"`
+}
+
+type PatchUserPayload struct {
+	Password string     `json:"password"`
+	Role     db.Role    `json:"role"`
+	MemberID *uuid.UUID `json:"member_id"`
+}
+
+// minPasswordLength - Fewest characters a password can have.
+const minPasswordLength = 8
+
+// missingUserHash - Compared against at logins with unknown usernames, so they take as long as known ones.
+var missingUserHash, _ = bcrypt.GenerateFromPassword([]byte("missing user"), bcrypt.DefaultCost)
+
+// Common request errors
+var errorUserID = errors.New("user id missing or invalid in request")
+var errorUsername = errors.New("username can't be empty")
+var errorUsernameTaken = errors.New("username is already taken")
+var errorPassword = fmt.Errorf("password must be at least %d characters", minPasswordLength)
+var errorRole = errors.New("role must be admin, librarian or member")
+var errorUserMember = errors.New("member accounts need the member_id of an existing member, staff accounts none")
+var errorDeleteSelf = errors.New("you can't delete your own account")
+
+// queryUserWithParamID - Build gorm user query with id from url params.
+func queryUserWithParamID(r *http.Request) (*db.User, error) {
+	id, ok := parseID(mux.Vars(r)["id"])
+	if !ok {
+		return nil, errorUserID
+	}
+
+	query := &db.User{ID: id}
+	return query, nil
+}
+
+// hashPassword - The bcrypt hash stored of a password, checking it's long enough.
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", errorPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// checkUserRole - Make sure a role is known, and member accounts, and only they, belong to a member.
+func checkUserRole(store db.Store, role db.Role, memberID *uuid.UUID) error {
+	if !db.IsRole(role) {
+		return errorRole
+	}
+	if (role == db.RoleMember) != (memberID != nil) {
+		return errorUserMember
+	}
+	if memberID == nil {
+		return nil
+	}
+
+	_, err := store.GetMember(*memberID)
+	if err == db.ErrNotFound {
+		return errorUserMember
+	}
+
+	return err
+}
+
+// handleUserError - Respond with the status matching a user lookup/update error.
+func handleUserError(w http.ResponseWriter, err error) {
+	switch err {
+	case db.ErrNotFound:
+		HandleErrorResponse(w, err, http.StatusNotFound)
+	case errorUsername, errorPassword, errorRole, errorUserMember:
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+	case errorUsernameTaken, errorDeleteSelf:
+		HandleErrorResponse(w, err, http.StatusConflict)
+	default:
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+	}
+}
+
+// CreateUser - Add an account that can sign in, recording it in the event log. Shared by the
+// users endpoint and the `users add` admin command.
+func CreateUser(store db.Store, payload PostUserPayload) (db.User, error) {
+	username := strings.TrimSpace(payload.Username)
+	if username == "" {
+		return db.User{}, errorUsername
+	}
+	if err := checkUserRole(store, payload.Role, payload.MemberID); err != nil {
+		return db.User{}, err
+	}
+	hash, err := hashPassword(payload.Password)
+	if err != nil {
+		return db.User{}, err
+	}
+
+	now := time.Now()
+	user := db.User{
+		Base: db.Base{
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		Username:     username,
+		PasswordHash: hash,
+		Role:         payload.Role,
+		MemberID:     payload.MemberID,
+	}
+	errTx := store.Transaction(func(tx db.Store) error {
+		_, err := tx.GetUserByUsername(username)
+		if err == nil {
+			return errorUsernameTaken
+		}
+		if err != db.ErrNotFound {
+			return err
+		}
+		if err := tx.CreateUser(&user); err != nil {
+			return err
+		}
+
+		return recordChange(tx, db.EntityUser, user.ID, db.CREATE, nil, user)
+	})
+
+	return user, errTx
+}
+
+// GetAllUsers - Retrieve all accounts.
+func (s *Server) GetAllUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := s.Store.GetAllUsers()
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(UsersResponse{
+		Data: users,
+	})
+}
+
+// GetUserByID - Retrieve a single account.
+func (s *Server) GetUserByID(w http.ResponseWriter, r *http.Request) {
+	query, err := queryUserWithParamID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.Store.GetUser(query.ID)
+	if err == db.ErrNotFound {
+		json.NewEncoder(w).Encode(&EmptyItemResponse{})
+		return
+	}
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(UserResponse{
+		Data: user,
+	})
+}
+
+// PostNewUser - Add an account with a username, password and role, member accounts name their member.
+func (s *Server) PostNewUser(w http.ResponseWriter, r *http.Request) {
+	var payload PostUserPayload
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := CreateUser(s.storeFor(r), payload)
+	if err != nil {
+		handleUserError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(UserResponse{
+		Data: user,
+	})
+}
+
+// PatchUpdateUser - Change an account's password, role or member. A new password or role signs
+// the account out everywhere.
+func (s *Server) PatchUpdateUser(w http.ResponseWriter, r *http.Request) {
+	query, err := queryUserWithParamID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var payload PatchUserPayload
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	currentUser, err := s.Store.GetUser(query.ID)
+	if err != nil {
+		handleUserError(w, err)
+		return
+	}
+
+	// Update only what's supplied
+	updates := map[string]interface{}{}
+	role, memberID := currentUser.Role, currentUser.MemberID
+	if payload.Role != "" {
+		role = payload.Role
+		updates["role"] = role
+		if role != db.RoleMember {
+			memberID = nil
+			updates["member_id"] = nil
+		}
+	}
+	if payload.MemberID != nil {
+		memberID = payload.MemberID
+		updates["member_id"] = *memberID
+	}
+	if err := checkUserRole(s.Store, role, memberID); err != nil {
+		handleUserError(w, err)
+		return
+	}
+	if payload.Password != "" {
+		hash, err := hashPassword(payload.Password)
+		if err != nil {
+			handleUserError(w, err)
+			return
+		}
+		updates["password_hash"] = hash
+	}
+
+	var updatedUser db.User
+	updates["updated_at"] = time.Now()
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		if err := tx.UpdateUser(query.ID, updates); err != nil {
+			return err
+		}
+		if payload.Password != "" || role != currentUser.Role {
+			if err := tx.DeleteUserSessions(query.ID, nil); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		if updatedUser, err = tx.GetUser(query.ID); err != nil {
+			return err
+		}
+
+		return recordChange(tx, db.EntityUser, query.ID, db.UPDATE, currentUser, updatedUser)
+	})
+	if errTx != nil {
+		HandleErrorResponse(w, errTx, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(UserResponse{
+		Data: updatedUser,
+	})
+}
+
+// DeleteUserByID - Remove an account and sign it out everywhere.
+func (s *Server) DeleteUserByID(w http.ResponseWriter, r *http.Request) {
+	query, err := queryUserWithParamID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	if query.ID == principalOf(r).UserID {
+		handleUserError(w, errorDeleteSelf)
+		return
+	}
+
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		user, err := tx.GetUser(query.ID)
+		if err == db.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.DeleteUserSessions(query.ID, nil); err != nil {
+			return err
+		}
+		if err := tx.DeleteUser(query.ID); err != nil {
+			return err
+		}
+
+		return recordChange(tx, db.EntityUser, query.ID, db.DELETE, user, nil)
+	})
+	if errTx != nil {
+		HandleErrorResponse(w, errTx, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,428 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"main/db"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type WebhooksResponse struct {
+	Data []db.Webhook `json:"data"`
+}
+
+type WebhookResponse struct {
+	Data db.Webhook `json:"data"`
+}
+
+// WebhookWithSecret - A webhook along with its secret, only sent back when the secret is set.
+type WebhookWithSecret struct {
+	db.Webhook
+	Secret string `json:"secret"`
+}
+
+type WebhookSecretResponse struct {
+	Data WebhookWithSecret `json:"data"`
+}
+
+type WebhookDeliveryListResponse struct {
+	Data []db.WebhookDelivery `json:"data"`
+	ListPage
+}
+
+type WebhookDeliveryResponse struct {
+	Data db.WebhookDelivery `json:"data"`
+}
+
+type PostWebhookPayload struct {
+	URL    string   `json:"url"`
+	Topics []string `json:"topics"`
+	Secret string   `json:"secret"`
+	Active *bool    `json:"active"`
+}
+
+type PatchWebhookPayload struct {
+	URL          string   `json:"url"`
+	Topics       []string `json:"topics"`
+	Active       *bool    `json:"active"`
+	RotateSecret bool     `json:"rotate_secret"`
+}
+
+// Common request errors
+var errorWebhookID = errors.New("webhook id missing or invalid in request")
+var errorWebhookURL = errors.New("url must be an absolute http or https url")
+var errorWebhookTopics = errors.New("topics must list entity.action topics, e.g. book.create, checkout.* or *")
+var errorDeliveryID = errors.New("delivery id missing or invalid in request")
+var errorDeliveryNotFailed = errors.New("only failed deliveries can be retried")
+
+// queryWebhookWithParamID - Build gorm webhook query with id from url params.
+func queryWebhookWithParamID(r *http.Request) (*db.Webhook, error) {
+	id, ok := parseID(mux.Vars(r)["id"])
+	if !ok {
+		return nil, errorWebhookID
+	}
+
+	return &db.Webhook{ID: id}, nil
+}
+
+// queryDeliveryWithParamID - Build gorm webhook delivery query with id from url params.
+func queryDeliveryWithParamID(r *http.Request) (*db.WebhookDelivery, error) {
+	id, ok := parseID(mux.Vars(r)["id"])
+	if !ok {
+		return nil, errorDeliveryID
+	}
+
+	return &db.WebhookDelivery{ID: id}, nil
+}
+
+// checkWebhookURL - Make sure events can be posted to a url.
+func checkWebhookURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errorWebhookURL
+	}
+
+	return nil
+}
+
+// parseWebhookTopics - Clean up and check the topics a webhook subscribes to.
+func parseWebhookTopics(topics []string) (db.TopicList, error) {
+	parsed := db.TopicList{}
+	for _, topic := range topics {
+		topic = strings.ToLower(strings.TrimSpace(topic))
+		if !db.IsTopic(topic) {
+			return nil, errorWebhookTopics
+		}
+		parsed = append(parsed, topic)
+	}
+	if len(parsed) == 0 {
+		return nil, errorWebhookTopics
+	}
+
+	return parsed, nil
+}
+
+// newWebhookSecret - A random key to sign a webhook's payloads with.
+func newWebhookSecret() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(key), nil
+}
+
+// handleWebhookError - Respond with the status matching a webhook lookup/update error.
+func handleWebhookError(w http.ResponseWriter, err error) {
+	switch err {
+	case db.ErrNotFound:
+		HandleErrorResponse(w, err, http.StatusNotFound)
+	case errorWebhookURL, errorWebhookTopics:
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+	case errorDeliveryNotFailed:
+		HandleErrorResponse(w, err, http.StatusConflict)
+	default:
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+	}
+}
+
+// GetAllWebhooks - Retrieve all webhook subscriptions.
+func (s *Server) GetAllWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := s.Store.GetAllWebhooks()
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(WebhooksResponse{
+		Data: webhooks,
+	})
+}
+
+// GetWebhookByID - Retrieve a single webhook subscription.
+func (s *Server) GetWebhookByID(w http.ResponseWriter, r *http.Request) {
+	query, err := queryWebhookWithParamID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := s.Store.GetWebhook(query.ID)
+	if err == db.ErrNotFound {
+		json.NewEncoder(w).Encode(&EmptyItemResponse{})
+		return
+	}
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(WebhookResponse{
+		Data: webhook,
+	})
+}
+
+// PostNewWebhook - Subscribe a url to event topics. The secret payloads are signed with is
+// generated unless given, and only sent back in this response.
+func (s *Server) PostNewWebhook(w http.ResponseWriter, r *http.Request) {
+	var payload PostWebhookPayload
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := checkWebhookURL(payload.URL); err != nil {
+		handleWebhookError(w, err)
+		return
+	}
+	topics, err := parseWebhookTopics(payload.Topics)
+	if err != nil {
+		handleWebhookError(w, err)
+		return
+	}
+	if payload.Secret == "" {
+		if payload.Secret, err = newWebhookSecret(); err != nil {
+			HandleErrorResponse(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	now := time.Now()
+	webhook := db.Webhook{
+		Base: db.Base{
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		URL:    payload.URL,
+		Topics: topics,
+		Active: payload.Active == nil || *payload.Active,
+		Secret: payload.Secret,
+	}
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		if err := tx.CreateWebhook(&webhook); err != nil {
+			return err
+		}
+
+		return recordChange(tx, db.EntityWebhook, webhook.ID, db.CREATE, nil, webhook)
+	})
+	if errTx != nil {
+		HandleErrorResponse(w, errTx, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(WebhookSecretResponse{
+		Data: WebhookWithSecret{Webhook: webhook, Secret: webhook.Secret},
+	})
+}
+
+// PatchUpdateWebhook - Change a webhook's url, topics or whether it's active, and optionally
+// replace its secret, which is then sent back.
+func (s *Server) PatchUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	query, err := queryWebhookWithParamID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var payload PatchWebhookPayload
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	currentWebhook, errCurrent := s.Store.GetWebhook(query.ID)
+	if errCurrent == db.ErrNotFound {
+		msg := fmt.Sprintf("no webhook with id %d found", query.ID)
+		HandleErrorResponse(w, errors.New(msg), http.StatusNotFound)
+		return
+	}
+	if errCurrent != nil {
+		HandleErrorResponse(w, errCurrent, http.StatusInternalServerError)
+		return
+	}
+
+	// Update only what's supplied
+	updates := map[string]interface{}{}
+	if payload.URL != "" {
+		if err := checkWebhookURL(payload.URL); err != nil {
+			handleWebhookError(w, err)
+			return
+		}
+		updates["url"] = payload.URL
+	}
+	if payload.Topics != nil {
+		topics, err := parseWebhookTopics(payload.Topics)
+		if err != nil {
+			handleWebhookError(w, err)
+			return
+		}
+		updates["topics"] = topics
+	}
+	if payload.Active != nil {
+		updates["active"] = *payload.Active
+	}
+	if payload.RotateSecret {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			HandleErrorResponse(w, err, http.StatusInternalServerError)
+			return
+		}
+		updates["secret"] = secret
+	}
+
+	var updatedWebhook db.Webhook
+	updates["updated_at"] = time.Now()
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		if err := tx.UpdateWebhook(query.ID, updates); err != nil {
+			return err
+		}
+
+		var err error
+		if updatedWebhook, err = tx.GetWebhook(query.ID); err != nil {
+			return err
+		}
+
+		return recordChange(tx, db.EntityWebhook, query.ID, db.UPDATE, currentWebhook, updatedWebhook)
+	})
+	if errTx != nil {
+		HandleErrorResponse(w, errTx, http.StatusInternalServerError)
+		return
+	}
+
+	if payload.RotateSecret {
+		json.NewEncoder(w).Encode(WebhookSecretResponse{
+			Data: WebhookWithSecret{Webhook: updatedWebhook, Secret: updatedWebhook.Secret},
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(WebhookResponse{
+		Data: updatedWebhook,
+	})
+}
+
+// DeleteWebhookByID - Unsubscribe a webhook, deliveries still queued for it fail.
+func (s *Server) DeleteWebhookByID(w http.ResponseWriter, r *http.Request) {
+	query, err := queryWebhookWithParamID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	errTx := s.storeFor(r).Transaction(func(tx db.Store) error {
+		webhook, err := tx.GetWebhook(query.ID)
+		if err == db.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.DeleteWebhook(query.ID); err != nil {
+			return err
+		}
+
+		return recordChange(tx, db.EntityWebhook, query.ID, db.DELETE, webhook, nil)
+	})
+	if errTx != nil {
+		HandleErrorResponse(w, errTx, http.StatusInternalServerError)
+	}
+}
+
+// GetWebhookDeliveries - Retrieve a page of a webhook's delivery log, filtered by ?status=,
+// ?topic= and ?event_id=.
+func (s *Server) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	query, err := queryWebhookWithParamID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	listQuery, err := parseListQuery(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	deliveries, page, err := s.Store.ListWebhookDeliveries(query.ID, listQuery)
+	if err != nil {
+		handleListError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(WebhookDeliveryListResponse{
+		Data:     deliveries,
+		ListPage: listPage(r, page, len(deliveries)),
+	})
+}
+
+// GetWebhookDeliveryByID - Retrieve a delivery with every attempt at sending it.
+func (s *Server) GetWebhookDeliveryByID(w http.ResponseWriter, r *http.Request) {
+	query, err := queryDeliveryWithParamID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	delivery, err := s.Store.GetWebhookDelivery(query.ID)
+	if err == db.ErrNotFound {
+		json.NewEncoder(w).Encode(&EmptyItemResponse{})
+		return
+	}
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(WebhookDeliveryResponse{
+		Data: delivery,
+	})
+}
+
+// PatchRetryWebhookDelivery - Queue a failed delivery to be sent again, with a fresh set of attempts.
+func (s *Server) PatchRetryWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	query, err := queryDeliveryWithParamID(r)
+	if err != nil {
+		HandleErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var delivery db.WebhookDelivery
+	errTx := s.Store.Transaction(func(tx db.Store) error {
+		current, err := tx.GetWebhookDelivery(query.ID)
+		if err != nil {
+			return err
+		}
+		if current.Status != db.DeliveryFailed {
+			return errorDeliveryNotFailed
+		}
+
+		now := time.Now()
+		err = tx.UpdateWebhookDelivery(query.ID, map[string]interface{}{
+			"status":          db.DeliveryPending,
+			"attempts":        0,
+			"next_attempt_at": now,
+			"updated_at":      now,
+		})
+		if err != nil {
+			return err
+		}
+
+		delivery, err = tx.GetWebhookDelivery(query.ID)
+		return err
+	})
+	if errTx != nil {
+		handleWebhookError(w, errTx)
+		return
+	}
+
+	json.NewEncoder(w).Encode(WebhookDeliveryResponse{
+		Data: delivery,
+	})
+}
@@ -0,0 +1,96 @@
+package isbn
+
+import (
+	"strconv"
+	"strings"
+)
+
+// span - A range of 7 digit prefixes whose leading Length digits form an identifier.
+type span struct {
+	From   int
+	To     int
+	Length int
+}
+
+// groups - Registration group lengths per EAN prefix, from the ISBN range message.
+var groups = map[string][]span{
+	"978": {
+		{0, 5999999, 1},
+		{6000000, 6499999, 3},
+		{6500000, 6599999, 2},
+		{6600000, 6999999, 0},
+		{7000000, 7999999, 1},
+		{8000000, 9499999, 2},
+		{9500000, 9899999, 3},
+		{9900000, 9989999, 4},
+		{9990000, 9999999, 5},
+	},
+	"979": {
+		{0, 999999, 0},
+		{1000000, 1299999, 2},
+		{1300000, 7999999, 0},
+		{8000000, 8999999, 1},
+		{9000000, 9999999, 0},
+	},
+}
+
+// registrants - Registrant (publisher) lengths of the English language groups,
+// which cover most of the catalogue. Other groups are split after the group only.
+var registrants = map[string][]span{
+	"978-0": {
+		{0, 1999999, 2},
+		{2000000, 6999999, 3},
+		{7000000, 8499999, 4},
+		{8500000, 8999999, 5},
+		{9000000, 9499999, 6},
+		{9500000, 9999999, 7},
+	},
+	"978-1": {
+		{0, 999999, 2},
+		{1000000, 3999999, 3},
+		{4000000, 5499999, 4},
+		{5500000, 8697999, 5},
+		{8698000, 9989999, 6},
+		{9990000, 9999999, 7},
+	},
+}
+
+// lookup - Length of the identifier at the start of digits, 0 if no range covers it.
+func lookup(spans []span, digits string) int {
+	padded := (digits + "0000000")[:7]
+	n, err := strconv.Atoi(padded)
+	if err != nil {
+		return 0
+	}
+	for _, s := range spans {
+		if n >= s.From && n <= s.To {
+			return s.Length
+		}
+	}
+
+	return 0
+}
+
+// Format - Hyphenate an ISBN as prefix-group-registrant-publication-check, e.g.
+// 978-1-59327-584-6. Groups without known registrant ranges are split as
+// prefix-group-rest-check, and anything that isn't a valid ISBN is returned as is.
+func Format(s string) string {
+	digits, err := Parse(s)
+	if err != nil {
+		return s
+	}
+
+	prefix, body, check := digits[:3], digits[3:12], digits[12:]
+	groupLength := lookup(groups[prefix], body)
+	if groupLength == 0 {
+		return strings.Join([]string{prefix, body, check}, "-")
+	}
+	group, rest := body[:groupLength], body[groupLength:]
+
+	registrantLength := lookup(registrants[prefix+"-"+group], rest)
+	if registrantLength == 0 || registrantLength >= len(rest) {
+		return strings.Join([]string{prefix, group, rest, check}, "-")
+	}
+
+	return strings.Join([]string{prefix, group, rest[:registrantLength], rest[registrantLength:], check}, "-")
+}
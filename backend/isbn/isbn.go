@@ -0,0 +1,138 @@
+// Package isbn parses and validates ISBN-10s and ISBN-13s, converts them to the
+// 13 digit form books are stored under and formats them with hyphens.
+package isbn
+
+import (
+	"errors"
+	"strings"
+)
+
+// Common isbn errors
+var ErrFormat = errors.New("isbn must be 10 or 13 digits, hyphens and spaces allowed")
+var ErrPrefix = errors.New("isbn-13 must start with 978 or 979")
+var ErrCheckDigit = errors.New("isbn check digit doesn't match")
+
+// Parse - Validate an ISBN-10 or ISBN-13 and return it as 13 digits without hyphens.
+func Parse(s string) (string, error) {
+	digits := clean(s)
+	switch len(digits) {
+	case 10:
+		return fromISBN10(digits)
+	case 13:
+		if err := validate13(digits); err != nil {
+			return "", err
+		}
+		return digits, nil
+	default:
+		return "", ErrFormat
+	}
+}
+
+// Valid - Check if s is a valid ISBN-10 or ISBN-13.
+func Valid(s string) bool {
+	_, err := Parse(s)
+	return err == nil
+}
+
+// ToISBN13 - Convert an ISBN-10 to its ISBN-13, under the 978 prefix.
+func ToISBN13(isbn10 string) (string, error) {
+	digits := clean(isbn10)
+	if len(digits) != 10 {
+		return "", ErrFormat
+	}
+
+	return fromISBN10(digits)
+}
+
+// Complete - Append the check digit to the first 12 digits of an ISBN-13.
+func Complete(body string) (string, error) {
+	if len(body) != 12 || !isDigits(body) {
+		return "", ErrFormat
+	}
+	if !strings.HasPrefix(body, "978") && !strings.HasPrefix(body, "979") {
+		return "", ErrPrefix
+	}
+
+	return body + string(checkDigit13(body)), nil
+}
+
+// clean - Drop hyphens and spaces, upper casing the X an ISBN-10 may end in.
+func clean(s string) string {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	return strings.NewReplacer("-", "", " ", "").Replace(s)
+}
+
+// fromISBN10 - Validate a cleaned ISBN-10 and convert it.
+func fromISBN10(digits string) (string, error) {
+	if !isDigits(digits[:9]) {
+		return "", ErrFormat
+	}
+	last := digits[9]
+	if last != 'X' && !isDigits(digits[9:]) {
+		return "", ErrFormat
+	}
+	if checkDigit10(digits[:9]) != last {
+		return "", ErrCheckDigit
+	}
+
+	body := "978" + digits[:9]
+	return body + string(checkDigit13(body)), nil
+}
+
+// validate13 - Check the prefix and check digit of a cleaned ISBN-13.
+func validate13(digits string) error {
+	if !isDigits(digits) {
+		return ErrFormat
+	}
+	if !strings.HasPrefix(digits, "978") && !strings.HasPrefix(digits, "979") {
+		return ErrPrefix
+	}
+	if checkDigit13(digits[:12]) != digits[12] {
+		return ErrCheckDigit
+	}
+
+	return nil
+}
+
+// checkDigit10 - Mod 11 check digit of the first 9 digits of an ISBN-10, X standing for 10.
+func checkDigit10(body string) byte {
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(body[i]-'0') * (10 - i)
+	}
+
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return 'X'
+	}
+
+	return byte('0' + check)
+}
+
+// checkDigit13 - Mod 10 check digit of the first 12 digits of an ISBN-13, weighted 1 and 3.
+func checkDigit13(body string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		d := int(body[i] - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+
+	return byte('0' + (10-sum%10)%10)
+}
+
+// isDigits - Check a string is non empty and only holds 0-9.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,107 @@
+package isbn
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+		err  error
+	}{
+		{"isbn-13", "9781593275846", "9781593275846", nil},
+		{"hyphenated isbn-13", "978-1-59327-584-6", "9781593275846", nil},
+		{"spaced isbn-13", " 978 1 59327 584 6 ", "9781593275846", nil},
+		{"979 prefix", "979-10-90636-07-1", "9791090636071", nil},
+		{"isbn-10", "1593275846", "9781593275846", nil},
+		{"hyphenated isbn-10", "0-306-40615-2", "9780306406157", nil},
+		{"isbn-10 ending in X", "080442957X", "9780804429573", nil},
+		{"isbn-10 ending in lower case x", "080442957x", "9780804429573", nil},
+		{"isbn-13 check digit", "9781593275847", "", ErrCheckDigit},
+		{"isbn-10 check digit", "0306406153", "", ErrCheckDigit},
+		{"unknown prefix", "9771593275846", "", ErrPrefix},
+		{"too short", "12345", "", ErrFormat},
+		{"too long", "97815932758460", "", ErrFormat},
+		{"letters", "97815932758A6", "", ErrFormat},
+		{"X inside an isbn-10", "08044X9573", "", ErrFormat},
+		{"empty", "", "", ErrFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.in)
+			if err != tt.err {
+				t.Fatalf("Parse(%q) error = %v, want %v", tt.in, err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("Parse(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToISBN13(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  error
+	}{
+		{"1593275846", "9781593275846", nil},
+		{"0-8044-2957-X", "9780804429573", nil},
+		{"9781593275846", "", ErrFormat},
+		{"1593275845", "", ErrCheckDigit},
+	}
+
+	for _, tt := range tests {
+		got, err := ToISBN13(tt.in)
+		if err != tt.err || got != tt.want {
+			t.Errorf("ToISBN13(%q) = %q, %v, want %q, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  error
+	}{
+		{"978159327584", "9781593275846", nil},
+		{"979109063607", "9791090636071", nil},
+		{"978000000000", "9780000000002", nil},
+		{"97815932758", "", ErrFormat},
+		{"9781593275846", "", ErrFormat},
+		{"97815932758A", "", ErrFormat},
+		{"977159327584", "", ErrPrefix},
+	}
+
+	for _, tt := range tests {
+		got, err := Complete(tt.in)
+		if err != tt.err || got != tt.want {
+			t.Errorf("Complete(%q) = %q, %v, want %q, %v", tt.in, got, err, tt.want, tt.err)
+		}
+		if err == nil && !Valid(got) {
+			t.Errorf("Complete(%q) = %q, which isn't valid", tt.in, got)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"9781593275846", "978-1-59327-584-6"},
+		{"1593275846", "978-1-59327-584-6"},
+		{"9780306406157", "978-0-306-40615-7"},
+		{"9791090636071", "979-10-9063607-1"},
+		{"not an isbn", "not an isbn"},
+		{"9781593275847", "9781593275847"},
+	}
+
+	for _, tt := range tests {
+		if got := Format(tt.in); got != tt.want {
+			t.Errorf("Format(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -2,13 +2,17 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
 	"log"
 	"main/db"
 	"main/handlers"
+	"main/webhook"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"time"
 )
 
@@ -21,108 +25,504 @@ func RouteLogger(next http.Handler) http.Handler {
 }
 
 // registerRoutes - Register all endpoint routes.
-func registerRoutes() *mux.Router {
+func registerRoutes(s *handlers.Server) *mux.Router {
 
 	// Base router/routes
 	router := mux.NewRouter()
 
 	// Register middlewares
 	router.Use(RouteLogger)
+	router.Use(s.Authenticate)
+
+	// Who may call each route: users by role, api keys by scope. Members are further limited
+	// to their own records by the handlers.
+	signedIn := handlers.Allow("", handlers.AllRoles...)
+	catalogRead := handlers.Allow(db.ScopeCatalogRead, handlers.AllRoles...)
+	catalogWrite := handlers.Allow(db.ScopeCatalogWrite, handlers.StaffRoles...)
+	circulationRead := handlers.Allow(db.ScopeCirculationRead, handlers.StaffRoles...)
+	circulationWrite := handlers.Allow(db.ScopeCirculationWrite, handlers.StaffRoles...)
+	ownCirculationRead := handlers.Allow(db.ScopeCirculationRead, handlers.AllRoles...)
+	ownCirculationWrite := handlers.Allow(db.ScopeCirculationWrite, handlers.AllRoles...)
+	membersRead := handlers.Allow(db.ScopeMembersRead, handlers.StaffRoles...)
+	ownMembersRead := handlers.Allow(db.ScopeMembersRead, handlers.AllRoles...)
+	membersWrite := handlers.Allow(db.ScopeMembersWrite, handlers.StaffRoles...)
+	eventsRead := handlers.Allow(db.ScopeEventsRead, handlers.StaffRoles...)
+	admin := handlers.Allow(db.ScopeAdmin, db.RoleAdmin)
 
 	// Util handlers
 	router.
 		HandleFunc("/health", handlers.GetHealthCheckHandler).
 		Methods("GET")
+	if s.DevMode {
+		router.
+			HandleFunc("/seed", admin(s.PostSeedDatabase)).
+			Methods("POST")
+	}
+
+	// Sign in
+	router.
+		HandleFunc("/auth/login", s.PostLogin).
+		Methods("POST")
+	router.
+		HandleFunc("/auth/logout", signedIn(s.PostLogout)).
+		Methods("POST")
+	router.
+		HandleFunc("/auth/me", signedIn(s.GetCurrentUser)).
+		Methods("GET")
+
+	// Users
+	router.
+		HandleFunc("/users", admin(s.PostNewUser)).
+		Methods("POST")
+	router.
+		HandleFunc("/users", admin(s.GetAllUsers)).
+		Methods("GET")
+	router.
+		HandleFunc("/users/{id}", admin(s.GetUserByID)).
+		Methods("GET")
+	router.
+		HandleFunc("/users/{id}", admin(s.PatchUpdateUser)).
+		Methods("PATCH")
+	router.
+		HandleFunc("/users/{id}", admin(s.DeleteUserByID)).
+		Methods("DELETE")
+
+	// API keys
+	router.
+		HandleFunc("/api-keys", admin(s.PostNewAPIKey)).
+		Methods("POST")
+	router.
+		HandleFunc("/api-keys", admin(s.GetAllAPIKeys)).
+		Methods("GET")
 	router.
-		HandleFunc("/seed", handlers.GetSeedDatabase).
+		HandleFunc("/api-keys/{id}", admin(s.GetAPIKeyByID)).
 		Methods("GET")
+	router.
+		HandleFunc("/api-keys/{id}", admin(s.DeleteAPIKeyByID)).
+		Methods("DELETE")
 
 	// Authors
 	router.
-		HandleFunc("/authors", handlers.PostNewAuthor).
+		HandleFunc("/authors", catalogWrite(s.PostNewAuthor)).
 		Methods("POST")
 	router.
-		HandleFunc("/authors", handlers.GetAllAuthors).
+		HandleFunc("/authors", catalogRead(s.GetAllAuthors)).
 		Methods("GET")
 	router.
-		HandleFunc("/authors/{id}", handlers.GetAuthorByID).
+		HandleFunc("/authors/{id}", catalogRead(s.GetAuthorByID)).
 		Methods("GET")
 	router.
-		HandleFunc("/authors/{id}/books", handlers.GetAuthorBooks).
+		HandleFunc("/authors/{id}/books", catalogRead(s.GetAuthorBooks)).
 		Methods("GET")
 	router.
-		HandleFunc("/authors/{id}", handlers.PatchUpdateAuthor).
+		HandleFunc("/authors/{id}", catalogWrite(s.PatchUpdateAuthor)).
 		Methods("PATCH")
 	router.
-		HandleFunc("/authors/{id}", handlers.DeleteAuthorByID).
+		HandleFunc("/authors/{id}", catalogWrite(s.DeleteAuthorByID)).
 		Methods("DELETE")
 
 	// Books
 	router.
-		HandleFunc("/books", handlers.PostNewBook).
+		HandleFunc("/books", catalogWrite(s.PostNewBook)).
 		Methods("POST")
 	router.
-		HandleFunc("/books", handlers.GetAllBooks).
+		HandleFunc("/books", catalogRead(s.GetAllBooks)).
 		Methods("GET")
 	router.
-		HandleFunc("/books/{isbn}", handlers.GetBookByISBN).
+		HandleFunc("/books/{isbn}", catalogRead(s.GetBookByISBN)).
+		Methods("GET")
+	router.
+		HandleFunc("/books/{isbn}/authors", catalogRead(s.GetBookAuthors)).
+		Methods("GET")
+	router.
+		HandleFunc("/books/{isbn}", catalogWrite(s.PatchUpdateBook)).
+		Methods("PATCH")
+	router.
+		HandleFunc("/books/{isbn}", catalogWrite(s.DeleteBookByISBN)).
+		Methods("DELETE")
+	router.
+		HandleFunc("/books/{isbn}/copies", catalogRead(s.GetBookCopies)).
 		Methods("GET")
 	router.
-		HandleFunc("/books/{isbn}/authors", handlers.GetBookAuthors).
+		HandleFunc("/books/{isbn}/copies", catalogWrite(s.PostNewBookCopies)).
+		Methods("POST")
+
+	// Copies
+	router.
+		HandleFunc("/copies/{id}", catalogRead(s.GetCopyByID)).
 		Methods("GET")
 	router.
-		HandleFunc("/books/{isbn}", handlers.PatchUpdateBook).
+		HandleFunc("/copies/{id}", catalogWrite(s.PatchUpdateCopy)).
 		Methods("PATCH")
 	router.
-		HandleFunc("/books/{isbn}", handlers.DeleteBookByISBN).
+		HandleFunc("/copies/{id}", catalogWrite(s.DeleteCopyByID)).
 		Methods("DELETE")
+	router.
+		HandleFunc("/copies/{id}/status", catalogWrite(s.PatchCopyStatus)).
+		Methods("PATCH")
+	router.
+		HandleFunc("/copies/{id}/history", circulationRead(s.GetCopyHistory)).
+		Methods("GET")
+
+	// Search
+	router.
+		HandleFunc("/search", catalogRead(s.GetSearch)).
+		Methods("GET")
+
+	// Barcodes
+	router.
+		HandleFunc("/barcodes/labels", circulationRead(s.GetBarcodeLabels)).
+		Methods("GET")
+	router.
+		HandleFunc("/barcodes/{barcode}", circulationRead(s.GetBarcodeLookup)).
+		Methods("GET")
 
 	// Checkouts
 	router.
-		HandleFunc("/checkouts", handlers.PostNewCheckouts).
+		HandleFunc("/checkouts", circulationWrite(s.PostNewCheckouts)).
+		Methods("POST")
+	router.
+		HandleFunc("/checkouts", ownCirculationRead(s.GetAllCheckouts)).
+		Methods("GET")
+	router.
+		HandleFunc("/checkouts/{member_id}", ownCirculationRead(s.GetCheckoutsByMemberID)).
+		Methods("GET")
+	router.
+		HandleFunc("/checkouts", circulationWrite(s.PatchReturnCheckout)).
+		Methods("PATCH")
+	router.
+		HandleFunc("/checkouts/loans/{id}", ownCirculationRead(s.GetCheckoutByID)).
+		Methods("GET")
+	router.
+		HandleFunc("/checkouts/copies/{id}", circulationRead(s.GetCheckoutsByCopyID)).
+		Methods("GET")
+	router.
+		HandleFunc("/checkouts/loans/{id}/return", circulationWrite(s.PatchReturnCheckoutByID)).
+		Methods("PATCH")
+	router.
+		HandleFunc("/checkouts/loans/{id}/renew", circulationWrite(s.PatchRenewCheckout)).
+		Methods("PATCH")
+	router.
+		HandleFunc("/checkouts/loans/{id}/lost", circulationWrite(s.PatchLostCheckout)).
+		Methods("PATCH")
+
+	// Check-ins
+	router.
+		HandleFunc("/checkins", circulationWrite(s.PostNewCheckins)).
+		Methods("POST")
+
+	// Loan policies
+	router.
+		HandleFunc("/policies", admin(s.PostNewLoanPolicy)).
 		Methods("POST")
 	router.
-		HandleFunc("/checkouts", handlers.GetAllCheckouts).
+		HandleFunc("/policies", circulationRead(s.GetAllLoanPolicies)).
 		Methods("GET")
 	router.
-		HandleFunc("/checkouts/{member_id}", handlers.GetCheckoutsByMemberID).
+		HandleFunc("/policies/resolve", circulationRead(s.GetResolvedLoanPolicy)).
 		Methods("GET")
 	router.
-		HandleFunc("/checkouts", handlers.PatchReturnCheckout).
+		HandleFunc("/policies/{id}", admin(s.PatchUpdateLoanPolicy)).
 		Methods("PATCH")
+	router.
+		HandleFunc("/policies/{id}", admin(s.DeleteLoanPolicyByID)).
+		Methods("DELETE")
 
 	// Events
 	router.
-		HandleFunc("/events/books/{isbn}", handlers.GetEventsByBookISBN).
+		HandleFunc("/events/stream", eventsRead(s.GetEventStream)).
 		Methods("GET")
 	router.
-		HandleFunc("/events", handlers.GetAllEvents).
+		HandleFunc("/events/books/{isbn}", eventsRead(s.GetEventsByBookISBN)).
+		Methods("GET")
+	router.
+		HandleFunc("/events", eventsRead(s.GetAllEvents)).
 		Methods("GET")
 
+	// Webhooks
+	router.
+		HandleFunc("/webhooks", admin(s.PostNewWebhook)).
+		Methods("POST")
+	router.
+		HandleFunc("/webhooks", admin(s.GetAllWebhooks)).
+		Methods("GET")
+	router.
+		HandleFunc("/webhooks/deliveries/{id}", admin(s.GetWebhookDeliveryByID)).
+		Methods("GET")
+	router.
+		HandleFunc("/webhooks/deliveries/{id}/retry", admin(s.PatchRetryWebhookDelivery)).
+		Methods("PATCH")
+	router.
+		HandleFunc("/webhooks/{id}", admin(s.GetWebhookByID)).
+		Methods("GET")
+	router.
+		HandleFunc("/webhooks/{id}/deliveries", admin(s.GetWebhookDeliveries)).
+		Methods("GET")
+	router.
+		HandleFunc("/webhooks/{id}", admin(s.PatchUpdateWebhook)).
+		Methods("PATCH")
+	router.
+		HandleFunc("/webhooks/{id}", admin(s.DeleteWebhookByID)).
+		Methods("DELETE")
+
+	// Holds
+	router.
+		HandleFunc("/holds", ownCirculationWrite(s.PostNewHold)).
+		Methods("POST")
+	router.
+		HandleFunc("/holds/books/{isbn}", circulationRead(s.GetHoldsByISBN)).
+		Methods("GET")
+	router.
+		HandleFunc("/holds/members/{member_id}", ownCirculationRead(s.GetHoldsByMemberID)).
+		Methods("GET")
+	router.
+		HandleFunc("/holds/{id}", ownCirculationRead(s.GetHoldByID)).
+		Methods("GET")
+	router.
+		HandleFunc("/holds/{id}/cancel", ownCirculationWrite(s.PatchCancelHold)).
+		Methods("PATCH")
+
 	// Members
 	router.
-		HandleFunc("/members", handlers.PostNewMember).
+		HandleFunc("/members", membersWrite(s.PostNewMember)).
 		Methods("POST")
 	router.
-		HandleFunc("/members", handlers.GetAllMembers).
+		HandleFunc("/members", membersRead(s.GetAllMembers)).
 		Methods("GET")
 	router.
-		HandleFunc("/members/{id}", handlers.GetMemberByID).
+		HandleFunc("/members/{id}", ownMembersRead(s.GetMemberByID)).
 		Methods("GET")
 	router.
-		HandleFunc("/members/{id}", handlers.PatchUpdateMember).
+		HandleFunc("/members/{id}", membersWrite(s.PatchUpdateMember)).
 		Methods("PATCH")
 	router.
-		HandleFunc("/members/{id}", handlers.DeleteMemberByID).
+		HandleFunc("/members/{id}/ledger", ownMembersRead(s.GetMemberLedger)).
+		Methods("GET")
+	router.
+		HandleFunc("/members/{id}/ledger", membersWrite(s.PostNewLedgerEntry)).
+		Methods("POST")
+	router.
+		HandleFunc("/members/{id}", membersWrite(s.DeleteMemberByID)).
 		Methods("DELETE")
 
 	return router
 }
 
+// openDB - Open the database selected by the DB_DRIVER env var (mysql by default).
+func openDB() (*gorm.DB, error) {
+	driver := os.Getenv("DB_DRIVER")
+	switch driver {
+	case "", "mysql":
+		return db.OpenMySQL(os.Getenv("MYSQL_CONNECT_STRING"))
+	case "sqlite":
+		path := os.Getenv("SQLITE_PATH")
+		if path == "" {
+			path = "library.db"
+		}
+		return db.OpenSQLite(path)
+	default:
+		return nil, fmt.Errorf("unsupported DB_DRIVER %q", driver)
+	}
+}
+
+// envInt - Read an integer env var, falling back when it isn't set.
+func envInt(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+
+	return n, nil
+}
+
+// configureLoans - Apply the LOAN_DAYS, MAX_RENEWALS, RENEWAL_GRACE_DAYS and HOLD_PICKUP_DAYS
+// env vars, the first two set the loan policy used when no stored policy matches.
+func configureLoans(s *handlers.Server) error {
+	days := []struct {
+		name  string
+		value *int
+		min   int
+	}{
+		{"LOAN_DAYS", &s.LoanPolicy.LoanDays, 1},
+		{"MAX_RENEWALS", &s.LoanPolicy.MaxRenewals, 0},
+		{"RENEWAL_GRACE_DAYS", &s.RenewalGraceDays, 0},
+		{"HOLD_PICKUP_DAYS", &s.HoldPickupDays, 1},
+	}
+
+	for _, day := range days {
+		n, err := envInt(day.name, *day.value)
+		if err != nil {
+			return err
+		}
+		if n < day.min {
+			return fmt.Errorf("invalid %s %q, must be at least %d", day.name, os.Getenv(day.name), day.min)
+		}
+		*day.value = n
+	}
+
+	return nil
+}
+
+// configureFines - Apply the FINE_PER_DAY_CENTS, FINE_CAP_CENTS, REPLACEMENT_FEE_CENTS
+// and FINE_THRESHOLD_CENTS env vars.
+func configureFines(s *handlers.Server) error {
+	amounts := []struct {
+		name  string
+		value *int64
+	}{
+		{"FINE_PER_DAY_CENTS", &s.Fines.PerDay},
+		{"FINE_CAP_CENTS", &s.Fines.ItemCap},
+		{"REPLACEMENT_FEE_CENTS", &s.Fines.Replacement},
+		{"FINE_THRESHOLD_CENTS", &s.Fines.Threshold},
+	}
+
+	for _, amount := range amounts {
+		n, err := envInt(amount.name, int(*amount.value))
+		if err != nil {
+			return err
+		}
+		*amount.value = int64(n)
+	}
+
+	return nil
+}
+
+// configureBarcodes - Apply the BARCODE_INSTITUTION and BARCODE_CHECK_DIGIT env vars.
+func configureBarcodes(s *handlers.Server) error {
+	if institution := os.Getenv("BARCODE_INSTITUTION"); institution != "" {
+		s.Barcodes.Institution = institution
+	}
+	if checkDigit := os.Getenv("BARCODE_CHECK_DIGIT"); checkDigit != "" {
+		enabled, err := strconv.ParseBool(checkDigit)
+		if err != nil {
+			return fmt.Errorf("invalid BARCODE_CHECK_DIGIT %q", checkDigit)
+		}
+		s.Barcodes.CheckDigit = enabled
+	}
+
+	return s.Barcodes.ValidateInstitution()
+}
+
+// configureSessions - Apply the SESSION_HOURS env var.
+func configureSessions(s *handlers.Server) error {
+	hours, err := envInt("SESSION_HOURS", int(s.SessionTTL/time.Hour))
+	if err != nil {
+		return err
+	}
+	if hours == 0 {
+		return fmt.Errorf("invalid SESSION_HOURS %q", os.Getenv("SESSION_HOURS"))
+	}
+	s.SessionTTL = time.Duration(hours) * time.Hour
+
+	return nil
+}
+
+// configureSeeding - Apply the SEED_DIR and APP_ENV env vars, APP_ENV=development enables
+// seeding over http.
+func configureSeeding(s *handlers.Server) error {
+	if dir := os.Getenv("SEED_DIR"); dir != "" {
+		s.SeedDir = dir
+	}
+
+	switch env := os.Getenv("APP_ENV"); env {
+	case "", "production":
+	case "development":
+		s.DevMode = true
+	default:
+		return fmt.Errorf("invalid APP_ENV %q", env)
+	}
+
+	return nil
+}
+
+// configureWebhooks - Apply the WEBHOOK_MAX_ATTEMPTS, WEBHOOK_BACKOFF_SECONDS and
+// WEBHOOK_TIMEOUT_SECONDS env vars.
+func configureWebhooks(d *webhook.Dispatcher) error {
+	var err error
+	if d.MaxAttempts, err = envInt("WEBHOOK_MAX_ATTEMPTS", d.MaxAttempts); err != nil {
+		return err
+	}
+
+	backoff, err := envInt("WEBHOOK_BACKOFF_SECONDS", int(d.Backoff/time.Second))
+	if err != nil {
+		return err
+	}
+	d.Backoff = time.Duration(backoff) * time.Second
+
+	timeout, err := envInt("WEBHOOK_TIMEOUT_SECONDS", int(d.Client.Timeout/time.Second))
+	if err != nil {
+		return err
+	}
+	d.Client.Timeout = time.Duration(timeout) * time.Second
+
+	return nil
+}
+
 // main - Setup http server.
 func main() {
 	var wait time.Duration
-	r := registerRoutes()
+
+	// Admin commands, e.g. `api migrate status`, run instead of the server.
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+
+	// Connect to the database, apply pending migrations and build handlers around it.
+	client, err := openDB()
+	if err != nil {
+		// Exit application, docker-compose will restart to try again.
+		log.Fatal(err)
+	}
+	if _, err := db.MigrateUp(client); err != nil {
+		log.Fatal(err)
+	}
+	store := db.NewGormStore(client)
+	server := handlers.NewServer(store)
+	if err := configureLoans(server); err != nil {
+		log.Fatal(err)
+	}
+	if err := configureFines(server); err != nil {
+		log.Fatal(err)
+	}
+	if err := configureBarcodes(server); err != nil {
+		log.Fatal(err)
+	}
+	if err := configureSessions(server); err != nil {
+		log.Fatal(err)
+	}
+	if err := configureSeeding(server); err != nil {
+		log.Fatal(err)
+	}
+	if users, err := store.CountUsers(); err != nil {
+		log.Fatal(err)
+	} else if users == 0 {
+		log.Println("no users yet, create an admin with `api users add -username <name> -role admin`")
+	}
+
+	// Label anything created before barcodes were introduced.
+	if err := server.AssignMissingBarcodes(); err != nil {
+		log.Fatal(err)
+	}
+	if err := server.RebuildSearchIndex(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Send the webhook deliveries queued with events in the background.
+	dispatcher := webhook.NewDispatcher(store)
+	if err := configureWebhooks(dispatcher); err != nil {
+		log.Fatal(err)
+	}
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	go dispatcher.Run(backgroundCtx)
+
+	// Expire holds past their pickup window in the background, so reads don't write.
+	go server.RunHoldExpiry(backgroundCtx, handlers.HoldExpiryInterval)
+	r := registerRoutes(server)
 
 	// Start server
 	address := "0.0.0.0:8080"
@@ -131,6 +531,7 @@ func main() {
 		Addr:         address,
 		WriteTimeout: 15 * time.Second,
 		ReadTimeout:  15 * time.Second,
+		ConnContext:  handlers.ConnContext,
 	}
 
 	// Run our server in a goroutine so that it doesn't block.
@@ -149,8 +550,7 @@ func main() {
 	signal.Notify(c, os.Interrupt)
 
 	// Close database connection gracefully
-	//db.MySQL.LogMode(true)
-	defer db.MySQL.Close()
+	defer store.Close()
 
 	// Block until we receive our signal.
 	<-c
@@ -162,6 +562,7 @@ func main() {
 	// Doesn't block if no connections, but will otherwise wait
 	// until the timeout deadline.
 	srv.Shutdown(ctx)
+	stopBackground()
 
 	// Optionally, you could run srv.Shutdown in a goroutine and block on
 	// <-ctx.Done() if your application should wait for other services
@@ -0,0 +1,305 @@
+// Package search is a small in-process full-text index over the catalogue, ranking
+// books by BM25 style relevance with prefix and typo tolerant matching.
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Document - The searchable text of one book, keyed by its isbn.
+type Document struct {
+	ISBN        string
+	Title       string
+	Description string
+	Authors     []string
+}
+
+// Searchable fields and how much a match in each counts.
+const (
+	FieldISBN        = "isbn"
+	FieldTitle       = "title"
+	FieldAuthors     = "authors"
+	FieldDescription = "description"
+)
+
+var fieldBoosts = map[string]float64{
+	FieldISBN:        4,
+	FieldTitle:       3,
+	FieldAuthors:     2,
+	FieldDescription: 1,
+}
+
+// How much a query word counts when it only matches as a prefix or with typos.
+const (
+	exactWeight  = 1.0
+	prefixWeight = 0.7
+	fuzzyWeight  = 0.5
+)
+
+// BM25 term frequency saturation and length normalization.
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// descriptionWindow - Words of a description shown around the first match.
+const descriptionWindow = 30
+
+// Hit - A matching document with its relevance and highlighted snippets per field.
+type Hit struct {
+	Document   Document
+	Score      float64
+	Highlights map[string]string
+}
+
+// Index - An inverted index of documents, safe for concurrent use.
+type Index struct {
+	mu       sync.RWMutex
+	docs     map[string]Document
+	lengths  map[string]map[string]int            // isbn -> field -> terms
+	postings map[string]map[string]map[string]int // term -> isbn -> field -> frequency
+	totals   map[string]int                       // field -> terms over all documents
+	vocab    []string                             // sorted terms, for prefix lookups
+}
+
+// NewIndex - Create an empty index.
+func NewIndex() *Index {
+	idx := &Index{}
+	idx.reset()
+	return idx
+}
+
+// reset - Drop every document.
+func (idx *Index) reset() {
+	idx.docs = map[string]Document{}
+	idx.lengths = map[string]map[string]int{}
+	idx.postings = map[string]map[string]map[string]int{}
+	idx.totals = map[string]int{}
+	idx.vocab = nil
+}
+
+// fields - The terms of each field of a document.
+func fields(doc Document) map[string][]string {
+	return map[string][]string{
+		FieldISBN:        {strings.ToLower(doc.ISBN)},
+		FieldTitle:       terms(doc.Title),
+		FieldAuthors:     terms(strings.Join(doc.Authors, " ")),
+		FieldDescription: terms(doc.Description),
+	}
+}
+
+// add - Index a document, which must not be in the index.
+func (idx *Index) add(doc Document) {
+	idx.docs[doc.ISBN] = doc
+	idx.lengths[doc.ISBN] = map[string]int{}
+	for field, words := range fields(doc) {
+		idx.lengths[doc.ISBN][field] = len(words)
+		idx.totals[field] += len(words)
+		for _, word := range words {
+			if idx.postings[word] == nil {
+				idx.postings[word] = map[string]map[string]int{}
+			}
+			if idx.postings[word][doc.ISBN] == nil {
+				idx.postings[word][doc.ISBN] = map[string]int{}
+			}
+			idx.postings[word][doc.ISBN][field]++
+		}
+	}
+}
+
+// remove - Drop a document from the index, if it's there.
+func (idx *Index) remove(isbn string) {
+	doc, ok := idx.docs[isbn]
+	if !ok {
+		return
+	}
+
+	for field, words := range fields(doc) {
+		idx.totals[field] -= len(words)
+		for _, word := range words {
+			delete(idx.postings[word], isbn)
+			if len(idx.postings[word]) == 0 {
+				delete(idx.postings, word)
+			}
+		}
+	}
+	delete(idx.docs, isbn)
+	delete(idx.lengths, isbn)
+}
+
+// sortVocab - Rebuild the sorted term list after the postings changed.
+func (idx *Index) sortVocab() {
+	idx.vocab = idx.vocab[:0]
+	for term := range idx.postings {
+		idx.vocab = append(idx.vocab, term)
+	}
+	sort.Strings(idx.vocab)
+}
+
+// Put - Add or replace a document.
+func (idx *Index) Put(doc Document) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(doc.ISBN)
+	idx.add(doc)
+	idx.sortVocab()
+}
+
+// Remove - Drop a document.
+func (idx *Index) Remove(isbn string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(isbn)
+	idx.sortVocab()
+}
+
+// Rebuild - Replace the whole index with docs.
+func (idx *Index) Rebuild(docs []Document) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.reset()
+	for _, doc := range docs {
+		idx.add(doc)
+	}
+	idx.sortVocab()
+}
+
+// Len - Number of documents in the index.
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return len(idx.docs)
+}
+
+// maxEdits - Typos tolerated in a query word, none for short words.
+func maxEdits(word string) int {
+	switch n := runeLen(word); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// expand - The indexed terms a query word matches, with how much each match counts.
+func (idx *Index) expand(word string) map[string]float64 {
+	matches := map[string]float64{}
+	if _, ok := idx.postings[word]; ok {
+		matches[word] = exactWeight
+	}
+
+	if runeLen(word) >= 2 {
+		for i := sort.SearchStrings(idx.vocab, word); i < len(idx.vocab) && strings.HasPrefix(idx.vocab[i], word); i++ {
+			if _, ok := matches[idx.vocab[i]]; !ok {
+				matches[idx.vocab[i]] = prefixWeight
+			}
+		}
+	}
+
+	if edits := maxEdits(word); edits > 0 {
+		for _, term := range idx.vocab {
+			if _, ok := matches[term]; ok {
+				continue
+			}
+			if d := editDistance(word, term, edits); d <= edits {
+				matches[term] = fuzzyWeight / float64(d)
+			}
+		}
+	}
+
+	return matches
+}
+
+// Search - Rank the documents matching query, best first. Every query word adds to
+// a document's score, documents matching more of the words rank higher.
+func (idx *Index) Search(query string) []Hit {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	words := terms(query)
+	if len(words) == 0 || len(idx.docs) == 0 {
+		return nil
+	}
+
+	n := float64(len(idx.docs))
+	scores := map[string]float64{}
+	matchedWords := map[string]int{}
+	matchedTerms := map[string]map[string]bool{}
+	for _, word := range words {
+		best := map[string]float64{}
+		for term, weight := range idx.expand(word) {
+			docs := idx.postings[term]
+			idf := math.Log(1 + (n-float64(len(docs))+0.5)/(float64(len(docs))+0.5))
+			for isbn, freqs := range docs {
+				var score float64
+				for field, freq := range freqs {
+					avg := float64(idx.totals[field]) / n
+					norm := 1 - b + b*float64(idx.lengths[isbn][field])/math.Max(avg, 1)
+					tf := float64(freq) * (k1 + 1) / (float64(freq) + k1*norm)
+					score += fieldBoosts[field] * tf
+				}
+				score *= weight * idf
+				if score > best[isbn] {
+					best[isbn] = score
+				}
+
+				if matchedTerms[isbn] == nil {
+					matchedTerms[isbn] = map[string]bool{}
+				}
+				matchedTerms[isbn][term] = true
+			}
+		}
+
+		// Only the best match of each query word counts towards a document.
+		for isbn, score := range best {
+			scores[isbn] += score
+			matchedWords[isbn]++
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for isbn, score := range scores {
+		coverage := float64(matchedWords[isbn]) / float64(len(words))
+		hits = append(hits, Hit{
+			Document:   idx.docs[isbn],
+			Score:      math.Round(score*coverage*1000) / 1000,
+			Highlights: snippets(idx.docs[isbn], matchedTerms[isbn]),
+		})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Document.ISBN < hits[j].Document.ISBN
+	})
+
+	return hits
+}
+
+// snippets - Highlighted text of each field of doc containing a matched term.
+func snippets(doc Document, matched map[string]bool) map[string]string {
+	out := map[string]string{}
+	if matched[strings.ToLower(doc.ISBN)] {
+		out[FieldISBN] = markOpen + doc.ISBN + markClose
+	}
+	if s, ok := highlight(doc.Title, matched, 0); ok {
+		out[FieldTitle] = s
+	}
+	if s, ok := highlight(strings.Join(doc.Authors, ", "), matched, 0); ok {
+		out[FieldAuthors] = s
+	}
+	if s, ok := highlight(doc.Description, matched, descriptionWindow); ok {
+		out[FieldDescription] = s
+	}
+
+	return out
+}
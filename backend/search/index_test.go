@@ -0,0 +1,92 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testDocuments - A small catalogue the ranking tests search.
+var testDocuments = []Document{
+	{ISBN: "9780141321066", Title: "The Secret Garden", Description: "A girl finds a locked garden.", Authors: []string{"Frances Hodgson Burnett"}},
+	{ISBN: "9781593275846", Title: "Gardening for Beginners", Description: "Soil, seeds and the secret of good compost.", Authors: []string{"Ana Costa"}},
+	{ISBN: "9780156907392", Title: "The Lighthouse", Description: "A family summer by the sea, and a garden nobody tends.", Authors: []string{"Virginia Woolf"}},
+	{ISBN: "9791090636071", Title: "Harbor Lights", Description: "Stories from a fishing town.", Authors: []string{"Kenji Sato"}},
+}
+
+// hitISBNs - The isbns of hits, best first.
+func hitISBNs(hits []Hit) []string {
+	var isbns []string
+	for _, hit := range hits {
+		isbns = append(isbns, hit.Document.ISBN)
+	}
+
+	return isbns
+}
+
+func TestSearchRanking(t *testing.T) {
+	idx := NewIndex()
+	idx.Rebuild(testDocuments)
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"title prefix beats description", "garden", []string{"9780141321066", "9781593275846", "9780156907392"}},
+		{"every word counts", "secret garden", []string{"9780141321066", "9781593275846", "9780156907392"}},
+		{"author", "woolf", []string{"9780156907392"}},
+		{"prefix", "lightho", []string{"9780156907392"}},
+		{"typo", "lighthuose", []string{"9780156907392"}},
+		{"isbn", "9791090636071", []string{"9791090636071"}},
+		{"short words need to match exactly", "sae", nil},
+		{"stop words only", "the of", nil},
+		{"no match", "submarine", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hitISBNs(idx.Search(tt.query)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchHighlights(t *testing.T) {
+	idx := NewIndex()
+	idx.Rebuild(testDocuments)
+
+	hits := idx.Search("burnett")
+	if len(hits) != 1 {
+		t.Fatalf("Search(%q) = %d hits, want 1", "burnett", len(hits))
+	}
+	want := map[string]string{FieldAuthors: "Frances Hodgson <mark>Burnett</mark>"}
+	if !reflect.DeepEqual(hits[0].Highlights, want) {
+		t.Errorf("Search(%q) highlights = %v, want %v", "burnett", hits[0].Highlights, want)
+	}
+}
+
+func TestPutAndRemove(t *testing.T) {
+	idx := NewIndex()
+	idx.Rebuild(testDocuments)
+
+	renamed := testDocuments[3]
+	renamed.Title = "Harbor Gardens"
+	idx.Put(renamed)
+	if got := hitISBNs(idx.Search("harbor lights")); !reflect.DeepEqual(got, []string{"9791090636071"}) {
+		t.Errorf("after Put, Search(%q) = %v", "harbor lights", got)
+	}
+	if got := hitISBNs(idx.Search("stories")); !reflect.DeepEqual(got, []string{"9791090636071"}) {
+		t.Errorf("after Put, Search(%q) = %v", "stories", got)
+	}
+
+	idx.Remove("9780141321066")
+	if idx.Len() != 3 {
+		t.Errorf("after Remove, Len() = %d, want 3", idx.Len())
+	}
+	for _, isbn := range hitISBNs(idx.Search("secret garden")) {
+		if isbn == "9780141321066" {
+			t.Errorf("after Remove, Search still finds %s", isbn)
+		}
+	}
+}
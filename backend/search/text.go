@@ -0,0 +1,169 @@
+package search
+
+import (
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// token - A word of a text, lower cased, with its byte offsets in the original.
+type token struct {
+	Term  string
+	Start int
+	End   int
+}
+
+// stopWords - Words too common to be worth indexing or searching for.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "for": true, "from": true, "in": true, "is": true,
+	"it": true, "of": true, "on": true, "or": true, "the": true, "to": true,
+	"with": true,
+}
+
+// tokenize - Split text into lower cased words of letters and digits.
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if word && start < 0 {
+			start = i
+		}
+		if !word && start >= 0 {
+			tokens = append(tokens, token{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{strings.ToLower(text[start:]), start, len(text)})
+	}
+
+	return tokens
+}
+
+// terms - The indexable terms of a text, stop words left out.
+func terms(text string) []string {
+	var out []string
+	for _, t := range tokenize(text) {
+		if !stopWords[t.Term] {
+			out = append(out, t.Term)
+		}
+	}
+
+	return out
+}
+
+// editDistance - Levenshtein distance between two words, giving up once it's over max.
+func editDistance(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if diff := len(ra) - len(rb); diff > max || -diff > max {
+		return max + 1
+	}
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if curr[j] < rowMin {
+				rowMin = curr[j]
+			}
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+// min - Smallest of three ints.
+func min(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+
+	return a
+}
+
+// Highlight markers wrapped around matched words in snippets.
+const (
+	markOpen  = "<mark>"
+	markClose = "</mark>"
+)
+
+// highlight - HTML escape text, marking the words whose term is in matched. With a
+// window above 0, only about that many words around the first match are kept.
+func highlight(text string, matched map[string]bool, window int) (string, bool) {
+	tokens := tokenize(text)
+	first := -1
+	for i, t := range tokens {
+		if matched[t.Term] {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return "", false
+	}
+
+	from, to := 0, len(text)
+	prefix, suffix := "", ""
+	if window > 0 && len(tokens) > window {
+		lo := first - window/3
+		if lo < 0 {
+			lo = 0
+		}
+		hi := lo + window
+		if hi > len(tokens) {
+			hi = len(tokens)
+			lo = hi - window
+		}
+		if lo > 0 {
+			from = tokens[lo].Start
+			prefix = "…"
+		}
+		if hi < len(tokens) {
+			to = tokens[hi-1].End
+			suffix = "…"
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(prefix)
+	last := from
+	for _, t := range tokens {
+		if t.Start < from || t.End > to || !matched[t.Term] {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[last:t.Start]))
+		b.WriteString(markOpen)
+		b.WriteString(html.EscapeString(text[t.Start:t.End]))
+		b.WriteString(markClose)
+		last = t.End
+	}
+	b.WriteString(html.EscapeString(text[last:to]))
+	b.WriteString(suffix)
+
+	return b.String(), true
+}
+
+// runeLen - Number of characters in a word.
+func runeLen(s string) int {
+	return utf8.RuneCountInString(s)
+}
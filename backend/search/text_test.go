@@ -0,0 +1,72 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		max  int
+		want int
+	}{
+		{"garden", "garden", 2, 0},
+		{"garden", "gardn", 2, 1},
+		{"garden", "garten", 2, 1},
+		{"garden", "gardens", 2, 1},
+		{"kitten", "sitting", 3, 3},
+		{"flaw", "lawn", 2, 2},
+		{"", "abc", 3, 3},
+		{"café", "cafe", 1, 1},
+		{"garden", "harbor", 2, 3},
+		{"lighthouse", "light", 2, 3},
+	}
+
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b, tt.max); got != tt.want {
+			t.Errorf("editDistance(%q, %q, %d) = %d, want %d", tt.a, tt.b, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestTerms(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"The Garden of Lisbon", []string{"garden", "lisbon"}},
+		{"C++ in 21 Days!", []string{"c", "21", "days"}},
+		{"Ünïcode  words", []string{"ünïcode", "words"}},
+		{"the and of", nil},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		if got := terms(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("terms(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	matched := map[string]bool{"garden": true}
+	tests := []struct {
+		text   string
+		window int
+		want   string
+		ok     bool
+	}{
+		{"The Garden", 0, "The <mark>Garden</mark>", true},
+		{"Tom & Jerry's garden", 0, "Tom &amp; Jerry&#39;s <mark>garden</mark>", true},
+		{"one two three four garden six seven eight nine", 3, "…four <mark>garden</mark> six…", true},
+		{"No match here", 0, "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := highlight(tt.text, matched, tt.window)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("highlight(%q, %d) = %q, %v, want %q, %v", tt.text, tt.window, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"main/db"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers sent with every delivery.
+const (
+	TopicHeader     = "X-Library-Topic"
+	DeliveryHeader  = "X-Library-Delivery"
+	TimestampHeader = "X-Library-Timestamp"
+	SignatureHeader = "X-Library-Signature"
+)
+
+// maxErrorLength - Most characters of a failure kept in the delivery log.
+const maxErrorLength = 1024
+
+// Sign - The signature of a body sent at timestamp (unix seconds): "sha256=" and the hex hmac-sha256,
+// keyed with the webhook secret, of the timestamp, a dot and the body.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher - Sends the deliveries queued in the outbox, retrying failures with exponential backoff.
+type Dispatcher struct {
+	Store  db.Store
+	Client *http.Client
+
+	// MaxAttempts - Tries at a delivery before it's marked failed.
+	MaxAttempts int
+
+	// Backoff - Wait before the first retry, doubled for every retry after it up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// PollInterval - How often the outbox is checked for due deliveries.
+	PollInterval time.Duration
+
+	// BatchSize - Most deliveries sent per check.
+	BatchSize int
+}
+
+// NewDispatcher - Create a Dispatcher for the outbox of the given store with the default settings.
+func NewDispatcher(store db.Store) *Dispatcher {
+	return &Dispatcher{
+		Store:        store,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts:  8,
+		Backoff:      30 * time.Second,
+		MaxBackoff:   6 * time.Hour,
+		PollInterval: 2 * time.Second,
+		BatchSize:    50,
+	}
+}
+
+// RetryDelay - How long to wait after a delivery's nth failed attempt.
+func (d *Dispatcher) RetryDelay(attempt int) time.Duration {
+	delay := d.Backoff
+	for i := 1; i < attempt && delay < d.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.MaxBackoff {
+		delay = d.MaxBackoff
+	}
+
+	return delay
+}
+
+// Run - Send due deliveries until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.DispatchDue(ctx, time.Now()); err != nil {
+			log.Println("webhooks::", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DispatchDue - Send the deliveries due by now and return how many were attempted.
+func (d *Dispatcher) DispatchDue(ctx context.Context, now time.Time) (int, error) {
+	deliveries, err := d.Store.GetDueWebhookDeliveries(now, d.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	attempted := 0
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			break
+		}
+
+		// Hold the delivery off while it's sent, a crash mid attempt retries it later.
+		claimed, err := d.Store.ClaimWebhookDelivery(delivery, now.Add(d.RetryDelay(delivery.Attempts+1)))
+		if err != nil {
+			return attempted, err
+		}
+		if !claimed {
+			continue
+		}
+		delivery.Attempts++
+		attempted++
+
+		if err := d.deliver(ctx, delivery); err != nil {
+			return attempted, err
+		}
+	}
+
+	return attempted, nil
+}
+
+// deliver - Post a claimed delivery to its webhook and log how it went.
+func (d *Dispatcher) deliver(ctx context.Context, delivery db.WebhookDelivery) error {
+	webhook, err := d.Store.GetWebhook(delivery.WebhookID)
+	if err == db.ErrNotFound || (err == nil && !webhook.Active) {
+		return d.finish(delivery, db.WebhookAttempt{Error: "webhook was deleted or deactivated"}, db.DeliveryFailed)
+	}
+	if err != nil {
+		return err
+	}
+
+	started := time.Now()
+	attempt := db.WebhookAttempt{}
+	statusCode, errSend := d.send(ctx, webhook, delivery, started)
+	attempt.StatusCode = statusCode
+	attempt.DurationMS = time.Since(started).Nanoseconds() / int64(time.Millisecond)
+
+	switch {
+	case errSend == nil && statusCode >= 200 && statusCode < 300:
+		return d.finish(delivery, attempt, db.DeliveryDelivered)
+	case errSend != nil:
+		attempt.Error = errSend.Error()
+	default:
+		attempt.Error = fmt.Sprintf("webhook answered %d", statusCode)
+	}
+	if len(attempt.Error) > maxErrorLength {
+		attempt.Error = attempt.Error[:maxErrorLength]
+	}
+
+	if delivery.Attempts >= d.MaxAttempts {
+		return d.finish(delivery, attempt, db.DeliveryFailed)
+	}
+	return d.finish(delivery, attempt, db.DeliveryPending)
+}
+
+// send - Post the signed payload of a delivery, returning the status the webhook answered with.
+func (d *Dispatcher) send(ctx context.Context, webhook db.Webhook, delivery db.WebhookDelivery, now time.Time) (int, error) {
+	body := []byte(delivery.Payload)
+	request, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request = request.WithContext(ctx)
+
+	timestamp := now.Unix()
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "local-library-webhooks")
+	request.Header.Set(TopicHeader, delivery.Topic)
+	request.Header.Set(DeliveryHeader, strconv.FormatUint(uint64(delivery.ID), 10))
+	request.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(SignatureHeader, Sign(webhook.Secret, timestamp, body))
+
+	response, err := d.Client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(response.Body, 64<<10))
+
+	return response.StatusCode, nil
+}
+
+// finish - Log an attempt at a delivery and move it to status, pending ones wait for their next retry.
+func (d *Dispatcher) finish(delivery db.WebhookDelivery, attempt db.WebhookAttempt, status db.DeliveryStatus) error {
+	now := time.Now()
+	attempt.CreatedAt = now
+	attempt.UpdatedAt = now
+	attempt.DeliveryID = delivery.ID
+	attempt.Attempt = delivery.Attempts
+
+	updates := map[string]interface{}{
+		"status":     status,
+		"last_error": attempt.Error,
+		"updated_at": now,
+	}
+	switch status {
+	case db.DeliveryDelivered:
+		updates["delivered_at"] = now
+		updates["next_attempt_at"] = nil
+	case db.DeliveryFailed:
+		updates["next_attempt_at"] = nil
+	default:
+		updates["next_attempt_at"] = now.Add(d.RetryDelay(delivery.Attempts))
+	}
+
+	return d.Store.Transaction(func(tx db.Store) error {
+		if err := tx.CreateWebhookAttempt(&attempt); err != nil {
+			return err
+		}
+
+		return tx.UpdateWebhookDelivery(delivery.ID, updates)
+	})
+}
@@ -0,0 +1,57 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		secret    string
+		timestamp int64
+		body      string
+		want      string
+	}{
+		{"s3cret", 1700000000, `{"topic":"book.create"}`, "sha256=dd617e335a381a71fe5e999bde26b5f152e2b4f5a8e9f82bfd608438da5f71b2"},
+		{"", 0, "", "sha256=b849d5a581847b281957065739df36df2463d1977ea8d6e1e4e6cf33fadc68c3"},
+	}
+
+	for _, tt := range tests {
+		if got := Sign(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+			t.Errorf("Sign(%q, %d, %q) = %q, want %q", tt.secret, tt.timestamp, tt.body, got, tt.want)
+		}
+	}
+
+	// The timestamp is signed too, so a payload can't be replayed under another one.
+	body := []byte(`{"topic":"book.create"}`)
+	if Sign("s3cret", 1700000000, body) == Sign("s3cret", 1700000001, body) {
+		t.Error("Sign ignores the timestamp")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	d := &Dispatcher{Backoff: 30 * time.Second, MaxBackoff: 10 * time.Minute}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{5, 8 * time.Minute},
+		{6, 10 * time.Minute},
+		{100, 10 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := d.RetryDelay(tt.attempt); got != tt.want {
+			t.Errorf("RetryDelay(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+
+	capped := &Dispatcher{Backoff: time.Hour, MaxBackoff: time.Minute}
+	if got := capped.RetryDelay(1); got != time.Minute {
+		t.Errorf("RetryDelay(1) with Backoff over MaxBackoff = %v, want %v", got, time.Minute)
+	}
+}
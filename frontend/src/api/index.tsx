@@ -44,13 +44,77 @@ export interface IPostNewMemberPayload extends IPostAuthorPayload {
 	image_url: string
 }
 
+export interface IListResponse<T = any> {
+	data: T[]
+	links?: {
+		next: string | null
+		prev: string | null
+	}
+}
+
 export const API_BASE_URL: string = "http://localhost:8000/api";
 
+// Every route but /health and /auth/login needs a bearer token, a session token from
+// postLogin or an api key. REACT_APP_API_TOKEN sets one at build time.
+const TOKEN_STORAGE_KEY: string = "apiToken";
+
+// Lists come back a page at a time, fetch them in the largest pages the api allows.
+const LIST_PAGE_LIMIT: number = 200;
+
 
 /* =======================================================
  *					        Utility Functions
 ======================================================= */
 
+const getAuthToken = (): string | null => {
+	return localStorage.getItem(TOKEN_STORAGE_KEY) || process.env.REACT_APP_API_TOKEN || null;
+};
+
+const setAuthToken = (token: string | null) => {
+	if (token) {
+		localStorage.setItem(TOKEN_STORAGE_KEY, token);
+	} else {
+		localStorage.removeItem(TOKEN_STORAGE_KEY);
+	}
+};
+
+// Fetch an api path with the bearer token and json body headers set.
+const apiFetch = (path: string, init: RequestInit = {}) => {
+	const headers = new Headers(init.headers);
+	const token = getAuthToken();
+	if (token) {
+		headers.set("Authorization", `Bearer ${token}`);
+	}
+	if (init.body) {
+		headers.set("Content-Type", "application/json");
+	}
+
+	return fetch(`${API_BASE_URL}${path}`, { ...init, headers });
+};
+
+// Fetch every page of a list, following the next links, and resolve to all of its data. Fails
+// on the first page that doesn't load, e.g. without a token.
+const fetchAllPages = (path: string): Promise<IListResponse> => {
+	const separator = path.includes("?") ? "&" : "?";
+	const getPage = (pagePath: string, data: any[]): Promise<IListResponse> => {
+		return apiFetch(pagePath)
+			.then(res => {
+				if (!res.ok) {
+					throw new Error(`${pagePath} responded ${res.status}`);
+				}
+				return res.json();
+			})
+			.then((res: IListResponse) => {
+				const all = data.concat(res.data || []);
+				return res.links && res.links.next
+					? getPage(res.links.next, all)
+					: { data: all };
+			});
+	};
+
+	return getPage(`${path}${separator}limit=${LIST_PAGE_LIMIT}`, []);
+};
+
 
 /* =======================================================
  *					Endpoint Handlers
@@ -61,11 +125,41 @@ export const API_BASE_URL: string = "http://localhost:8000/api";
 ============================================== */
 
 const getHealthCheck = () => {
-	return fetch(`${API_BASE_URL}/health`);
+	return apiFetch("/health");
 };
 
-const getSeedDatabase = () => {
-	return fetch(`${API_BASE_URL}/seed`);
+// Only routed when the api runs with APP_ENV=development, and only for admins.
+const postSeedDatabase = () => {
+	return apiFetch("/seed", {
+		method: "POST"
+	});
+};
+
+
+/* 				  Auth Handlers
+============================================== */
+
+const postLogin = (username: string, password: string) => {
+	return apiFetch("/auth/login", {
+		method: "POST",
+		body: JSON.stringify({ username, password })
+	})
+		.then(res => res.json())
+		.then(res => {
+			if (res.data && res.data.token) {
+				setAuthToken(res.data.token);
+			}
+			return res;
+		});
+};
+
+const postLogout = () => {
+	return apiFetch("/auth/logout", {
+		method: "POST"
+	}).then(res => {
+		setAuthToken(null);
+		return res;
+	});
 };
 
 
@@ -73,17 +167,16 @@ const getSeedDatabase = () => {
 ============================================== */
 
 const getAllAuthors = () => {
-	return fetch(`${API_BASE_URL}/authors?books=true`)
-		.then(res => res.json());
+	return fetchAllPages("/authors?books=true");
 };
 
 const getAuthorByID = (id: string) => {
-	return fetch(`${API_BASE_URL}/authors/${id}`)
+	return apiFetch(`/authors/${id}`)
 		.then(res => res.json());
 };
 
 const postCreateNewAuthor = (author: IPostAuthorPayload) => {
-	return fetch(`${API_BASE_URL}/authors`, {
+	return apiFetch("/authors", {
 		method: "POST",
 		body: JSON.stringify(author)
 	});
@@ -94,31 +187,30 @@ const postCreateNewAuthor = (author: IPostAuthorPayload) => {
 ============================================== */
 
 const getAllBooks = () => {
-	return fetch(`${API_BASE_URL}/books`)
-		.then(res => res.json());
+	return fetchAllPages("/books");
 };
 
 const getBookByISBN = (isbn: string) => {
-	return fetch(`${API_BASE_URL}/books/${isbn}`)
+	return apiFetch(`/books/${isbn}`)
 		.then(res => res.json());
 };
 
 const patchUpdateBookByISBN = (payload: IPatchUpdateBookPayload) => {
 	const { isbn } = payload;
-	return fetch(`${API_BASE_URL}/books/${isbn}`, {
+	return apiFetch(`/books/${isbn}`, {
 		method: "PATCH",
 		body: JSON.stringify(payload)
 	});
 };
 
 const deleteBookByISBN = (isbn: string) => {
-	return fetch(`${API_BASE_URL}/books/${isbn}`, {
+	return apiFetch(`/books/${isbn}`, {
 		method: "DELETE"
 	});
 };
 
 const postNewBook = (payload: IPostNewBookPayload) => {
-	return fetch(`${API_BASE_URL}/books`, {
+	return apiFetch("/books", {
 		method: "POST",
 		body: JSON.stringify(payload)
 	});
@@ -129,24 +221,23 @@ const postNewBook = (payload: IPostNewBookPayload) => {
 ============================================== */
 
 const getAllCheckouts = () => {
-	return fetch(`${API_BASE_URL}/checkouts`)
-		.then(res => res.json());
+	return fetchAllPages("/checkouts");
 };
 
 const getCheckoutsByMemberID = (id: string) => {
-	return fetch(`${API_BASE_URL}/checkouts/${id}`)
+	return apiFetch(`/checkouts/${id}`)
 		.then(res => res.json());
 };
 
 const postNewCheckouts = (payload: IPostNewCheckoutsPayload) => {
-	return fetch(`${API_BASE_URL}/checkouts`, {
+	return apiFetch("/checkouts", {
 		method: "POST",
 		body: JSON.stringify(payload)
 	});
 };
 
 const patchReturnCheckout = (payload: IPatchReturnCheckout) => {
-	return fetch(`${API_BASE_URL}/checkouts`, {
+	return apiFetch("/checkouts", {
 		method: "PATCH",
 		body: JSON.stringify(payload)
 	});
@@ -157,12 +248,11 @@ const patchReturnCheckout = (payload: IPatchReturnCheckout) => {
 ============================================== */
 
 const getAllEvents = () => {
-	return fetch(`${API_BASE_URL}/events`)
-		.then(res => res.json());
+	return fetchAllPages("/events");
 };
 
 const getEventsByBookISBN = (isbn: string) => {
-	return fetch(`${API_BASE_URL}/events/books/${isbn}`)
+	return apiFetch(`/events/books/${isbn}`)
 		.then(res => res.json());
 };
 
@@ -171,17 +261,16 @@ const getEventsByBookISBN = (isbn: string) => {
 ============================================== */
 
 const getAllMembers = () => {
-	return fetch(`${API_BASE_URL}/members`)
-		.then(res => res.json());
+	return fetchAllPages("/members");
 };
 
 const getMemberByID = (id: string) => {
-	return fetch(`${API_BASE_URL}/members/${id}`)
+	return apiFetch(`/members/${id}`)
 		.then(res => res.json());
 };
 
 const postCreateNewMember = (member: IPostNewMemberPayload) => {
-	return fetch(`${API_BASE_URL}/members`, {
+	return apiFetch("/members", {
 		method: "POST",
 		body: JSON.stringify(member)
 	});
@@ -192,7 +281,11 @@ export interface IAPI {
 
 	// Util
 	getHealthCheck: typeof getHealthCheck
-	getSeedDatabase: typeof getSeedDatabase
+	postSeedDatabase: typeof postSeedDatabase
+
+	// Auth
+	postLogin: typeof postLogin
+	postLogout: typeof postLogout
 
 	// Authors
 	getAllAuthors: typeof getAllAuthors
@@ -226,7 +319,11 @@ export const api: IAPI = {
 
 	// Util
 	getHealthCheck,
-	getSeedDatabase,
+	postSeedDatabase,
+
+	// Auth
+	postLogin,
+	postLogout,
 
 	// Authors
 	getAllAuthors,
@@ -103,9 +103,8 @@ const BooksView: ComponentType<IBooksView> = (props: IBooksView): JSX.Element =>
 
 				// Seed the database if there's no test data yet.
 				if (!hasData[0]) {
-					return api.getSeedDatabase()
-						.then(console.log)
-						.then(() => window.location.reload())
+					return api.postSeedDatabase()
+						.then(res => res.ok ? window.location.reload() : stopLoader())
 						.catch(console.error);
 				}
 
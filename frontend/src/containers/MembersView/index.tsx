@@ -94,9 +94,8 @@ const MembersView: ComponentType<IMembersView> = (props: IMembersView): JSX.Elem
 
 				// Seed the database if there's no test data yet.
 				if (!hasData[0]) {
-					return api.getSeedDatabase()
-						.then(console.log)
-						.then(window.location.reload)
+					return api.postSeedDatabase()
+						.then(res => res.ok ? window.location.reload() : stopLoader())
 						.catch(console.error);
 				}
 